@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// setupLogging makes the default slog logger write -log-format records at
+// -log-level and above to w. The standard log package writes through it too.
+func setupLogging(w io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level %q: must be debug, info, warn, or error", *logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid -log-format %q: must be text or json", *logFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs msg at error level with the given attributes and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
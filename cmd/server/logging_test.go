@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent loggers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// captureLogs configures logging with the given -log-format and -log-level
+// into a buffer until the test ends.
+func captureLogs(t *testing.T, format, level string) *syncBuffer {
+	t.Helper()
+	oldFormat, oldLevel, oldLogger := *logFormat, *logLevel, slog.Default()
+	t.Cleanup(func() {
+		*logFormat, *logLevel = oldFormat, oldLevel
+		slog.SetDefault(oldLogger)
+	})
+	*logFormat, *logLevel = format, level
+	buf := &syncBuffer{}
+	if err := setupLogging(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestLoggingLevelAndFormat(t *testing.T) {
+	logs := captureLogs(t, "text", "warn")
+	slog.Info("hidden")
+	slog.Warn("shown", "key", "value")
+	logs.mu.Lock()
+	got := logs.buf.String()
+	logs.mu.Unlock()
+	if strings.Contains(got, "hidden") || !strings.Contains(got, "level=WARN msg=shown key=value") {
+		t.Fatalf("expected only the warning as a text record, got %q", got)
+	}
+
+	for _, bad := range [][2]string{{"xml", "info"}, {"json", "loud"}} {
+		*logFormat, *logLevel = bad[0], bad[1]
+		if err := setupLogging(&syncBuffer{}); err == nil {
+			t.Fatalf("expected -log-format=%s -log-level=%s to be rejected", bad[0], bad[1])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package config sets a program's flags from a YAML file and from
+// environment variables as well as from the command line, so secrets need not
+// appear in the process list and long flag lists can live in a file.
+//
+// Every flag can be set three ways. The file is a mapping from flag names to
+// values:
+//
+//	tcp: ":6380"
+//	read-timeout: 5s
+//	snapshot-file: /var/lib/mycache/dump.json
+//
+// and the environment variable for a flag is MYCACHE_ followed by its name in
+// upper case with dashes replaced by underscores, such as MYCACHE_READ_TIMEOUT.
+// A setting in the environment overrides the command line, which overrides the
+// file, which overrides the flag's default.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable Load reads.
+const EnvPrefix = "MYCACHE_"
+
+// EnvName returns the environment variable that sets the flag name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load applies the config file and environment variables to fs, which must
+// already have parsed the command line. The file is the one named by the flag
+// configFlag, or by its environment variable, and is skipped if that is empty;
+// settings in it are ignored for flags given on the command line, and it may
+// not set configFlag itself. lookupEnv is usually os.LookupEnv.
+//
+// The returned error names the setting that could not be applied: an unknown
+// name in the file, or a value the flag rejects.
+func Load(fs *flag.FlagSet, configFlag string, lookupEnv func(string) (string, bool)) error {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	var path string
+	if f := fs.Lookup(configFlag); f != nil {
+		path = f.Value.String()
+	}
+	if env, ok := lookupEnv(EnvName(configFlag)); ok {
+		path = env
+	}
+	if path != "" {
+		if err := loadFile(fs, path, configFlag, onCommandLine); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		value, ok := lookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value %q: %w", name, value, setErr)
+		}
+	})
+	return err
+}
+
+// loadFile applies the settings in the file at path to the flags in fs not
+// given on the command line.
+func loadFile(fs *flag.FlagSet, path, configFlag string, onCommandLine map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil // An empty file sets nothing.
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of setting names to values", path, root.Line)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		name := key.Value
+		switch {
+		case fs.Lookup(name) == nil:
+			return fmt.Errorf("%s:%d: unknown setting %q", path, key.Line, name)
+		case name == configFlag:
+			return fmt.Errorf("%s:%d: %s cannot be set in the config file", path, key.Line, name)
+		case seen[name]:
+			return fmt.Errorf("%s:%d: %s is set more than once", path, key.Line, name)
+		case value.Kind != yaml.ScalarNode:
+			return fmt.Errorf("%s:%d: %s: expected a single value", path, value.Line, name)
+		}
+		seen[name] = true
+		if onCommandLine[name] {
+			continue
+		}
+		s := value.Value
+		if value.Tag == "!!null" {
+			s = ""
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("%s:%d: %s: invalid value %q: %w", path, value.Line, name, s, err)
+		}
+	}
+	return nil
+}
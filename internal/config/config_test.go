@@ -0,0 +1,149 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlags is a small flag set resembling the server's.
+type testFlags struct {
+	fs      *flag.FlagSet
+	config  *string
+	addr    *string
+	timeout *time.Duration
+	workers *int
+	tls     *bool
+}
+
+func newTestFlags(t *testing.T, args ...string) *testFlags {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := &testFlags{
+		fs:      fs,
+		config:  fs.String("config", "", "config file"),
+		addr:    fs.String("tcp", ":8080", "address"),
+		timeout: fs.Duration("read-timeout", 0, "timeout"),
+		workers: fs.Int("workers", 10, "workers"),
+		tls:     fs.Bool("tls", false, "TLS"),
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// writeFile writes a config file and returns its path.
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// env returns a lookup function over vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("tls-client-ca"); got != "MYCACHE_TLS_CLIENT_CA" {
+		t.Fatalf("expected MYCACHE_TLS_CLIENT_CA, got %s", got)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+tcp: ":7000"
+read-timeout: 5s
+workers: 4
+tls: true
+`)
+	f := newTestFlags(t, "-config", path, "-workers", "8", "-read-timeout", "1s")
+	err := Load(f.fs, "config", env(map[string]string{"MYCACHE_READ_TIMEOUT": "2s"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *f.addr != ":7000" || !*f.tls {
+		t.Fatalf("expected the file to override the defaults, got %q and %t", *f.addr, *f.tls)
+	}
+	if *f.workers != 8 {
+		t.Fatalf("expected the command line to override the file, got %d workers", *f.workers)
+	}
+	if *f.timeout != 2*time.Second {
+		t.Fatalf("expected the environment to override the command line, got %s", *f.timeout)
+	}
+}
+
+func TestLoadPartialFile(t *testing.T) {
+	f := newTestFlags(t, "-config", writeFile(t, "workers: 3\n"))
+	if err := Load(f.fs, "config", env(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if *f.workers != 3 || *f.addr != ":8080" || *f.timeout != 0 || *f.tls {
+		t.Fatalf("expected settings missing from the file to keep their defaults, got %+v", f)
+	}
+
+	f = newTestFlags(t, "-config", writeFile(t, "# Nothing set yet.\n"))
+	if err := Load(f.fs, "config", env(nil)); err != nil || *f.workers != 10 {
+		t.Fatalf("expected an empty file to set nothing, got %d workers, %v", *f.workers, err)
+	}
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	f := newTestFlags(t)
+	if err := Load(f.fs, "config", env(map[string]string{"MYCACHE_TCP": ":9000"})); err != nil {
+		t.Fatal(err)
+	}
+	if *f.addr != ":9000" {
+		t.Fatalf("expected the environment to apply without a file, got %q", *f.addr)
+	}
+}
+
+func TestLoadConfigPathFromEnv(t *testing.T) {
+	f := newTestFlags(t, "-config", "/does/not/exist.yaml")
+	path := writeFile(t, "workers: 2\n")
+	if err := Load(f.fs, "config", env(map[string]string{"MYCACHE_CONFIG": path})); err != nil {
+		t.Fatal(err)
+	}
+	if *f.workers != 2 {
+		t.Fatalf("expected MYCACHE_CONFIG to name the file, got %d workers", *f.workers)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, file string
+		env        map[string]string
+		want       string
+	}{
+		{name: "unknown setting", file: "tcp: \":1\"\nwrokers: 4\n", want: `config.yaml:2: unknown setting "wrokers"`},
+		{name: "invalid value", file: "read-timeout: soon\n", want: `config.yaml:1: read-timeout: invalid value "soon"`},
+		{name: "not a scalar", file: "tcp:\n  - a\n  - b\n", want: "config.yaml:2: tcp: expected a single value"},
+		{name: "duplicate", file: "workers: 1\nworkers: 2\n", want: "config.yaml:2: workers is set more than once"},
+		{name: "config in file", file: "config: other.yaml\n", want: "config.yaml:1: config cannot be set in the config file"},
+		{name: "not a mapping", file: "- workers\n", want: "config.yaml:1: expected a mapping"},
+		{name: "invalid env", file: "", env: map[string]string{"MYCACHE_WORKERS": "many"}, want: `MYCACHE_WORKERS: invalid value "many"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFlags(t, "-config", writeFile(t, tt.file))
+			err := Load(f.fs, "config", env(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	f := newTestFlags(t, "-config", filepath.Join(t.TempDir(), "missing.yaml"))
+	if err := Load(f.fs, "config", env(nil)); err == nil {
+		t.Fatal("expected a missing config file to be an error")
+	}
+}
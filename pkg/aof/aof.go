@@ -0,0 +1,321 @@
+// Package aof implements an append-only log of cache writes that can be
+// replayed to rebuild the cache after a restart.
+//
+// Each record is framed as a uvarint payload length, a CRC-32 (IEEE) of the
+// payload, and the payload itself, so a record torn by a crash is detected and
+// discarded on replay.
+package aof
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Op identifies the operation a record replays.
+type Op byte
+
+const (
+	// OpSet stores Value under Key, expiring at ExpireAt if it is non-zero.
+	OpSet Op = iota + 1
+	// OpDel removes Key.
+	OpDel
+	// OpAppend appends Value to the value stored under Key.
+	OpAppend
+	// OpRename moves Key to the key held in Value.
+	OpRename
+	// OpFlush removes every key.
+	OpFlush
+	// OpDelPrefix removes every key that starts with Key.
+	OpDelPrefix
+	// OpRestore stores the value serialized in Value by cache.Dump under Key,
+	// expiring at ExpireAt if it is non-zero. It records values of kinds
+	// other than strings, such as hashes, whole.
+	OpRestore
+)
+
+// Record is a single logged write.
+type Record struct {
+	Op       Op
+	Key      string
+	Value    string
+	ExpireAt time.Time // Zero means the key does not expire.
+}
+
+// FsyncPolicy controls how often the log is flushed to stable storage.
+type FsyncPolicy int
+
+const (
+	// FsyncEverySecond fsyncs once per second, so a crash loses at most about
+	// a second of writes. This is the default.
+	FsyncEverySecond FsyncPolicy = iota
+	// FsyncAlways fsyncs after every record.
+	FsyncAlways
+	// FsyncNo leaves flushing to the operating system.
+	FsyncNo
+)
+
+// ParseFsyncPolicy parses "always", "everysec", or "no".
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySecond, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q", s)
+}
+
+// ErrCorrupt is returned by ReadRecord for a record that fails its checksum or is
+// cut short.
+var ErrCorrupt = errors.New("aof: corrupt record")
+
+// maxRecordSize bounds the payload length accepted on replay, so a corrupt
+// length cannot trigger a huge allocation.
+const maxRecordSize = 1 << 30
+
+// Writer appends records to a log file. It is safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	policy FsyncPolicy
+	buf    []byte
+	dirty  bool // Records written since the last fsync.
+	err    error
+	closed bool
+
+	size        int64 // Current length of the log.
+	rewriteSize int64 // Length after the last rewrite, or when opened.
+	rewriting   bool
+	pending     []byte // Records appended while a rewrite is running.
+
+	// compacted, if set, is called by Rewrite between compacting the old log
+	// and swapping in the new one. Tests use it to write mid-rewrite.
+	compacted func()
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the log at path for appending, creating it if needed.
+func Open(path string, policy FsyncPolicy) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w := &Writer{
+		path:        path,
+		f:           f,
+		policy:      policy,
+		size:        info.Size(),
+		rewriteSize: info.Size(),
+		stop:        make(chan struct{}),
+	}
+	if policy == FsyncEverySecond {
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// Append writes rec to the log. Every record reaches the operating system
+// before Append returns; whether it is also fsynced depends on the policy.
+func (w *Writer) Append(rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	w.buf = AppendRecord(w.buf[:0], rec)
+	if _, err := w.f.Write(w.buf); err != nil {
+		w.err = err
+		return err
+	}
+	w.size += int64(len(w.buf))
+	if w.rewriting {
+		w.pending = append(w.pending, w.buf...)
+	}
+	if w.policy == FsyncAlways {
+		if err := w.f.Sync(); err != nil {
+			w.err = err
+			return err
+		}
+		return nil
+	}
+	w.dirty = true
+	return nil
+}
+
+// syncLoop fsyncs pending writes once per second until Close.
+func (w *Writer) syncLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.err == nil {
+				w.err = w.f.Sync()
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Close fsyncs and closes the log.
+func (w *Writer) Close() error {
+	close(w.stop)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// AppendRecord appends the framed encoding of rec to dst, as the log stores
+// it. Replication streams records to replicas in the same framing.
+func AppendRecord(dst []byte, rec Record) []byte {
+	var expireAt int64
+	if !rec.ExpireAt.IsZero() {
+		expireAt = rec.ExpireAt.UnixNano()
+	}
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(rec.Key)+len(rec.Value)+binary.MaxVarintLen64)
+	payload = append(payload, byte(rec.Op))
+	payload = binary.AppendUvarint(payload, uint64(len(rec.Key)))
+	payload = append(payload, rec.Key...)
+	payload = binary.AppendUvarint(payload, uint64(len(rec.Value)))
+	payload = append(payload, rec.Value...)
+	payload = binary.AppendVarint(payload, expireAt)
+
+	dst = binary.AppendUvarint(dst, uint64(len(payload)))
+	dst = binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(payload))
+	return append(dst, payload...)
+}
+
+// Replay calls apply for every intact record in the log at path, in order. If
+// the log ends in a damaged record, as happens when the process dies midway
+// through a write, the file is truncated after the last intact record and the
+// number of bytes discarded is returned. A missing file replays nothing.
+func Replay(path string, apply func(Record)) (discarded int64, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := ReadRecord(r)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if errors.Is(err, ErrCorrupt) {
+			info, statErr := f.Stat()
+			if statErr != nil {
+				return 0, statErr
+			}
+			if err := f.Truncate(offset); err != nil {
+				return 0, err
+			}
+			return info.Size() - offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset += n
+		apply(rec)
+	}
+}
+
+// ReadRecord decodes one framed record from r and returns it with its
+// encoded size. It returns io.EOF only at a clean record boundary, and
+// ErrCorrupt for a damaged record.
+func ReadRecord(r *bufio.Reader) (Record, int64, error) {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return Record{}, 0, io.EOF
+	}
+	if err != nil || size > maxRecordSize {
+		return Record{}, 0, ErrCorrupt
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Record{}, 0, ErrCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Record{}, 0, ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header) {
+		return Record{}, 0, ErrCorrupt
+	}
+	rec, ok := decode(payload)
+	if !ok {
+		return Record{}, 0, ErrCorrupt
+	}
+	n := int64(len(binary.AppendUvarint(nil, size))) + 4 + int64(size)
+	return rec, n, nil
+}
+
+// decode parses a record payload.
+func decode(p []byte) (Record, bool) {
+	if len(p) == 0 {
+		return Record{}, false
+	}
+	rec := Record{Op: Op(p[0])}
+	p = p[1:]
+	key, p, ok := field(p)
+	if !ok {
+		return Record{}, false
+	}
+	value, p, ok := field(p)
+	if !ok {
+		return Record{}, false
+	}
+	expireAt, n := binary.Varint(p)
+	if n <= 0 || n != len(p) {
+		return Record{}, false
+	}
+	rec.Key, rec.Value = string(key), string(value)
+	if expireAt != 0 {
+		rec.ExpireAt = time.Unix(0, expireAt)
+	}
+	return rec, true
+}
+
+// field splits a uvarint length-prefixed field off the front of p.
+func field(p []byte) (data, rest []byte, ok bool) {
+	n, k := binary.Uvarint(p)
+	if k <= 0 || uint64(len(p)-k) < n {
+		return nil, nil, false
+	}
+	return p[k : k+int(n)], p[k+int(n):], true
+}
@@ -0,0 +1,172 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sampleRecords() []Record {
+	return []Record{
+		{Op: OpSet, Key: "a", Value: "1"},
+		{Op: OpSet, Key: "b", Value: "hello world", ExpireAt: time.Unix(2000, 0)},
+		{Op: OpAppend, Key: "a", Value: "23"},
+		{Op: OpRename, Key: "b", Value: "c"},
+		{Op: OpDel, Key: "a"},
+		{Op: OpDelPrefix, Key: "c"},
+		{Op: OpFlush},
+	}
+}
+
+func writeLog(t *testing.T, path string, policy FsyncPolicy, recs []Record) {
+	t.Helper()
+	w, err := Open(path, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if err := w.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func replayAll(t *testing.T, path string) ([]Record, int64) {
+	t.Helper()
+	var got []Record
+	discarded, err := Replay(path, func(rec Record) { got = append(got, rec) })
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return got, discarded
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySecond, FsyncNo} {
+		path := filepath.Join(t.TempDir(), "cache.aof")
+		want := sampleRecords()
+		writeLog(t, path, policy, want)
+
+		got, discarded := replayAll(t, path)
+		if discarded != 0 {
+			t.Fatalf("policy %d: expected nothing discarded, got %d bytes", policy, discarded)
+		}
+		for i := range got {
+			if !got[i].ExpireAt.IsZero() {
+				got[i].ExpireAt = got[i].ExpireAt.UTC()
+				want[i].ExpireAt = want[i].ExpireAt.UTC()
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("policy %d: got %+v, want %+v", policy, got, want)
+		}
+	}
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	got, discarded := replayAll(t, filepath.Join(t.TempDir(), "missing.aof"))
+	if len(got) != 0 || discarded != 0 {
+		t.Fatalf("expected an empty replay, got %d records and %d discarded bytes", len(got), discarded)
+	}
+}
+
+// TestReplayTornWrite simulates the process being killed at every byte offset
+// of the log: replay must return an intact prefix, truncate the damage, and
+// leave a log that can be appended to again.
+func TestReplayTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.aof")
+	recs := sampleRecords()
+	writeLog(t, full, FsyncNo, recs)
+	data, err := os.ReadFile(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "torn.aof")
+	for cut := 0; cut < len(data); cut++ {
+		if err := os.WriteFile(path, data[:cut], 0o600); err != nil {
+			t.Fatal(err)
+		}
+		got, discarded := replayAll(t, path)
+		if len(got) > len(recs) {
+			t.Fatalf("cut %d: replayed %d records from %d", cut, len(got), len(recs))
+		}
+		info, _ := os.Stat(path)
+		if info.Size()+discarded != int64(cut) {
+			t.Fatalf("cut %d: size %d plus %d discarded bytes does not add up", cut, info.Size(), discarded)
+		}
+
+		// Appending after recovery yields a clean log.
+		writeLog(t, path, FsyncNo, []Record{{Op: OpSet, Key: "after", Value: "crash"}})
+		again, discarded := replayAll(t, path)
+		if discarded != 0 || len(again) != len(got)+1 || again[len(again)-1].Key != "after" {
+			t.Fatalf("cut %d: expected %d records ending in 'after', got %+v (%d discarded)", cut, len(got)+1, again, discarded)
+		}
+	}
+}
+
+func TestReplayCorruptChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	writeLog(t, path, FsyncNo, sampleRecords()[:2])
+	data, _ := os.ReadFile(path)
+	data[len(data)-2] ^= 0xff
+	os.WriteFile(path, data, 0o600)
+
+	got, discarded := replayAll(t, path)
+	if len(got) != 1 || discarded == 0 {
+		t.Fatalf("expected the damaged second record to be discarded, got %d records and %d bytes", len(got), discarded)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for s, want := range map[string]FsyncPolicy{"always": FsyncAlways, "everysec": FsyncEverySecond, "no": FsyncNo} {
+		if got, err := ParseFsyncPolicy(s); err != nil || got != want {
+			t.Errorf("ParseFsyncPolicy(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestRecordStream(t *testing.T) {
+	want := sampleRecords()
+	var stream []byte
+	for _, rec := range want {
+		stream = AppendRecord(stream, rec)
+	}
+	r := bufio.NewReader(bytes.NewReader(stream))
+	var total int64
+	for i := range want {
+		rec, n, err := ReadRecord(r)
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !rec.ExpireAt.Equal(want[i].ExpireAt) {
+			t.Fatalf("record %d: expected expiry %v, got %v", i, want[i].ExpireAt, rec.ExpireAt)
+		}
+		rec.ExpireAt = want[i].ExpireAt
+		if rec != want[i] {
+			t.Fatalf("record %d: expected %+v, got %+v", i, want[i], rec)
+		}
+		total += n
+	}
+	if total != int64(len(stream)) {
+		t.Fatalf("expected sizes adding up to %d bytes, got %d", len(stream), total)
+	}
+	if _, _, err := ReadRecord(r); err != io.EOF {
+		t.Fatalf("expected io.EOF at the end of the stream, got %v", err)
+	}
+	if _, _, err := ReadRecord(bufio.NewReader(bytes.NewReader(stream[:len(stream)-1]))); err != nil {
+		t.Fatalf("expected the first record of a torn stream, got %v", err)
+	}
+}
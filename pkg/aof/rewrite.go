@@ -0,0 +1,194 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrRewriteInProgress is returned by Rewrite while another rewrite is running.
+var ErrRewriteInProgress = errors.New("aof: rewrite already in progress")
+
+// Rewrite replaces the log with a compact equivalent holding one OpSet or
+// OpRestore record per live key. The log as it stood when Rewrite began is folded into the
+// keys' final values and TTLs; records appended meanwhile keep going to the
+// old file and are also buffered, then copied to the end of the new one before
+// it is renamed over the old file, so no write is lost. Appends continue
+// normally afterwards.
+func (w *Writer) Rewrite() error {
+	w.mu.Lock()
+	if w.err != nil {
+		w.mu.Unlock()
+		return w.err
+	}
+	if w.rewriting {
+		w.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+	w.rewriting = true
+	w.pending = nil
+	end := w.size
+	w.mu.Unlock()
+
+	tmp, err := w.compact(end)
+	if w.compacted != nil {
+		w.compacted()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := w.pending
+	w.rewriting, w.pending = false, nil
+	if err != nil {
+		return err
+	}
+	if w.closed {
+		os.Remove(tmp)
+		return os.ErrClosed
+	}
+	return w.swap(tmp, pending)
+}
+
+// compact folds the first end bytes of the log into one record per live key
+// and writes them to a temporary file next to the log, returning its path.
+func (w *Writer) compact(end int64) (string, error) {
+	live, err := fold(w.path, end)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(live))
+	for key := range live {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	f, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".rewrite-*")
+	if err != nil {
+		return "", err
+	}
+	bw := bufio.NewWriter(f)
+	var buf []byte
+	for _, key := range keys {
+		buf = AppendRecord(buf[:0], live[key])
+		if _, err = bw.Write(buf); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// fold replays the first end bytes of the log at path and returns the
+// resulting value of every unexpired key as an OpSet or OpRestore record.
+func fold(path string, end int64) (map[string]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	live := make(map[string]Record)
+	r := bufio.NewReader(io.LimitReader(f, end))
+	for {
+		rec, _, err := ReadRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch rec.Op {
+		case OpSet, OpRestore:
+			live[rec.Key] = rec
+		case OpDel:
+			delete(live, rec.Key)
+		case OpAppend:
+			cur, ok := live[rec.Key]
+			if !ok {
+				cur = Record{Op: OpSet, Key: rec.Key}
+			}
+			cur.Value += rec.Value
+			live[rec.Key] = cur
+		case OpRename:
+			if cur, ok := live[rec.Key]; ok {
+				delete(live, rec.Key)
+				cur.Key = rec.Value
+				live[rec.Value] = cur
+			}
+		case OpFlush:
+			clear(live)
+		case OpDelPrefix:
+			for key := range live {
+				if strings.HasPrefix(key, rec.Key) {
+					delete(live, key)
+				}
+			}
+		}
+	}
+	now := time.Now()
+	for key, rec := range live {
+		if !rec.ExpireAt.IsZero() && !rec.ExpireAt.After(now) {
+			delete(live, key)
+		}
+	}
+	return live, nil
+}
+
+// swap appends pending to the compacted log at tmp, renames it over the log,
+// and reopens it for appending. The caller must hold w.mu.
+func (w *Writer) swap(tmp string, pending []byte) error {
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(pending)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, w.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	// The old file may hold records that were never fsynced, but every one of
+	// them is now in the new file, which just was.
+	w.f.Close()
+	w.f = f
+	w.dirty = false
+	w.rewriteSize = info.Size()
+	w.size = info.Size() + int64(len(pending))
+	return nil
+}
+
+// Size returns the current length of the log and its length right after the
+// last rewrite, or when it was opened if it has not been rewritten. Callers
+// compare the two to decide when a rewrite is due.
+func (w *Writer) Size() (current, rewritten int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size, w.rewriteSize
+}
@@ -0,0 +1,180 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rebuild replays the log at path into a key/value map.
+func rebuild(t *testing.T, path string) map[string]string {
+	t.Helper()
+	state := make(map[string]string)
+	recs, _ := replayAll(t, path)
+	for _, rec := range recs {
+		switch rec.Op {
+		case OpSet, OpRestore:
+			state[rec.Key] = rec.Value
+		case OpDel:
+			delete(state, rec.Key)
+		case OpAppend:
+			state[rec.Key] += rec.Value
+		case OpRename:
+			if v, ok := state[rec.Key]; ok {
+				delete(state, rec.Key)
+				state[rec.Value] = v
+			}
+		case OpFlush:
+			clear(state)
+		case OpDelPrefix:
+			for key := range state {
+				if strings.HasPrefix(key, rec.Key) {
+					delete(state, key)
+				}
+			}
+		}
+	}
+	return state
+}
+
+func TestRewriteCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	w, err := Open(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 1000; i++ {
+		w.Append(Record{Op: OpSet, Key: "counter", Value: "x"})
+	}
+	w.Append(Record{Op: OpAppend, Key: "counter", Value: "y"})
+	w.Append(Record{Op: OpSet, Key: "gone", Value: "1"})
+	w.Append(Record{Op: OpDel, Key: "gone"})
+	w.Append(Record{Op: OpSet, Key: "old", Value: "v"})
+	w.Append(Record{Op: OpRename, Key: "old", Value: "new"})
+	w.Append(Record{Op: OpSet, Key: "tenant:a", Value: "v"})
+	w.Append(Record{Op: OpSet, Key: "tenant:b", Value: "v"})
+	w.Append(Record{Op: OpDelPrefix, Key: "tenant:"})
+	w.Append(Record{Op: OpSet, Key: "stale", Value: "v", ExpireAt: time.Now().Add(-time.Second)})
+	want := rebuild(t, path)
+	before, _ := w.Size()
+
+	if err := w.Rewrite(); err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	after, rewritten := w.Size()
+	if after != rewritten || after >= before/100 {
+		t.Fatalf("expected a compact log, size went from %d to %d (rewritten %d)", before, after, rewritten)
+	}
+	recs, _ := replayAll(t, path)
+	if len(recs) != 2 {
+		t.Fatalf("expected one record per live key, got %+v", recs)
+	}
+	for _, rec := range recs {
+		if rec.Op != OpSet {
+			t.Fatalf("expected only sets, got %+v", rec)
+		}
+	}
+	delete(want, "stale")
+	if got := rebuild(t, path); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v after rewrite, got %v", want, got)
+	}
+
+	// Appends continue to the new file.
+	if err := w.Append(Record{Op: OpSet, Key: "later", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rebuild(t, path)["later"]; got != "1" {
+		t.Fatalf("expected append after rewrite to land in the new log, got %q", got)
+	}
+	if current, _ := w.Size(); current <= rewritten {
+		t.Fatalf("expected size to grow past %d, got %d", rewritten, current)
+	}
+}
+
+func TestRewriteKeepsLastRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	w, err := Open(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	expireAt := time.Now().Add(time.Hour).Truncate(0)
+	w.Append(Record{Op: OpSet, Key: "h", Value: "string"})
+	w.Append(Record{Op: OpRestore, Key: "h", Value: "dump1"})
+	w.Append(Record{Op: OpRestore, Key: "h", Value: "dump2", ExpireAt: expireAt})
+	w.Append(Record{Op: OpRestore, Key: "old", Value: "dump3"})
+	w.Append(Record{Op: OpRename, Key: "old", Value: "new"})
+	if err := w.Rewrite(); err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	recs, _ := replayAll(t, path)
+	want := []Record{
+		{Op: OpRestore, Key: "h", Value: "dump2", ExpireAt: expireAt},
+		{Op: OpRestore, Key: "new", Value: "dump3"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, recs)
+	}
+	for i, rec := range recs {
+		if rec.Op != want[i].Op || rec.Key != want[i].Key || rec.Value != want[i].Value || !rec.ExpireAt.Equal(want[i].ExpireAt) {
+			t.Fatalf("expected %+v, got %+v", want[i], rec)
+		}
+	}
+}
+
+func TestRewriteKeepsConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	w, err := Open(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Append(Record{Op: OpSet, Key: "a", Value: "1"})
+	w.Append(Record{Op: OpSet, Key: "a", Value: "2"})
+	w.compacted = func() {
+		w.Append(Record{Op: OpAppend, Key: "a", Value: "3"})
+		w.Append(Record{Op: OpSet, Key: "b", Value: "during"})
+		if err := w.Rewrite(); err != ErrRewriteInProgress {
+			t.Errorf("expected ErrRewriteInProgress, got %v", err)
+		}
+	}
+	if err := w.Rewrite(); err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	w.compacted = nil
+
+	want := map[string]string{"a": "23", "b": "during"}
+	if got := rebuild(t, path); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRewriteLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.aof")
+	w, err := Open(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(Record{Op: OpSet, Key: "a", Value: "1"})
+	if err := w.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "cache.aof" {
+		t.Fatalf("expected only the log in %s, found %v", dir, entries)
+	}
+}
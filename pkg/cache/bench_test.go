@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Baseline numbers, from go test -run '^$' -bench . -benchmem on a
+// single-vCPU Intel Xeon VM with Go 1.23, so the parallel benchmarks show no
+// lock contention. Compare against them when touching the hot paths:
+//
+//	BenchmarkShardedCacheSet                     300.6 ns/op    8 B/op    1 allocs/op
+//	BenchmarkShardedCacheGetHit                  241.4 ns/op    5 B/op    1 allocs/op
+//	BenchmarkShardedCacheGetMiss                  70.7 ns/op    0 B/op    0 allocs/op
+//	BenchmarkParallelMixed/read-90               269.0 ns/op    5 B/op    1 allocs/op
+//	BenchmarkParallelMixed/read-50               288.2 ns/op    6 B/op    1 allocs/op
+//	BenchmarkShardCounts/shards-1                243.4 ns/op    5 B/op    1 allocs/op
+//	BenchmarkShardCounts/shards-16               273.5 ns/op    5 B/op    1 allocs/op
+//	BenchmarkShardCounts/shards-256              249.4 ns/op    5 B/op    1 allocs/op
+//	BenchmarkGetShard                             18.6 ns/op    0 B/op    0 allocs/op
+
+// benchmarkKeySpace is the number of distinct keys the benchmarks touch.
+const benchmarkKeySpace = 1 << 16
+
+// zipfKeys returns n keys drawn from benchmarkKeys(space) with a Zipfian
+// distribution, so a few hot keys take most of the accesses as in real
+// workloads. The sequence is the same on every call.
+func zipfKeys(n, space int) []string {
+	keys := benchmarkKeys(space)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, uint64(space-1))
+	seq := make([]string, n)
+	for i := range seq {
+		seq[i] = keys[zipf.Uint64()]
+	}
+	return seq
+}
+
+// filledCache returns a cache holding every key of benchmarkKeys(space).
+func filledCache(space int, opts ...Option) *ShardedCache {
+	cache := NewShardedCache(append([]Option{WithShardCapacity(0)}, opts...)...)
+	for _, key := range benchmarkKeys(space) {
+		cache.Set(key, "value")
+	}
+	return cache
+}
+
+func BenchmarkShardedCacheSet(b *testing.B) {
+	cache := NewShardedCache(WithShardCapacity(0))
+	keys := zipfKeys(benchmarkKeySpace, benchmarkKeySpace)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(keys[i%len(keys)], "value")
+	}
+}
+
+func BenchmarkShardedCacheGetHit(b *testing.B) {
+	cache := filledCache(benchmarkKeySpace)
+	keys := zipfKeys(benchmarkKeySpace, benchmarkKeySpace)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.Get(keys[i%len(keys)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkShardedCacheGetMiss(b *testing.B) {
+	cache := filledCache(benchmarkKeySpace)
+	keys := make([]string, benchmarkKeySpace)
+	for i := range keys {
+		keys[i] = "missing-" + strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.Get(keys[i%len(keys)]); err == nil {
+			b.Fatal("expected a miss")
+		}
+	}
+}
+
+// benchmarkMixed runs goroutines that each Get a Zipfian key readPercent
+// times in a hundred and Set it otherwise.
+func benchmarkMixed(b *testing.B, cache *ShardedCache, readPercent int) {
+	keys := zipfKeys(benchmarkKeySpace, benchmarkKeySpace)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine starts at its own offset so they do not hit the
+		// same key in lockstep.
+		i := rand.Intn(len(keys))
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%100 < readPercent {
+				cache.Get(key)
+			} else {
+				cache.Set(key, "value")
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelMixed(b *testing.B) {
+	for _, readPercent := range []int{90, 50} {
+		b.Run("read-"+strconv.Itoa(readPercent), func(b *testing.B) {
+			benchmarkMixed(b, filledCache(benchmarkKeySpace), readPercent)
+		})
+	}
+}
+
+func BenchmarkShardCounts(b *testing.B) {
+	for _, shards := range []int{1, 16, 256} {
+		b.Run("shards-"+strconv.Itoa(shards), func(b *testing.B) {
+			benchmarkMixed(b, filledCache(benchmarkKeySpace, WithShardCount(shards)), 90)
+		})
+	}
+}
+
+func BenchmarkGetShard(b *testing.B) {
+	cache := NewShardedCache()
+	keys := zipfKeys(benchmarkKeySpace, benchmarkKeySpace)
+	var sink *Shard
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sink = cache.getShard(keys[i%len(keys)])
+	}
+	_ = sink
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"math/bits"
+	"time"
+)
+
+// DefaultMaxBitOffset is the largest offset SetBit accepts unless
+// WithMaxBitOffset says otherwise, which caps a bitmap at 8 MiB.
+const DefaultMaxBitOffset = 8<<23 - 1
+
+// WithMaxBitOffset sets the largest offset SetBit and GetBit accept, so that a
+// single SetBit cannot allocate a huge value. The default is
+// DefaultMaxBitOffset.
+func WithMaxBitOffset(n int) Option {
+	return func(sc *ShardedCache) {
+		if n > 0 {
+			sc.maxBitOffset = n
+		}
+	}
+}
+
+// SetBit sets or clears the bit at offset in the string stored at key, which
+// is treated as a byte array with bit 0 the most significant bit of the first
+// byte, and returns the bit's previous value. The value is zero-padded as
+// needed to reach offset, and a missing key is created with the default TTL.
+// Growth counts against the byte budget configured with WithMaxBytes. It
+// returns ErrBitOffset for an offset outside [0, WithMaxBitOffset],
+// ErrValueTooLarge if the value would grow past the WithMaxValueBytes limit,
+// and ErrWrongType if key holds another kind of value. Because stored values
+// are never modified in place, each change copies the value. Like SetNX, it
+// does not call the write-through function.
+func (sc *ShardedCache) SetBit(key string, offset int, value bool) (bool, error) {
+	if offset < 0 || offset > sc.maxBitOffset {
+		return false, ErrBitOffset
+	}
+	old, evicted, err := sc.getShard(key).setBit(key, offset, value, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return old, err
+}
+
+// GetBit returns the bit at offset in the string stored at key, with the same
+// layout as SetBit. Bits past the end of the value, and those of a missing
+// key, are zero.
+func (sc *ShardedCache) GetBit(key string, offset int) (bool, error) {
+	if offset < 0 || offset > sc.maxBitOffset {
+		return false, ErrBitOffset
+	}
+	var bit bool
+	err := sc.getShard(key).viewString(key, func(value []byte) {
+		if i := offset / 8; i < len(value) {
+			bit = value[i]&bitMask(offset) != 0
+		}
+	})
+	return bit, err
+}
+
+// BitCount returns the number of set bits in the string stored at key, or 0
+// if key is missing.
+func (sc *ShardedCache) BitCount(key string) (int, error) {
+	n := 0
+	err := sc.getShard(key).viewString(key, func(value []byte) {
+		for _, b := range value {
+			n += bits.OnesCount8(b)
+		}
+	})
+	return n, err
+}
+
+// bitMask selects the bit at offset within its byte.
+func bitMask(offset int) byte {
+	return 0x80 >> (offset % 8)
+}
+
+// setBit changes one bit of the value at key and returns its previous value.
+func (s *Shard) setBit(key string, offset int, bit bool, ttl time.Duration) (bool, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, ok := s.live(key, s.clock().UnixNano())
+	var current []byte
+	if ok {
+		if elem.Value.(*entry).kind != kindString {
+			return false, nil, ErrWrongType
+		}
+		current = elem.Value.(*entry).plain()
+	}
+	i, mask := offset/8, bitMask(offset)
+	if i < len(current) && (current[i]&mask != 0) == bit {
+		return bit, nil, nil
+	}
+	if i >= len(current) && s.maxValueBytes > 0 && i+1 > s.maxValueBytes {
+		return false, nil, ErrValueTooLarge
+	}
+	value := make([]byte, max(len(current), i+1))
+	copy(value, current)
+	old := value[i]&mask != 0
+	if bit {
+		value[i] |= mask
+	} else {
+		value[i] &^= mask
+	}
+	if !ok {
+		return old, s.setLocked(key, value, ttl), nil
+	}
+	return old, s.replaceValue(elem, value), nil
+}
+
+// viewString calls fn with the string value at key under the shard lock,
+// promoting the entry and counting a hit or miss; fn must not retain it. A
+// missing key is passed as a nil value.
+func (s *Shard) viewString(key string, fn func(value []byte)) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindString, now.UnixNano())
+	if err == ErrKeyNotFound {
+		s.stats.misses.Add(1)
+		fn(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.readObject(elem, now)
+	fn(elem.Value.(*entry).plain())
+	return nil
+}
@@ -0,0 +1,94 @@
+package cache
+
+import "testing"
+
+func TestSetBitGetBit(t *testing.T) {
+	c := NewShardedCache()
+	if old, err := c.SetBit("active", 10, true); err != nil || old {
+		t.Fatalf("expected a fresh bit, got %v, %v", old, err)
+	}
+	if v, _ := c.Get("active"); v != "\x00\x20" {
+		t.Fatalf("expected bit 10 to be the third bit of the second byte, got %q", v)
+	}
+	if old, _ := c.SetBit("active", 10, true); !old {
+		t.Fatal("expected the previous bit to be set")
+	}
+	if bit, err := c.GetBit("active", 10); err != nil || !bit {
+		t.Fatalf("expected bit 10 set, got %v, %v", bit, err)
+	}
+	if bit, _ := c.GetBit("active", 1000); bit {
+		t.Fatal("expected bits past the end to be zero")
+	}
+	if bit, err := c.GetBit("missing", 0); err != nil || bit {
+		t.Fatalf("expected a missing key to read as zero, got %v, %v", bit, err)
+	}
+
+	c.SetBit("active", 0, true)
+	c.SetBit("active", 7, true)
+	if n, _ := c.BitCount("active"); n != 3 {
+		t.Fatalf("expected 3 bits set, got %d", n)
+	}
+	if old, _ := c.SetBit("active", 10, false); !old {
+		t.Fatal("expected clearing to report the set bit")
+	}
+	if n, _ := c.BitCount("active"); n != 2 {
+		t.Fatalf("expected 2 bits set, got %d", n)
+	}
+	if n, err := c.BitCount("missing"); err != nil || n != 0 {
+		t.Fatalf("expected no bits for a missing key, got %d, %v", n, err)
+	}
+}
+
+func TestSetBitKeepsReadersSafe(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("k", "\x00")
+	before, _ := c.GetBytes("k")
+	c.SetBit("k", 0, true)
+	if before[0] != 0 {
+		t.Fatal("expected SetBit not to modify a value a reader holds")
+	}
+}
+
+func TestSetBitLimits(t *testing.T) {
+	c := NewShardedCache(WithMaxBitOffset(63), WithMaxValueBytes(4))
+	if _, err := c.SetBit("k", 64, true); err != ErrBitOffset {
+		t.Fatalf("expected ErrBitOffset past the limit, got %v", err)
+	}
+	if _, err := c.SetBit("k", -1, true); err != ErrBitOffset {
+		t.Fatalf("expected ErrBitOffset for a negative offset, got %v", err)
+	}
+	if _, err := c.GetBit("k", 64); err != ErrBitOffset {
+		t.Fatalf("expected GetBit to share the limit, got %v", err)
+	}
+	if _, err := c.SetBit("k", 40, true); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge past WithMaxValueBytes, got %v", err)
+	}
+	if c.Exists("k") {
+		t.Fatal("expected a rejected SetBit to store nothing")
+	}
+
+	c.HSet("h", "f", "v")
+	if _, err := c.SetBit("h", 0, true); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType on a hash, got %v", err)
+	}
+	if _, err := c.BitCount("h"); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType on a hash, got %v", err)
+	}
+}
+
+func TestSetBitCountsTowardsMaxBytes(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1))
+	c.SetBit("bits", 0, true)
+	small := c.MemoryUsage()
+	c.SetBit("bits", 8*99, true)
+	if got := c.MemoryUsage(); got != small+99 {
+		t.Fatalf("expected growth to 100 bytes to add 99 to %d, got %d", small, got)
+	}
+
+	c = NewShardedCache(WithShardCount(1), WithMaxBytes(400))
+	c.Set("old", "v")
+	c.SetBit("bits", 8*250, true)
+	if c.Exists("old") || !c.Exists("bits") {
+		t.Fatal("expected a growing bitmap to evict older entries")
+	}
+}
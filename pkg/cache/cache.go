@@ -1,44 +1,203 @@
 package cache
 
 import (
-	"errors"
 	"sync"
+	"time"
 )
 
+// item holds a cached value along with its expiration time.
+type item struct {
+	value     string
+	expiresAt int64 // Unix nanoseconds; zero means the item never expires.
+}
+
+// expired reports whether the item has passed its expiration time.
+func (it item) expired(now int64) bool {
+	return it.expiresAt > 0 && now >= it.expiresAt
+}
+
+// cacheStripes is the number of independently locked maps in a Cache. It must
+// be a power of two.
+const cacheStripes = 32
+
+// stripe is one independently locked partition of a Cache.
+type stripe struct {
+	mu   sync.RWMutex
+	data map[string]item
+}
+
 // Cache represents a simple thread-safe in-memory key-value store.
+// Keys are spread over lock stripes so operations on different keys rarely
+// contend.
 type Cache struct {
-	mu   sync.RWMutex
-	data map[string]string
+	stripes [cacheStripes]stripe
 }
 
 // NewCache creates and returns a new Cache instance.
 func NewCache() *Cache {
-	return &Cache{
-		data: make(map[string]string),
+	c := &Cache{}
+	for i := range c.stripes {
+		c.stripes[i].data = make(map[string]item)
 	}
+	return c
+}
+
+// stripe returns the stripe that owns key.
+func (c *Cache) stripe(key string) *stripe {
+	return &c.stripes[fnv32a(key)&(cacheStripes-1)]
 }
 
-// Set inserts or updates the value for a given key.
+// Set inserts or updates the value for a given key. The value never expires.
 func (c *Cache) Set(key, value string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.data[key] = value
+	c.SetWithTTL(key, value, DefaultExpiration)
 }
 
-// Get retrieves the value for a given key. Returns an error if the key is not found.
+// SetWithTTL inserts or updates the value for a given key, expiring it after ttl.
+// A Cache has no default TTL, so DefaultExpiration (zero) and NoExpiration
+// both store the value without expiration.
+func (c *Cache) SetWithTTL(key, value string, ttl time.Duration) {
+	s := c.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = item{value: value, expiresAt: expiration(ttl)}
+}
+
+// SetNX stores value under key only if the key is absent or expired.
+// It reports whether the value was stored.
+func (c *Cache) SetNX(key, value string) bool {
+	return c.SetNXWithTTL(key, value, DefaultExpiration)
+}
+
+// SetNXWithTTL is like SetNX but expires the stored value after ttl, which
+// accepts the same sentinels as SetWithTTL.
+func (c *Cache) SetNXWithTTL(key, value string, ttl time.Duration) bool {
+	s := c.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if it, exists := s.data[key]; exists && !it.expired(time.Now().UnixNano()) {
+		return false
+	}
+	s.data[key] = item{value: value, expiresAt: expiration(ttl)}
+	return true
+}
+
+// Get retrieves the value for a given key. Returns ErrKeyNotFound if the key is
+// not found or has expired.
 func (c *Cache) Get(key string) (string, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	value, exists := c.data[key]
-	if !exists {
-		return "", errors.New("key not found")
+	s := c.stripe(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it, exists := s.data[key]
+	if !exists || it.expired(time.Now().UnixNano()) {
+		return "", ErrKeyNotFound
+	}
+	return it.value, nil
+}
+
+// GetDel returns the value for key and removes it under a single lock.
+// A missing or expired key yields the same error as Get.
+func (c *Cache) GetDel(key string) (string, error) {
+	s := c.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, exists := s.data[key]
+	if !exists || it.expired(time.Now().UnixNano()) {
+		return "", ErrKeyNotFound
 	}
-	return value, nil
+	delete(s.data, key)
+	return it.value, nil
+}
+
+// Exists reports whether key is present and unexpired.
+func (c *Cache) Exists(key string) bool {
+	s := c.stripe(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it, exists := s.data[key]
+	return exists && !it.expired(time.Now().UnixNano())
+}
+
+// Expire sets key to expire after ttl from now; a ttl of zero or less removes
+// its expiration. It reports whether the key exists.
+func (c *Cache) Expire(key string, ttl time.Duration) bool {
+	s := c.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, exists := s.data[key]
+	if !exists || it.expired(time.Now().UnixNano()) {
+		return false
+	}
+	it.expiresAt = expiration(ttl)
+	s.data[key] = it
+	return true
+}
+
+// Keys returns all unexpired keys in the cache, in no particular order. Keys
+// are collected one stripe at a time, so the result is not an atomic snapshot.
+func (c *Cache) Keys() []string {
+	now := time.Now().UnixNano()
+	var keys []string
+	for i := range c.stripes {
+		s := &c.stripes[i]
+		s.mu.RLock()
+		for key, it := range s.data {
+			if !it.expired(now) {
+				keys = append(keys, key)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return keys
 }
 
 // Delete removes a key-value pair from the cache.
 func (c *Cache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.data, key)
+	s := c.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+}
+
+// Clear removes all items from the cache. Stripes are cleared one at a time, so
+// concurrent writers may repopulate an already cleared stripe before Clear
+// returns.
+func (c *Cache) Clear() {
+	for i := range c.stripes {
+		s := &c.stripes[i]
+		s.mu.Lock()
+		s.data = make(map[string]item)
+		s.mu.Unlock()
+	}
+}
+
+// MaxValueBytes returns zero: a Cache does not limit value sizes.
+func (c *Cache) MaxValueBytes() int {
+	return 0
+}
+
+// Len returns the number of items in the cache, including expired items that
+// have not been removed yet.
+func (c *Cache) Len() int {
+	n := 0
+	for i := range c.stripes {
+		s := &c.stripes[i]
+		s.mu.RLock()
+		n += len(s.data)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// expiration converts a TTL into an absolute expiration time in Unix nanoseconds.
+// A ttl of zero or less yields zero, meaning no expiration.
+func expiration(ttl time.Duration) int64 {
+	return expirationFrom(time.Now(), ttl)
+}
+
+// expirationFrom is expiration relative to now.
+func expirationFrom(now time.Time, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now.Add(ttl).UnixNano()
 }
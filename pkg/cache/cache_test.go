@@ -1,6 +1,12 @@
 package cache
 
-import "testing"
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestCacheSetAndGet(t *testing.T) {
 	c := NewCache()
@@ -19,8 +25,8 @@ func TestCacheSetAndGet(t *testing.T) {
 func TestCacheGetNonExistent(t *testing.T) {
 	c := NewCache()
 	_, err := c.Get("nonexistent")
-	if err == nil {
-		t.Fatal("expected an error for a nonexistent key")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound for a nonexistent key, got %v", err)
 	}
 }
 
@@ -35,3 +41,201 @@ func TestCacheDelete(t *testing.T) {
 		t.Fatal("expected an error after deleting the key")
 	}
 }
+
+func TestCacheSetWithTTLExpires(t *testing.T) {
+	c := NewCache()
+	c.SetWithTTL("short", "lived", 20*time.Millisecond)
+	c.SetWithTTL("long", "lived", time.Hour)
+
+	if v, err := c.Get("short"); err != nil || v != "lived" {
+		t.Fatalf("expected key 'short' to exist before expiry, got %v, %v", v, err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, err := c.Get("short"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected key 'short' to have expired, got %v", err)
+	}
+	if v, err := c.Get("long"); err != nil || v != "lived" {
+		t.Fatalf("expected key 'long' to still exist, got %v, %v", v, err)
+	}
+}
+
+func TestCacheSetWithTTLOverwrite(t *testing.T) {
+	c := NewCache()
+	c.SetWithTTL("key", "first", 20*time.Millisecond)
+	c.SetWithTTL("key", "second", time.Hour)
+
+	time.Sleep(40 * time.Millisecond)
+
+	value, err := c.Get("key")
+	if err != nil {
+		t.Fatalf("expected overwritten key to use the new TTL, got error: %v", err)
+	}
+	if value != "second" {
+		t.Fatalf("expected value 'second', got %q", value)
+	}
+
+	// Overwriting with a zero TTL removes the expiration.
+	c.SetWithTTL("key", "third", 20*time.Millisecond)
+	c.Set("key", "forever")
+	time.Sleep(40 * time.Millisecond)
+	if v, err := c.Get("key"); err != nil || v != "forever" {
+		t.Fatalf("expected key without TTL to persist, got %v, %v", v, err)
+	}
+}
+
+func TestCacheLen(t *testing.T) {
+	c := NewCache()
+	if n := c.Len(); n != 0 {
+		t.Fatalf("expected empty cache to have length 0, got %d", n)
+	}
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Set("a", "3")
+	if n := c.Len(); n != 2 {
+		t.Fatalf("expected length 2, got %d", n)
+	}
+	c.Delete("a")
+	if n := c.Len(); n != 1 {
+		t.Fatalf("expected length 1 after delete, got %d", n)
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	c := NewCache()
+	c.Set("a", "1")
+	c.Set("b", "2")
+
+	c.Clear()
+
+	if n := c.Len(); n != 0 {
+		t.Fatalf("expected empty cache after Clear, got length %d", n)
+	}
+	if _, err := c.Get("a"); err == nil {
+		t.Fatal("expected key 'a' to be cleared")
+	}
+	c.Set("a", "3")
+	if v, err := c.Get("a"); err != nil || v != "3" {
+		t.Fatalf("expected cache to be usable after Clear, got %v, %v", v, err)
+	}
+}
+
+func TestCacheExists(t *testing.T) {
+	c := NewCache()
+	c.Set("present", "v")
+	c.SetWithTTL("expired", "v", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if !c.Exists("present") {
+		t.Fatal("expected key 'present' to exist")
+	}
+	if c.Exists("expired") {
+		t.Fatal("expected expired key not to exist")
+	}
+	if c.Exists("missing") {
+		t.Fatal("expected missing key not to exist")
+	}
+}
+
+func TestCacheSetNX(t *testing.T) {
+	c := NewCache()
+	if !c.SetNX("lock", "owner-1") {
+		t.Fatal("expected SetNX on a missing key to succeed")
+	}
+	if c.SetNX("lock", "owner-2") {
+		t.Fatal("expected SetNX on an existing key to fail")
+	}
+	if v, _ := c.Get("lock"); v != "owner-1" {
+		t.Fatalf("expected original owner to keep the key, got %v", v)
+	}
+
+	c.SetNXWithTTL("lease", "owner-1", 20*time.Millisecond)
+	if c.SetNXWithTTL("lease", "owner-2", time.Hour) {
+		t.Fatal("expected SetNXWithTTL to fail while the lease is live")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !c.SetNXWithTTL("lease", "owner-2", time.Hour) {
+		t.Fatal("expected SetNXWithTTL to succeed after the lease expired")
+	}
+}
+
+func TestCacheGetDel(t *testing.T) {
+	c := NewCache()
+	c.Set("token", "secret")
+
+	v, err := c.GetDel("token")
+	if err != nil || v != "secret" {
+		t.Fatalf("expected GetDel to return 'secret', got %v, %v", v, err)
+	}
+	if c.Exists("token") {
+		t.Fatal("expected GetDel to remove the key")
+	}
+	if _, err := c.GetDel("token"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected second GetDel to report key not found, got %v", err)
+	}
+}
+
+func TestCacheStripedConcurrentAccess(t *testing.T) {
+	c := NewCache()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := strconv.Itoa(g*100 + i)
+				c.Set(key, strconv.Itoa(i))
+				if v, err := c.Get(key); err != nil || v != strconv.Itoa(i) {
+					t.Errorf("Get(%q) = %q, %v; want %d", key, v, err, i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := c.Len(); n != 800 {
+		t.Fatalf("expected 800 items, got %d", n)
+	}
+	c.Clear()
+	if n := c.Len(); n != 0 {
+		t.Fatalf("expected empty cache after Clear, got %d", n)
+	}
+}
+
+// lockedMap is the single-mutex design Cache used before lock striping,
+// kept as a benchmark baseline.
+type lockedMap struct {
+	mu   sync.RWMutex
+	data map[string]item
+}
+
+func (m *lockedMap) Set(key, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = item{value: value}
+}
+
+// Run with -cpu=1,4,16 to compare how writes scale.
+func BenchmarkCacheSetParallel(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	for _, bc := range []struct {
+		name string
+		set  func(string, string)
+	}{
+		{"single-lock", (&lockedMap{data: make(map[string]item)}).Set},
+		{"striped", NewCache().Set},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					bc.set(keys[i%len(keys)], "value")
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// WithCompression stores string values longer than threshold bytes
+// Snappy-compressed, which suits large, repetitive values such as JSON
+// documents. Values that do not shrink are stored as they are. Reads
+// decompress the value, so every hit on a compressed entry costs an
+// allocation and a decode; GetBytes returns a fresh slice for such entries
+// regardless of WithCopyOnRead. Byte budgets and MemoryUsage count the
+// compressed size, and Stats reports the bytes saved. A threshold of zero or
+// less, the default, disables compression.
+func WithCompression(threshold int) Option {
+	return func(sc *ShardedCache) {
+		sc.compressThreshold = max(threshold, 0)
+	}
+}
+
+// storeValue sets ent's value, compressing it if the shard compresses values
+// of its size and compression makes it smaller. The caller must hold the
+// shard lock and account for the change in ent's size.
+func (s *Shard) storeValue(ent *entry, value []byte) {
+	ent.value, ent.compressed = value, false
+	if s.compressThreshold <= 0 || len(value) <= s.compressThreshold {
+		return
+	}
+	if encoded := s2.EncodeSnappy(nil, value); len(encoded) < len(value) {
+		ent.value, ent.compressed = encoded, true
+	}
+}
+
+// plain returns a view of the entry's value that readers may hold after the
+// shard lock is released, decompressing it if it is stored compressed.
+func (e *entry) plain() []byte {
+	if !e.compressed {
+		return readValue(e.value)
+	}
+	value, err := s2.Decode(nil, e.value)
+	if err != nil {
+		// The shard encoded the value itself, so it cannot be corrupt.
+		panic(fmt.Sprintf("cache: decoding compressed value of %q: %v", e.key, err))
+	}
+	return value
+}
+
+// saved returns how many bytes compression saves on the entry's value.
+func (e *entry) saved() int64 {
+	if !e.compressed {
+		return 0
+	}
+	n, err := s2.DecodedLen(e.value)
+	if err != nil {
+		return 0
+	}
+	return int64(n - len(e.value))
+}
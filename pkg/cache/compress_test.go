@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// jsonBlob returns a repetitive JSON document of about n bytes, which
+// compresses well like the values WithCompression is meant for.
+func jsonBlob(n int) string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i := 0; b.Len() < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(`{"id":` + strconv.Itoa(i) + `,"name":"user","active":true,"tags":["a","b"]}`)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+func TestCompression(t *testing.T) {
+	c := NewShardedCache(WithCompression(64), WithShardCount(1))
+	blob := jsonBlob(4096)
+	c.Set("big", blob)
+	c.Set("small", "value")
+
+	ent, _ := c.getShard("big").peekEntry("big")
+	if !ent.compressed || len(ent.value) >= len(blob)/2 {
+		t.Fatalf("expected the large value to be stored compressed, got %d bytes", len(ent.value))
+	}
+	if ent, _ := c.getShard("small").peekEntry("small"); ent.compressed {
+		t.Fatal("expected a value under the threshold to be stored as is")
+	}
+	if v, err := c.Get("big"); err != nil || v != blob {
+		t.Fatalf("expected the value back from Get, got %d bytes, %v", len(v), err)
+	}
+	if v, err := c.GetBytes("big"); err != nil || string(v) != blob {
+		t.Fatalf("expected the value back from GetBytes, got %d bytes, %v", len(v), err)
+	}
+
+	saved := int64(len(blob) - len(ent.value))
+	if got := c.Stats().BytesSaved; got != saved {
+		t.Fatalf("expected %d bytes saved, got %d", saved, got)
+	}
+	want := ent.size() + int64(len("small")+len("value")) + entryOverhead
+	if got := c.MemoryUsage(); got != want {
+		t.Fatalf("expected memory usage of the compressed size %d, got %d", want, got)
+	}
+
+	c.Delete("big")
+	if got := c.Stats().BytesSaved; got != 0 {
+		t.Fatalf("expected no bytes saved after Delete, got %d", got)
+	}
+}
+
+func TestCompressionIncompressible(t *testing.T) {
+	c := NewShardedCache(WithCompression(16))
+	value := make([]byte, 256)
+	rand.New(rand.NewSource(1)).Read(value)
+	c.SetBytes("k", value)
+	if ent, _ := c.getShard("k").peekEntry("k"); ent.compressed {
+		t.Fatal("expected a value that does not shrink to be stored as is")
+	}
+	if v, _ := c.GetBytes("k"); !bytes.Equal(v, value) {
+		t.Fatal("expected the value back")
+	}
+}
+
+func TestCompressionWrites(t *testing.T) {
+	c := NewShardedCache(WithCompression(64))
+	blob := jsonBlob(1024)
+	c.Set("k", blob)
+
+	if n, err := c.Append("k", "tail"); err != nil || n != len(blob)+4 {
+		t.Fatalf("expected Append to extend the value to %d bytes, got %d, %v", len(blob)+4, n, err)
+	}
+	if v, _ := c.Get("k"); v != blob+"tail" {
+		t.Fatal("expected the appended value back")
+	}
+	if ok, err := c.CompareAndSwap("k", blob+"tail", blob); !ok || err != nil {
+		t.Fatalf("expected CompareAndSwap to match the compressed value, got %v, %v", ok, err)
+	}
+	if err := c.Rename("k", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("renamed"); v != blob {
+		t.Fatal("expected the renamed value back")
+	}
+
+	payload, err := c.Dump("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := NewShardedCache()
+	if err := plain.Restore("k", payload, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := plain.Get("k"); v != blob {
+		t.Fatal("expected a dump to restore on a cache without compression")
+	}
+
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	if err := c.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := plain.Get("renamed"); v != blob {
+		t.Fatal("expected a snapshot to load on a cache without compression")
+	}
+	if got := plain.Stats().BytesSaved; got != 0 {
+		t.Fatalf("expected no bytes saved without compression, got %d", got)
+	}
+}
+
+// BenchmarkCompressedGetHit measures what compression adds to a hit on a 4 KiB
+// JSON value, the cost paid by hit-heavy workloads. On the machine of the
+// baseline numbers in bench_test.go, decoding about doubles the cost of a hit
+// while the entries take an eighth of the memory:
+//
+//	BenchmarkCompressedGetHit/plain        979.5 ns/op   4219 B/entry   4864 B/op   1 allocs/op
+//	BenchmarkCompressedGetHit/compressed    2113 ns/op  532.9 B/entry   9728 B/op   2 allocs/op
+func BenchmarkCompressedGetHit(b *testing.B) {
+	blob := jsonBlob(4096)
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"plain", nil},
+		{"compressed", []Option{WithCompression(1024)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := NewShardedCache(append([]Option{WithShardCapacity(0)}, bc.opts...)...)
+			keys := benchmarkKeys(1024)
+			for _, key := range keys {
+				cache.Set(key, blob)
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(blob)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := cache.Get(keys[i%len(keys)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(cache.MemoryUsage())/float64(len(keys)), "B/entry")
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// dumpVersion is the first byte of every Dump payload. Restore also accepts
+// version 1 payloads, which predate the kind byte and hold only strings, and
+// rejects any other version.
+const dumpVersion = 2
+
+// Dump serializes key's value, TTL, and remaining lifetime into a payload that
+// Restore accepts, possibly on another cache. The payload is a version byte,
+// a byte giving the value's kind, the uvarint-prefixed value, the varint TTL
+// and remaining lifetime in nanoseconds (zero meaning no expiration), and a
+// CRC-32 of everything before it. Values of kinds other than strings, such as
+// hashes, are serialized whole. Dump does not count as an access.
+func (sc *ShardedCache) Dump(key string) ([]byte, error) {
+	ent, ok := sc.getShard(key).dumpEntry(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	var remaining int64
+	if ent.expiresAt > 0 {
+		remaining = ent.expiresAt - sc.clock().UnixNano()
+	}
+	value := ent.plain()
+	p := make([]byte, 0, 2+3*binary.MaxVarintLen64+len(value)+4)
+	p = append(p, dumpVersion, byte(ent.kind))
+	p = binary.AppendUvarint(p, uint64(len(value)))
+	p = append(p, value...)
+	p = binary.AppendVarint(p, int64(ent.ttl))
+	p = binary.AppendVarint(p, remaining)
+	return binary.BigEndian.AppendUint32(p, crc32.ChecksumIEEE(p)), nil
+}
+
+// Restore stores the value from a Dump payload under key. A positive ttl sets
+// the remaining lifetime; zero keeps the one carried in the payload. Restore
+// returns ErrKeyExists if key is present and replace is false, an error
+// wrapping ErrCorruptDump if the payload is damaged, and ErrValueTooLarge if
+// a string value exceeds the WithMaxValueBytes limit. Like LoadFromFile, it
+// does not call the write-through function.
+func (sc *ShardedCache) Restore(key string, payload []byte, ttl time.Duration, replace bool) error {
+	kind, value, entTTL, remaining, err := decodeDump(payload)
+	if err != nil {
+		return err
+	}
+	ent := &entry{key: key, ttl: entTTL, freq: 1, kind: kind}
+	if kind == kindString {
+		if sc.tooLarge(value) {
+			return ErrValueTooLarge
+		}
+		ent.value = value
+	} else if ent.object, ent.objectSize, err = decodeObject(kind, value); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptDump, err)
+	}
+	if ttl > 0 {
+		remaining = ttl
+	}
+	if remaining > 0 {
+		ent.expiresAt = sc.clock().Add(remaining).UnixNano()
+	}
+	stored, evicted := sc.getShard(key).restoreEntry(ent, replace)
+	sc.notifyEvicted(evicted)
+	if !stored {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+// decodeDump verifies and parses a Dump payload.
+func decodeDump(p []byte) (kind valueKind, value []byte, ttl, remaining time.Duration, err error) {
+	if len(p) < 5 {
+		return 0, nil, 0, 0, fmt.Errorf("%w: too short", ErrCorruptDump)
+	}
+	body, sum := p[:len(p)-4], binary.BigEndian.Uint32(p[len(p)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return 0, nil, 0, 0, fmt.Errorf("%w: checksum mismatch", ErrCorruptDump)
+	}
+	switch body[0] {
+	case 1:
+		body = body[1:]
+	case dumpVersion:
+		if len(body) < 2 || valueKind(body[1]) > kindZSet {
+			return 0, nil, 0, 0, fmt.Errorf("%w: bad kind", ErrCorruptDump)
+		}
+		kind, body = valueKind(body[1]), body[2:]
+	default:
+		return 0, nil, 0, 0, fmt.Errorf("%w: unsupported version %d", ErrCorruptDump, body[0])
+	}
+	n, k := binary.Uvarint(body)
+	if k <= 0 || uint64(len(body)-k) < n {
+		return 0, nil, 0, 0, fmt.Errorf("%w: bad value length", ErrCorruptDump)
+	}
+	value = append([]byte(nil), body[k:k+int(n)]...)
+	body = body[k+int(n):]
+	t, k := binary.Varint(body)
+	if k <= 0 {
+		return 0, nil, 0, 0, fmt.Errorf("%w: bad ttl", ErrCorruptDump)
+	}
+	r, k2 := binary.Varint(body[k:])
+	if k2 <= 0 || k+k2 != len(body) || r < 0 {
+		return 0, nil, 0, 0, fmt.Errorf("%w: bad remaining lifetime", ErrCorruptDump)
+	}
+	return kind, value, time.Duration(t), time.Duration(r), nil
+}
+
+// peekEntry returns a copy of key's unexpired entry without affecting its LRU
+// position or access statistics. The copy shares the entry's value, which is
+// never modified in place.
+func (s *Shard) peekEntry(key string) (entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.data[key]
+	if !ok || elem.Value.(*entry).expired(s.clock().UnixNano()) {
+		return entry{}, false
+	}
+	return *elem.Value.(*entry), true
+}
+
+// dumpEntry is like peekEntry but returns a detached copy, whose value holds
+// the encoding of an object.
+func (s *Shard) dumpEntry(key string) (entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.data[key]
+	if !ok || elem.Value.(*entry).expired(s.clock().UnixNano()) {
+		return entry{}, false
+	}
+	return elem.Value.(*entry).detached(), true
+}
+
+// restoreEntry inserts ent unless its key holds an unexpired entry and replace
+// is false. It reports whether ent was stored and returns the entries evicted
+// to make room.
+func (s *Shard) restoreEntry(ent *entry, replace bool) (bool, []entry) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if !replace {
+		if elem, ok := s.data[ent.key]; ok && !elem.Value.(*entry).expired(s.clock().UnixNano()) {
+			return false, nil
+		}
+	}
+	s.stats.sets.Add(1)
+	return true, s.insertLocked(ent)
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDumpRestore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	src := NewShardedCache(WithClock(clock.Now))
+	src.SetWithTTL("session", "alice", time.Minute)
+	src.Set("forever", "ok")
+
+	payload, err := src.Dump("session")
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	clock.Advance(20 * time.Second)
+
+	dst := NewShardedCache(WithClock(clock.Now))
+	if err := dst.Restore("session", payload, 0, false); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if v, err := dst.Get("session"); err != nil || v != "alice" {
+		t.Fatalf("expected alice, got %q, %v", v, err)
+	}
+	if _, at, err := dst.PeekWithExpiry("session"); err != nil || !at.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("expected expiry a minute from now, got %v, %v", at, err)
+	}
+	clock.Advance(59 * time.Second)
+	if _, err := dst.Get("session"); err != nil {
+		t.Fatalf("expected the remaining TTL to restart at Restore, got %v", err)
+	}
+	clock.Advance(2 * time.Second)
+	if _, err := dst.Get("session"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected session to expire, got %v", err)
+	}
+
+	payload, err = src.Dump("forever")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Restore("copy", payload, time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Second)
+	if _, err := dst.Get("copy"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected an explicit ttl to override the payload, got %v", err)
+	}
+
+	if _, err := src.Dump("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestRestoreExistingKey(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("a", "old")
+	c.Set("b", "new")
+	payload, _ := c.Dump("b")
+
+	if err := c.Restore("a", payload, 0, false); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if v, _ := c.Get("a"); v != "old" {
+		t.Fatalf("expected a to be untouched, got %q", v)
+	}
+	if err := c.Restore("a", payload, 0, true); err != nil {
+		t.Fatalf("Restore with replace: %v", err)
+	}
+	if v, _ := c.Get("a"); v != "new" {
+		t.Fatalf("expected a to be replaced, got %q", v)
+	}
+}
+
+func TestRestoreRejectsCorruptPayload(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("a", "value")
+	payload, _ := c.Dump("a")
+
+	flipped := append([]byte(nil), payload...)
+	flipped[3] ^= 0xff
+	wrongVersion := append([]byte(nil), payload...)
+	wrongVersion[0] = dumpVersion + 1
+
+	for name, p := range map[string][]byte{
+		"flipped":   flipped,
+		"truncated": payload[:len(payload)-1],
+		"empty":     nil,
+		"version":   wrongVersion,
+	} {
+		if err := c.Restore("b", p, 0, false); !errors.Is(err, ErrCorruptDump) {
+			t.Errorf("%s: expected ErrCorruptDump, got %v", name, err)
+		}
+	}
+	if c.Exists("b") {
+		t.Fatal("expected nothing stored from a corrupt payload")
+	}
+}
+
+func TestDumpRestoreKinds(t *testing.T) {
+	src := NewShardedCache()
+	src.HSet("h", "f", "v")
+	src.RPush("l", "a", "b", "c")
+	src.SAdd("s", "x", "y")
+	src.ZAdd("z", ZMember{Member: "m", Score: 2.5}, ZMember{Member: "n", Score: -1})
+
+	dst := NewShardedCache()
+	for _, key := range []string{"h", "l", "s", "z"} {
+		payload, err := src.Dump(key)
+		if err != nil {
+			t.Fatalf("Dump(%q): %v", key, err)
+		}
+		if err := dst.Restore(key, payload, 0, false); err != nil {
+			t.Fatalf("Restore(%q): %v", key, err)
+		}
+	}
+	if v, err := dst.HGet("h", "f"); err != nil || v != "v" {
+		t.Fatalf("expected the hash field to round-trip, got %q, %v", v, err)
+	}
+	if values, _ := dst.LRange("l", 0, -1); !slices.Equal(values, []string{"a", "b", "c"}) {
+		t.Fatalf("expected the list in order, got %q", values)
+	}
+	if members, _ := dst.SMembers("s"); len(members) != 2 {
+		t.Fatalf("expected two set members, got %q", members)
+	}
+	if members, _ := dst.ZRange("z", 0, -1); !slices.Equal(members, []ZMember{{"n", -1}, {"m", 2.5}}) {
+		t.Fatalf("expected the sorted set by score, got %v", members)
+	}
+	if got, want := dst.MemoryUsage(), src.MemoryUsage(); got != want {
+		t.Fatalf("expected restored objects to use %d bytes, got %d", want, got)
+	}
+}
@@ -0,0 +1,49 @@
+package cache
+
+import "errors"
+
+// Errors returned by cache operations. Callers should compare against them
+// with errors.Is.
+var (
+	// ErrKeyNotFound is returned when a key is missing or has expired.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrValueTooLarge is returned when a write would store a value larger
+	// than the limit configured with WithMaxValueBytes.
+	ErrValueTooLarge = errors.New("value too large")
+
+	// ErrNotNumeric is returned by Increment when the stored value is not a
+	// base-10 integer.
+	ErrNotNumeric = errors.New("value is not an integer")
+
+	// ErrOverflow is returned by Increment when the result does not fit in an
+	// int64.
+	ErrOverflow = errors.New("increment would overflow")
+
+	// ErrLoaderPanic is wrapped by the error returned when a loader passed to
+	// GetOrCompute or WithLoader panics.
+	ErrLoaderPanic = errors.New("loader panicked")
+
+	// ErrCorruptSnapshot is wrapped by the error LoadFromFile returns for a
+	// file that is not a valid snapshot.
+	ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+	// ErrCorruptDump is wrapped by the error Restore returns for a payload
+	// that fails its checksum or was not produced by Dump.
+	ErrCorruptDump = errors.New("corrupt dump payload")
+
+	// ErrKeyExists is returned by Restore when the key is already present and
+	// replacing it was not requested.
+	ErrKeyExists = errors.New("key already exists")
+
+	// ErrInvalidScore is returned by ZAdd for a score that is NaN.
+	ErrInvalidScore = errors.New("score is not a number")
+
+	// ErrBitOffset is returned by SetBit and GetBit for a negative offset or
+	// one past the limit configured with WithMaxBitOffset.
+	ErrBitOffset = errors.New("bit offset is out of range")
+
+	// ErrWrongType is returned when an operation meets a key holding another
+	// kind of value, such as HGet on a string or Get on a hash.
+	ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+)
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// EvictionPolicy selects which entry a full shard removes to make room.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry. This is the default.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used entry, breaking ties by recency.
+	// Access counters are halved periodically so keys that were hot long ago
+	// eventually become evictable again.
+	LFU
+)
+
+// lfuAgingFactor controls how often LFU counters decay: after
+// lfuAgingFactor*capacity accesses, every counter in the shard is halved.
+const lfuAgingFactor = 10
+
+// evictionSamples is how many entries a read-heavy shard inspects when
+// choosing an entry to evict.
+const evictionSamples = 5
+
+// touch records an access to elem: it stamps the access time, moves the
+// entry to the front of the LRU list and, under LFU, bumps its access counter.
+// Read-heavy shards only stamp the access time. The caller must hold the shard
+// lock.
+func (s *Shard) touch(elem *list.Element) {
+	ent := elem.Value.(*entry)
+	atomic.StoreInt64(&ent.accessed, s.clock().UnixNano())
+	if s.readHeavy {
+		return
+	}
+	s.lru.MoveToFront(elem)
+	if s.policy != LFU {
+		return
+	}
+	if ent.freq < ^uint32(0) {
+		ent.freq++
+	}
+	s.accesses++
+	if s.capacity > 0 && s.accesses >= lfuAgingFactor*s.capacity {
+		s.ageCounters()
+	}
+}
+
+// hit records a read of elem's entry: it touches the entry and counts a hit
+// for both the entry and the shard. The caller must hold the shard lock.
+func (s *Shard) hit(elem *list.Element) {
+	s.touch(elem)
+	countHit(elem.Value.(*entry))
+	s.stats.hits.Add(1)
+}
+
+// ageCounters halves every access counter in the shard.
+// The caller must hold the shard lock.
+func (s *Shard) ageCounters() {
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*entry).freq /= 2
+	}
+	s.accesses = 0
+}
+
+// victim returns the element the shard's eviction policy would remove next,
+// ignoring skip, or nil if there is no other candidate. The caller must hold
+// the shard lock.
+func (s *Shard) victim(skip *list.Element) *list.Element {
+	if s.readHeavy {
+		return s.sampleVictim(skip)
+	}
+	if s.policy != LFU {
+		elem := s.lru.Back()
+		if elem != nil && elem == skip {
+			elem = elem.Prev()
+		}
+		return elem
+	}
+	// Walk from the least recently used end so ties go to the oldest entry.
+	var min *list.Element
+	for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+		if elem == skip {
+			continue
+		}
+		if min == nil || elem.Value.(*entry).freq < min.Value.(*entry).freq {
+			min = elem
+			if min.Value.(*entry).freq == 0 {
+				break
+			}
+		}
+	}
+	return min
+}
+
+// sampleVictim approximates LRU for read-heavy shards, whose list order does
+// not track reads. It inspects up to evictionSamples entries in map iteration
+// order, which Go randomizes, and returns the one accessed least recently.
+// The caller must hold the shard lock.
+func (s *Shard) sampleVictim(skip *list.Element) *list.Element {
+	var oldest *list.Element
+	var oldestAt int64
+	sampled := 0
+	for _, elem := range s.data {
+		if elem == skip {
+			continue
+		}
+		if at := atomic.LoadInt64(&elem.Value.(*entry).accessed); oldest == nil || at < oldestAt {
+			oldest, oldestAt = elem, at
+		}
+		if sampled++; sampled == evictionSamples {
+			break
+		}
+	}
+	return oldest
+}
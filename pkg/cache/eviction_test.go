@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(3), WithEvictionPolicy(LFU))
+
+	cache.Set("hot", "1")
+	cache.Set("warm", "2")
+	cache.Set("cold", "3")
+	for i := 0; i < 5; i++ {
+		cache.Get("hot")
+	}
+	cache.Get("warm")
+
+	// A scan of new keys should only ever evict the coldest entries.
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprintf("scan-%d", i), "x")
+	}
+
+	if _, err := cache.Get("hot"); err != nil {
+		t.Fatal("expected frequently used key 'hot' to survive the scan")
+	}
+	if _, err := cache.Get("cold"); err == nil {
+		t.Fatal("expected rarely used key 'cold' to be evicted")
+	}
+}
+
+func TestLFUCountersAge(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2), WithEvictionPolicy(LFU))
+
+	cache.Set("once-hot", "1")
+	for i := 0; i < 8; i++ {
+		cache.Get("once-hot")
+	}
+
+	// Keep a second key busy long enough for several aging rounds.
+	cache.Set("busy", "2")
+	for i := 0; i < 10*lfuAgingFactor*2; i++ {
+		cache.Get("busy")
+	}
+
+	cache.Set("new", "3")
+	if _, err := cache.Get("once-hot"); err == nil {
+		t.Fatal("expected stale once-hot key to become evictable after aging")
+	}
+	if _, err := cache.Get("busy"); err != nil {
+		t.Fatal("expected currently busy key to survive")
+	}
+}
+
+func TestLRURemainsDefault(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+	if cache.policy != LRU {
+		t.Fatalf("expected default policy LRU, got %v", cache.policy)
+	}
+}
+
+func TestReadHeavyEvictsLeastRecentlyRead(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(3), WithReadHeavy(true))
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Set("c", "3")
+	time.Sleep(time.Millisecond)
+	cache.Get("a")
+	cache.Get("c")
+
+	// With fewer entries than the sample size, the choice is exact.
+	cache.Set("d", "4")
+	if _, err := cache.Get("b"); err == nil {
+		t.Fatal("expected unread key 'b' to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("expected key %q to survive, got %v", key, err)
+		}
+	}
+}
+
+func TestReadHeavyPrefersColdKeys(t *testing.T) {
+	const half = 50
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2*half), WithReadHeavy(true))
+	for i := 0; i < half; i++ {
+		cache.Set(fmt.Sprintf("cold-%d", i), "v")
+		cache.Set(fmt.Sprintf("hot-%d", i), "v")
+	}
+	time.Sleep(time.Millisecond)
+	for i := 0; i < half; i++ {
+		cache.Get(fmt.Sprintf("hot-%d", i))
+	}
+	time.Sleep(time.Millisecond)
+	for i := 0; i < half/2; i++ {
+		cache.Set(fmt.Sprintf("new-%d", i), "v")
+	}
+
+	hot, cold := 0, 0
+	for i := 0; i < half; i++ {
+		if cache.Exists(fmt.Sprintf("hot-%d", i)) {
+			hot++
+		}
+		if cache.Exists(fmt.Sprintf("cold-%d", i)) {
+			cold++
+		}
+	}
+	// Sampling is approximate, but nearly every eviction should hit a cold key.
+	if hot < half-half/5 || cold > hot {
+		t.Fatalf("expected cold keys to be evicted first, %d hot and %d cold survived", hot, cold)
+	}
+}
+
+func TestReadHeavyConcurrentAccess(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(2), WithShardCapacity(16), WithReadHeavy(true))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key-%d", (g*i)%64)
+				if g%2 == 0 {
+					cache.Set(key, "v")
+				} else {
+					cache.Get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := cache.Len(); n > 32 {
+		t.Fatalf("expected at most 32 items, got %d", n)
+	}
+}
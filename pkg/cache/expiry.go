@@ -0,0 +1,113 @@
+package cache
+
+import "container/heap"
+
+// Each shard indexes the deadlines of its entries in a min-heap, so that
+// deleteExpired only visits entries whose deadline has passed instead of
+// scanning the whole shard.
+//
+// An entry has at most one live node, whose deadline is kept in
+// entry.scheduled. A node is pushed only when an entry gains a deadline
+// earlier than its node's, so sliding TTLs, which only push deadlines later,
+// never touch the heap; such a node is re-pushed with the entry's current
+// deadline when it comes due. A node left behind by an entry that was
+// removed or rescheduled is a tombstone, dropped when it is popped, and the
+// heap is rebuilt without them once they outnumber the live nodes.
+
+// minExpiryCompaction is the smallest heap worth rebuilding to drop
+// tombstones.
+const minExpiryCompaction = 64
+
+// expiryNode schedules ent for removal at the Unix-nanosecond time at.
+type expiryNode struct {
+	at  int64
+	ent *entry
+}
+
+// live reports whether n is ent's current node rather than a tombstone.
+func (n expiryNode) live() bool {
+	return n.ent.scheduled == n.at
+}
+
+// expiryHeap is a min-heap of expiry nodes by deadline.
+type expiryHeap []expiryNode
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiryNode)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	old[len(old)-1] = expiryNode{} // Let a removed entry be collected.
+	*h = old[:len(old)-1]
+	return x
+}
+
+// schedule indexes ent's deadline after it was set, unless its node already
+// comes due no later. The caller must hold the shard lock.
+func (s *Shard) schedule(ent *entry) {
+	if ent.expiresAt == 0 || (ent.scheduled != 0 && ent.scheduled <= ent.expiresAt) {
+		return
+	}
+	if ent.scheduled != 0 {
+		s.noteTombstone()
+	}
+	ent.scheduled = ent.expiresAt
+	heap.Push(&s.expiry, expiryNode{at: ent.expiresAt, ent: ent})
+}
+
+// unschedule turns ent's node, if it has one, into a tombstone once ent is
+// removed from the shard. The caller must hold the shard lock.
+func (s *Shard) unschedule(ent *entry) {
+	if ent.scheduled != 0 {
+		ent.scheduled = 0
+		s.noteTombstone()
+	}
+}
+
+// noteTombstone counts a node that no longer schedules its entry, rebuilding
+// the heap once they make up more than half of it. The caller must hold the
+// shard lock.
+func (s *Shard) noteTombstone() {
+	s.tombstones++
+	if s.tombstones > len(s.expiry)/2 && len(s.expiry) >= minExpiryCompaction {
+		live := s.expiry[:0]
+		for _, n := range s.expiry {
+			if n.live() {
+				live = append(live, n)
+			}
+		}
+		clear(s.expiry[len(live):])
+		s.expiry = live
+		heap.Init(&s.expiry)
+		s.tombstones = 0
+	}
+}
+
+// expireDue removes the entries whose deadline is at or before cutoff and
+// returns how many it removed. Nodes whose entry was given a later deadline
+// or none at all are moved or dropped on the way. The caller must hold the
+// shard lock.
+func (s *Shard) expireDue(cutoff int64) int {
+	removed := 0
+	for len(s.expiry) > 0 && s.expiry[0].at <= cutoff {
+		n := heap.Pop(&s.expiry).(expiryNode)
+		if !n.live() {
+			s.tombstones--
+			continue
+		}
+		ent := n.ent
+		ent.scheduled = 0
+		if ent.expiresAt > cutoff {
+			s.schedule(ent)
+			continue
+		}
+		if ent.expiresAt == 0 {
+			continue
+		}
+		s.removeExpired(s.data[ent.key])
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDeleteExpiredFollowsRescheduledDeadlines(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewShardedCache(WithClock(clock.Now), WithShardCount(1))
+	cache.SetWithTTL("overwritten", "v", time.Second)
+	cache.SetWithTTL("overwritten", "v", time.Minute)
+	cache.SetWithTTL("extended", "v", time.Second)
+	cache.Expire("extended", time.Minute)
+	cache.SetWithTTL("shortened", "v", time.Hour)
+	cache.Expire("shortened", time.Second)
+	cache.SetWithTTL("persisted", "v", time.Second)
+	cache.Expire("persisted", 0)
+	cache.SetWithTTL("due", "v", time.Second)
+
+	clock.Advance(2 * time.Second)
+	if n := cache.DeleteExpired(); n != 2 {
+		t.Fatalf("expected 2 entries removed, got %d", n)
+	}
+	for _, key := range []string{"overwritten", "extended", "persisted"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("expected %q to survive, got %v", key, err)
+		}
+	}
+
+	clock.Advance(time.Minute)
+	if n := cache.DeleteExpired(); n != 2 {
+		t.Fatalf("expected 2 entries removed, got %d", n)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("expected only the persisted entry left, got %d entries", n)
+	}
+}
+
+func TestDeleteExpiredSlidingTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewShardedCache(WithClock(clock.Now), WithSlidingTTL(true))
+	cache.SetWithTTL("key", "v", 2*time.Second)
+
+	clock.Advance(time.Second)
+	cache.Get("key")
+	clock.Advance(1500 * time.Millisecond)
+	if n := cache.DeleteExpired(); n != 0 {
+		t.Fatalf("expected the slid entry to survive, got %d removed", n)
+	}
+	clock.Advance(time.Second)
+	if n := cache.DeleteExpired(); n != 1 {
+		t.Fatalf("expected 1 entry removed, got %d", n)
+	}
+}
+
+func TestExpiryHeapDropsTombstones(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewShardedCache(WithClock(clock.Now), WithShardCount(1), WithShardCapacity(0))
+	const keys = 100
+	for round := 0; round < 50; round++ {
+		for i := 0; i < keys; i++ {
+			// Each round gives every key an earlier deadline, leaving its
+			// previous node behind, and deletes a tenth of them.
+			key := fmt.Sprintf("key-%d", i)
+			cache.SetWithTTL(key, "v", time.Duration(1000-round)*time.Second)
+			if i%10 == round%10 {
+				cache.Delete(key)
+			}
+		}
+	}
+	shard := cache.shards[0]
+	if n := len(shard.expiry); n > 2*keys {
+		t.Fatalf("expected the heap to stay within %d nodes, got %d", 2*keys, n)
+	}
+
+	clock.Advance(time.Hour)
+	cache.DeleteExpired()
+	if n, tombstones := len(shard.expiry), shard.tombstones; n != 0 || tombstones != 0 {
+		t.Fatalf("expected an empty heap, got %d nodes and %d tombstones", n, tombstones)
+	}
+}
+
+// scanExpired is the full-scan sweep that the expiry heap replaced, kept to
+// compare against it.
+func (s *Shard) scanExpired(now int64) int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	removed := 0
+	for _, elem := range s.data {
+		if elem.Value.(*entry).expired(now) {
+			s.removeExpired(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+func BenchmarkDeleteExpired(b *testing.B) {
+	const n = 1_000_000
+	keys := benchmarkKeys(n)
+	for _, bc := range []struct {
+		name  string
+		sweep func(s *Shard, now int64) int
+	}{
+		{"scan", (*Shard).scanExpired},
+		{"heap", (*Shard).deleteExpired},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			clock := &fakeClock{now: time.Unix(1000, 0)}
+			cache := NewShardedCache(WithClock(clock.Now), WithShardCapacity(0))
+			for i, key := range keys {
+				// One key in a hundred expires in each second of the
+				// first hundred seconds.
+				cache.SetWithTTL(key, "v", time.Duration(i%100+1)*time.Second)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				clock.Advance(time.Second)
+				now := clock.Now().UnixNano()
+				removed := 0
+				for _, shard := range cache.shards {
+					removed += bc.sweep(shard, now)
+				}
+				if removed != n/100 {
+					b.Fatalf("expected %d entries removed, got %d", n/100, removed)
+				}
+				b.StopTimer()
+				// Re-add the removed keys to come due again in a hundred
+				// sweeps, so every sweep removes 1% of the cache.
+				for j := i % 100; j < n; j += 100 {
+					cache.SetWithTTL(keys[j], "v", 100*time.Second)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import "time"
+
+// SetMode selects when SetFlagged stores a value.
+type SetMode int
+
+const (
+	// SetAlways stores the value unconditionally.
+	SetAlways SetMode = iota
+	// SetIfAbsent stores the value only if the key is missing or expired.
+	SetIfAbsent
+	// SetIfPresent stores the value only if the key holds an unexpired entry.
+	SetIfPresent
+)
+
+// SetFlagged stores value under key along with flags, an opaque number kept
+// with the entry for protocols such as memcached that attach one to every
+// value. mode decides whether an existing key is overwritten, and ttl accepts
+// the same sentinels as SetWithTTL. It reports whether the value was stored
+// and returns ErrValueTooLarge for a value over the WithMaxValueBytes limit.
+// Like SetNX, it does not call the write-through function. Writes through
+// any other method reset the flags to zero.
+func (sc *ShardedCache) SetFlagged(key, value string, flags uint32, ttl time.Duration, mode SetMode) (bool, error) {
+	if sc.tooLarge([]byte(value)) {
+		return false, ErrValueTooLarge
+	}
+	stored, evicted := sc.getShard(key).setFlagged(key, []byte(value), flags, sc.resolveTTL(ttl), mode)
+	sc.notifyEvicted(evicted)
+	return stored, nil
+}
+
+// GetFlagged is like Get but also returns the flags stored by SetFlagged.
+// Unlike Get, it does not consult the loader or serve stale entries.
+func (sc *ShardedCache) GetFlagged(key string) (string, uint32, error) {
+	value, flags, ok := sc.getShard(key).getFlagged(key)
+	if !ok {
+		return "", 0, ErrKeyNotFound
+	}
+	return string(value), flags, nil
+}
+
+// setFlagged stores value and flags under key if mode allows it, reporting
+// whether it did.
+func (s *Shard) setFlagged(key string, value []byte, flags uint32, ttl time.Duration, mode SetMode) (bool, []entry) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if mode != SetAlways {
+		_, present := s.live(key, s.clock().UnixNano())
+		if present != (mode == SetIfPresent) {
+			return false, nil
+		}
+	}
+	evicted := s.setLocked(key, value, ttl)
+	s.data[key].Value.(*entry).flags = flags
+	return true, evicted
+}
+
+// getFlagged returns key's unexpired value and flags, promoting the entry and
+// counting a hit or miss. Keys holding values other than strings are missing.
+func (s *Shard) getFlagged(key string) ([]byte, uint32, bool) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, ok := s.live(key, now.UnixNano())
+	if !ok || elem.Value.(*entry).kind != kindString {
+		s.stats.misses.Add(1)
+		return nil, 0, false
+	}
+	ent := elem.Value.(*entry)
+	if s.slidingTTL && ent.ttl > 0 {
+		ent.expiresAt = now.Add(ent.ttl).UnixNano()
+	}
+	s.hit(elem)
+	return ent.plain(), ent.flags, true
+}
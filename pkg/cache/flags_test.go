@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetFlagged(t *testing.T) {
+	c := NewShardedCache()
+
+	if stored, err := c.SetFlagged("k", "v1", 7, NoExpiration, SetIfPresent); err != nil || stored {
+		t.Fatalf("expected SetIfPresent on a missing key to store nothing, got %v, %v", stored, err)
+	}
+	if stored, _ := c.SetFlagged("k", "v1", 7, NoExpiration, SetIfAbsent); !stored {
+		t.Fatal("expected SetIfAbsent on a missing key to store")
+	}
+	if stored, _ := c.SetFlagged("k", "v2", 8, NoExpiration, SetIfAbsent); stored {
+		t.Fatal("expected SetIfAbsent on a present key to store nothing")
+	}
+	if v, flags, err := c.GetFlagged("k"); err != nil || v != "v1" || flags != 7 {
+		t.Fatalf("expected v1 with flags 7, got %q, %d, %v", v, flags, err)
+	}
+	if stored, _ := c.SetFlagged("k", "v3", 9, NoExpiration, SetIfPresent); !stored {
+		t.Fatal("expected SetIfPresent on a present key to store")
+	}
+	if v, flags, _ := c.GetFlagged("k"); v != "v3" || flags != 9 {
+		t.Fatalf("expected v3 with flags 9, got %q, %d", v, flags)
+	}
+
+	c.Set("k", "plain")
+	if v, flags, _ := c.GetFlagged("k"); v != "plain" || flags != 0 {
+		t.Fatalf("expected a plain Set to reset flags, got %q, %d", v, flags)
+	}
+	if _, _, err := c.GetFlagged("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := NewShardedCache(WithClock(clock.Now))
+	c.Set("k", "v")
+
+	if !c.Expire("k", time.Second) {
+		t.Fatal("expected Expire to find k")
+	}
+	if _, at, _ := c.PeekWithExpiry("k"); !at.Equal(clock.Now().Add(time.Second)) {
+		t.Fatalf("expected k to expire in a second, got %v", at)
+	}
+	c.Expire("k", 0)
+	clock.Advance(time.Hour)
+	if !c.Exists("k") {
+		t.Fatal("expected Expire with zero to remove the expiration")
+	}
+	c.Expire("k", time.Second)
+	clock.Advance(2 * time.Second)
+	if c.Expire("k", time.Minute) {
+		t.Fatal("expected Expire on an expired key to report false")
+	}
+}
+
+func TestExpireTime(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := NewShardedCache(WithClock(clock.Now))
+	c.Set("k", "v")
+	c.HSet("h", "f", "v")
+	c.Expire("h", time.Minute)
+
+	if at, err := c.ExpireTime("k"); err != nil || !at.IsZero() {
+		t.Fatalf("expected no expiration, got %v, %v", at, err)
+	}
+	if at, err := c.ExpireTime("h"); err != nil || !at.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("expected a hash to expire in a minute, got %v, %v", at, err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := c.ExpireTime("h"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound for an expired key, got %v", err)
+	}
+	if _, err := c.ExpireTime("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
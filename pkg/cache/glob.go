@@ -0,0 +1,31 @@
+package cache
+
+// matchGlob reports whether s matches pattern, where '*' matches any sequence
+// of characters (including none) and '?' matches exactly one character.
+// All other characters match themselves.
+func matchGlob(pattern, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+	// Positions to resume from after the most recent '*'.
+	star, mark := -1, 0
+	pi, si := 0, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			// Let the last '*' absorb one more character and retry.
+			mark++
+			pi, si = star+1, mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
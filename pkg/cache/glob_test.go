@@ -0,0 +1,31 @@
+package cache
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "session:42", false},
+		{"*:42", "user:42", true},
+		{"u?er", "user", true},
+		{"u?er", "uer", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"**", "x", true},
+		{"?", "é", true},
+		{"[ab]", "a", false},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package cache
+
+// FNV-1a 32-bit parameters, matching hash/fnv.
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// fnv32a returns the 32-bit FNV-1a hash of key. It produces the same values as
+// hash/fnv's New32a but works on the string directly, so hashing a key does
+// not allocate a hasher or convert the key to a byte slice.
+func fnv32a(key string) uint32 {
+	hash := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= fnvPrime32
+	}
+	return hash
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"hash/fnv"
+	"strconv"
+	"testing"
+)
+
+// hasherFNV is the previous shard hash, kept to benchmark against.
+func hasherFNV(key string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return hash.Sum32()
+}
+
+func TestFNV32aMatchesStdlib(t *testing.T) {
+	for _, key := range []string{"", "a", "key", "user:1000", "日本語"} {
+		if got, want := fnv32a(key), hasherFNV(key); got != want {
+			t.Errorf("fnv32a(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestDefaultHashDistribution(t *testing.T) {
+	const shards, keys = 16, 160000
+	cache := NewShardedCache(WithShardCount(shards))
+	counts := make([]int, shards)
+	for i := 0; i < keys; i++ {
+		counts[cache.shardIndex("key:"+strconv.Itoa(i))]++
+	}
+	want := keys / shards
+	for i, n := range counts {
+		if n < want*9/10 || n > want*11/10 {
+			t.Errorf("shard %d got %d keys, want about %d", i, n, want)
+		}
+	}
+}
+
+func TestWithHashFunc(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithHashFunc(func(string) uint32 { return 2 }))
+	for _, key := range []string{"a", "b", "c"} {
+		if idx := cache.shardIndex(key); idx != 2 {
+			t.Fatalf("expected key %q in shard 2, got %d", key, idx)
+		}
+		cache.Set(key, "v")
+	}
+	if n := cache.ShardStats()[2].Items; n != 3 {
+		t.Fatalf("expected 3 items in shard 2, got %d", n)
+	}
+}
+
+func BenchmarkShardIndex(b *testing.B) {
+	keys := benchmarkKeys(64)
+	for _, bc := range []struct {
+		name string
+		hash func(string) uint32
+	}{
+		{"fnv-hasher", hasherFNV},
+		{"fnv-inline", fnv32a},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := NewShardedCache(WithHashFunc(bc.hash))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cache.shardIndex(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func TestShardCountRoundsToPowerOfTwo(t *testing.T) {
+	for _, tc := range []struct{ requested, want int }{
+		{1, 1}, {2, 2}, {3, 4}, {5, 8}, {16, 16}, {17, 32},
+	} {
+		if got := len(NewShardedCache(WithShardCount(tc.requested)).shards); got != tc.want {
+			t.Errorf("WithShardCount(%d): got %d shards, want %d", tc.requested, got, tc.want)
+		}
+	}
+	// A small total capacity rounds down so no shard is left without room.
+	if got := len(NewShardedCache(WithShardCount(16), WithTotalCapacity(3)).shards); got != 2 {
+		t.Errorf("expected 2 shards for total capacity 3, got %d", got)
+	}
+}
+
+func BenchmarkShardSelect(b *testing.B) {
+	keys := benchmarkKeys(64)
+	hashes := make([]uint32, len(keys))
+	for i, key := range keys {
+		hashes[i] = fnv32a(key)
+	}
+	count := uint32(16)
+	mask := count - 1
+	var sink uint32
+	b.Run("modulo", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sink += hashes[i%len(hashes)] % count
+		}
+	})
+	b.Run("mask", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sink += hashes[i%len(hashes)] & mask
+		}
+	})
+	_ = sink
+}
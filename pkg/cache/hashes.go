@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"container/list"
+	"maps"
+	"math"
+	"strconv"
+	"time"
+)
+
+// fieldOverhead approximates the per-field bookkeeping cost of a hash in
+// bytes: its map slot and string headers.
+const fieldOverhead = 48
+
+// fieldSize returns the approximate size of one hash field in bytes.
+func fieldSize(field, value string) int64 {
+	return int64(len(field)+len(value)) + fieldOverhead
+}
+
+// HSet sets field in the hash stored at key to value, creating the hash with
+// the default TTL if key is missing, and reports whether the field is new.
+// The whole hash is one entry for LRU and capacity purposes, and its fields
+// count towards WithMaxBytes. It returns ErrWrongType if key holds another
+// kind of value and ErrValueTooLarge if value exceeds the WithMaxValueBytes
+// limit. Like SetFlagged, hash writes do not call the write-through function.
+func (sc *ShardedCache) HSet(key, field, value string) (bool, error) {
+	if sc.tooLarge([]byte(value)) {
+		return false, ErrValueTooLarge
+	}
+	created, evicted, err := sc.getShard(key).hset(key, field, value, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return created, err
+}
+
+// HGet returns the value of field in the hash stored at key. It returns
+// ErrKeyNotFound if the key or the field is missing and ErrWrongType if key
+// holds another kind of value.
+func (sc *ShardedCache) HGet(key, field string) (string, error) {
+	return sc.getShard(key).hget(key, field)
+}
+
+// HGetAll returns a copy of every field in the hash stored at key. A missing
+// key yields an empty map.
+func (sc *ShardedCache) HGetAll(key string) (map[string]string, error) {
+	return sc.getShard(key).hgetAll(key)
+}
+
+// HDel removes fields from the hash stored at key and returns how many were
+// present. Removing the last field deletes the key.
+func (sc *ShardedCache) HDel(key string, fields ...string) (int, error) {
+	return sc.getShard(key).hdel(key, fields)
+}
+
+// HIncrBy adds delta to the integer stored in field of the hash at key and
+// returns the result, treating a missing field or key as zero. Like
+// Increment, it returns ErrNotNumeric if the field does not hold a base-10
+// integer and ErrOverflow if the result would overflow int64.
+func (sc *ShardedCache) HIncrBy(key, field string, delta int64) (int64, error) {
+	n, evicted, err := sc.getShard(key).hincrBy(key, field, delta, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return n, err
+}
+
+// hashFor returns the hash stored at key, creating an empty one with the given
+// ttl if key is missing. The caller must hold the shard lock.
+func (s *Shard) hashFor(key string, ttl time.Duration) (map[string]string, *list.Element, []entry, error) {
+	elem, evicted, err := s.objectFor(key, kindHash, ttl, func() any { return make(map[string]string) })
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return elem.Value.(*entry).object.(map[string]string), elem, evicted, nil
+}
+
+// hset sets one field of the hash at key, reporting whether it is new.
+func (s *Shard) hset(key, field, value string, ttl time.Duration) (bool, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	h, elem, evicted, err := s.hashFor(key, ttl)
+	if err != nil {
+		return false, nil, err
+	}
+	delta := fieldSize(field, value)
+	old, exists := h[field]
+	if exists {
+		delta -= fieldSize(field, old)
+	}
+	h[field] = value
+	s.stats.sets.Add(1)
+	return !exists, append(evicted, s.resizeObject(elem, delta)...), nil
+}
+
+// hget returns one field of the hash at key, counting a hit or miss.
+func (s *Shard) hget(key, field string) (string, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindHash, now.UnixNano())
+	if err != nil {
+		if err == ErrKeyNotFound {
+			s.stats.misses.Add(1)
+		}
+		return "", err
+	}
+	value, ok := elem.Value.(*entry).object.(map[string]string)[field]
+	if !ok {
+		s.stats.misses.Add(1)
+		return "", ErrKeyNotFound
+	}
+	s.readObject(elem, now)
+	return value, nil
+}
+
+// hgetAll returns a copy of the hash at key.
+func (s *Shard) hgetAll(key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindHash, now.UnixNano())
+	if err == ErrKeyNotFound {
+		s.stats.misses.Add(1)
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.readObject(elem, now)
+	return maps.Clone(elem.Value.(*entry).object.(map[string]string)), nil
+}
+
+// hdel removes fields from the hash at key, deleting the key once the hash is
+// empty.
+func (s *Shard) hdel(key string, fields []string) (int, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, err := s.liveObject(key, kindHash, s.clock().UnixNano())
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	h := elem.Value.(*entry).object.(map[string]string)
+	removed := 0
+	var delta int64
+	for _, field := range fields {
+		if value, ok := h[field]; ok {
+			delete(h, field)
+			delta -= fieldSize(field, value)
+			removed++
+		}
+	}
+	if len(h) == 0 {
+		s.removeElement(elem)
+		s.stats.deletes.Add(1)
+		return removed, nil
+	}
+	s.resizeObject(elem, delta) // Shrinking never evicts.
+	return removed, nil
+}
+
+// hincrBy adds delta to the integer in one field of the hash at key.
+func (s *Shard) hincrBy(key, field string, delta int64, ttl time.Duration) (int64, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if elem, err := s.liveObject(key, kindHash, s.clock().UnixNano()); err == nil {
+		h := elem.Value.(*entry).object.(map[string]string)
+		if old, ok := h[field]; ok {
+			current, err := strconv.ParseInt(old, 10, 64)
+			if err != nil {
+				return 0, nil, ErrNotNumeric
+			}
+			if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+				return 0, nil, ErrOverflow
+			}
+			delta += current
+		}
+	} else if err != ErrKeyNotFound {
+		return 0, nil, err
+	}
+	h, elem, evicted, err := s.hashFor(key, ttl)
+	if err != nil {
+		return 0, nil, err
+	}
+	value := strconv.FormatInt(delta, 10)
+	size := fieldSize(field, value)
+	if old, ok := h[field]; ok {
+		size -= fieldSize(field, old)
+	}
+	h[field] = value
+	s.stats.sets.Add(1)
+	return delta, append(evicted, s.resizeObject(elem, size)...), nil
+}
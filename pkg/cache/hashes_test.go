@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestHashOperations(t *testing.T) {
+	c := NewShardedCache()
+	if created, err := c.HSet("user", "name", "ada"); err != nil || !created {
+		t.Fatalf("expected a new field, got %v, %v", created, err)
+	}
+	if created, err := c.HSet("user", "name", "grace"); err != nil || created {
+		t.Fatalf("expected an overwritten field, got %v, %v", created, err)
+	}
+	c.HSet("user", "lang", "cobol")
+	if v, err := c.HGet("user", "name"); err != nil || v != "grace" {
+		t.Fatalf("expected grace, got %q, %v", v, err)
+	}
+	if _, err := c.HGet("user", "missing"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound for a missing field, got %v", err)
+	}
+	all, err := c.HGetAll("user")
+	if err != nil || len(all) != 2 || all["lang"] != "cobol" {
+		t.Fatalf("unexpected HGetAll result %v, %v", all, err)
+	}
+	all["lang"] = "changed"
+	if v, _ := c.HGet("user", "lang"); v != "cobol" {
+		t.Fatal("expected HGetAll to return a copy")
+	}
+	if all, err := c.HGetAll("nobody"); err != nil || len(all) != 0 {
+		t.Fatalf("expected an empty map for a missing key, got %v, %v", all, err)
+	}
+
+	if n, err := c.HDel("user", "name", "missing"); err != nil || n != 1 {
+		t.Fatalf("expected one field removed, got %d, %v", n, err)
+	}
+	if n, _ := c.HDel("user", "lang"); n != 1 || c.Exists("user") {
+		t.Fatal("expected removing the last field to delete the key")
+	}
+}
+
+func TestHashIncrBy(t *testing.T) {
+	c := NewShardedCache()
+	if n, err := c.HIncrBy("h", "n", 5); err != nil || n != 5 {
+		t.Fatalf("expected 5, got %d, %v", n, err)
+	}
+	if n, err := c.HIncrBy("h", "n", -7); err != nil || n != -2 {
+		t.Fatalf("expected -2, got %d, %v", n, err)
+	}
+	c.HSet("h", "s", "abc")
+	if _, err := c.HIncrBy("h", "s", 1); err != ErrNotNumeric {
+		t.Fatalf("expected ErrNotNumeric, got %v", err)
+	}
+	c.HSet("h", "max", "9223372036854775807")
+	if _, err := c.HIncrBy("h", "max", 1); err != ErrOverflow {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+	if v, _ := c.HGet("h", "max"); v != "9223372036854775807" {
+		t.Fatalf("expected a failed increment to leave the field alone, got %q", v)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("str", "v")
+	c.HSet("hash", "f", "1")
+
+	if _, err := c.HSet("str", "f", "v"); err != ErrWrongType {
+		t.Fatalf("expected HSet on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.HGet("str", "f"); err != ErrWrongType {
+		t.Fatalf("expected HGet on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.HGetAll("str"); err != ErrWrongType {
+		t.Fatalf("expected HGetAll on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.Get("hash"); err != ErrWrongType {
+		t.Fatalf("expected Get on a hash to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.Increment("hash", 1); err != ErrWrongType {
+		t.Fatalf("expected Increment on a hash to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.Append("hash", "x"); err != ErrWrongType {
+		t.Fatalf("expected Append on a hash to fail with ErrWrongType, got %v", err)
+	}
+	if v := c.MGet("hash", "str"); len(v) != 1 || v["str"] != "v" {
+		t.Fatalf("expected MGet to skip the hash, got %v", v)
+	}
+
+	c.Set("hash", "plain")
+	if v, err := c.Get("hash"); err != nil || v != "plain" {
+		t.Fatalf("expected Set to replace the hash, got %q, %v", v, err)
+	}
+}
+
+func TestHashIsOneEntry(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+	for i := 0; i < 10; i++ {
+		c.HSet("h", fmt.Sprintf("f%d", i), "v")
+	}
+	c.Set("a", "v")
+	if c.Len() != 2 {
+		t.Fatalf("expected the hash to count as one entry, got %d keys", c.Len())
+	}
+	c.HGet("h", "f0") // Promote h over a.
+	c.Set("b", "v")
+	if c.Exists("a") || !c.Exists("h") {
+		t.Fatal("expected a hash read to promote the whole hash")
+	}
+}
+
+func TestHashMemoryUsage(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1))
+	c.HSet("h", "field", "value")
+	one := c.MemoryUsage()
+	c.HSet("h", "other", "value")
+	if got := c.MemoryUsage(); got != one+fieldSize("other", "value") {
+		t.Fatalf("expected a second field to add %d bytes to %d, got %d", fieldSize("other", "value"), one, got)
+	}
+	c.HSet("h", "other", "longer value")
+	c.HDel("h", "other")
+	if got := c.MemoryUsage(); got != one {
+		t.Fatalf("expected %d bytes after removing the field, got %d", one, got)
+	}
+	c.HDel("h", "field")
+	if got := c.MemoryUsage(); got != 0 {
+		t.Fatalf("expected no memory in use after the hash is gone, got %d", got)
+	}
+}
+
+func TestHashMaxBytesEvicts(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1), WithMaxBytes(1200))
+	c.Set("old", "v")
+	for i := 0; i < 15; i++ {
+		c.HSet("h", fmt.Sprintf("f%d", i), "value")
+	}
+	if c.Exists("old") || c.Stats().Evictions == 0 {
+		t.Fatal("expected a growing hash to evict older entries")
+	}
+	if c.MemoryUsage() > 1200 {
+		t.Fatalf("expected memory within the budget, got %d", c.MemoryUsage())
+	}
+}
+
+func TestHashesSkippedBySnapshots(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("str", "v")
+	c.HSet("hash", "f", "v")
+	seen := 0
+	c.ForEach(func(key, value string) bool {
+		if key == "hash" {
+			t.Fatal("expected ForEach to skip the hash")
+		}
+		seen++
+		return true
+	})
+	if seen != 1 {
+		t.Fatalf("expected one string entry, visited %d", seen)
+	}
+}
+
+func TestHashConcurrent(t *testing.T) {
+	c := NewShardedCache(WithShardCount(4))
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.HIncrBy("counters", fmt.Sprintf("f%d", i%10), 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	all, _ := c.HGetAll("counters")
+	for field, v := range all {
+		if v != "800" {
+			t.Fatalf("expected %s to reach 800, got %s", field, v)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// EntryInfo describes a cached entry, as returned by GetEntryInfo.
+//
+// The creation time, hit counter, and access time live in every entry and
+// are counted by MemoryUsage and WithMaxBytes like the rest of the entry; the
+// access time is the one read-heavy shards use for eviction. Recording an
+// access reads the clock once more per hit outside read-heavy mode.
+type EntryInfo struct {
+	Value      string        // The value; empty for hashes, lists, sets, and sorted sets.
+	Created    time.Time     // When the value was stored by a Set, Restore, or similar.
+	LastAccess time.Time     // When the entry was last read or written.
+	Hits       uint32        // Reads since Created, saturating at math.MaxUint32.
+	TTL        time.Duration // Time left to live; zero if the entry never expires.
+	Shard      int           // Index of the shard holding the entry.
+}
+
+// countHit adds a read to ent's hit counter, which saturates rather than
+// wrapping. Callers holding only the shard's read lock may race on it, so a
+// few concurrent reads may go uncounted near the limit.
+func countHit(ent *entry) {
+	if atomic.LoadUint32(&ent.hits) < math.MaxUint32 {
+		atomic.AddUint32(&ent.hits, 1)
+	}
+}
+
+// GetEntryInfo returns key's value along with its metadata. Unlike Get, it
+// does not promote the entry, count a hit or miss, or extend a sliding TTL,
+// so inspecting an entry does not change it. It returns ErrKeyNotFound if key
+// is missing or expired, whatever its type.
+func (sc *ShardedCache) GetEntryInfo(key string) (EntryInfo, error) {
+	idx := sc.shardIndex(key)
+	info, ok := sc.shards[idx].entryInfo(key)
+	if !ok {
+		return EntryInfo{}, ErrKeyNotFound
+	}
+	info.Shard = idx
+	return info, nil
+}
+
+// entryInfo returns the metadata of key's unexpired entry, without its shard
+// index.
+func (s *Shard) entryInfo(key string) (EntryInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.data[key]
+	now := s.clock().UnixNano()
+	if !ok || elem.Value.(*entry).expired(now) {
+		return EntryInfo{}, false
+	}
+	ent := elem.Value.(*entry)
+	info := EntryInfo{
+		Created:    time.Unix(0, ent.created),
+		LastAccess: time.Unix(0, atomic.LoadInt64(&ent.accessed)),
+		Hits:       atomic.LoadUint32(&ent.hits),
+	}
+	if ent.kind == kindString {
+		info.Value = string(ent.plain())
+	}
+	if ent.expiresAt > 0 {
+		info.TTL = time.Duration(ent.expiresAt - now)
+	}
+	return info, true
+}
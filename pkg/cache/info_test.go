@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetEntryInfo(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := NewShardedCache(WithClock(clock.Now), WithShardCapacity(2), WithShardCount(1))
+	c.SetWithTTL("a", "1", time.Minute)
+	created := clock.Now()
+	c.Set("b", "2")
+
+	clock.Advance(time.Second)
+	c.Get("a")
+	c.Get("a")
+	clock.Advance(time.Second)
+	info, err := c.GetEntryInfo("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EntryInfo{
+		Value:      "1",
+		Created:    created,
+		LastAccess: created.Add(time.Second),
+		Hits:       2,
+		TTL:        58 * time.Second,
+		Shard:      c.shardIndex("a"),
+	}
+	if !info.Created.Equal(want.Created) || !info.LastAccess.Equal(want.LastAccess) {
+		t.Fatalf("expected created %v and accessed %v, got %v and %v", want.Created, want.LastAccess, info.Created, info.LastAccess)
+	}
+	info.Created, info.LastAccess = want.Created, want.LastAccess
+	if info != want {
+		t.Fatalf("expected %+v, got %+v", want, info)
+	}
+	if hits := c.Stats().Hits; hits != 2 {
+		t.Fatalf("expected GetEntryInfo not to count a hit, got %d hits", hits)
+	}
+
+	// Inspecting b must not promote it over a, so b is still evicted first.
+	c.GetEntryInfo("b")
+	c.Set("c", "3")
+	if c.Exists("b") || !c.Exists("a") {
+		t.Fatal("expected b to be evicted as least recently used")
+	}
+
+	// Overwriting a value starts its metadata over.
+	c.Set("a", "new")
+	if info, _ := c.GetEntryInfo("a"); info.Hits != 0 || !info.Created.Equal(clock.Now()) || info.TTL != 0 {
+		t.Fatalf("expected fresh metadata after Set, got %+v", info)
+	}
+	if _, err := c.GetEntryInfo("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestGetEntryInfoReadHeavy(t *testing.T) {
+	c := NewShardedCache(WithReadHeavy(true))
+	c.Set("k", "v")
+	for i := 0; i < 3; i++ {
+		c.Get("k")
+	}
+	if info, err := c.GetEntryInfo("k"); err != nil || info.Hits != 3 {
+		t.Fatalf("expected 3 hits, got %+v, %v", info, err)
+	}
+}
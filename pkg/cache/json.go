@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonEntry is one element of the array written by ExportJSON.
+type jsonEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTLMs int64  `json:"ttl_ms"` // Remaining lifetime; zero means no expiration.
+}
+
+// ExportJSON writes every unexpired string entry to w as a JSON array of
+// {"key", "value", "ttl_ms"} objects, where ttl_ms is the remaining lifetime
+// in milliseconds, rounded up, or zero for entries that never expire. Entries
+// are streamed one shard at a time, least recently used first, so only one
+// shard is copied in memory at once. Values that are not valid UTF-8 are not
+// preserved exactly; use SaveToFile for a lossless copy.
+func (sc *ShardedCache) ExportJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[")
+	sep := "\n"
+	for _, shard := range sc.shards {
+		now := sc.clock().UnixNano()
+		entries := shard.snapshot(now)
+		for i := len(entries) - 1; i >= 0; i-- {
+			ent := entries[i]
+			je := jsonEntry{Key: ent.key, Value: string(ent.plain())}
+			if ent.expiresAt > 0 {
+				remaining := time.Duration(ent.expiresAt - now)
+				je.TTLMs = int64((remaining + time.Millisecond - 1) / time.Millisecond)
+			}
+			b, err := json.Marshal(je)
+			if err != nil {
+				return err
+			}
+			bw.WriteString(sep)
+			// bufio.Writer errors are sticky, so checking the last write suffices.
+			if _, err := bw.Write(b); err != nil {
+				return err
+			}
+			sep = ",\n"
+		}
+	}
+	bw.WriteString("\n]\n")
+	return bw.Flush()
+}
+
+// ImportJSON reads an array in the format written by ExportJSON and stores
+// each entry. It is ImportJSONCounts without the counts.
+func (sc *ShardedCache) ImportJSON(r io.Reader) error {
+	_, _, err := sc.ImportJSONCounts(r)
+	return err
+}
+
+// ImportJSONCounts reads an array in the format written by ExportJSON, decoding
+// one entry at a time, and stores each entry, overwriting existing keys. It
+// returns how many entries were stored and how many were skipped because
+// their value exceeds the WithMaxValueBytes limit or their ttl_ms is negative.
+// Entries are inserted under the cache's capacity settings, so importing more
+// than fits evicts the least recently used entries, as LoadFromFile does.
+// Like LoadFromFile, it does not call the write-through function. On a
+// malformed document the entries before the error remain stored.
+func (sc *ShardedCache) ImportJSONCounts(r io.Reader) (loaded, skipped int, err error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return 0, 0, errors.New("import: expected a JSON array")
+	}
+	for dec.More() {
+		var je jsonEntry
+		if err := dec.Decode(&je); err != nil {
+			return loaded, skipped, fmt.Errorf("import entry %d: %w", loaded+skipped, err)
+		}
+		value := []byte(je.Value)
+		if je.TTLMs < 0 || sc.tooLarge(value) {
+			skipped++
+			continue
+		}
+		ttl := time.Duration(je.TTLMs) * time.Millisecond
+		ent := &entry{key: je.Key, value: value, ttl: ttl, freq: 1}
+		if ttl > 0 {
+			ent.expiresAt = sc.clock().Add(ttl).UnixNano()
+		}
+		sc.notifyEvicted(sc.getShard(ent.key).restore(ent))
+		loaded++
+	}
+	if _, err := dec.Token(); err != nil {
+		return loaded, skipped, fmt.Errorf("import: %w", err)
+	}
+	return loaded, skipped, nil
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	src := NewShardedCache(WithClock(clock.Now))
+	src.Set("plain", "value")
+	src.Set("quoted", `say "hi"`)
+	src.SetWithTTL("session", "alice", 1500*time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := src.ExportJSON(&buf); err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	var parsed []jsonEntry
+	if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("export is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(parsed) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", parsed)
+	}
+	for _, je := range parsed {
+		if want := int64(0); je.Key == "session" {
+			want = 1500
+			if je.TTLMs != want {
+				t.Fatalf("expected ttl_ms %d for session, got %d", want, je.TTLMs)
+			}
+		} else if je.TTLMs != want {
+			t.Fatalf("expected no ttl for %s, got %d", je.Key, je.TTLMs)
+		}
+	}
+
+	dst := NewShardedCache(WithClock(clock.Now))
+	loaded, skipped, err := dst.ImportJSONCounts(&buf)
+	if err != nil || loaded != 3 || skipped != 0 {
+		t.Fatalf("expected 3 loaded and none skipped, got %d, %d, %v", loaded, skipped, err)
+	}
+	if v, _ := dst.Get("quoted"); v != `say "hi"` {
+		t.Fatalf("expected quoted value to survive, got %q", v)
+	}
+	clock.Advance(2 * time.Second)
+	if dst.Exists("session") || !dst.Exists("plain") {
+		t.Fatal("expected only session to expire")
+	}
+}
+
+func TestImportJSONSkipsAndHonorsCapacity(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1), WithShardCapacity(2), WithMaxValueBytes(3))
+	doc := `[
+		{"key": "a", "value": "1", "ttl_ms": 0},
+		{"key": "big", "value": "toolong", "ttl_ms": 0},
+		{"key": "gone", "value": "x", "ttl_ms": -5},
+		{"key": "b", "value": "2", "ttl_ms": 0},
+		{"key": "c", "value": "3", "ttl_ms": 0}
+	]`
+	loaded, skipped, err := c.ImportJSONCounts(strings.NewReader(doc))
+	if err != nil || loaded != 3 || skipped != 2 {
+		t.Fatalf("expected 3 loaded and 2 skipped, got %d, %d, %v", loaded, skipped, err)
+	}
+	if c.Len() != 2 || c.Exists("a") {
+		t.Fatalf("expected capacity to evict the oldest import, have %v", c.Keys())
+	}
+}
+
+func TestImportJSONMalformed(t *testing.T) {
+	c := NewShardedCache()
+	for _, doc := range []string{`{"key": "a"}`, `[{"key": "a", "value": "1"}, {"key": 5}]`, `[{"key": "a", "value": "1"}`} {
+		if err := c.ImportJSON(strings.NewReader(doc)); err == nil {
+			t.Errorf("expected an error importing %s", doc)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// valueKind is the type of value an entry holds. Strings live in entry.value;
+// every other kind lives in entry.object and is only ever read or modified
+// under the shard lock.
+type valueKind uint8
+
+const (
+	kindString valueKind = iota
+	kindHash             // object is a map[string]string of fields to values.
+	kindList             // object is a *deque of elements.
+	kindSet              // object is a map[string]struct{} of members.
+	kindZSet             // object is a *zset.
+)
+
+// detached returns a copy of e that may be read after the shard lock is
+// released. Objects are modified in place, so the copy of an entry of another
+// kind holds its object encoded in value; see encodeObject. The caller must
+// hold the shard lock.
+func (e *entry) detached() entry {
+	c := *e
+	if e.kind != kindString {
+		c.value, c.object, c.compressed = encodeObject(e.kind, e.object), nil, false
+	}
+	return c
+}
+
+// encodeObject serializes an object of the given kind as the uvarint number
+// of its items followed by the items. A hash item is a field and its value,
+// a list item an element, counting from the front, a set item a member, and
+// a sorted set item a member and its score, as the 8 big-endian bytes of its
+// IEEE 754 bits. Strings are prefixed with their uvarint length.
+func encodeObject(kind valueKind, object any) []byte {
+	var b []byte
+	appendString := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	switch kind {
+	case kindHash:
+		h := object.(map[string]string)
+		b = binary.AppendUvarint(b, uint64(len(h)))
+		for field, value := range h {
+			appendString(field)
+			appendString(value)
+		}
+	case kindList:
+		d := object.(*deque)
+		b = binary.AppendUvarint(b, uint64(d.len()))
+		for i := 0; i < d.len(); i++ {
+			appendString(d.at(i))
+		}
+	case kindSet:
+		set := object.(map[string]struct{})
+		b = binary.AppendUvarint(b, uint64(len(set)))
+		for member := range set {
+			appendString(member)
+		}
+	case kindZSet:
+		z := object.(*zset)
+		b = binary.AppendUvarint(b, uint64(len(z.scores)))
+		for member, score := range z.scores {
+			appendString(member)
+			b = binary.BigEndian.AppendUint64(b, math.Float64bits(score))
+		}
+	}
+	return b
+}
+
+// errBadObject is returned by decodeObject for a malformed encoding.
+var errBadObject = errors.New("malformed object")
+
+// decodeObject rebuilds an object of the given kind from its encodeObject
+// form and returns it along with its size for the shard's byte budget.
+func decodeObject(kind valueKind, b []byte) (any, int64, error) {
+	n, k := binary.Uvarint(b)
+	// Every item takes at least a byte, which bounds a corrupt count.
+	if k <= 0 || n > uint64(len(b)) {
+		return nil, 0, errBadObject
+	}
+	b = b[k:]
+	readString := func() (string, bool) {
+		n, k := binary.Uvarint(b)
+		if k <= 0 || uint64(len(b)-k) < n {
+			return "", false
+		}
+		s := string(b[k : k+int(n)])
+		b = b[k+int(n):]
+		return s, true
+	}
+	var object any
+	var size int64
+	switch kind {
+	case kindHash:
+		h := make(map[string]string, n)
+		for range n {
+			field, ok1 := readString()
+			value, ok2 := readString()
+			if !ok1 || !ok2 {
+				return nil, 0, errBadObject
+			}
+			if old, ok := h[field]; ok {
+				size -= fieldSize(field, old)
+			}
+			h[field] = value
+			size += fieldSize(field, value)
+		}
+		object = h
+	case kindList:
+		d := new(deque)
+		for range n {
+			value, ok := readString()
+			if !ok {
+				return nil, 0, errBadObject
+			}
+			d.pushBack(value)
+			size += elementSize(value)
+		}
+		object = d
+	case kindSet:
+		set := make(map[string]struct{}, n)
+		for range n {
+			member, ok := readString()
+			if !ok {
+				return nil, 0, errBadObject
+			}
+			if _, ok := set[member]; !ok {
+				set[member] = struct{}{}
+				size += memberSize(member)
+			}
+		}
+		object = set
+	case kindZSet:
+		z := newZSet()
+		for range n {
+			member, ok := readString()
+			if !ok || len(b) < 8 {
+				return nil, 0, errBadObject
+			}
+			score := math.Float64frombits(binary.BigEndian.Uint64(b))
+			b = b[8:]
+			if math.IsNaN(score) {
+				return nil, 0, errBadObject
+			}
+			if old, ok := z.scores[member]; ok {
+				z.sl.delete(old, member)
+			} else {
+				size += zmemberSize(member)
+			}
+			z.scores[member] = score
+			z.sl.insert(score, member)
+		}
+		object = z
+	default:
+		return nil, 0, errBadObject
+	}
+	if len(b) != 0 || n == 0 {
+		return nil, 0, errBadObject
+	}
+	return object, size, nil
+}
+
+// liveObject returns the element for key's unexpired entry of the given kind.
+// It fails with ErrKeyNotFound if there is none and ErrWrongType if the entry
+// holds another kind. The caller must hold the shard lock.
+func (s *Shard) liveObject(key string, kind valueKind, now int64) (*list.Element, error) {
+	elem, ok := s.live(key, now)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if elem.Value.(*entry).kind != kind {
+		return nil, ErrWrongType
+	}
+	return elem, nil
+}
+
+// insertObject stores object under key as a new entry of the given kind,
+// replacing any entry there, and returns its element along with the entries
+// evicted to make room. The object counts as empty until resizeObject is
+// called. The caller must hold the shard lock.
+func (s *Shard) insertObject(key string, kind valueKind, object any, ttl time.Duration) (*list.Element, []entry) {
+	ent := &entry{
+		key:       key,
+		ttl:       ttl,
+		expiresAt: expirationFrom(s.clock(), ttl),
+		freq:      1,
+		kind:      kind,
+		object:    object,
+	}
+	evicted := s.insertLocked(ent)
+	return s.data[key], evicted
+}
+
+// objectFor returns the element for key's unexpired entry of the given kind,
+// storing the result of create with the given ttl if key is missing, along
+// with the entries evicted to make room. The caller must hold the shard lock.
+func (s *Shard) objectFor(key string, kind valueKind, ttl time.Duration, create func() any) (*list.Element, []entry, error) {
+	elem, err := s.liveObject(key, kind, s.clock().UnixNano())
+	if err == ErrKeyNotFound {
+		elem, evicted := s.insertObject(key, kind, create(), ttl)
+		return elem, evicted, nil
+	}
+	return elem, nil, err
+}
+
+// resizeObject adds delta to the size of the object held by elem after a
+// write, gives the entry a new version, promotes it, and returns the entries
+// evicted to stay within the shard's budgets. The caller must hold the shard
+// lock.
+func (s *Shard) resizeObject(elem *list.Element, delta int64) []entry {
+	elem.Value.(*entry).objectSize += delta
+	s.bytes += delta
+	s.bump(elem.Value.(*entry))
+	s.touch(elem)
+	return s.evictOverflow(elem)
+}
+
+// readObject records a read of the object held by elem at now: it extends a
+// sliding TTL, promotes the entry, and counts a hit. The caller must hold the
+// shard lock.
+func (s *Shard) readObject(elem *list.Element, now time.Time) {
+	if ent := elem.Value.(*entry); s.slidingTTL && ent.ttl > 0 {
+		ent.expiresAt = now.Add(ent.ttl).UnixNano()
+	}
+	s.hit(elem)
+}
@@ -0,0 +1,268 @@
+package cache
+
+import "time"
+
+// elementOverhead approximates the per-element bookkeeping cost of a list in
+// bytes: its slot in the deque's buffer.
+const elementOverhead = 16
+
+// elementSize returns the approximate size of one list element in bytes.
+func elementSize(value string) int64 {
+	return int64(len(value)) + elementOverhead
+}
+
+// deque is a double-ended queue of strings backed by a ring buffer, so pushes
+// and pops at either end and indexing are all O(1).
+type deque struct {
+	buf  []string
+	head int // Index in buf of the first element.
+	n    int
+}
+
+func (d *deque) len() int { return d.n }
+
+// at returns the i-th element, counting from the front.
+func (d *deque) at(i int) string {
+	return d.buf[(d.head+i)%len(d.buf)]
+}
+
+// grow makes room for at least one more element.
+func (d *deque) grow() {
+	if d.n < len(d.buf) {
+		return
+	}
+	buf := make([]string, max(4, 2*len(d.buf)))
+	for i := 0; i < d.n; i++ {
+		buf[i] = d.at(i)
+	}
+	d.buf, d.head = buf, 0
+}
+
+func (d *deque) pushFront(value string) {
+	d.grow()
+	d.head = (d.head + len(d.buf) - 1) % len(d.buf)
+	d.buf[d.head] = value
+	d.n++
+}
+
+func (d *deque) pushBack(value string) {
+	d.grow()
+	d.buf[(d.head+d.n)%len(d.buf)] = value
+	d.n++
+}
+
+func (d *deque) popFront() string {
+	value := d.buf[d.head]
+	d.buf[d.head] = ""
+	d.head = (d.head + 1) % len(d.buf)
+	d.n--
+	return value
+}
+
+func (d *deque) popBack() string {
+	i := (d.head + d.n - 1) % len(d.buf)
+	value := d.buf[i]
+	d.buf[i] = ""
+	d.n--
+	return value
+}
+
+// listRange resolves start and stop, which may count back from the end when
+// negative, to a half-open range of indexes into a list of length n. The
+// range is empty if it falls outside the list.
+func listRange(start, stop, n int) (from, to int) {
+	if start < 0 {
+		start = max(0, n+start)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// LPush inserts values at the head of the list stored at key, one after
+// another, creating the list with the default TTL if key is missing, and
+// returns its new length. The whole list is one entry for LRU and capacity
+// purposes, and its elements count towards WithMaxBytes. It returns
+// ErrWrongType if key holds another kind of value and ErrValueTooLarge if a
+// value exceeds the WithMaxValueBytes limit, in which case nothing is pushed.
+// Like HSet, list writes do not call the write-through function.
+func (sc *ShardedCache) LPush(key string, values ...string) (int, error) {
+	return sc.push(key, values, true)
+}
+
+// RPush is like LPush but appends values at the tail of the list.
+func (sc *ShardedCache) RPush(key string, values ...string) (int, error) {
+	return sc.push(key, values, false)
+}
+
+func (sc *ShardedCache) push(key string, values []string, front bool) (int, error) {
+	for _, value := range values {
+		if sc.tooLarge([]byte(value)) {
+			return 0, ErrValueTooLarge
+		}
+	}
+	n, evicted, err := sc.getShard(key).push(key, values, front, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return n, err
+}
+
+// LPop removes and returns the first element of the list stored at key.
+// Popping the last element deletes the key. It returns ErrKeyNotFound if key
+// is missing and ErrWrongType if it holds another kind of value.
+func (sc *ShardedCache) LPop(key string) (string, error) {
+	return sc.getShard(key).pop(key, true)
+}
+
+// RPop is like LPop but removes the last element of the list.
+func (sc *ShardedCache) RPop(key string) (string, error) {
+	return sc.getShard(key).pop(key, false)
+}
+
+// LRange returns the elements of the list stored at key from start to stop
+// inclusive. Negative indexes count back from the end, so -1 is the last
+// element, and out-of-range indexes are clamped to the list. A missing key
+// yields an empty slice.
+func (sc *ShardedCache) LRange(key string, start, stop int) ([]string, error) {
+	return sc.getShard(key).lrange(key, start, stop)
+}
+
+// LLen returns the length of the list stored at key, or 0 if key is missing.
+func (sc *ShardedCache) LLen(key string) (int, error) {
+	return sc.getShard(key).llen(key)
+}
+
+// LTrim keeps only the elements of the list stored at key from start to stop
+// inclusive, with the same index semantics as LRange, and deletes the key if
+// no element is left. LPush followed by LTrim(key, 0, n-1) caps a list at its
+// n most recent elements.
+func (sc *ShardedCache) LTrim(key string, start, stop int) error {
+	return sc.getShard(key).ltrim(key, start, stop)
+}
+
+// push adds values to one end of the list at key and returns its length.
+func (s *Shard) push(key string, values []string, front bool, ttl time.Duration) (int, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, evicted, err := s.objectFor(key, kindList, ttl, func() any { return new(deque) })
+	if err != nil {
+		return 0, nil, err
+	}
+	d := elem.Value.(*entry).object.(*deque)
+	var delta int64
+	for _, value := range values {
+		if front {
+			d.pushFront(value)
+		} else {
+			d.pushBack(value)
+		}
+		delta += elementSize(value)
+	}
+	s.stats.sets.Add(1)
+	return d.len(), append(evicted, s.resizeObject(elem, delta)...), nil
+}
+
+// pop removes one element from an end of the list at key, deleting the key
+// once the list is empty.
+func (s *Shard) pop(key string, front bool) (string, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindList, now.UnixNano())
+	if err != nil {
+		if err == ErrKeyNotFound {
+			s.stats.misses.Add(1)
+		}
+		return "", err
+	}
+	d := elem.Value.(*entry).object.(*deque)
+	var value string
+	if front {
+		value = d.popFront()
+	} else {
+		value = d.popBack()
+	}
+	s.stats.hits.Add(1)
+	if d.len() == 0 {
+		s.removeElement(elem)
+		s.stats.deletes.Add(1)
+		return value, nil
+	}
+	s.resizeObject(elem, -elementSize(value)) // Shrinking never evicts.
+	return value, nil
+}
+
+// lrange copies a range of the list at key.
+func (s *Shard) lrange(key string, start, stop int) ([]string, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindList, now.UnixNano())
+	if err == ErrKeyNotFound {
+		s.stats.misses.Add(1)
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.readObject(elem, now)
+	d := elem.Value.(*entry).object.(*deque)
+	from, to := listRange(start, stop, d.len())
+	values := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		values = append(values, d.at(i))
+	}
+	return values, nil
+}
+
+// llen returns the length of the list at key without promoting it.
+func (s *Shard) llen(key string) (int, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, err := s.liveObject(key, kindList, s.clock().UnixNano())
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return elem.Value.(*entry).object.(*deque).len(), nil
+}
+
+// ltrim drops the elements of the list at key outside a range.
+func (s *Shard) ltrim(key string, start, stop int) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, err := s.liveObject(key, kindList, s.clock().UnixNano())
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d := elem.Value.(*entry).object.(*deque)
+	from, to := listRange(start, stop, d.len())
+	if from == to {
+		s.removeElement(elem)
+		s.stats.deletes.Add(1)
+		return nil
+	}
+	var delta int64
+	for d.len() > to {
+		delta -= elementSize(d.popBack())
+	}
+	for i := 0; i < from; i++ {
+		delta -= elementSize(d.popFront())
+	}
+	s.resizeObject(elem, delta)
+	return nil
+}
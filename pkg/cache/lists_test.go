@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestListPushPop(t *testing.T) {
+	c := NewShardedCache()
+	if n, err := c.RPush("q", "b", "c"); err != nil || n != 2 {
+		t.Fatalf("expected length 2, got %d, %v", n, err)
+	}
+	if n, _ := c.LPush("q", "a", "z"); n != 4 {
+		t.Fatalf("expected length 4, got %d", n)
+	}
+	if got, _ := c.LRange("q", 0, -1); !slices.Equal(got, []string{"z", "a", "b", "c"}) {
+		t.Fatalf("unexpected list %v", got)
+	}
+	if v, err := c.LPop("q"); err != nil || v != "z" {
+		t.Fatalf("expected z, got %q, %v", v, err)
+	}
+	if v, err := c.RPop("q"); err != nil || v != "c" {
+		t.Fatalf("expected c, got %q, %v", v, err)
+	}
+	if n, _ := c.LLen("q"); n != 2 {
+		t.Fatalf("expected length 2, got %d", n)
+	}
+	c.LPop("q")
+	c.LPop("q")
+	if c.Exists("q") {
+		t.Fatal("expected popping the last element to delete the key")
+	}
+	if _, err := c.LPop("q"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if n, err := c.LLen("q"); err != nil || n != 0 {
+		t.Fatalf("expected a missing list to have length 0, got %d, %v", n, err)
+	}
+}
+
+func TestListRange(t *testing.T) {
+	c := NewShardedCache()
+	c.RPush("l", "0", "1", "2", "3", "4")
+	for _, tc := range []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, 2, []string{"0", "1", "2"}},
+		{-2, -1, []string{"3", "4"}},
+		{-100, 1, []string{"0", "1"}},
+		{3, 100, []string{"3", "4"}},
+		{3, 1, []string{}},
+		{5, 10, []string{}},
+		{0, -6, []string{}},
+	} {
+		got, err := c.LRange("l", tc.start, tc.stop)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("LRange(%d, %d) = %v, %v; want %v", tc.start, tc.stop, got, err, tc.want)
+		}
+	}
+	if got, err := c.LRange("missing", 0, -1); err != nil || got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty slice for a missing key, got %v, %v", got, err)
+	}
+}
+
+func TestListTrim(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1))
+	for i := 0; i < 10; i++ {
+		c.LPush("recent", fmt.Sprint(i))
+		c.LTrim("recent", 0, 2)
+	}
+	if got, _ := c.LRange("recent", 0, -1); !slices.Equal(got, []string{"9", "8", "7"}) {
+		t.Fatalf("expected the three most recent items, got %v", got)
+	}
+	if got := c.MemoryUsage(); got != 3*elementSize("9")+entryOverhead+int64(len("recent")) {
+		t.Fatalf("expected trimmed elements to leave the memory count, got %d", got)
+	}
+	c.LTrim("recent", 5, 10)
+	if c.Exists("recent") {
+		t.Fatal("expected trimming every element to delete the key")
+	}
+}
+
+func TestListWrongType(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("str", "v")
+	c.RPush("list", "a")
+	c.HSet("hash", "f", "v")
+
+	if _, err := c.LPush("str", "x"); err != ErrWrongType {
+		t.Fatalf("expected LPush on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.RPop("str"); err != ErrWrongType {
+		t.Fatalf("expected RPop on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.LRange("hash", 0, -1); err != ErrWrongType {
+		t.Fatalf("expected LRange on a hash to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.LLen("str"); err != ErrWrongType {
+		t.Fatalf("expected LLen on a string to fail with ErrWrongType, got %v", err)
+	}
+	if err := c.LTrim("str", 0, 1); err != ErrWrongType {
+		t.Fatalf("expected LTrim on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.Get("list"); err != ErrWrongType {
+		t.Fatalf("expected Get on a list to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.HGet("list", "f"); err != ErrWrongType {
+		t.Fatalf("expected HGet on a list to fail with ErrWrongType, got %v", err)
+	}
+}
+
+func TestListValueTooLarge(t *testing.T) {
+	c := NewShardedCache(WithMaxValueBytes(3))
+	if _, err := c.RPush("l", "ok", "too large"); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if c.Exists("l") {
+		t.Fatal("expected nothing pushed")
+	}
+}
+
+func TestDequeWraps(t *testing.T) {
+	var d deque
+	for i := 0; i < 100; i++ {
+		d.pushBack(fmt.Sprint(i))
+		if i%3 == 0 {
+			d.popFront()
+		}
+		if i%5 == 0 {
+			d.pushFront("f")
+		}
+	}
+	var want []string
+	for i := 0; i < 100; i++ {
+		want = append(want, fmt.Sprint(i))
+		if i%3 == 0 {
+			want = want[1:]
+		}
+		if i%5 == 0 {
+			want = append([]string{"f"}, want...)
+		}
+	}
+	if d.len() != len(want) {
+		t.Fatalf("expected %d elements, got %d", len(want), d.len())
+	}
+	for i, v := range want {
+		if got := d.at(i); got != v {
+			t.Fatalf("element %d: expected %q, got %q", i, v, got)
+		}
+	}
+}
+
+func TestListConcurrentPushPop(t *testing.T) {
+	c := NewShardedCache()
+	const pushers, perPusher = 4, 500
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	popped := make(map[string]bool)
+	for p := 0; p < pushers; p++ {
+		wg.Add(2)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perPusher; i++ {
+				if i%2 == 0 {
+					c.LPush("q", fmt.Sprintf("%d-%d", p, i))
+				} else {
+					c.RPush("q", fmt.Sprintf("%d-%d", p, i))
+				}
+			}
+		}(p)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perPusher; i++ {
+				pop := c.LPop
+				if i%2 == 0 {
+					pop = c.RPop
+				}
+				if v, err := pop("q"); err == nil {
+					mu.Lock()
+					if popped[v] {
+						t.Errorf("%s popped twice", v)
+					}
+					popped[v] = true
+					mu.Unlock()
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	rest, _ := c.LRange("q", 0, -1)
+	for _, v := range rest {
+		if popped[v] {
+			t.Fatalf("%s both popped and left in the list", v)
+		}
+	}
+	if got := len(popped) + len(rest); got != pushers*perPusher {
+		t.Fatalf("expected %d elements in total, got %d", pushers*perPusher, got)
+	}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// call is an in-flight or completed loader invocation.
+type call struct {
+	wg    sync.WaitGroup
+	value string
+	err   error
+}
+
+// flightGroup deduplicates concurrent loads of the same key so only one
+// loader runs at a time per key.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fn for key unless a call for key is already in flight, in which case
+// it waits for that call and returns its result. A panic in fn is recovered and
+// reported to every caller as an error wrapping ErrLoaderPanic.
+func (g *flightGroup) do(key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.value, c.err = "", fmt.Errorf("%w: %v", ErrLoaderPanic, r)
+			}
+		}()
+		c.value, c.err = fn()
+	}()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.wg.Done()
+	return c.value, c.err
+}
+
+// GetOrCompute returns the value for key, calling loader to produce and store
+// it on a miss. Concurrent callers that miss on the same key share a single
+// loader invocation and all receive its result. The loader runs without any
+// shard lock held. Loader errors are returned to every waiting caller and are
+// not cached, so the next call retries. A panicking loader is reported as an
+// error wrapping ErrLoaderPanic.
+func (sc *ShardedCache) GetOrCompute(key string, loader func() (string, error)) (string, error) {
+	if value, err := sc.Get(key); err == nil || err == ErrWrongType {
+		return value, err
+	}
+	return sc.flights.do(key, func() (string, error) {
+		// Another flight may have stored the value since our miss.
+		if value, err := sc.getShard(key).lookup(key); err != ErrKeyNotFound {
+			return string(value), err
+		}
+		value, err := loader()
+		if err != nil {
+			return "", err
+		}
+		sc.storeLoaded(key, value, DefaultExpiration)
+		return value, nil
+	})
+}
+
+// load fetches key with the loader configured by WithLoader, sharing a single
+// loader invocation among concurrent misses on the same key. The result is
+// stored with the TTL the loader returns. Errors are returned but not cached,
+// except that ErrKeyNotFound is remembered when WithNegativeTTL is set.
+func (sc *ShardedCache) load(key string) (string, error) {
+	return sc.flights.do(key, func() (string, error) {
+		shard := sc.getShard(key)
+		// Another flight may have stored the value since our miss.
+		if value, err := shard.lookup(key); err != ErrKeyNotFound {
+			return string(value), err
+		}
+		if sc.negativeTTL > 0 && shard.knownMissing(key, sc.clock().UnixNano()) {
+			return "", ErrKeyNotFound
+		}
+		value, ttl, err := sc.loader(key)
+		if err != nil {
+			if sc.negativeTTL > 0 && errors.Is(err, ErrKeyNotFound) {
+				shard.rememberMissing(key, sc.clock().Add(sc.negativeTTL).UnixNano())
+			}
+			return "", err
+		}
+		sc.storeLoaded(key, value, ttl)
+		return value, nil
+	})
+}
+
+// storeLoaded caches a value produced by a loader. It already came from the
+// backing store, so it is not written through.
+func (sc *ShardedCache) storeLoaded(key, value string, ttl time.Duration) {
+	if !sc.tooLarge([]byte(value)) {
+		sc.store(key, []byte(value), ttl, nil)
+	}
+}
+
+// knownMissing reports whether key has an unexpired negative entry.
+func (s *Shard) knownMissing(key string, now int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expiresAt, ok := s.negative[key]
+	return ok && now < expiresAt
+}
+
+// rememberMissing records that key does not exist until expiresAt, unless the
+// key was stored while the loader ran.
+func (s *Shard) rememberMissing(key string, expiresAt int64) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if _, ok := s.data[key]; ok {
+		return
+	}
+	if s.negative == nil {
+		s.negative = make(map[string]int64)
+	}
+	s.negative[key] = expiresAt
+}
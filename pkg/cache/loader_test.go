@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrComputeSingleFlight(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func() (string, error) {
+		calls.Add(1)
+		<-release
+		return "computed", nil
+	}
+
+	const callers = 100
+	var wg sync.WaitGroup
+	var started sync.WaitGroup
+	results := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			v, err := cache.GetOrCompute("hot", loader)
+			if err != nil {
+				t.Errorf("caller %d: unexpected error %v", i, err)
+			}
+			results[i] = v
+		}(i)
+	}
+	started.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected loader to run exactly once, ran %d times", n)
+	}
+	for i, v := range results {
+		if v != "computed" {
+			t.Fatalf("caller %d got %q, expected 'computed'", i, v)
+		}
+	}
+	if v, err := cache.Get("hot"); err != nil || v != "computed" {
+		t.Fatalf("expected computed value to be cached, got %q, %v", v, err)
+	}
+	if len(cache.flights.calls) != 0 {
+		t.Fatalf("expected in-flight map to be empty, has %d entries", len(cache.flights.calls))
+	}
+}
+
+func TestGetOrComputeErrorNotCached(t *testing.T) {
+	cache := NewShardedCache()
+	failure := errors.New("backend down")
+	calls := 0
+	loader := func() (string, error) {
+		calls++
+		if calls == 1 {
+			return "", failure
+		}
+		return "recovered", nil
+	}
+
+	if _, err := cache.GetOrCompute("key", loader); !errors.Is(err, failure) {
+		t.Fatalf("expected loader error, got %v", err)
+	}
+	if cache.Exists("key") {
+		t.Fatal("expected failed load not to be cached")
+	}
+	if v, err := cache.GetOrCompute("key", loader); err != nil || v != "recovered" {
+		t.Fatalf("expected retry to succeed, got %q, %v", v, err)
+	}
+}
+
+func TestWithLoaderReadThrough(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewShardedCache(WithLoader(func(key string) (string, time.Duration, error) {
+		calls.Add(1)
+		return "loaded:" + key, 20 * time.Millisecond, nil
+	}))
+
+	if v, err := cache.Get("a"); err != nil || v != "loaded:a" {
+		t.Fatalf("expected loaded value, got %q, %v", v, err)
+	}
+	if v, _ := cache.Get("a"); v != "loaded:a" || calls.Load() != 1 {
+		t.Fatalf("expected cached value without a second load, got %q after %d loads", v, calls.Load())
+	}
+
+	// The loader's TTL applies to the stored value.
+	time.Sleep(40 * time.Millisecond)
+	cache.Get("a")
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected expired value to be reloaded, loader ran %d times", n)
+	}
+}
+
+func TestWithLoaderConcurrentMisses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	failure := errors.New("backend down")
+	cache := NewShardedCache(WithLoader(func(key string) (string, time.Duration, error) {
+		calls.Add(1)
+		<-release
+		return "", 0, failure
+	}))
+
+	const callers = 50
+	var wg sync.WaitGroup
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = cache.Get("key")
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected loader to run exactly once, ran %d times", n)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, failure) {
+			t.Fatalf("caller %d: expected loader error, got %v", i, err)
+		}
+	}
+	if cache.Exists("key") {
+		t.Fatal("expected failed load not to be cached")
+	}
+}
+
+func TestWithLoaderPanicRecovered(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewShardedCache(WithLoader(func(key string) (string, time.Duration, error) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		return "ok", 0, nil
+	}))
+
+	if _, err := cache.Get("key"); !errors.Is(err, ErrLoaderPanic) {
+		t.Fatalf("expected ErrLoaderPanic, got %v", err)
+	}
+	if len(cache.flights.calls) != 0 {
+		t.Fatalf("expected in-flight map to be empty after a panic, has %d entries", len(cache.flights.calls))
+	}
+	if v, err := cache.Get("key"); err != nil || v != "ok" {
+		t.Fatalf("expected retry after panic to succeed, got %q, %v", v, err)
+	}
+}
+
+func TestWithLoaderEvictionDuringLoad(t *testing.T) {
+	var evicted []string
+	var cache *ShardedCache
+	cache = NewShardedCache(
+		WithShardCount(1),
+		WithShardCapacity(1),
+		WithOnEvict(func(key, value string) { evicted = append(evicted, key) }),
+		WithLoader(func(key string) (string, time.Duration, error) {
+			// Fill the shard while the load is in progress.
+			cache.Set("other", "v")
+			return "loaded", 0, nil
+		}),
+	)
+
+	if v, err := cache.Get("key"); err != nil || v != "loaded" {
+		t.Fatalf("expected loaded value, got %q, %v", v, err)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("expected capacity to hold after load, got %d items", n)
+	}
+	if len(evicted) != 1 || evicted[0] != "other" {
+		t.Fatalf("expected 'other' to be evicted by the loaded value, got %v", evicted)
+	}
+}
+
+func TestNegativeTTLCachesMisses(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewShardedCache(
+		WithNegativeTTL(30*time.Millisecond),
+		WithLoader(func(key string) (string, time.Duration, error) {
+			calls.Add(1)
+			return "", 0, ErrKeyNotFound
+		}),
+	)
+
+	for i := 0; i < 10; i++ {
+		if _, err := cache.Get("ghost"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected one load per negative-TTL window, got %d", n)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cache.Get("ghost")
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected a new load after the window expired, got %d", n)
+	}
+}
+
+func TestNegativeTTLClearedBySet(t *testing.T) {
+	cache := NewShardedCache(
+		WithNegativeTTL(time.Hour),
+		WithLoader(func(key string) (string, time.Duration, error) {
+			return "", 0, ErrKeyNotFound
+		}),
+	)
+	cache.Get("key")
+
+	cache.Set("key", "now exists")
+	if v, err := cache.Get("key"); err != nil || v != "now exists" {
+		t.Fatalf("expected Set to clear the negative entry, got %q, %v", v, err)
+	}
+	cache.Delete("key")
+	if n := len(cache.getShard("key").negative); n != 0 {
+		t.Fatalf("expected no negative entries after Set, got %d", n)
+	}
+}
+
+// fakeClock is a manually advanced clock for WithClock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache := NewShardedCache(
+		WithClock(clock.Now),
+		WithStaleWhileRevalidate(time.Minute),
+		WithLoader(func(key string) (string, time.Duration, error) {
+			if calls.Add(1) > 1 {
+				<-release
+			}
+			return "v" + strconv.Itoa(int(calls.Load())), 10 * time.Second, nil
+		}),
+	)
+
+	// Fresh: the first Get loads synchronously.
+	if v, err := cache.Get("k"); err != nil || v != "v1" {
+		t.Fatalf("expected fresh load 'v1', got %q, %v", v, err)
+	}
+
+	// Stale: within the grace window the old value is served immediately and
+	// exactly one refresh is started.
+	clock.Advance(15 * time.Second)
+	for i := 0; i < 5; i++ {
+		if v, err := cache.Get("k"); err != nil || v != "v1" {
+			t.Fatalf("expected stale value 'v1', got %q, %v", v, err)
+		}
+	}
+	if st := cache.Stats(); st.StaleHits != 5 {
+		t.Fatalf("expected 5 stale hits, got %d", st.StaleHits)
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := cache.Get("k"); v == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected background refresh to store 'v2'")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected exactly one refresh, loader ran %d times", n)
+	}
+
+	// Fully expired: past the grace window Get waits on the loader again.
+	clock.Advance(10*time.Second + 2*time.Minute)
+	if v, err := cache.Get("k"); err != nil || v != "v3" {
+		t.Fatalf("expected synchronous reload 'v3', got %q, %v", v, err)
+	}
+}
+
+func TestStaleEntriesSurviveJanitorDuringGrace(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewShardedCache(
+		WithClock(clock.Now),
+		WithStaleWhileRevalidate(time.Minute),
+		WithLoader(func(key string) (string, time.Duration, error) {
+			return "", 0, errors.New("backend down")
+		}),
+	)
+	cache.SetWithTTL("k", "v", time.Second)
+
+	clock.Advance(30 * time.Second)
+	if n := cache.DeleteExpired(); n != 0 {
+		t.Fatalf("expected stale entry to be kept during grace, removed %d", n)
+	}
+	clock.Advance(time.Minute)
+	if n := cache.DeleteExpired(); n != 1 {
+		t.Fatalf("expected entry to be removed after grace, removed %d", n)
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import "time"
+
+// shardGroup is a run of keys that hash to the same shard.
+type shardGroup struct {
+	shard *Shard
+	keys  []string
+}
+
+// groupByShard buckets keys by the shard they hash to using a counting sort,
+// so the grouping costs a fixed number of allocations regardless of how many
+// shards are touched.
+func (sc *ShardedCache) groupByShard(keys []string) []shardGroup {
+	idx := make([]int, len(keys))
+	counts := make([]int, len(sc.shards)+1)
+	for i, key := range keys {
+		idx[i] = sc.shardIndex(key)
+		counts[idx[i]+1]++
+	}
+	touched := 0
+	for i := 1; i < len(counts); i++ {
+		if counts[i] > 0 {
+			touched++
+		}
+		counts[i] += counts[i-1]
+	}
+
+	sorted := make([]string, len(keys))
+	next := append([]int(nil), counts[:len(sc.shards)]...)
+	for i, key := range keys {
+		sorted[next[idx[i]]] = key
+		next[idx[i]]++
+	}
+
+	groups := make([]shardGroup, 0, touched)
+	for i, shard := range sc.shards {
+		if start, end := counts[i], counts[i+1]; end > start {
+			groups = append(groups, shardGroup{shard: shard, keys: sorted[start:end]})
+		}
+	}
+	return groups
+}
+
+// MGet returns the values of the given keys that are present and unexpired.
+// Missing keys, and keys holding values other than strings, are absent from
+// the result. Keys are grouped by shard and each
+// shard's group is read under a single lock acquisition.
+func (sc *ShardedCache) MGet(keys ...string) map[string]string {
+	result := make(map[string]string, len(keys))
+	now := sc.clock()
+	for _, g := range sc.groupByShard(keys) {
+		g.shard.mget(g.keys, result, now)
+	}
+	return result
+}
+
+// MSet stores all pairs with the default TTL, taking each shard's lock once for
+// all of the pairs that hash to it. Pairs whose value exceeds the
+// WithMaxValueBytes limit are skipped.
+func (sc *ShardedCache) MSet(pairs map[string]string) {
+	keys := make([]string, 0, len(pairs))
+	for key, value := range pairs {
+		if sc.maxValueBytes > 0 && len(value) > sc.maxValueBytes {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	ttl := sc.resolveTTL(DefaultExpiration)
+	for _, g := range sc.groupByShard(keys) {
+		sc.notifyEvicted(g.shard.mset(g.keys, pairs, ttl))
+	}
+}
+
+// MDel removes the given keys, taking each shard's lock once, and returns the
+// number of keys that were present.
+func (sc *ShardedCache) MDel(keys ...string) int {
+	removed := 0
+	for _, g := range sc.groupByShard(keys) {
+		removed += g.shard.mdel(g.keys)
+	}
+	return removed
+}
+
+// mget copies the string values of keys unexpired at now into result,
+// promoting each hit.
+func (s *Shard) mget(keys []string, result map[string]string, now time.Time) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	for _, key := range keys {
+		elem, ok := s.live(key, now.UnixNano())
+		if !ok || elem.Value.(*entry).kind != kindString {
+			s.stats.misses.Add(1)
+			continue
+		}
+		ent := elem.Value.(*entry)
+		if s.slidingTTL && ent.ttl > 0 {
+			ent.expiresAt = now.Add(ent.ttl).UnixNano()
+		}
+		s.hit(elem)
+		result[key] = string(ent.plain())
+	}
+}
+
+// mset stores pairs[key] for each key with the given ttl.
+func (s *Shard) mset(keys []string, pairs map[string]string, ttl time.Duration) []entry {
+	s.mu.Lock()
+	defer s.unlock()
+
+	var evicted []entry
+	for _, key := range keys {
+		evicted = append(evicted, s.setLocked(key, []byte(pairs[key]), ttl)...)
+	}
+	return evicted
+}
+
+// mdel removes keys from the shard and returns how many were present.
+func (s *Shard) mdel(keys []string) int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	removed := 0
+	for _, key := range keys {
+		if elem, ok := s.data[key]; ok {
+			s.removeElement(elem)
+			s.stats.deletes.Add(1)
+			removed++
+		}
+	}
+	return removed
+}
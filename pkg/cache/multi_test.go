@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardedCacheMSetMGet(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8))
+	pairs := map[string]string{}
+	for i := 0; i < 20; i++ {
+		pairs[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("value-%d", i)
+	}
+	cache.MSet(pairs)
+
+	keys := []string{"missing"}
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	got := cache.MGet(keys...)
+	if len(got) != len(pairs) {
+		t.Fatalf("expected %d values, got %d", len(pairs), len(got))
+	}
+	for key, want := range pairs {
+		if got[key] != want {
+			t.Fatalf("key %q: expected %q, got %q", key, want, got[key])
+		}
+	}
+	if _, ok := got["missing"]; ok {
+		t.Fatal("expected missing key to be absent from MGet result")
+	}
+}
+
+func TestShardedCacheMDel(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8))
+	cache.MSet(map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	if n := cache.MDel("a", "b", "missing"); n != 2 {
+		t.Fatalf("expected 2 keys removed, got %d", n)
+	}
+	if cache.Exists("a") || cache.Exists("b") || !cache.Exists("c") {
+		t.Fatal("expected only 'a' and 'b' to be deleted")
+	}
+}
+
+func benchmarkKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	return keys
+}
+
+func BenchmarkMGet(b *testing.B) {
+	cache := NewShardedCache(WithShardCapacity(0))
+	keys := benchmarkKeys(50)
+	for _, key := range keys {
+		cache.Set(key, "value")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.MGet(keys...)
+	}
+}
+
+func BenchmarkGetLoop(b *testing.B) {
+	cache := NewShardedCache(WithShardCapacity(0))
+	keys := benchmarkKeys(50)
+	for _, key := range keys {
+		cache.Set(key, "value")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := make(map[string]string, len(keys))
+		for _, key := range keys {
+			if v, err := cache.Get(key); err == nil {
+				result[key] = v
+			}
+		}
+	}
+}
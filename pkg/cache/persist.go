@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotMagic identifies a snapshot file and its format version.
+// readSnapshot also accepts snapshotMagicV1 files, which predate the kind byte
+// and hold only strings.
+const (
+	snapshotMagic   = "IMCSNAP2"
+	snapshotMagicV1 = "IMCSNAP1"
+)
+
+// maxSnapshotField bounds key and value lengths read from a snapshot, so a
+// corrupt length cannot trigger a huge allocation.
+const maxSnapshotField = 1 << 30
+
+// SaveToFile writes every unexpired entry to path, replacing the file
+// atomically: the snapshot is written to a temporary file in the same
+// directory and renamed over path once complete. Each entry records its key,
+// value, TTL, and remaining lifetime. Entries are written from least to most
+// recently used, so loading into a smaller cache keeps the hottest keys.
+// Shards are copied one at a time, so the snapshot is not atomic with respect to
+// concurrent writes.
+func (sc *ShardedCache) SaveToFile(path string) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err := sc.writeSnapshot(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteSnapshot writes the cache's unexpired entries to w in the
+// SaveToFile format, for example to send them to a replica.
+func (sc *ShardedCache) WriteSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := sc.writeSnapshot(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeSnapshot encodes the cache's entries to w. Each record is the key, a
+// byte giving the value's kind, and the value, with an object encoded as by
+// Dump, prefixed with its uvarint length, followed by the varint TTL and remaining lifetime in
+// nanoseconds; a zero lifetime means no expiration.
+func (sc *ShardedCache) writeSnapshot(w *bufio.Writer) error {
+	now := sc.clock().UnixNano()
+
+	// Order entries across shards by their relative LRU position, oldest
+	// first. Shard snapshots list the most recently used entry first.
+	type aged struct {
+		age float64
+		ent entry
+	}
+	var all []aged
+	for _, shard := range sc.shards {
+		entries := shard.snapshotAll(now)
+		for i, ent := range entries {
+			all = append(all, aged{age: float64(i+1) / float64(len(entries)), ent: ent})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].age > all[j].age })
+
+	if _, err := w.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64)
+	for _, r := range all {
+		var remaining int64
+		if r.ent.expiresAt > 0 {
+			remaining = r.ent.expiresAt - now
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(r.ent.key)))
+		w.Write(buf)
+		w.WriteString(r.ent.key)
+		w.WriteByte(byte(r.ent.kind))
+		value := r.ent.plain()
+		buf = binary.AppendUvarint(buf[:0], uint64(len(value)))
+		w.Write(buf)
+		w.Write(value)
+		buf = binary.AppendVarint(buf[:0], int64(r.ent.ttl))
+		buf = binary.AppendVarint(buf, remaining)
+		// bufio.Writer errors are sticky, so checking the last write suffices.
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFromFile reads a snapshot written by SaveToFile and adds its entries to
+// the cache, overwriting existing keys. Remaining lifetimes resume from the
+// time of loading. Entries are inserted in the snapshot's recency order under
+// the cache's current capacity settings, so any overflow evicts the entries
+// that were least recently used when the snapshot was taken.
+func (sc *ShardedCache) LoadFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sc.readSnapshot(bufio.NewReader(f))
+}
+
+// ReadSnapshot adds the entries of a snapshot read from r to the cache, as
+// LoadFromFile does.
+func (sc *ShardedCache) ReadSnapshot(r io.Reader) error {
+	return sc.readSnapshot(bufio.NewReader(r))
+}
+
+// readSnapshot decodes entries written by writeSnapshot from r.
+func (sc *ShardedCache) readSnapshot(r *bufio.Reader) error {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || (string(magic) != snapshotMagic && string(magic) != snapshotMagicV1) {
+		return fmt.Errorf("%w: bad header", ErrCorruptSnapshot)
+	}
+	kinds := string(magic) == snapshotMagic
+	now := sc.clock()
+	for {
+		key, err := readBytes(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		kind := kindString
+		if kinds {
+			b, err := r.ReadByte()
+			if err != nil || valueKind(b) > kindZSet {
+				return fmt.Errorf("%w: bad kind", ErrCorruptSnapshot)
+			}
+			kind = valueKind(b)
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		ttl, err := binary.ReadVarint(r)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+		remaining, err := binary.ReadVarint(r)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+		if remaining < 0 || (kind == kindString && sc.tooLarge(value)) {
+			continue
+		}
+		ent := &entry{key: string(key), ttl: time.Duration(ttl), freq: 1, kind: kind}
+		if kind == kindString {
+			ent.value = value
+		} else if ent.object, ent.objectSize, err = decodeObject(kind, value); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+		if remaining > 0 {
+			ent.expiresAt = now.Add(time.Duration(remaining)).UnixNano()
+		}
+		sc.notifyEvicted(sc.getShard(ent.key).restore(ent))
+	}
+}
+
+// readBytes reads a uvarint length-prefixed byte string. It returns io.EOF
+// only if r is exhausted before the length.
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("%w: length %d out of range", ErrCorruptSnapshot, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	return b, nil
+}
+
+// restore inserts a snapshot entry and returns the entries evicted to make
+// room for it.
+func (s *Shard) restore(ent *entry) []entry {
+	s.mu.Lock()
+	defer s.unlock()
+	return s.insertLocked(ent)
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	path := filepath.Join(t.TempDir(), "cache.snap")
+
+	src := NewShardedCache(WithClock(clock.Now), WithShardCapacity(0))
+	src.Set("forever", "a")
+	src.SetWithTTL("short", "b", 10*time.Second)
+	src.SetWithTTL("long", "c", time.Hour)
+	src.SetWithTTL("gone", "d", time.Second)
+	src.SetBytes("binary", []byte{0, 1, 2, 255})
+
+	clock.Advance(5 * time.Second)
+	if err := src.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+
+	dst := NewShardedCache(WithClock(clock.Now), WithShardCapacity(0), WithShardCount(4))
+	if err := dst.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	if n := dst.Len(); n != 4 {
+		t.Fatalf("expected 4 entries after load, got %d", n)
+	}
+	if dst.Exists("gone") {
+		t.Fatal("expected an entry expired at save time not to be restored")
+	}
+	if v, _ := dst.GetBytes("binary"); string(v) != "\x00\x01\x02\xff" {
+		t.Fatalf("expected binary value to round-trip, got %q", v)
+	}
+
+	// "short" had 5s left when saved.
+	clock.Advance(4 * time.Second)
+	if v, err := dst.Get("short"); err != nil || v != "b" {
+		t.Fatalf("expected 'short' to survive until its remaining TTL, got %q, %v", v, err)
+	}
+	clock.Advance(2 * time.Second)
+	if dst.Exists("short") {
+		t.Fatal("expected 'short' to expire after its remaining TTL")
+	}
+	for key, want := range map[string]string{"forever": "a", "long": "c"} {
+		if v, err := dst.Get(key); err != nil || v != want {
+			t.Fatalf("expected %q=%q, got %q, %v", key, want, v, err)
+		}
+	}
+}
+
+func TestSnapshotStream(t *testing.T) {
+	src := NewShardedCache()
+	src.Set("a", "1")
+	src.SetWithTTL("b", "2", time.Hour)
+	var buf bytes.Buffer
+	if err := src.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewShardedCache()
+	if err := dst.ReadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get("a"); v != "1" {
+		t.Fatalf("expected a=1, got %q", v)
+	}
+	if _, at, _ := dst.PeekWithExpiry("b"); time.Until(at) < 59*time.Minute {
+		t.Fatalf("expected b to keep its TTL, got an expiry at %v", at)
+	}
+}
+
+func TestSnapshotKinds(t *testing.T) {
+	src := NewShardedCache()
+	src.HSet("h", "f", "v")
+	src.RPush("l", "a", "b")
+	src.SAdd("s", "x")
+	src.ZAdd("z", ZMember{Member: "m", Score: 1})
+	src.Expire("h", time.Hour)
+	var buf bytes.Buffer
+	if err := src.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewShardedCache()
+	if err := dst.ReadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.HGet("h", "f"); v != "v" {
+		t.Fatalf("expected h.f=v, got %q", v)
+	}
+	if at, _ := dst.ExpireTime("h"); time.Until(at) < 59*time.Minute {
+		t.Fatalf("expected h to keep its TTL, got an expiry at %v", at)
+	}
+	if values, _ := dst.LRange("l", 0, -1); len(values) != 2 || values[0] != "a" {
+		t.Fatalf("expected the list in order, got %q", values)
+	}
+	if ok, _ := dst.SIsMember("s", "x"); !ok {
+		t.Fatal("expected x in the set")
+	}
+	if score, err := dst.ZScore("z", "m"); err != nil || score != 1 {
+		t.Fatalf("expected m's score to be 1, got %v, %v", score, err)
+	}
+}
+
+func TestSnapshotReadsVersion1(t *testing.T) {
+	// "IMCSNAP1", then key "k", value "v", TTL 0, and no expiration.
+	data := []byte("IMCSNAP1\x01k\x01v\x00\x00")
+	c := NewShardedCache()
+	if err := c.ReadSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("k"); v != "v" {
+		t.Fatalf("expected k=v, got %q", v)
+	}
+}
+
+func TestSnapshotLoadRespectsCapacity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	src := NewShardedCache(WithShardCount(1), WithShardCapacity(0))
+	for i := 0; i < 10; i++ {
+		src.Set("key-"+strconv.Itoa(i), "v")
+	}
+	// Make key-0 the most recently used.
+	src.Get("key-0")
+	if err := src.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+
+	dst := NewShardedCache(WithShardCount(1), WithShardCapacity(3))
+	if err := dst.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	for _, key := range []string{"key-0", "key-9", "key-8"} {
+		if !dst.Exists(key) {
+			t.Errorf("expected recently used %q to be kept", key)
+		}
+	}
+	if n := dst.Len(); n != 3 {
+		t.Fatalf("expected 3 entries, got %d", n)
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	if err := os.WriteFile(path, []byte("not a snapshot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewShardedCache().LoadFromFile(path); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Fatalf("expected ErrCorruptSnapshot, got %v", err)
+	}
+
+	// A truncated record is also rejected.
+	src := NewShardedCache()
+	src.Set("key", "value")
+	if err := src.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-3], 0o644)
+	if err := NewShardedCache().LoadFromFile(path); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Fatalf("expected ErrCorruptSnapshot for a truncated file, got %v", err)
+	}
+}
+
+func TestSaveToFileLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewShardedCache()
+	cache.Set("k", "v")
+	for i := 0; i < 2; i++ {
+		if err := cache.SaveToFile(filepath.Join(dir, "cache.snap")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected only the snapshot file, found %d entries", len(entries))
+	}
+}
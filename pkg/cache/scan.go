@@ -0,0 +1,79 @@
+package cache
+
+import "unsafe"
+
+// defaultScanCount is the batch size used when Scan is called with a
+// non-positive count.
+const defaultScanCount = 10
+
+// Scan iterates over the cache incrementally. Start with cursor 0 and pass the
+// returned cursor to the next call; a returned cursor of 0 means the iteration
+// is complete. Each call returns at most count keys (count <= 0 selects a small
+// default), exceeding it only when keys share a scan hash.
+//
+// Within a shard, keys are visited in order of a hash of the key, so for a
+// cache that is not modified during the scan every key is returned exactly
+// once. Keys inserted or deleted mid-scan may or may not be returned. Shards
+// keep their keys ordered by that hash, so a call costs O(log n + count)
+// rather than a pass over the shard.
+func (sc *ShardedCache) Scan(cursor uint64, count int) (keys []string, nextCursor uint64) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	idx, pos := int(cursor>>32), cursor&0xffffffff
+	now := sc.clock().UnixNano()
+	for idx < len(sc.shards) && len(keys) < count {
+		var done bool
+		keys, pos, done = sc.shards[idx].scan(keys, pos, count-len(keys), now)
+		if done {
+			idx, pos = idx+1, 0
+		}
+	}
+	if idx >= len(sc.shards) {
+		return keys, 0
+	}
+	return keys, uint64(idx)<<32 | pos
+}
+
+// scan appends live keys whose scan hash is at least pos to dst, visiting
+// them in hash order, until it has appended count and the next key's hash
+// differs from the last one's. It returns the position to resume from and
+// whether the shard has been fully visited. Walking s.keyOrder from pos, it
+// only visits the keys it returns and those that have expired.
+func (s *Shard) scan(dst []string, pos uint64, count int, now int64) ([]string, uint64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	added, last := 0, -1.0
+	for n := s.keyOrder.firstAtLeast(float64(pos)); n != nil; n = n.levels[0].next {
+		if added >= count && n.score != last {
+			return dst, uint64(n.score), false
+		}
+		if s.data[n.member].Value.(*entry).expired(now) {
+			continue
+		}
+		dst = append(dst, n.member)
+		added, last = added+1, n.score
+	}
+	return dst, 0, true
+}
+
+// scanHash orders keys within a shard for Scan.
+func scanHash(key string) uint32 {
+	return fnv32a(key)
+}
+
+// scanNodeOverhead approximates what a key costs Shard.keyOrder: its node
+// and, on average, 4/3 links.
+const scanNodeOverhead = int64(unsafe.Sizeof(znode{})) + 4*int64(unsafe.Sizeof(zlevel{}))/3
+
+// indexKey adds key to s.keyOrder. The caller must hold the shard lock.
+func (s *Shard) indexKey(key string) {
+	s.keyOrder.insert(float64(scanHash(key)), key)
+}
+
+// unindexKey removes key from s.keyOrder. The caller must hold the shard
+// lock.
+func (s *Shard) unindexKey(key string) {
+	s.keyOrder.delete(float64(scanHash(key)), key)
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestShardedCacheScanVisitsEveryKeyOnce(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8), WithShardCapacity(0))
+	const n = 1000
+	for i := 0; i < n; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+
+	seen := map[string]bool{}
+	var cursor uint64
+	calls := 0
+	for {
+		keys, next := cache.Scan(cursor, 37)
+		if len(keys) > 37 {
+			t.Fatalf("expected at most 37 keys per call, got %d", len(keys))
+		}
+		for _, key := range keys {
+			if seen[key] {
+				t.Fatalf("key %q returned twice", key)
+			}
+			seen[key] = true
+		}
+		calls++
+		if calls > n {
+			t.Fatal("scan did not terminate")
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != n {
+		t.Fatalf("expected scan to visit %d keys, got %d", n, len(seen))
+	}
+}
+
+func TestShardedCacheScanEmpty(t *testing.T) {
+	cache := NewShardedCache()
+	keys, next := cache.Scan(0, 10)
+	if len(keys) != 0 || next != 0 {
+		t.Fatalf("expected empty scan to finish immediately, got %v, %d", keys, next)
+	}
+}
+
+func TestShardedCacheScanTerminatesUnderMutation(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithShardCapacity(0))
+	for i := 0; i < 200; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+
+	var cursor uint64
+	for i := 0; ; i++ {
+		_, next := cache.Scan(cursor, 10)
+		cache.Set(fmt.Sprintf("new-%d", i), "v")
+		cache.Delete(fmt.Sprintf("key-%d", i))
+		if next == 0 {
+			break
+		}
+		if i > 10000 {
+			t.Fatal("scan did not terminate")
+		}
+		cursor = next
+	}
+}
+
+// scanAll returns every key a full Scan of cache visits.
+func scanAll(cache *ShardedCache, count int) []string {
+	var all []string
+	var cursor uint64
+	for {
+		keys, next := cache.Scan(cursor, count)
+		all = append(all, keys...)
+		if next == 0 {
+			return all
+		}
+		cursor = next
+	}
+}
+
+func TestShardedCacheScanFollowsWrites(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(2), WithShardCapacity(3))
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		cache.Set(key, "v")
+	}
+	cache.Rename(cache.Keys()[0], "renamed")
+	cache.Delete(cache.Keys()[0])
+	keys := scanAll(cache, 2)
+	slices.Sort(keys)
+	want := cache.Keys()
+	slices.Sort(want)
+	if !slices.Equal(keys, want) {
+		t.Fatalf("expected Scan to visit %v, got %v", want, keys)
+	}
+
+	cache.Clear()
+	cache.Set("x", "v")
+	if keys := scanAll(cache, 10); !slices.Equal(keys, []string{"x"}) {
+		t.Fatalf("expected only x after Clear, got %v", keys)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	cache := filledCache(benchmarkKeySpace)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if keys := scanAll(cache, 10); len(keys) != benchmarkKeySpace {
+			b.Fatalf("expected %d keys, got %d", benchmarkKeySpace, len(keys))
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// memberOverhead approximates the per-member bookkeeping cost of a set in
+// bytes: its map slot and string header.
+const memberOverhead = 32
+
+// memberSize returns the approximate size of one set member in bytes.
+func memberSize(member string) int64 {
+	return int64(len(member)) + memberOverhead
+}
+
+// SAdd adds members to the set stored at key, creating the set with the
+// default TTL if key is missing, and returns how many were not already
+// present. The TTL applies to the whole set, which is one entry for LRU and
+// capacity purposes. It returns ErrWrongType if key holds another kind of
+// value and ErrValueTooLarge if a member exceeds the WithMaxValueBytes limit,
+// in which case nothing is added. Like HSet, set writes do not call the
+// write-through function.
+func (sc *ShardedCache) SAdd(key string, members ...string) (int, error) {
+	for _, member := range members {
+		if sc.tooLarge([]byte(member)) {
+			return 0, ErrValueTooLarge
+		}
+	}
+	added, evicted, err := sc.getShard(key).sadd(key, members, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return added, err
+}
+
+// SRem removes members from the set stored at key and returns how many were
+// present. Removing the last member deletes the key.
+func (sc *ShardedCache) SRem(key string, members ...string) (int, error) {
+	return sc.getShard(key).srem(key, members)
+}
+
+// SIsMember reports whether member belongs to the set stored at key.
+func (sc *ShardedCache) SIsMember(key, member string) (bool, error) {
+	var present bool
+	err := sc.getShard(key).viewSet(key, func(set map[string]struct{}) {
+		_, present = set[member]
+	})
+	return present, err
+}
+
+// SMembers returns the members of the set stored at key in no particular
+// order. A missing key yields an empty slice.
+func (sc *ShardedCache) SMembers(key string) ([]string, error) {
+	var members []string
+	err := sc.getShard(key).viewSet(key, func(set map[string]struct{}) {
+		members = make([]string, 0, len(set))
+		for member := range set {
+			members = append(members, member)
+		}
+	})
+	return members, err
+}
+
+// SCard returns the number of members in the set stored at key, or 0 if key
+// is missing.
+func (sc *ShardedCache) SCard(key string) (int, error) {
+	var n int
+	err := sc.getShard(key).viewSet(key, func(set map[string]struct{}) {
+		n = len(set)
+	})
+	return n, err
+}
+
+// SInter returns the members present in both the sets stored at a and b, in no
+// particular order. A missing key counts as an empty set. The two keys may
+// live in different shards, which are read one after the other, so the result
+// is not atomic.
+func (sc *ShardedCache) SInter(a, b string) ([]string, error) {
+	setA, setB, err := sc.setPair(a, b)
+	if err != nil {
+		return nil, err
+	}
+	members := []string{}
+	for member := range setA {
+		if _, ok := setB[member]; ok {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+// SUnion returns the members present in either of the sets stored at a and b,
+// in no particular order. Like SInter, it is not atomic.
+func (sc *ShardedCache) SUnion(a, b string) ([]string, error) {
+	setA, setB, err := sc.setPair(a, b)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(setA, setB)
+	return slices.Collect(maps.Keys(setA)), nil
+}
+
+// setPair returns copies of the sets stored at a and b, locking one shard at
+// a time.
+func (sc *ShardedCache) setPair(a, b string) (map[string]struct{}, map[string]struct{}, error) {
+	sets := [2]map[string]struct{}{}
+	for i, key := range [2]string{a, b} {
+		err := sc.getShard(key).viewSet(key, func(set map[string]struct{}) {
+			sets[i] = maps.Clone(set)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if sets[i] == nil {
+			sets[i] = make(map[string]struct{})
+		}
+	}
+	return sets[0], sets[1], nil
+}
+
+// sadd adds members to the set at key and returns how many are new.
+func (s *Shard) sadd(key string, members []string, ttl time.Duration) (int, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, evicted, err := s.objectFor(key, kindSet, ttl, func() any { return make(map[string]struct{}) })
+	if err != nil {
+		return 0, nil, err
+	}
+	set := elem.Value.(*entry).object.(map[string]struct{})
+	added := 0
+	var delta int64
+	for _, member := range members {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			delta += memberSize(member)
+			added++
+		}
+	}
+	s.stats.sets.Add(1)
+	return added, append(evicted, s.resizeObject(elem, delta)...), nil
+}
+
+// srem removes members from the set at key, deleting the key once the set is
+// empty.
+func (s *Shard) srem(key string, members []string) (int, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, err := s.liveObject(key, kindSet, s.clock().UnixNano())
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	set := elem.Value.(*entry).object.(map[string]struct{})
+	removed := 0
+	var delta int64
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			delta -= memberSize(member)
+			removed++
+		}
+	}
+	if len(set) == 0 {
+		s.removeElement(elem)
+		s.stats.deletes.Add(1)
+		return removed, nil
+	}
+	s.resizeObject(elem, delta) // Shrinking never evicts.
+	return removed, nil
+}
+
+// viewSet calls fn with the set at key under the shard lock; fn must not
+// retain it. A missing key is passed as a nil set.
+func (s *Shard) viewSet(key string, fn func(set map[string]struct{})) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindSet, now.UnixNano())
+	if err == ErrKeyNotFound {
+		s.stats.misses.Add(1)
+		fn(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.readObject(elem, now)
+	fn(elem.Value.(*entry).object.(map[string]struct{}))
+	return nil
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSetOperations(t *testing.T) {
+	c := NewShardedCache()
+	if n, err := c.SAdd("emailed", "ada", "bob", "ada"); err != nil || n != 2 {
+		t.Fatalf("expected 2 new members, got %d, %v", n, err)
+	}
+	if n, _ := c.SAdd("emailed", "bob", "cy"); n != 1 {
+		t.Fatalf("expected a duplicate to be ignored, got %d added", n)
+	}
+	if ok, err := c.SIsMember("emailed", "bob"); err != nil || !ok {
+		t.Fatalf("expected bob to be a member, got %v, %v", ok, err)
+	}
+	if ok, _ := c.SIsMember("emailed", "dee"); ok {
+		t.Fatal("expected dee not to be a member")
+	}
+	if n, _ := c.SCard("emailed"); n != 3 {
+		t.Fatalf("expected 3 members, got %d", n)
+	}
+	members, _ := c.SMembers("emailed")
+	slices.Sort(members)
+	if !slices.Equal(members, []string{"ada", "bob", "cy"}) {
+		t.Fatalf("unexpected members %v", members)
+	}
+	if members, err := c.SMembers("missing"); err != nil || members == nil || len(members) != 0 {
+		t.Fatalf("expected an empty slice for a missing key, got %v, %v", members, err)
+	}
+
+	if n, _ := c.SRem("emailed", "ada", "dee"); n != 1 {
+		t.Fatalf("expected one member removed, got %d", n)
+	}
+	if n, _ := c.SRem("emailed", "bob", "cy"); n != 2 || c.Exists("emailed") {
+		t.Fatal("expected removing the last member to delete the key")
+	}
+}
+
+func TestSetInterUnion(t *testing.T) {
+	c := NewShardedCache(WithShardCount(8))
+	c.SAdd("a", "1", "2", "3")
+	c.SAdd("b", "2", "3", "4")
+
+	inter, _ := c.SInter("a", "b")
+	slices.Sort(inter)
+	if !slices.Equal(inter, []string{"2", "3"}) {
+		t.Fatalf("unexpected intersection %v", inter)
+	}
+	union, _ := c.SUnion("a", "b")
+	slices.Sort(union)
+	if !slices.Equal(union, []string{"1", "2", "3", "4"}) {
+		t.Fatalf("unexpected union %v", union)
+	}
+	if inter, err := c.SInter("a", "missing"); err != nil || len(inter) != 0 {
+		t.Fatalf("expected an empty intersection with a missing key, got %v, %v", inter, err)
+	}
+	if union, _ := c.SUnion("missing", "a"); len(union) != 3 {
+		t.Fatalf("expected the union with a missing key to be a, got %v", union)
+	}
+	if n, _ := c.SCard("a"); n != 3 {
+		t.Fatalf("expected SUnion to leave a unchanged, got %d members", n)
+	}
+}
+
+func TestSetWrongType(t *testing.T) {
+	c := NewShardedCache()
+	c.Set("str", "v")
+	c.SAdd("set", "m")
+
+	if _, err := c.SAdd("str", "m"); err != ErrWrongType {
+		t.Fatalf("expected SAdd on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.SRem("str", "m"); err != ErrWrongType {
+		t.Fatalf("expected SRem on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.SIsMember("str", "m"); err != ErrWrongType {
+		t.Fatalf("expected SIsMember on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.SInter("set", "str"); err != ErrWrongType {
+		t.Fatalf("expected SInter with a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.Get("set"); err != ErrWrongType {
+		t.Fatalf("expected Get on a set to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.LLen("set"); err != ErrWrongType {
+		t.Fatalf("expected LLen on a set to fail with ErrWrongType, got %v", err)
+	}
+}
+
+func TestSetTTLAppliesToWholeSet(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := NewShardedCache(WithClock(clock.Now), WithDefaultTTL(time.Minute))
+	c.SAdd("s", "a")
+	clock.Advance(30 * time.Second)
+	c.SAdd("s", "b") // Adding members does not extend the TTL.
+	clock.Advance(31 * time.Second)
+	if c.Exists("s") {
+		t.Fatal("expected the set to expire a minute after it was created")
+	}
+	if n, _ := c.SCard("s"); n != 0 {
+		t.Fatalf("expected an expired set to be empty, got %d members", n)
+	}
+}
+
+func TestSetMemoryUsage(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1))
+	c.SAdd("s", "a")
+	one := c.MemoryUsage()
+	c.SAdd("s", "a", "bc")
+	if got := c.MemoryUsage(); got != one+memberSize("bc") {
+		t.Fatalf("expected only the new member to add bytes, got %d from %d", got, one)
+	}
+	c.SRem("s", "a", "bc")
+	if got := c.MemoryUsage(); got != 0 {
+		t.Fatalf("expected no memory in use after the set is gone, got %d", got)
+	}
+}
@@ -1,25 +1,147 @@
 package cache
 
 import (
+	"bytes"
 	"container/list"
-	"errors"
-	"hash/fnv"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// TTL sentinels accepted by SetWithTTL.
+const (
+	// DefaultExpiration applies the cache's default TTL, if one is configured.
+	DefaultExpiration time.Duration = 0
+	// NoExpiration stores the entry without any expiration, overriding the default TTL.
+	NoExpiration time.Duration = -1
 )
 
 // entry represents a key-value pair stored in the cache.
 type entry struct {
-	key   string
-	value string
+	key       string
+	value     []byte // Never modified in place below len(value); see readValue.
+	ttl       time.Duration
+	expiresAt int64  // Unix nanoseconds; zero means the entry never expires.
+	scheduled int64  // Deadline of the entry's node in Shard.expiry, or zero; see expiry.go.
+	freq      uint32 // Access counter used by the LFU policy.
+	hits      uint32 // Reads since the value was stored; accessed atomically. See GetEntryInfo.
+	version   uint64 // Set from Shard.version by every write; see GetWithVersion.
+	flags     uint32 // Opaque client flags; see SetFlagged.
+	accessed  int64  // Unix nanoseconds of the last read or promotion; accessed atomically.
+	created   int64  // Unix nanoseconds of when the value was stored.
+
+	refreshing bool // A stale-while-revalidate refresh is in flight.
+	compressed bool // value is Snappy-compressed; see WithCompression.
+
+	// tags are indexed in Shard.tags; see SetWithTags.
+	tags []string
+
+	// kind tells which field holds the value: value for strings, object for
+	// every other kind, whose approximate size is kept in objectSize. See
+	// kinds.go.
+	kind       valueKind
+	object     any
+	objectSize int64
+}
+
+// mapSlotOverhead approximates what a key costs Shard.data besides the key's
+// bytes: the string header, the element pointer, and the map's control byte,
+// with room for the slots a map keeps empty.
+const mapSlotOverhead = 32
+
+// entryOverhead approximates the per-entry bookkeeping cost in bytes: the map
+// slot, the list element, the scan index node, and the entry struct itself.
+const entryOverhead = int64(unsafe.Sizeof(entry{})+unsafe.Sizeof(list.Element{})) + mapSlotOverhead + scanNodeOverhead
+
+// size returns the approximate memory footprint of the entry in bytes.
+func (e *entry) size() int64 {
+	n := int64(len(e.key)+len(e.value)) + entryOverhead + e.objectSize
+	for _, tag := range e.tags {
+		n += int64(len(tag))
+	}
+	return n
+}
+
+// readValue returns a view of a stored value that readers may hold after the
+// shard lock is released. Stored bytes are never modified in place, and
+// capping the capacity keeps appends by the reader from touching the cache's
+// spare capacity.
+func readValue(v []byte) []byte {
+	return v[:len(v):len(v)]
+}
+
+// expired reports whether the entry has passed its expiration time.
+func (e *entry) expired(now int64) bool {
+	return e.expiresAt > 0 && now >= e.expiresAt
 }
 
 // Shard represents a partition of the cache.
 // It holds its own data map, LRU list for eviction, and a mutex.
 type Shard struct {
-	mu       sync.Mutex
+	mu       sync.RWMutex
 	data     map[string]*list.Element
 	lru      *list.List
 	capacity int
+
+	// keyOrder holds the keys of data ordered by scanHash, for Scan.
+	keyOrder *skiplist
+
+	// version counts the shard's writes. Each write gives the entry it
+	// changes the next count, so no two writes to a key share a version.
+	version uint64
+
+	// slidingTTL resets an entry's expiration to now+ttl on every successful get.
+	slidingTTL bool
+
+	// staleGrace is how long past expiration get still serves an entry while
+	// it is refreshed. See WithStaleWhileRevalidate.
+	staleGrace time.Duration
+
+	policy   EvictionPolicy
+	accesses int // Accesses since the LFU counters were last aged.
+
+	// readHeavy lets get run under the read lock, recording recency in
+	// entry.accessed instead of moving the entry in the LRU list.
+	readHeavy bool
+
+	// onExpire is called for each entry removed because it expired; expired
+	// holds those removed since the lock was taken, for unlock to report.
+	onExpire func(key, value string)
+	expired  []entry
+
+	// expiry indexes the deadlines of the shard's entries, and tombstones
+	// counts its nodes that no longer schedule one. See expiry.go.
+	expiry     expiryHeap
+	tombstones int
+
+	bytes    int64 // Approximate size of all entries in the shard.
+	maxBytes int64 // Byte budget for the shard; zero means unlimited.
+
+	maxValueBytes int // Largest value the shard accepts; zero means unlimited.
+
+	// compressThreshold is the length above which values are stored
+	// compressed, or zero; saved is how many bytes that saves across the
+	// shard's entries. See WithCompression.
+	compressThreshold int
+	saved             int64
+
+	// negative maps keys a loader reported as missing to the Unix-nanosecond
+	// time that knowledge expires. See WithNegativeTTL.
+	negative map[string]int64
+
+	// tags maps each tag to the keys of the shard's entries carrying it. It
+	// is guarded by mu like data, so keeping it current never takes a second
+	// lock.
+	tags map[string]map[string]struct{}
+
+	clock func() time.Time
+
+	stats shardStats
 }
 
 // newShard creates a new shard with a given capacity.
@@ -28,80 +150,671 @@ func newShard(capacity int) *Shard {
 	return &Shard{
 		data:     make(map[string]*list.Element),
 		lru:      list.New(),
+		keyOrder: newSkiplist(),
 		capacity: capacity,
+		clock:    time.Now,
 	}
 }
 
-// set inserts or updates a key-value pair in the shard.
+// set inserts or updates a key-value pair in the shard, expiring it after ttl.
 // If the key exists, it updates its value and moves it to the front of the LRU list.
-// If the shard is at capacity, it evicts the least recently used item.
-func (s *Shard) set(key, value string) {
+// If the shard exceeds its item or byte capacity, it evicts entries according
+// to the eviction policy and returns the evicted entries.
+// Non-empty tags are attached to the entry; see SetWithTags.
+func (s *Shard) set(key string, value []byte, ttl time.Duration, tags []string) []entry {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// If key exists, update the value and move to front.
-	if elem, ok := s.data[key]; ok {
-		elem.Value.(*entry).value = value
-		s.lru.MoveToFront(elem)
-		return
+	defer s.unlock()
+	evicted := s.setLocked(key, value, ttl)
+	if len(tags) > 0 {
+		evicted = append(evicted, s.tagLocked(s.data[key], tags)...)
 	}
+	return evicted
+}
 
-	// If capacity is set and reached, evict the least recently used entry.
-	if s.capacity > 0 && s.lru.Len() >= s.capacity {
-		s.evict()
+// setLocked is set for callers that already hold the shard lock.
+func (s *Shard) setLocked(key string, value []byte, ttl time.Duration) []entry {
+	expiresAt := expirationFrom(s.clock(), ttl)
+	s.stats.sets.Add(1)
+
+	// If key exists, update the value and move to front. An expired entry is
+	// removed first, so that it is reported to OnExpire.
+	if elem, ok := s.live(key, s.clock().UnixNano()); ok {
+		ent := elem.Value.(*entry)
+		s.bytes -= ent.size()
+		s.saved -= ent.saved()
+		s.untagLocked(ent)
+		s.storeValue(ent, value)
+		ent.ttl = ttl
+		ent.expiresAt = expiresAt
+		s.schedule(ent)
+		ent.refreshing = false
+		ent.flags = 0
+		ent.tags = nil
+		ent.kind, ent.object, ent.objectSize = kindString, nil, 0
+		ent.created = s.clock().UnixNano()
+		atomic.StoreUint32(&ent.hits, 0)
+		s.bytes += ent.size()
+		s.saved += ent.saved()
+		s.bump(ent)
+		s.touch(elem)
+		return s.evictOverflow(elem)
 	}
 
 	// Insert new entry at the front of the LRU list.
-	ent := &entry{key: key, value: value}
+	return s.insertLocked(&entry{key: key, value: value, ttl: ttl, expiresAt: expiresAt, freq: 1})
+}
+
+// insertLocked adds ent at the front of the LRU list, replacing any existing
+// entry with the same key, and returns the entries evicted to make room.
+// The caller must hold the shard lock.
+func (s *Shard) insertLocked(ent *entry) []entry {
+	if elem, ok := s.data[ent.key]; ok {
+		if elem.Value.(*entry).expired(s.clock().UnixNano()) {
+			s.removeExpired(elem)
+		} else {
+			s.removeElement(elem)
+		}
+	}
+	ent.accessed = s.clock().UnixNano()
+	if ent.created == 0 { // A renamed entry keeps its creation time.
+		ent.created = ent.accessed
+	}
+	if len(s.negative) > 0 {
+		delete(s.negative, ent.key)
+	}
+	s.bump(ent)
+	ent.scheduled = 0 // A copy of a removed entry is not in the heap.
+	s.schedule(ent)
 	elem := s.lru.PushFront(ent)
-	s.data[key] = elem
+	s.data[ent.key] = elem
+	s.indexKey(ent.key)
+	s.indexTagsLocked(ent)
+	if ent.kind == kindString && !ent.compressed {
+		s.storeValue(ent, ent.value)
+	}
+	s.bytes += ent.size()
+	s.saved += ent.saved()
+	return s.evictOverflow(elem)
+}
+
+// setNX stores value with the given ttl only if key has no unexpired entry,
+// reporting whether it was stored.
+func (s *Shard) setNX(key string, value []byte, ttl time.Duration) (bool, []entry) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if elem, ok := s.data[key]; ok && !elem.Value.(*entry).expired(s.clock().UnixNano()) {
+		return false, nil
+	}
+	return true, s.setLocked(key, value, ttl)
+}
+
+// replaceValue swaps the value of the entry held by elem, keeping its TTL, and
+// promotes it. It returns any entries evicted because the entry grew.
+// The caller must hold the shard lock.
+func (s *Shard) replaceValue(elem *list.Element, value []byte) []entry {
+	ent := elem.Value.(*entry)
+	s.bytes -= ent.size()
+	s.saved -= ent.saved()
+	s.storeValue(ent, value)
+	s.bytes += ent.size()
+	s.saved += ent.saved()
+	s.bump(ent)
+	s.stats.sets.Add(1)
+	s.touch(elem)
+	return s.evictOverflow(elem)
+}
+
+// live returns the element for key if it holds an entry unexpired at now,
+// removing the entry if it has expired. The caller must hold the shard lock.
+func (s *Shard) live(key string, now int64) (*list.Element, bool) {
+	elem, ok := s.data[key]
+	if !ok {
+		return nil, false
+	}
+	if elem.Value.(*entry).expired(now) {
+		s.removeExpired(elem)
+		return nil, false
+	}
+	return elem, true
+}
+
+// compareAndSwap replaces key's value with newValue if it currently equals old.
+func (s *Shard) compareAndSwap(key string, old, newValue []byte) (bool, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, ok := s.live(key, s.clock().UnixNano())
+	if !ok {
+		return false, nil, ErrKeyNotFound
+	}
+	if elem.Value.(*entry).kind != kindString {
+		return false, nil, ErrWrongType
+	}
+	if !bytes.Equal(elem.Value.(*entry).plain(), old) {
+		s.touch(elem)
+		return false, nil, nil
+	}
+	return true, s.replaceValue(elem, newValue), nil
+}
+
+// deleteIfEquals removes key if its value equals value, reporting whether it
+// did.
+func (s *Shard) deleteIfEquals(key string, value []byte) bool {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, ok := s.live(key, s.clock().UnixNano())
+	if !ok {
+		return false
+	}
+	if ent := elem.Value.(*entry); ent.kind != kindString || !bytes.Equal(ent.plain(), value) {
+		return false
+	}
+	s.removeElement(elem)
+	s.stats.deletes.Add(1)
+	return true
+}
+
+// expireIfEquals resets key's TTL to ttl if its value equals value,
+// reporting whether it did.
+func (s *Shard) expireIfEquals(key string, value []byte, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, ok := s.live(key, now.UnixNano())
+	if !ok {
+		return false
+	}
+	ent := elem.Value.(*entry)
+	if ent.kind != kindString || !bytes.Equal(ent.plain(), value) {
+		return false
+	}
+	ent.ttl = max(ttl, 0)
+	ent.expiresAt = expirationFrom(now, ttl)
+	s.schedule(ent)
+	s.bump(ent)
+	return true
+}
+
+// increment adds delta to the integer stored at key, treating a missing key as
+// zero and storing new keys with the given ttl.
+func (s *Shard) increment(key string, delta int64, ttl time.Duration) (int64, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, ok := s.live(key, s.clock().UnixNano())
+	if !ok {
+		return delta, s.setLocked(key, strconv.AppendInt(nil, delta, 10), ttl), nil
+	}
+	if elem.Value.(*entry).kind != kindString {
+		return 0, nil, ErrWrongType
+	}
+	current, err := strconv.ParseInt(string(elem.Value.(*entry).plain()), 10, 64)
+	if err != nil {
+		return 0, nil, ErrNotNumeric
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, nil, ErrOverflow
+	}
+	current += delta
+	return current, s.replaceValue(elem, strconv.AppendInt(nil, current, 10)), nil
+}
+
+// appendValue appends suffix to key's value, creating the key with the given
+// ttl if it is missing, and returns the new length. The value grows in place
+// when it has spare capacity, which never disturbs bytes readers already hold.
+// It fails with ErrValueTooLarge if the result would exceed the value limit.
+func (s *Shard) appendValue(key string, suffix []byte, ttl time.Duration) (int, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, ok := s.live(key, s.clock().UnixNano())
+	var current []byte
+	if ok {
+		ent := elem.Value.(*entry)
+		if ent.kind != kindString {
+			return 0, nil, ErrWrongType
+		}
+		current = ent.value
+		if ent.compressed {
+			current = ent.plain()
+		}
+	}
+	if s.maxValueBytes > 0 && len(current)+len(suffix) > s.maxValueBytes {
+		return len(current), nil, ErrValueTooLarge
+	}
+	if !ok {
+		return len(suffix), s.setLocked(key, suffix, ttl), nil
+	}
+	value := append(current, suffix...)
+	return len(value), s.replaceValue(elem, value), nil
+}
+
+// getOrSet returns the existing unexpired value for key, or stores value with
+// the given ttl if there is none, all under one lock. The boolean reports
+// whether an existing value was returned.
+func (s *Shard) getOrSet(key string, value []byte, ttl time.Duration) ([]byte, bool, []entry) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if elem, ok := s.data[key]; ok {
+		ent := elem.Value.(*entry)
+		if !ent.expired(s.clock().UnixNano()) && ent.kind == kindString {
+			s.hit(elem)
+			return ent.plain(), true, nil
+		}
+	}
+	return value, false, s.setLocked(key, value, ttl)
 }
 
 // get retrieves a key's value from the shard and updates its position in the LRU list.
-func (s *Shard) get(key string) (string, error) {
+// Expired entries are removed on access and reported as not found. With sliding
+// TTL enabled, the entry's expiration is pushed back under the same lock.
+// Within the stale grace period an expired value is still returned, and refresh
+// is true for the first such read, which the caller must then refresh.
+func (s *Shard) get(key string) (value []byte, refresh bool, err error) {
+	if s.readHeavy && !s.slidingTTL && s.staleGrace == 0 {
+		value, err := s.getShared(key)
+		return value, false, err
+	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
 
 	if elem, ok := s.data[key]; ok {
-		s.lru.MoveToFront(elem)
-		return elem.Value.(*entry).value, nil
+		ent := elem.Value.(*entry)
+		now := s.clock()
+		if ent.kind != kindString && !ent.expired(now.UnixNano()) {
+			return nil, false, ErrWrongType
+		}
+		if ent.expired(now.UnixNano()) {
+			if s.staleGrace > 0 && now.UnixNano() < ent.expiresAt+int64(s.staleGrace) {
+				refresh = !ent.refreshing
+				ent.refreshing = true
+				s.hit(elem)
+				s.stats.staleHits.Add(1)
+				return ent.plain(), refresh, nil
+			}
+			s.removeExpired(elem)
+			s.stats.misses.Add(1)
+			return nil, false, ErrKeyNotFound
+		}
+		if s.slidingTTL && ent.ttl > 0 {
+			ent.expiresAt = now.Add(ent.ttl).UnixNano()
+		}
+		s.hit(elem)
+		return ent.plain(), false, nil
+	}
+	s.stats.misses.Add(1)
+	return nil, false, ErrKeyNotFound
+}
+
+// endRefresh clears key's in-flight refresh mark after a failed refresh, so a
+// later stale read can try again.
+func (s *Shard) endRefresh(key string) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if elem, ok := s.data[key]; ok {
+		elem.Value.(*entry).refreshing = false
+	}
+}
+
+// getShared is get for read-heavy shards. It holds only the read lock and
+// records the access with an atomic timestamp. Expired entries are reported as
+// missing and left for the next write or the janitor to remove.
+func (s *Shard) getShared(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if elem, ok := s.data[key]; ok {
+		ent := elem.Value.(*entry)
+		now := s.clock().UnixNano()
+		if !ent.expired(now) {
+			if ent.kind != kindString {
+				return nil, ErrWrongType
+			}
+			atomic.StoreInt64(&ent.accessed, now)
+			countHit(ent)
+			s.stats.hits.Add(1)
+			return ent.plain(), nil
+		}
+	}
+	s.stats.misses.Add(1)
+	return nil, ErrKeyNotFound
+}
+
+// lookup returns the unexpired value for key without affecting its LRU
+// position or access statistics.
+func (s *Shard) lookup(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.data[key]
+	if !ok || elem.Value.(*entry).expired(s.clock().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	if elem.Value.(*entry).kind != kindString {
+		return nil, ErrWrongType
+	}
+	return elem.Value.(*entry).plain(), nil
+}
+
+// getDel returns key's unexpired value and removes the entry under one lock.
+func (s *Shard) getDel(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, ok := s.live(key, s.clock().UnixNano())
+	if !ok {
+		s.stats.misses.Add(1)
+		return nil, ErrKeyNotFound
+	}
+	if elem.Value.(*entry).kind != kindString {
+		return nil, ErrWrongType
 	}
-	return "", errors.New("key not found")
+	value := elem.Value.(*entry).plain()
+	s.removeElement(elem)
+	s.stats.hits.Add(1)
+	s.stats.deletes.Add(1)
+	return value, nil
+}
+
+// touchKey promotes key's entry in the LRU list without reading its value.
+func (s *Shard) touchKey(key string) bool {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, ok := s.live(key, now.UnixNano())
+	if !ok {
+		return false
+	}
+	if ent := elem.Value.(*entry); s.slidingTTL && ent.ttl > 0 {
+		ent.expiresAt = now.Add(ent.ttl).UnixNano()
+	}
+	s.touch(elem)
+	return true
+}
+
+// expire resets key's TTL to ttl, reporting whether the key exists.
+func (s *Shard) expire(key string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, ok := s.live(key, now.UnixNano())
+	if !ok {
+		return false
+	}
+	ent := elem.Value.(*entry)
+	ent.ttl = max(ttl, 0)
+	ent.expiresAt = expirationFrom(now, ttl)
+	s.schedule(ent)
+	s.bump(ent)
+	return true
+}
+
+// exists reports whether the shard holds an unexpired entry for key, without
+// affecting its LRU position or access statistics.
+func (s *Shard) exists(key string, now int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.data[key]
+	return ok && !elem.Value.(*entry).expired(now)
 }
 
 // delete removes a key from the shard.
 func (s *Shard) delete(key string) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
 
 	if elem, ok := s.data[key]; ok {
-		s.lru.Remove(elem)
-		delete(s.data, key)
+		s.removeElement(elem)
+		s.stats.deletes.Add(1)
 	}
 }
 
-// evict removes the least recently used item from the shard.
-func (s *Shard) evict() {
-	elem := s.lru.Back()
-	if elem == nil {
-		return
+// overCapacity reports whether the shard exceeds its item or byte budget.
+// The caller must hold the shard lock.
+func (s *Shard) overCapacity() bool {
+	return (s.capacity > 0 && s.lru.Len() > s.capacity) ||
+		(s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+// evictOverflow evicts entries until the shard is within its budgets, never
+// evicting keep, and returns copies of the evicted entries. An entry larger
+// than the byte budget is kept on its own. The caller must hold the shard lock.
+func (s *Shard) evictOverflow(keep *list.Element) []entry {
+	var evicted []entry
+	for s.overCapacity() {
+		elem := s.victim(keep)
+		if elem == nil {
+			break
+		}
+		evicted = append(evicted, *elem.Value.(*entry))
+		s.removeElement(elem)
+		s.stats.evictions.Add(1)
+	}
+	return evicted
+}
+
+// clear removes every entry from the shard by reallocating its map and list.
+// If keep is true, the removed entries are returned.
+func (s *Shard) clear(keep bool) []entry {
+	s.mu.Lock()
+	defer s.unlock()
+
+	var removed []entry
+	if keep {
+		removed = make([]entry, 0, s.lru.Len())
+		for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+			removed = append(removed, *elem.Value.(*entry))
+		}
 	}
+	s.data = make(map[string]*list.Element)
+	s.lru = list.New()
+	s.keyOrder = newSkiplist()
+	s.bytes = 0
+	s.saved = 0
+	s.accesses = 0
+	s.negative = nil
+	s.tags = nil
+	clear(s.expiry)
+	s.expiry = s.expiry[:0]
+	s.tombstones = 0
+	return removed
+}
+
+// deleteMatching removes every entry whose key is accepted by match and
+// returns how many were removed. It walks the map once and unlinks each match
+// from the LRU list in constant time.
+func (s *Shard) deleteMatching(match func(string) bool) int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	removed := 0
+	for key, elem := range s.data {
+		if match(key) {
+			s.removeElement(elem)
+			s.stats.deletes.Add(1)
+			removed++
+		}
+	}
+	return removed
+}
+
+// deleteExpired removes every expired entry from the shard and returns how many
+// were removed. It only visits the entries whose deadline has passed.
+func (s *Shard) deleteExpired(now int64) int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	// Entries in their stale grace period are kept for get to serve.
+	removed := s.expireDue(now - int64(s.staleGrace))
+	for key, expiresAt := range s.negative {
+		if now >= expiresAt {
+			delete(s.negative, key)
+		}
+	}
+	return removed
+}
+
+// removeExpired removes the expired entry held by elem, queueing it for the
+// OnExpire callback. The caller must hold the shard lock and release it with
+// unlock.
+func (s *Shard) removeExpired(elem *list.Element) {
+	if s.onExpire != nil {
+		s.expired = append(s.expired, *elem.Value.(*entry))
+	}
+	s.removeElement(elem)
+}
+
+// unlock releases the shard lock taken with s.mu.Lock, then invokes the
+// OnExpire callback for the entries that expired meanwhile, so that the
+// callback may safely use the cache.
+func (s *Shard) unlock() {
+	expired := s.expired
+	s.expired = nil
+	s.mu.Unlock()
+	s.notifyExpired(expired)
+}
+
+// notifyExpired invokes the OnExpire callback for each expired entry.
+func (s *Shard) notifyExpired(expired []entry) {
+	for _, ent := range expired {
+		s.onExpire(ent.key, string(ent.plain()))
+	}
+}
+
+// removeElement deletes the entry held by elem from both the map and the LRU list.
+// The caller must hold the shard lock.
+func (s *Shard) removeElement(elem *list.Element) {
 	ent := elem.Value.(*entry)
 	delete(s.data, ent.key)
+	s.unindexKey(ent.key)
 	s.lru.Remove(elem)
+	s.untagLocked(ent)
+	s.unschedule(ent)
+	s.bytes -= ent.size()
+	s.saved -= ent.saved()
+}
+
+// keys appends the shard's live keys accepted by match to dst.
+func (s *Shard) keys(dst []string, match func(string) bool, now int64) []string {
+	s.mu.Lock()
+	defer s.unlock()
+
+	for key, elem := range s.data {
+		if elem.Value.(*entry).expired(now) {
+			continue
+		}
+		if match == nil || match(key) {
+			dst = append(dst, key)
+		}
+	}
+	return dst
+}
+
+// snapshot returns copies of the shard's live string entries.
+func (s *Shard) snapshot(now int64) []entry {
+	s.mu.Lock()
+	defer s.unlock()
+
+	entries := make([]entry, 0, len(s.data))
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		if ent := elem.Value.(*entry); !ent.expired(now) && ent.kind == kindString {
+			entries = append(entries, *ent)
+		}
+	}
+	return entries
+}
+
+// snapshotAll is like snapshot but returns entries of every kind, detached
+// from their objects.
+func (s *Shard) snapshotAll(now int64) []entry {
+	s.mu.Lock()
+	defer s.unlock()
+
+	entries := make([]entry, 0, len(s.data))
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		if ent := elem.Value.(*entry); !ent.expired(now) {
+			entries = append(entries, ent.detached())
+		}
+	}
+	return entries
+}
+
+// len returns the number of entries in the shard.
+func (s *Shard) len() int {
+	s.mu.Lock()
+	defer s.unlock()
+	return s.lru.Len()
+}
+
+// memoryUsage returns the approximate size of the shard's entries in bytes.
+func (s *Shard) memoryUsage() int64 {
+	s.mu.Lock()
+	defer s.unlock()
+	return s.bytes
+}
+
+// bytesSaved returns how many bytes compression saves across the shard's
+// entries.
+func (s *Shard) bytesSaved() int64 {
+	s.mu.Lock()
+	defer s.unlock()
+	return s.saved
 }
 
 // ShardedCache represents a thread-safe in-memory cache that partitions keys into shards.
 type ShardedCache struct {
-	shards        []*Shard
-	shardCount    int
-	shardCapacity int
+	shards            []*Shard
+	shardCount        int
+	shardMask         uint32 // shardCount - 1; shardCount is always a power of two.
+	shardCapacity     int
+	hash              func(string) uint32
+	slidingTTL        bool
+	defaultTTL        atomic.Int64 // A time.Duration, changed by SetDefaultTTL.
+	policy            EvictionPolicy
+	maxBytes          atomic.Int64 // Changed by SetMaxBytes.
+	tuneMu            sync.Mutex   // Serializes SetMaxBytes.
+	totalCapacity     int
+	maxValueBytes     int
+	maxBitOffset      int
+	compressThreshold int
+	readHeavy         bool
+
+	onEvict        func(key, value string)
+	onExpire       func(key, value string)
+	clearCallbacks bool
+
+	flights flightGroup
+	loader  func(key string) (string, time.Duration, error)
+
+	negativeTTL time.Duration
+	staleGrace  time.Duration
+
+	clock func() time.Time
+
+	writeThrough  func(key, value string) error
+	deleteThrough func(key string) error
+	writeBehind   *writeBehind
+	copyOnRead    bool
+
+	janitorInterval time.Duration
+	stop            chan struct{}
+	closeOnce       sync.Once
+	wg              sync.WaitGroup
 }
 
 // Option represents a functional option for configuring the ShardedCache.
 type Option func(*ShardedCache)
 
-// WithShardCount sets the number of shards in the cache.
+// WithShardCount sets the number of shards in the cache. The count is rounded
+// up to the next power of two so shards can be selected with a bit mask.
 func WithShardCount(n int) Option {
 	return func(sc *ShardedCache) {
 		if n > 0 {
@@ -111,55 +824,873 @@ func WithShardCount(n int) Option {
 }
 
 // WithShardCapacity sets the capacity for each shard.
+// A capacity of zero removes the item limit.
 func WithShardCapacity(cap int) Option {
 	return func(sc *ShardedCache) {
-		if cap > 0 {
+		if cap >= 0 {
 			sc.shardCapacity = cap
 		}
 	}
 }
 
+// WithJanitorInterval enables a background goroutine that removes expired
+// entries every d. Call Close to stop it.
+func WithJanitorInterval(d time.Duration) Option {
+	return func(sc *ShardedCache) {
+		if d > 0 {
+			sc.janitorInterval = d
+		}
+	}
+}
+
+// WithSlidingTTL makes every successful Get reset the entry's expiration to
+// now plus the TTL it was stored with. Entries without a TTL are unaffected.
+func WithSlidingTTL(enabled bool) Option {
+	return func(sc *ShardedCache) {
+		sc.slidingTTL = enabled
+	}
+}
+
+// WithDefaultTTL sets the TTL applied by Set and by SetWithTTL when called with
+// DefaultExpiration. SetWithTTL with a positive TTL overrides it per key, and
+// NoExpiration stores the key without any expiration.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(sc *ShardedCache) {
+		if d > 0 {
+			sc.defaultTTL.Store(int64(d))
+		}
+	}
+}
+
+// WithEvictionPolicy sets the policy shards use to choose entries to evict.
+// The default is LRU.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(sc *ShardedCache) {
+		sc.policy = p
+	}
+}
+
+// WithMaxBytes bounds the approximate memory used by keys and values. The
+// budget is split evenly across shards, and each shard evicts entries until it
+// is back under its share. It can be combined with WithShardCapacity, in which
+// case both limits are enforced.
+func WithMaxBytes(n int64) Option {
+	return func(sc *ShardedCache) {
+		if n > 0 {
+			sc.maxBytes.Store(n)
+		}
+	}
+}
+
+// WithTotalCapacity sets the item capacity of the whole cache instead of each
+// shard. The budget is divided evenly across shards, with the remainder spread
+// one item at a time over the first shards, so the cache never holds more than
+// n items. Shards do not borrow from each other: when keys are skewed towards
+// one shard, that shard evicts once it reaches its share even if others have
+// room. If n is smaller than the shard count, the shard count is reduced to n.
+// This option takes precedence over WithShardCapacity.
+func WithTotalCapacity(n int) Option {
+	return func(sc *ShardedCache) {
+		if n > 0 {
+			sc.totalCapacity = n
+		}
+	}
+}
+
+// WithOnEvict registers fn to be called for every entry evicted to stay within
+// the cache's capacity. It is called after the shard lock is released, so fn
+// may safely use the cache.
+func WithOnEvict(fn func(key, value string)) Option {
+	return func(sc *ShardedCache) {
+		sc.onEvict = fn
+	}
+}
+
+// WithOnExpire registers fn to be called for every entry removed because it
+// expired, whether by the janitor, DeleteExpired, or an operation that finds
+// it expired; each expired entry is reported once. Like the OnEvict callback,
+// it is called after the shard lock is released, so fn may safely use the
+// cache. The value is empty for entries that do not hold strings.
+func WithOnExpire(fn func(key, value string)) Option {
+	return func(sc *ShardedCache) {
+		sc.onExpire = fn
+	}
+}
+
+// WithClearCallbacks makes Clear invoke the OnEvict callback for every cleared
+// entry. It is off by default because clearing a large cache would otherwise
+// fire one callback per entry.
+func WithClearCallbacks(enabled bool) Option {
+	return func(sc *ShardedCache) {
+		sc.clearCallbacks = enabled
+	}
+}
+
+// WithCopyOnRead controls whether GetBytes returns a copy of the stored value
+// (the default) or the internal slice. Disabling it avoids an allocation per
+// read, but callers must then never modify the returned slice.
+func WithCopyOnRead(enabled bool) Option {
+	return func(sc *ShardedCache) {
+		sc.copyOnRead = enabled
+	}
+}
+
+// WithMaxValueBytes rejects values longer than n bytes. SetE, CompareAndSwap,
+// and Append return ErrValueTooLarge for such values; Set and the other write
+// methods leave the cache unchanged.
+func WithMaxValueBytes(n int) Option {
+	return func(sc *ShardedCache) {
+		if n > 0 {
+			sc.maxValueBytes = n
+		}
+	}
+}
+
+// WithHashFunc sets the function used to map keys to shards. It must be
+// deterministic and should spread keys evenly; the default is an
+// allocation-free FNV-1a.
+func WithHashFunc(fn func(string) uint32) Option {
+	return func(sc *ShardedCache) {
+		if fn != nil {
+			sc.hash = fn
+		}
+	}
+}
+
+// WithReadHeavy optimizes the cache for workloads dominated by Get. Shards use
+// a read-write lock and Get takes only the read lock, so concurrent reads of
+// the same shard no longer serialize. Recency is tracked with a per-entry
+// timestamp, and eviction removes the least recently used of a small random
+// sample instead of following the configured eviction policy. With sliding TTL
+// enabled, Get still takes the write lock to extend the expiration.
+func WithReadHeavy(enabled bool) Option {
+	return func(sc *ShardedCache) {
+		sc.readHeavy = enabled
+	}
+}
+
+// WithLoader makes Get read through to load on a miss. Concurrent misses on
+// the same key share one call to load; its value is stored with the returned
+// TTL, which accepts the same sentinels as SetWithTTL. Errors are returned to
+// every waiting caller and are not cached.
+func WithLoader(load func(key string) (string, time.Duration, error)) Option {
+	return func(sc *ShardedCache) {
+		sc.loader = load
+	}
+}
+
+// WithWriteThrough calls write synchronously before Set, SetE, SetWithTTL, or
+// SetBytes stores a value, so a backing store sees every write first. The hook
+// runs without any shard lock held. If it fails, the cache is left unmodified
+// and SetE returns the error. Other writes, such as SetNX, MSet, or values
+// stored by a loader, bypass the hook.
+func WithWriteThrough(write func(key, value string) error) Option {
+	return func(sc *ShardedCache) {
+		sc.writeThrough = write
+	}
+}
+
+// WithDeleteThrough calls del synchronously before Delete or DeleteE removes a
+// key, without any shard lock held. If it fails, the key is kept and DeleteE
+// returns the error.
+func WithDeleteThrough(del func(key string) error) Option {
+	return func(sc *ShardedCache) {
+		sc.deleteThrough = del
+	}
+}
+
+// WithNegativeTTL remembers keys the WithLoader loader reported as missing by
+// returning ErrKeyNotFound. For d afterwards, Get answers ErrKeyNotFound for
+// such a key without calling the loader again. Storing the key clears the
+// negative entry immediately.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(sc *ShardedCache) {
+		if d > 0 {
+			sc.negativeTTL = d
+		}
+	}
+}
+
+// WithStaleWhileRevalidate lets Get serve an expired value for up to grace
+// after its expiration, instead of waiting on the WithLoader loader. The first
+// such Get starts a single background refresh through the loader; a failed
+// refresh is retried by a later stale Get. Past the grace period the key
+// behaves like a normal miss. It has no effect without a loader.
+func WithStaleWhileRevalidate(grace time.Duration) Option {
+	return func(sc *ShardedCache) {
+		if grace > 0 {
+			sc.staleGrace = grace
+		}
+	}
+}
+
+// WithClock sets the function the cache uses to read the current time for
+// expiration and recency. It defaults to time.Now and is mainly useful for
+// tests that need to step through TTL phases deterministically.
+func WithClock(now func() time.Time) Option {
+	return func(sc *ShardedCache) {
+		if now != nil {
+			sc.clock = now
+		}
+	}
+}
+
 // NewShardedCache creates a new ShardedCache instance with the provided options.
 // Defaults: 16 shards, 100 items per shard.
 func NewShardedCache(opts ...Option) *ShardedCache {
 	sc := &ShardedCache{
 		shardCount:    16,
 		shardCapacity: 100,
+		hash:          fnv32a,
+		clock:         time.Now,
+		copyOnRead:    true,
+		maxBitOffset:  DefaultMaxBitOffset,
 	}
 	// Apply options.
 	for _, opt := range opts {
 		opt(sc)
 	}
+	sc.shardCount = ceilPow2(sc.shardCount)
+	if sc.totalCapacity > 0 && sc.totalCapacity < sc.shardCount {
+		// Round down so that every shard can hold at least one item.
+		sc.shardCount = ceilPow2(sc.totalCapacity+1) / 2
+	}
+	sc.shardMask = uint32(sc.shardCount - 1)
 	// Initialize shards.
 	sc.shards = make([]*Shard, sc.shardCount)
 	for i := 0; i < sc.shardCount; i++ {
-		sc.shards[i] = newShard(sc.shardCapacity)
+		sc.shards[i] = newShard(sc.capacityOf(i))
+		sc.shards[i].slidingTTL = sc.slidingTTL
+		sc.shards[i].policy = sc.policy
+		sc.shards[i].maxValueBytes = sc.maxValueBytes
+		sc.shards[i].compressThreshold = sc.compressThreshold
+		sc.shards[i].onExpire = sc.onExpire
+		sc.shards[i].readHeavy = sc.readHeavy
+		sc.shards[i].clock = sc.clock
+		if sc.loader != nil {
+			sc.shards[i].staleGrace = sc.staleGrace
+		}
+		sc.shards[i].maxBytes = sc.shardMaxBytes(sc.maxBytes.Load())
+	}
+	sc.stop = make(chan struct{})
+	if sc.janitorInterval > 0 {
+		sc.wg.Add(1)
+		go sc.runJanitor()
+	}
+	if sc.writeBehind != nil {
+		sc.wg.Add(1)
+		go sc.runWriteBehind()
 	}
 	return sc
 }
 
+// ceilPow2 returns the smallest power of two greater than or equal to n.
+func ceilPow2(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// capacityOf returns the item capacity of shard i.
+func (sc *ShardedCache) capacityOf(i int) int {
+	if sc.totalCapacity <= 0 {
+		return sc.shardCapacity
+	}
+	capacity := sc.totalCapacity / sc.shardCount
+	if i < sc.totalCapacity%sc.shardCount {
+		capacity++
+	}
+	return capacity
+}
+
+// runJanitor periodically purges expired entries until the cache is closed.
+func (sc *ShardedCache) runJanitor() {
+	defer sc.wg.Done()
+	ticker := time.NewTicker(sc.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sc.DeleteExpired()
+		case <-sc.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired removes all expired entries, locking one shard at a time,
+// and returns the number of entries removed.
+func (sc *ShardedCache) DeleteExpired() int {
+	now := sc.clock().UnixNano()
+	removed := 0
+	for _, shard := range sc.shards {
+		removed += shard.deleteExpired(now)
+	}
+	return removed
+}
+
+// Keys returns all unexpired keys in the cache, in no particular order.
+// Keys are collected one shard at a time, so the result is not an atomic
+// snapshot: concurrent writes to other shards may or may not be reflected.
+func (sc *ShardedCache) Keys() []string {
+	return sc.collectKeys(nil)
+}
+
+// KeysMatching returns the unexpired keys matching the glob pattern, where '*'
+// matches any sequence of characters and '?' matches a single character.
+// Like Keys, the result is not an atomic snapshot.
+func (sc *ShardedCache) KeysMatching(pattern string) []string {
+	return sc.collectKeys(func(key string) bool {
+		return matchGlob(pattern, key)
+	})
+}
+
+// KeysWithPrefix returns the unexpired keys that start with prefix. Like Keys,
+// the result is not an atomic snapshot.
+func (sc *ShardedCache) KeysWithPrefix(prefix string) []string {
+	return sc.collectKeys(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// collectKeys gathers keys accepted by match from every shard.
+func (sc *ShardedCache) collectKeys(match func(string) bool) []string {
+	now := sc.clock().UnixNano()
+	keys := []string{}
+	for _, shard := range sc.shards {
+		keys = shard.keys(keys, match, now)
+	}
+	return keys
+}
+
+// ForEach calls fn for each unexpired string entry, stopping early if fn
+// returns false. Entries are visited shard by shard in no particular order.
+// Each shard's entries are copied under its lock and fn is called after the
+// lock is released, so fn may safely read or modify the cache; such changes may
+// or may not be reflected in the remaining iteration.
+func (sc *ShardedCache) ForEach(fn func(key, value string) bool) {
+	now := sc.clock().UnixNano()
+	for _, shard := range sc.shards {
+		for _, ent := range shard.snapshot(now) {
+			if !fn(ent.key, string(ent.plain())) {
+				return
+			}
+		}
+	}
+}
+
+// Clear removes all entries from the cache, one shard at a time. Statistics
+// counters are left untouched. If WithClearCallbacks is enabled, the OnEvict
+// callback fires for each cleared entry.
+func (sc *ShardedCache) Clear() {
+	notify := sc.clearCallbacks && sc.onEvict != nil
+	for _, shard := range sc.shards {
+		removed := shard.clear(notify)
+		if notify {
+			sc.notifyEvicted(removed)
+		}
+	}
+}
+
+// DeleteByPrefix removes every entry whose key starts with prefix, locking one
+// shard at a time, and returns the number of entries removed. Like Keys, it is
+// not atomic: keys written to other shards meanwhile may survive.
+func (sc *ShardedCache) DeleteByPrefix(prefix string) int {
+	return sc.deleteMatching(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// DeleteByPattern removes every entry whose key matches the glob pattern, with
+// the syntax of KeysMatching, and returns the number of entries removed. Like
+// DeleteByPrefix, it is not atomic across shards.
+func (sc *ShardedCache) DeleteByPattern(pattern string) int {
+	return sc.deleteMatching(func(key string) bool {
+		return matchGlob(pattern, key)
+	})
+}
+
+// deleteMatching removes the entries accepted by match from every shard.
+func (sc *ShardedCache) deleteMatching(match func(string) bool) int {
+	removed := 0
+	for _, shard := range sc.shards {
+		removed += shard.deleteMatching(match)
+	}
+	return removed
+}
+
+// Len returns the number of entries in the cache, including expired entries
+// that have not been removed yet. Shards are locked one at a time, so the
+// result is not an atomic snapshot under concurrent writes.
+func (sc *ShardedCache) Len() int {
+	total := 0
+	for _, shard := range sc.shards {
+		total += shard.len()
+	}
+	return total
+}
+
+// Cap returns the configured item capacity of the cache, summed over all
+// shards. It returns zero if the item count is unlimited.
+func (sc *ShardedCache) Cap() int {
+	total := 0
+	for _, shard := range sc.shards {
+		if shard.capacity <= 0 {
+			return 0
+		}
+		total += shard.capacity
+	}
+	return total
+}
+
+// MemoryUsage returns the approximate number of bytes used by all entries,
+// computed as key and value lengths plus a fixed per-entry overhead.
+func (sc *ShardedCache) MemoryUsage() int64 {
+	var total int64
+	for _, shard := range sc.shards {
+		total += shard.memoryUsage()
+	}
+	return total
+}
+
+// DefaultTTL returns the TTL applied to writes made with DefaultExpiration,
+// or zero if there is none.
+func (sc *ShardedCache) DefaultTTL() time.Duration {
+	return time.Duration(sc.defaultTTL.Load())
+}
+
+// SetDefaultTTL changes the TTL applied to later writes made with
+// DefaultExpiration, as set at construction by WithDefaultTTL. Zero or a
+// negative TTL removes the default. Entries already stored keep their
+// expiration.
+func (sc *ShardedCache) SetDefaultTTL(d time.Duration) {
+	sc.defaultTTL.Store(int64(max(d, 0)))
+}
+
+// MaxValueBytes returns the value size limit set by WithMaxValueBytes, or
+// zero if values are unlimited.
+func (sc *ShardedCache) MaxValueBytes() int {
+	return sc.maxValueBytes
+}
+
+// MaxBytes returns the memory budget set by WithMaxBytes or SetMaxBytes, or
+// zero if memory is unlimited.
+func (sc *ShardedCache) MaxBytes() int64 {
+	return sc.maxBytes.Load()
+}
+
+// SetMaxBytes changes the memory budget set by WithMaxBytes; zero or a
+// negative value removes it. Shards over their new share evict entries at
+// once, invoking the OnEvict callback for each.
+func (sc *ShardedCache) SetMaxBytes(n int64) {
+	sc.tuneMu.Lock()
+	defer sc.tuneMu.Unlock()
+
+	n = max(n, 0)
+	sc.maxBytes.Store(n)
+	for _, shard := range sc.shards {
+		shard.mu.Lock()
+		shard.maxBytes = sc.shardMaxBytes(n)
+		evicted := shard.evictOverflow(nil)
+		shard.mu.Unlock()
+		sc.notifyEvicted(evicted)
+	}
+}
+
+// shardMaxBytes returns each shard's share of a cache-wide memory budget.
+func (sc *ShardedCache) shardMaxBytes(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	return max(n/int64(sc.shardCount), 1)
+}
+
+// Close stops any background goroutines started by the cache, waiting for
+// queued write-behind writes to be flushed. It is safe to call Close more than
+// once.
+func (sc *ShardedCache) Close() {
+	sc.closeOnce.Do(func() {
+		if sc.writeBehind != nil {
+			sc.writeBehind.close()
+		}
+		close(sc.stop)
+	})
+	sc.wg.Wait()
+}
+
 // getShard selects a shard based on the key's hash.
 func (sc *ShardedCache) getShard(key string) *Shard {
-	hash := fnv.New32a()
-	hash.Write([]byte(key))
-	idx := hash.Sum32() % uint32(sc.shardCount)
-	return sc.shards[idx]
+	return sc.shards[sc.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard that key hashes to.
+func (sc *ShardedCache) shardIndex(key string) int {
+	return int(sc.hash(key) & sc.shardMask)
 }
 
 // Set inserts or updates the key-value pair in the appropriate shard.
+// The value expires after the default TTL, or never if none is configured.
+// Values over the WithMaxValueBytes limit are not stored; use SetE to observe
+// that case.
 func (sc *ShardedCache) Set(key, value string) {
+	sc.SetWithTTL(key, value, DefaultExpiration)
+}
+
+// SetE is like Set but returns ErrValueTooLarge instead of silently dropping a
+// value over the WithMaxValueBytes limit.
+func (sc *ShardedCache) SetE(key, value string) error {
+	return sc.setWithTTL(key, []byte(value), DefaultExpiration, nil)
+}
+
+// SetWithTTL inserts or updates the key-value pair in the appropriate shard,
+// expiring it after ttl. DefaultExpiration (zero) uses the cache's default TTL,
+// which means no expiration unless WithDefaultTTL was given; NoExpiration
+// always stores the value without expiration.
+func (sc *ShardedCache) SetWithTTL(key, value string, ttl time.Duration) {
+	sc.setWithTTL(key, []byte(value), ttl, nil)
+}
+
+// SetWithTTLE is like SetWithTTL but returns ErrValueTooLarge instead of
+// silently dropping a value over the WithMaxValueBytes limit.
+func (sc *ShardedCache) SetWithTTLE(key, value string, ttl time.Duration) error {
+	return sc.setWithTTL(key, []byte(value), ttl, nil)
+}
+
+// setWithTTL stores value under key with tags unless it exceeds the value size
+// limit or the write-through hook fails.
+func (sc *ShardedCache) setWithTTL(key string, value []byte, ttl time.Duration, tags []string) error {
+	if sc.tooLarge(value) {
+		return ErrValueTooLarge
+	}
+	if sc.writeThrough != nil {
+		if err := sc.writeThrough(key, string(value)); err != nil {
+			return err
+		}
+	}
+	sc.store(key, value, ttl, tags)
+	if sc.writeBehind != nil {
+		sc.enqueue(key, string(value))
+	}
+	return nil
+}
+
+// store inserts value under key without consulting the write-through hook.
+func (sc *ShardedCache) store(key string, value []byte, ttl time.Duration, tags []string) {
+	shard := sc.getShard(key)
+	sc.notifyEvicted(shard.set(key, value, sc.resolveTTL(ttl), tags))
+}
+
+// tooLarge reports whether value exceeds the configured value size limit.
+func (sc *ShardedCache) tooLarge(value []byte) bool {
+	return sc.maxValueBytes > 0 && len(value) > sc.maxValueBytes
+}
+
+// SetNX stores value under key with the default TTL only if the key is absent
+// or expired. It reports whether the value was stored.
+func (sc *ShardedCache) SetNX(key, value string) bool {
+	return sc.SetNXWithTTL(key, value, DefaultExpiration)
+}
+
+// SetNXWithTTL is like SetNX but expires the stored value after ttl, which
+// accepts the same sentinels as SetWithTTL. Combined with a TTL it can be used
+// as a lease: the first caller to claim the key holds it until it expires.
+func (sc *ShardedCache) SetNXWithTTL(key, value string, ttl time.Duration) bool {
+	if sc.tooLarge([]byte(value)) {
+		return false
+	}
+	shard := sc.getShard(key)
+	stored, evicted := shard.setNX(key, []byte(value), sc.resolveTTL(ttl))
+	sc.notifyEvicted(evicted)
+	return stored
+}
+
+// CompareAndSwap sets key to newValue only if its current value equals old,
+// checking and updating under a single shard lock. It returns ErrKeyNotFound if
+// the key is missing or expired, false if the current value differs from old, and
+// true if the value was replaced. The entry is promoted in the LRU list either
+// way and keeps its existing TTL. A newValue over the WithMaxValueBytes limit
+// is rejected with ErrValueTooLarge.
+func (sc *ShardedCache) CompareAndSwap(key, old, newValue string) (bool, error) {
+	if sc.tooLarge([]byte(newValue)) {
+		return false, ErrValueTooLarge
+	}
+	shard := sc.getShard(key)
+	swapped, evicted, err := shard.compareAndSwap(key, []byte(old), []byte(newValue))
+	sc.notifyEvicted(evicted)
+	return swapped, err
+}
+
+// DeleteIfEquals removes key only if its current value equals value,
+// checking and deleting under a single shard lock, and reports whether it
+// did. Paired with SetNXWithTTL it releases a lease only for the holder
+// whose token is stored. Like SetNX, it does not call the delete-through
+// function.
+func (sc *ShardedCache) DeleteIfEquals(key, value string) bool {
+	return sc.getShard(key).deleteIfEquals(key, []byte(value))
+}
+
+// ExpireIfEquals is like Expire, but only resets key's TTL if its current
+// value equals value, under the same lock as the check. It reports whether
+// it did, which renews a lease only for its holder.
+func (sc *ShardedCache) ExpireIfEquals(key, value string, ttl time.Duration) bool {
+	return sc.getShard(key).expireIfEquals(key, []byte(value), ttl)
+}
+
+// Increment atomically adds delta to the integer stored at key and returns the
+// new value. A missing key is treated as zero and created with the default
+// TTL; an existing key keeps its TTL. It returns ErrNotNumeric if the stored
+// value is not a base-10 integer and ErrOverflow if the result would overflow
+// int64.
+func (sc *ShardedCache) Increment(key string, delta int64) (int64, error) {
+	shard := sc.getShard(key)
+	n, evicted, err := shard.increment(key, delta, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return n, err
+}
+
+// Append appends suffix to the value stored at key under the shard lock and
+// returns the new length of the value. A missing key is created with the
+// default TTL; an existing key keeps its TTL. Growth counts against the byte
+// budget configured with WithMaxBytes. If the result would exceed the
+// WithMaxValueBytes limit, the value is left unchanged and ErrValueTooLarge is
+// returned along with the current length.
+func (sc *ShardedCache) Append(key, suffix string) (newLen int, err error) {
+	shard := sc.getShard(key)
+	newLen, evicted, err := shard.appendValue(key, []byte(suffix), sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return newLen, err
+}
+
+// Rename moves the entry stored at oldKey to newKey, preserving its TTL and
+// metadata and overwriting any existing newKey. It returns ErrKeyNotFound if
+// oldKey is missing or expired. When the keys live in different shards, both shard
+// locks are taken in shard-index order so concurrent renames cannot deadlock.
+func (sc *ShardedCache) Rename(oldKey, newKey string) error {
+	evicted, expired, err := sc.rename(oldKey, newKey)
+	sc.notifyEvicted(evicted)
+	sc.getShard(oldKey).notifyExpired(expired)
+	return err
+}
+
+// rename performs Rename under the shard locks and returns the evicted and
+// expired entries, which it leaves to Rename to report once both locks are
+// released.
+func (sc *ShardedCache) rename(oldKey, newKey string) (evicted, expired []entry, err error) {
+	i, j := sc.shardIndex(oldKey), sc.shardIndex(newKey)
+	src, dst := sc.shards[i], sc.shards[j]
+	switch {
+	case i == j:
+		src.mu.Lock()
+		defer src.mu.Unlock()
+	case i < j:
+		src.mu.Lock()
+		defer src.mu.Unlock()
+		dst.mu.Lock()
+		defer dst.mu.Unlock()
+	default:
+		dst.mu.Lock()
+		defer dst.mu.Unlock()
+		src.mu.Lock()
+		defer src.mu.Unlock()
+	}
+
+	defer func() {
+		// Both shards may have expired entries: src's oldKey, or dst's
+		// newKey being replaced.
+		expired, src.expired = src.expired, nil
+		if i != j {
+			expired, dst.expired = append(expired, dst.expired...), nil
+		}
+	}()
+	elem, ok := src.live(oldKey, sc.clock().UnixNano())
+	if !ok {
+		return nil, nil, ErrKeyNotFound
+	}
+	if oldKey == newKey {
+		return nil, nil, nil
+	}
+	ent := *elem.Value.(*entry)
+	src.removeElement(elem)
+	ent.key = newKey
+	return dst.insertLocked(&ent), nil, nil
+}
+
+// GetOrSet returns the existing value for key if present, promoting it in the
+// LRU list. Otherwise it stores value with the default TTL and returns it. The
+// loaded result is true if the value was already present. The check and the
+// insert happen under a single shard lock, mirroring sync.Map.LoadOrStore.
+// A missing key is not stored if value exceeds the WithMaxValueBytes limit.
+func (sc *ShardedCache) GetOrSet(key, value string) (actual string, loaded bool) {
+	if sc.tooLarge([]byte(value)) {
+		if existing, err := sc.Get(key); err == nil {
+			return existing, true
+		}
+		return value, false
+	}
 	shard := sc.getShard(key)
-	shard.set(key, value)
+	existing, loaded, evicted := shard.getOrSet(key, []byte(value), sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	if !loaded {
+		return value, false
+	}
+	return string(existing), true
 }
 
-// Get retrieves the value for a key from the appropriate shard.
+// notifyEvicted invokes the OnEvict callback for each evicted entry.
+func (sc *ShardedCache) notifyEvicted(evicted []entry) {
+	if sc.onEvict == nil {
+		return
+	}
+	for _, ent := range evicted {
+		sc.onEvict(ent.key, string(ent.plain()))
+	}
+}
+
+// resolveTTL applies the default TTL and the NoExpiration sentinel to ttl.
+func (sc *ShardedCache) resolveTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl == DefaultExpiration:
+		return time.Duration(sc.defaultTTL.Load())
+	case ttl < 0:
+		return 0
+	}
+	return ttl
+}
+
+// Get retrieves the value for a key from the appropriate shard. A missing or
+// expired key yields ErrKeyNotFound, unless a loader configured with
+// WithLoader supplies the value.
 func (sc *ShardedCache) Get(key string) (string, error) {
 	shard := sc.getShard(key)
-	return shard.get(key)
+	value, refresh, err := shard.get(key)
+	if err != nil {
+		if sc.loader != nil && err == ErrKeyNotFound {
+			return sc.load(key)
+		}
+		return "", err
+	}
+	if refresh {
+		go sc.refresh(key)
+	}
+	return string(value), nil
 }
 
-// Delete removes the key from the appropriate shard.
-func (sc *ShardedCache) Delete(key string) {
+// refresh reloads a stale key in the background for stale-while-revalidate.
+func (sc *ShardedCache) refresh(key string) {
+	if _, err := sc.load(key); err != nil {
+		sc.getShard(key).endRefresh(key)
+	}
+}
+
+// SetBytes inserts or updates key with a binary value and the default TTL.
+// The cache takes ownership of value without copying it, so the caller must
+// not modify the slice after the call. Like Set, values over the
+// WithMaxValueBytes limit are not stored.
+func (sc *ShardedCache) SetBytes(key string, value []byte) {
+	sc.setWithTTL(key, value, DefaultExpiration, nil)
+}
+
+// GetBytes retrieves the binary value for key. With copy-on-read enabled (the
+// default) it returns a fresh copy the caller may modify freely. With
+// WithCopyOnRead(false) it returns the cache's internal slice without copying;
+// the caller must then treat it as read-only.
+func (sc *ShardedCache) GetBytes(key string) ([]byte, error) {
 	shard := sc.getShard(key)
-	shard.delete(key)
+	value, refresh, err := shard.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		go sc.refresh(key)
+	}
+	if sc.copyOnRead {
+		return bytes.Clone(value), nil
+	}
+	return value, nil
+}
+
+// GetDel returns the value for key and removes it atomically, so at most one
+// caller can observe a given value. A missing key yields the same error as Get.
+func (sc *ShardedCache) GetDel(key string) (string, error) {
+	value, err := sc.getShard(key).getDel(key)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Touch marks key as recently used, as a Get would, without copying its value.
+// With sliding TTL enabled it also extends the entry's expiration. It reports
+// whether the key exists.
+func (sc *ShardedCache) Touch(key string) bool {
+	return sc.getShard(key).touchKey(key)
+}
+
+// Expire sets key to expire after ttl from now; a ttl of zero or less removes
+// its expiration. The new ttl also becomes the entry's sliding window. It
+// reports whether the key exists.
+func (sc *ShardedCache) Expire(key string, ttl time.Duration) bool {
+	return sc.getShard(key).expire(key, ttl)
+}
+
+// ExpireTime returns when key expires, or the zero time if it never does. It
+// returns ErrKeyNotFound if key is missing or expired, whatever its type.
+func (sc *ShardedCache) ExpireTime(key string) (time.Time, error) {
+	ent, ok := sc.getShard(key).peekEntry(key)
+	if !ok {
+		return time.Time{}, ErrKeyNotFound
+	}
+	if ent.expiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, ent.expiresAt), nil
+}
+
+// Peek returns the value for key without promoting it in the LRU list or
+// counting a hit or miss, so monitoring reads do not disturb eviction order.
+func (sc *ShardedCache) Peek(key string) (string, error) {
+	value, err := sc.getShard(key).lookup(key)
+	return string(value), err
+}
+
+// PeekWithExpiry is like Peek but also returns when the entry expires. The
+// time is zero if the entry never expires.
+func (sc *ShardedCache) PeekWithExpiry(key string) (string, time.Time, error) {
+	ent, ok := sc.getShard(key).peekEntry(key)
+	if !ok {
+		return "", time.Time{}, ErrKeyNotFound
+	}
+	if ent.kind != kindString {
+		return "", time.Time{}, ErrWrongType
+	}
+	var expireAt time.Time
+	if ent.expiresAt > 0 {
+		expireAt = time.Unix(0, ent.expiresAt)
+	}
+	return string(ent.plain()), expireAt, nil
+}
+
+// Exists reports whether key is present and unexpired. Unlike Get, it does not
+// promote the entry in the LRU list or copy its value.
+func (sc *ShardedCache) Exists(key string) bool {
+	return sc.getShard(key).exists(key, sc.clock().UnixNano())
+}
+
+// Delete removes the key from the appropriate shard. If the delete-through
+// hook fails, the key is kept; use DeleteE to observe that case.
+func (sc *ShardedCache) Delete(key string) {
+	sc.DeleteE(key)
+}
+
+// DeleteE is like Delete but returns the error from the delete-through hook.
+func (sc *ShardedCache) DeleteE(key string) error {
+	if sc.deleteThrough != nil {
+		if err := sc.deleteThrough(key); err != nil {
+			return err
+		}
+	}
+	sc.getShard(key).delete(key)
+	return nil
 }
@@ -1,6 +1,17 @@
 package cache
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestShardedCacheSetAndGet(t *testing.T) {
 	// Create a sharded cache with 4 shards and a capacity of 2 per shard.
@@ -60,3 +71,1255 @@ func TestShardedCacheDelete(t *testing.T) {
 		t.Fatal("expected key 'test' to be deleted")
 	}
 }
+
+func TestShardedCacheSetWithTTLExpires(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithShardCapacity(10))
+	cache.SetWithTTL("short", "lived", 20*time.Millisecond)
+	cache.SetWithTTL("long", "lived", time.Hour)
+
+	if v, err := cache.Get("short"); err != nil || v != "lived" {
+		t.Fatalf("expected key 'short' to exist before expiry, got %q, %v", v, err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, err := cache.Get("short"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected key 'short' to have expired, got %v", err)
+	}
+	if v, err := cache.Get("long"); err != nil || v != "lived" {
+		t.Fatalf("expected key 'long' to still exist, got %q, %v", v, err)
+	}
+}
+
+func TestShardedCacheSetWithTTLOverwrite(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithShardCapacity(10))
+	cache.SetWithTTL("key", "first", time.Hour)
+	cache.SetWithTTL("key", "second", 20*time.Millisecond)
+
+	time.Sleep(40 * time.Millisecond)
+
+	// The shorter TTL from the overwrite must take effect.
+	if _, err := cache.Get("key"); err == nil {
+		t.Fatal("expected overwritten key to expire with the new TTL")
+	}
+
+	cache.SetWithTTL("key", "third", 20*time.Millisecond)
+	cache.Set("key", "forever")
+	time.Sleep(40 * time.Millisecond)
+	if v, err := cache.Get("key"); err != nil || v != "forever" {
+		t.Fatalf("expected key without TTL to persist, got %q, %v", v, err)
+	}
+}
+
+func TestShardedCacheJanitorPurgesExpired(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(10), WithJanitorInterval(10*time.Millisecond))
+	defer cache.Close()
+
+	cache.SetWithTTL("expiring", "value", 20*time.Millisecond)
+	cache.Set("permanent", "value")
+
+	// Wait for the janitor to run without issuing any Get calls.
+	time.Sleep(100 * time.Millisecond)
+
+	shard := cache.shards[0]
+	shard.mu.Lock()
+	_, expiringPresent := shard.data["expiring"]
+	_, permanentPresent := shard.data["permanent"]
+	lruLen := shard.lru.Len()
+	shard.mu.Unlock()
+
+	if expiringPresent {
+		t.Fatal("expected janitor to remove the expired key from the shard")
+	}
+	if !permanentPresent {
+		t.Fatal("expected janitor to keep the non-expiring key")
+	}
+	if lruLen != 1 {
+		t.Fatalf("expected LRU list to hold 1 entry, got %d", lruLen)
+	}
+}
+
+func TestShardedCacheCloseIdempotent(t *testing.T) {
+	cache := NewShardedCache(WithJanitorInterval(time.Millisecond))
+	cache.Close()
+	cache.Close()
+}
+
+func TestShardedCacheSlidingTTL(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithShardCapacity(10), WithSlidingTTL(true))
+	ttl := 60 * time.Millisecond
+	cache.SetWithTTL("read", "value", ttl)
+	cache.SetWithTTL("unread", "value", ttl)
+
+	// Read "read" shortly before it would expire, resetting its clock.
+	time.Sleep(40 * time.Millisecond)
+	if _, err := cache.Get("read"); err != nil {
+		t.Fatalf("expected key 'read' to exist before expiry, got error: %v", err)
+	}
+
+	// Past the original deadline, but within a full TTL window of the read.
+	time.Sleep(40 * time.Millisecond)
+	if _, err := cache.Get("unread"); err == nil {
+		t.Fatal("expected unread key to expire")
+	}
+	if _, err := cache.Get("read"); err != nil {
+		t.Fatalf("expected recently read key to survive another TTL window, got error: %v", err)
+	}
+}
+
+func TestShardedCacheDefaultTTL(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithShardCapacity(10), WithDefaultTTL(30*time.Millisecond))
+	cache.Set("default", "value")
+	cache.SetWithTTL("longer", "value", time.Hour)
+	cache.SetWithTTL("never", "value", NoExpiration)
+
+	time.Sleep(60 * time.Millisecond)
+
+	if _, err := cache.Get("default"); err == nil {
+		t.Fatal("expected key set without TTL to expire after the default TTL")
+	}
+	if _, err := cache.Get("longer"); err != nil {
+		t.Fatalf("expected per-key TTL to override the default, got error: %v", err)
+	}
+	if _, err := cache.Get("never"); err != nil {
+		t.Fatalf("expected NoExpiration key to persist, got error: %v", err)
+	}
+}
+
+func TestShardedCacheSetDefaultTTL(t *testing.T) {
+	cache := NewShardedCache(WithDefaultTTL(time.Hour))
+	cache.Set("before", "value")
+	cache.SetDefaultTTL(time.Minute)
+	cache.Set("after", "value")
+	cache.SetDefaultTTL(0)
+	cache.Set("none", "value")
+
+	if _, at, _ := cache.PeekWithExpiry("before"); time.Until(at) < 59*time.Minute {
+		t.Fatalf("expected an existing entry to keep its expiration, expires at %v", at)
+	}
+	if _, at, _ := cache.PeekWithExpiry("after"); time.Until(at) > time.Minute {
+		t.Fatalf("expected the new default TTL, expires at %v", at)
+	}
+	if _, at, _ := cache.PeekWithExpiry("none"); !at.IsZero() || cache.DefaultTTL() != 0 {
+		t.Fatalf("expected no expiration once the default is removed, expires at %v", at)
+	}
+}
+
+func TestEntryOverhead(t *testing.T) {
+	if strconv.IntSize != 64 {
+		t.Skip("entry sizes are checked on 64-bit platforms")
+	}
+	// Update this along with the entry struct, so that byte budgets keep
+	// up with what entries cost.
+	if entryOverhead != 317 {
+		t.Fatalf("expected an entry overhead of 317 bytes, got %d", entryOverhead)
+	}
+	cache := NewShardedCache()
+	cache.Set("key", "value")
+	if got := cache.MemoryUsage(); got != 317+int64(len("key")+len("value")) {
+		t.Fatalf("expected memory usage of 325 bytes, got %d", got)
+	}
+}
+
+func TestShardedCacheMaxBytes(t *testing.T) {
+	// One shard with room for roughly three small entries.
+	budget := int64(3 * (entryOverhead + 2))
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(100), WithMaxBytes(budget))
+
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Set("c", "3")
+	if got := cache.MemoryUsage(); got != budget {
+		t.Fatalf("expected memory usage %d, got %d", budget, got)
+	}
+
+	// A fourth entry pushes the shard over budget and evicts the LRU entry.
+	cache.Set("d", "4")
+	if _, err := cache.Get("a"); err == nil {
+		t.Fatal("expected key 'a' to be evicted by the byte budget")
+	}
+	if got := cache.MemoryUsage(); got > budget {
+		t.Fatalf("expected memory usage within %d, got %d", budget, got)
+	}
+}
+
+func TestShardedCacheSetMaxBytes(t *testing.T) {
+	var evicted []string
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(100),
+		WithOnEvict(func(key, value string) { evicted = append(evicted, key) }))
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(key, "1")
+	}
+
+	// Shrinking the budget evicts the least recently used entries at once.
+	budget := int64(2 * (entryOverhead + 2))
+	cache.SetMaxBytes(budget)
+	if cache.MaxBytes() != budget || cache.MemoryUsage() > budget {
+		t.Fatalf("expected usage within %d, got %d of %d", budget, cache.MemoryUsage(), cache.MaxBytes())
+	}
+	if len(evicted) != 2 || evicted[0] != "a" || evicted[1] != "b" {
+		t.Fatalf("expected a and b to be evicted, got %v", evicted)
+	}
+
+	// Removing the budget lets the cache grow again.
+	cache.SetMaxBytes(0)
+	for _, key := range []string{"e", "f", "g"} {
+		cache.Set(key, "1")
+	}
+	if cache.Len() != 5 || cache.MaxBytes() != 0 {
+		t.Fatalf("expected 5 keys with no budget, got %d and %d", cache.Len(), cache.MaxBytes())
+	}
+}
+
+func TestShardedCacheMaxBytesGrowingUpdate(t *testing.T) {
+	budget := int64(3 * (entryOverhead + 2))
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(100), WithMaxBytes(budget))
+
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Set("c", "3")
+
+	// Growing "c" must account for the delta and evict older entries.
+	cache.Set("c", string(make([]byte, entryOverhead)))
+	if _, err := cache.Get("a"); err == nil {
+		t.Fatal("expected key 'a' to be evicted after 'c' grew")
+	}
+	if _, err := cache.Get("c"); err != nil {
+		t.Fatal("expected updated key 'c' to remain")
+	}
+	if got := cache.MemoryUsage(); got > budget {
+		t.Fatalf("expected memory usage within %d, got %d", budget, got)
+	}
+
+	cache.Delete("c")
+	cache.Delete("b")
+	if got := cache.MemoryUsage(); got != 0 {
+		t.Fatalf("expected memory usage 0 after deleting all keys, got %d", got)
+	}
+}
+
+func TestShardedCacheMaxBytesWithItemCapacity(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2), WithMaxBytes(1<<20))
+
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Set("c", "3")
+	if _, err := cache.Get("a"); err == nil {
+		t.Fatal("expected item capacity to be enforced alongside the byte budget")
+	}
+}
+
+// keysForShard returns n distinct keys that all hash to the given shard.
+func keysForShard(sc *ShardedCache, shard, n int) []string {
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if sc.getShard(key) == sc.shards[shard] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// itemCount returns the number of entries across all shards.
+func itemCount(sc *ShardedCache) int {
+	total := 0
+	for _, shard := range sc.shards {
+		shard.mu.Lock()
+		total += shard.lru.Len()
+		shard.mu.Unlock()
+	}
+	return total
+}
+
+func TestShardedCacheTotalCapacitySkewed(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithTotalCapacity(10))
+
+	for _, key := range keysForShard(cache, 0, 50) {
+		cache.Set(key, "v")
+		if n := itemCount(cache); n > 10 {
+			t.Fatalf("cache holds %d items, exceeding total capacity 10", n)
+		}
+	}
+	// Shard 0 receives the remainder: 10/4 = 2, plus one of the 2 leftover items.
+	if n := itemCount(cache); n != 3 {
+		t.Fatalf("expected skewed shard to hold its share of 3 items, got %d", n)
+	}
+}
+
+func TestShardedCacheTotalCapacityBalanced(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithTotalCapacity(10))
+
+	var keys []string
+	for i := 0; i < 4; i++ {
+		keys = append(keys, keysForShard(cache, i, cache.capacityOf(i))...)
+	}
+	for _, key := range keys {
+		cache.Set(key, "v")
+	}
+	if n := itemCount(cache); n != 10 {
+		t.Fatalf("expected 10 items with a balanced distribution, got %d", n)
+	}
+	for _, key := range keys {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("expected key %q not to be evicted prematurely", key)
+		}
+	}
+}
+
+func TestShardedCacheTotalCapacitySmallerThanShardCount(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(16), WithTotalCapacity(3))
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+	if n := itemCount(cache); n > 3 {
+		t.Fatalf("cache holds %d items, exceeding total capacity 3", n)
+	}
+}
+
+func TestShardedCacheLenAndCap(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithShardCapacity(5))
+	if c := cache.Cap(); c != 20 {
+		t.Fatalf("expected capacity 20, got %d", c)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+	if n := cache.Len(); n != itemCount(cache) {
+		t.Fatalf("expected Len %d, got %d", itemCount(cache), n)
+	}
+
+	if c := NewShardedCache(WithTotalCapacity(50)).Cap(); c != 50 {
+		t.Fatalf("expected total capacity 50, got %d", c)
+	}
+	if c := NewShardedCache(WithShardCapacity(0)).Cap(); c != 0 {
+		t.Fatalf("expected unlimited capacity to report 0, got %d", c)
+	}
+}
+
+func TestShardedCacheLenConcurrent(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8), WithShardCapacity(50))
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i%100)
+				cache.Set(key, "v")
+				cache.Delete(key)
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			if n := cache.Len(); n < 0 || n > cache.Cap() {
+				t.Fatalf("Len returned %d, outside [0, %d]", n, cache.Cap())
+			}
+		}
+	}
+}
+
+func TestShardedCacheKeys(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8))
+	if keys := cache.Keys(); len(keys) != 0 {
+		t.Fatalf("expected no keys in an empty cache, got %v", keys)
+	}
+	if keys := cache.KeysMatching("*"); len(keys) != 0 {
+		t.Fatalf("expected no matches in an empty cache, got %v", keys)
+	}
+
+	want := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		cache.Set(key, "v")
+		want[key] = true
+		cache.Set(fmt.Sprintf("session:%d", i), "v")
+	}
+	cache.SetWithTTL("user:expired", "v", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if keys := cache.Keys(); len(keys) != 40 {
+		t.Fatalf("expected 40 keys, got %d", len(keys))
+	}
+
+	got := cache.KeysMatching("user:*")
+	shards := map[*Shard]bool{}
+	for _, key := range got {
+		if !want[key] {
+			t.Fatalf("unexpected key %q in matches", key)
+		}
+		shards[cache.getShard(key)] = true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d matches, got %d", len(want), len(got))
+	}
+	if len(shards) < 2 {
+		t.Fatal("expected matching keys to span multiple shards")
+	}
+
+	if got := cache.KeysMatching("user:?"); len(got) != 10 {
+		t.Fatalf("expected 10 single-digit user keys, got %d", len(got))
+	}
+	if got := cache.KeysWithPrefix("user:"); len(got) != len(want) {
+		t.Fatalf("expected %d keys with prefix, got %d", len(want), len(got))
+	}
+	cache.Set("glob*[", "v")
+	if got := cache.KeysWithPrefix("glob*"); len(got) != 1 {
+		t.Fatalf("expected prefixes to match literally, got %v", got)
+	}
+}
+
+func TestShardedCacheDeleteByPrefix(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	for i := 0; i < 50; i++ {
+		cache.Set(fmt.Sprintf("tenant:a:%d", i), "v")
+		cache.Set(fmt.Sprintf("tenant:b:%d", i), "v")
+	}
+	cache.Set("tenant:a", "v")
+
+	if n := cache.DeleteByPrefix("tenant:a:"); n != 50 {
+		t.Fatalf("expected 50 keys removed, got %d", n)
+	}
+	if cache.Len() != 51 || !cache.Exists("tenant:a") || !cache.Exists("tenant:b:7") {
+		t.Fatalf("expected other keys to survive, got %d keys", cache.Len())
+	}
+	if n := cache.DeleteByPrefix("none:"); n != 0 {
+		t.Fatalf("expected nothing removed, got %d", n)
+	}
+	var want int64
+	for _, key := range cache.Keys() {
+		want += int64(len(key)+len("v")) + entryOverhead
+	}
+	if used := cache.MemoryUsage(); used != want {
+		t.Fatalf("expected memory usage of %d for the survivors, got %d", want, used)
+	}
+}
+
+func TestShardedCacheDeleteByPattern(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("user:%d:session", i), "v")
+		cache.Set(fmt.Sprintf("user:%d:profile", i), "v")
+	}
+
+	if n := cache.DeleteByPattern("user:?:session"); n != 10 {
+		t.Fatalf("expected 10 single-digit sessions removed, got %d", n)
+	}
+	if n := cache.DeleteByPattern("user:*:session"); n != 10 {
+		t.Fatalf("expected the other 10 sessions removed, got %d", n)
+	}
+	if got := cache.KeysMatching("*:session"); len(got) != 0 {
+		t.Fatalf("expected no sessions left, got %v", got)
+	}
+	if cache.Len() != 20 {
+		t.Fatalf("expected the profiles to survive, got %d keys", cache.Len())
+	}
+}
+
+func BenchmarkDeleteByPrefix(b *testing.B) {
+	const n = 1_000_000
+	keys := make([]string, n)
+	for i := range keys {
+		// One key in ten belongs to the prefix being invalidated.
+		keys[i] = fmt.Sprintf("app%d:key-%d", i%10, i)
+	}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cache := NewShardedCache(WithShardCapacity(0))
+		for _, key := range keys {
+			cache.Set(key, "v")
+		}
+		b.StartTimer()
+		if removed := cache.DeleteByPrefix("app3:"); removed != n/10 {
+			b.Fatalf("expected %d keys removed, got %d", n/10, removed)
+		}
+	}
+}
+
+func TestShardedCacheForEach(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("%d", i))
+	}
+
+	total := 0
+	cache.ForEach(func(key, value string) bool {
+		total++
+		return true
+	})
+	if total != 20 {
+		t.Fatalf("expected ForEach to visit 20 entries, got %d", total)
+	}
+
+	visited := 0
+	cache.ForEach(func(key, value string) bool {
+		visited++
+		return visited < 5
+	})
+	if visited != 5 {
+		t.Fatalf("expected ForEach to stop after 5 entries, got %d", visited)
+	}
+}
+
+func TestShardedCacheForEachMutatesCache(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1))
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+
+	// Touching the cache from the callback, including the visited key's own
+	// shard, must not deadlock.
+	cache.ForEach(func(key, value string) bool {
+		cache.Get(key)
+		cache.Delete(key)
+		cache.Set("copy:"+key, value)
+		return true
+	})
+
+	if n := len(cache.KeysMatching("key-*")); n != 0 {
+		t.Fatalf("expected all original keys deleted, %d remain", n)
+	}
+	if n := len(cache.KeysMatching("copy:*")); n != 10 {
+		t.Fatalf("expected 10 copied keys, got %d", n)
+	}
+}
+
+func TestShardedCacheOnEvict(t *testing.T) {
+	var evicted []string
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2), WithOnEvict(func(key, value string) {
+		evicted = append(evicted, key+"="+value)
+	}))
+
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Set("c", "3")
+
+	if len(evicted) != 1 || evicted[0] != "a=1" {
+		t.Fatalf("expected eviction of a=1, got %v", evicted)
+	}
+}
+
+func TestShardedCacheOnExpire(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	var expired []string
+	var cache *ShardedCache
+	cache = NewShardedCache(WithShardCount(1), WithClock(clock.Now), WithOnExpire(func(key, value string) {
+		expired = append(expired, key+"="+value)
+		cache.Exists(key) // The callback may use the cache.
+	}))
+
+	cache.SetWithTTL("read", "1", time.Second)
+	cache.SetWithTTL("swept", "2", time.Second)
+	cache.SetWithTTL("renamed", "3", time.Second)
+	cache.SetWithTTL("overwritten", "4", time.Second)
+	cache.Set("kept", "5")
+	clock.Advance(2 * time.Second)
+
+	cache.Get("read")
+	cache.Get("read")
+	cache.Rename("renamed", "elsewhere")
+	cache.Set("overwritten", "new")
+	cache.DeleteExpired()
+	cache.DeleteExpired()
+
+	slices.Sort(expired)
+	want := []string{"overwritten=4", "read=1", "renamed=3", "swept=2"}
+	if !slices.Equal(expired, want) {
+		t.Fatalf("expected each expired entry reported once, got %v", expired)
+	}
+}
+
+func TestShardedCacheClear(t *testing.T) {
+	evicted := 0
+	cache := NewShardedCache(WithShardCount(4), WithOnEvict(func(key, value string) {
+		evicted++
+	}))
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+
+	cache.Clear()
+
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("expected empty cache after Clear, got %d entries", n)
+	}
+	if got := cache.MemoryUsage(); got != 0 {
+		t.Fatalf("expected memory usage 0 after Clear, got %d", got)
+	}
+	if evicted != 0 {
+		t.Fatalf("expected no callbacks without WithClearCallbacks, got %d", evicted)
+	}
+	cache.Set("again", "v")
+	if _, err := cache.Get("again"); err != nil {
+		t.Fatal("expected cache to be usable after Clear")
+	}
+}
+
+func TestShardedCacheClearCallbacks(t *testing.T) {
+	cleared := map[string]bool{}
+	cache := NewShardedCache(WithShardCount(4), WithClearCallbacks(true), WithOnEvict(func(key, value string) {
+		cleared[key] = true
+	}))
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), "v")
+	}
+
+	cache.Clear()
+
+	if len(cleared) != 10 {
+		t.Fatalf("expected callbacks for 10 cleared entries, got %d", len(cleared))
+	}
+}
+
+func TestShardedCacheExistsDoesNotPromote(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+
+	if !cache.Exists("a") {
+		t.Fatal("expected key 'a' to exist")
+	}
+	if cache.Exists("missing") {
+		t.Fatal("expected missing key not to exist")
+	}
+
+	// "a" is still the least recently used entry, so it is evicted next.
+	cache.Set("c", "3")
+	if cache.Exists("a") {
+		t.Fatal("expected Exists not to protect 'a' from eviction")
+	}
+	if st := cache.Stats(); st.Hits != 0 || st.Misses != 0 {
+		t.Fatalf("expected Exists not to count hits or misses, got %+v", st)
+	}
+}
+
+func TestShardedCacheGetOrSet(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+
+	if actual, loaded := cache.GetOrSet("a", "1"); loaded || actual != "1" {
+		t.Fatalf("expected first GetOrSet to store '1', got %q, loaded=%v", actual, loaded)
+	}
+	if actual, loaded := cache.GetOrSet("a", "2"); !loaded || actual != "1" {
+		t.Fatalf("expected second GetOrSet to load '1', got %q, loaded=%v", actual, loaded)
+	}
+
+	// Loading "a" promotes it, so inserting two more keys evicts "b" first.
+	cache.Set("b", "2")
+	cache.GetOrSet("a", "ignored")
+	cache.GetOrSet("c", "3")
+	if cache.Exists("b") {
+		t.Fatal("expected GetOrSet insert to evict the least recently used key")
+	}
+	if !cache.Exists("a") {
+		t.Fatal("expected promoted key 'a' to survive eviction")
+	}
+}
+
+func TestShardedCacheGetOrSetRace(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	const workers = 50
+	results := make([]string, workers)
+	loadedCount := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			actual, loaded := cache.GetOrSet("contended", fmt.Sprintf("value-%d", i))
+			results[i] = actual
+			if loaded {
+				mu.Lock()
+				loadedCount++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	winner, _ := cache.Get("contended")
+	for i, got := range results {
+		if got != winner {
+			t.Fatalf("goroutine %d saw %q, expected winning value %q", i, got, winner)
+		}
+	}
+	if loadedCount != workers-1 {
+		t.Fatalf("expected exactly one insert and %d loads, got %d loads", workers-1, loadedCount)
+	}
+}
+
+func TestShardedCacheSetNX(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	if !cache.SetNX("lock", "owner-1") {
+		t.Fatal("expected SetNX on a missing key to succeed")
+	}
+	if cache.SetNX("lock", "owner-2") {
+		t.Fatal("expected SetNX on an existing key to fail")
+	}
+	if v, _ := cache.Get("lock"); v != "owner-1" {
+		t.Fatalf("expected original owner to keep the key, got %q", v)
+	}
+
+	cache.SetNXWithTTL("lease", "owner-1", 20*time.Millisecond)
+	if cache.SetNXWithTTL("lease", "owner-2", time.Hour) {
+		t.Fatal("expected SetNXWithTTL to fail while the lease is live")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !cache.SetNXWithTTL("lease", "owner-2", time.Hour) {
+		t.Fatal("expected SetNXWithTTL to succeed after the lease expired")
+	}
+}
+
+func TestShardedCacheSetNXRace(t *testing.T) {
+	cache := NewShardedCache()
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if cache.SetNX("lock", fmt.Sprintf("owner-%d", i)) {
+				wins.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := wins.Load(); n != 1 {
+		t.Fatalf("expected exactly one SetNX winner, got %d", n)
+	}
+}
+
+func TestShardedCacheCompareAndSwap(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+
+	if _, err := cache.CompareAndSwap("missing", "a", "b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound from CompareAndSwap on a missing key, got %v", err)
+	}
+
+	cache.Set("key", "v1")
+	if ok, err := cache.CompareAndSwap("key", "stale", "v2"); ok || err != nil {
+		t.Fatalf("expected mismatched CompareAndSwap to fail without error, got %v, %v", ok, err)
+	}
+	if ok, err := cache.CompareAndSwap("key", "v1", "v2"); !ok || err != nil {
+		t.Fatalf("expected matching CompareAndSwap to succeed, got %v, %v", ok, err)
+	}
+	if v, _ := cache.Get("key"); v != "v2" {
+		t.Fatalf("expected value 'v2', got %q", v)
+	}
+}
+
+func TestShardedCacheCompareAndSwapNoLostUpdates(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	cache.Set("counter", "0")
+
+	const workers, perWorker = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				for {
+					old, _ := cache.Get("counter")
+					n, _ := strconv.Atoi(old)
+					if ok, _ := cache.CompareAndSwap("counter", old, strconv.Itoa(n+1)); ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := cache.Get("counter"); v != strconv.Itoa(workers*perWorker) {
+		t.Fatalf("expected counter %d, got %s", workers*perWorker, v)
+	}
+}
+
+func TestShardedCacheDeleteIfEquals(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewShardedCache(WithShardCount(4), WithClock(clock.Now))
+
+	if cache.DeleteIfEquals("lock", "token") {
+		t.Fatal("expected DeleteIfEquals on a missing key to report false")
+	}
+	cache.SetNXWithTTL("lock", "holder-1", time.Second)
+	if cache.ExpireIfEquals("lock", "holder-2", time.Hour) || cache.DeleteIfEquals("lock", "holder-2") {
+		t.Fatal("expected another token to leave the lock alone")
+	}
+	if !cache.ExpireIfEquals("lock", "holder-1", time.Minute) {
+		t.Fatal("expected the holder to renew the lock")
+	}
+	clock.Advance(30 * time.Second)
+	if !cache.Exists("lock") {
+		t.Fatal("expected the renewed lock to outlive its first TTL")
+	}
+
+	// Once the lock expires and changes hands, the stale holder cannot
+	// release it.
+	clock.Advance(time.Minute)
+	cache.SetNXWithTTL("lock", "holder-2", time.Minute)
+	if cache.DeleteIfEquals("lock", "holder-1") {
+		t.Fatal("expected the stale holder to fail to delete the lock")
+	}
+	if !cache.DeleteIfEquals("lock", "holder-2") || cache.Exists("lock") {
+		t.Fatal("expected the holder to delete the lock")
+	}
+
+	cache.HSet("hash", "f", "v")
+	if cache.DeleteIfEquals("hash", "v") {
+		t.Fatal("expected DeleteIfEquals to leave a hash alone")
+	}
+}
+
+func TestShardedCacheIncrement(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+
+	if n, err := cache.Increment("counter", 5); err != nil || n != 5 {
+		t.Fatalf("expected missing key to start at 0 and become 5, got %d, %v", n, err)
+	}
+	if n, err := cache.Increment("counter", -7); err != nil || n != -2 {
+		t.Fatalf("expected -2, got %d, %v", n, err)
+	}
+	if v, _ := cache.Get("counter"); v != "-2" {
+		t.Fatalf("expected stored value '-2', got %q", v)
+	}
+
+	cache.Set("text", "hello")
+	if _, err := cache.Increment("text", 1); !errors.Is(err, ErrNotNumeric) {
+		t.Fatalf("expected ErrNotNumeric incrementing a non-numeric value, got %v", err)
+	}
+	if v, _ := cache.Get("text"); v != "hello" {
+		t.Fatalf("expected non-numeric value to be unchanged, got %q", v)
+	}
+
+	cache.Set("big", strconv.FormatInt(math.MaxInt64, 10))
+	if _, err := cache.Increment("big", 1); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestShardedCacheIncrementConcurrent(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Increment("hits", 1); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := cache.Get("hits"); v != "1000" {
+		t.Fatalf("expected 1000 after 1000 parallel increments, got %s", v)
+	}
+}
+
+func TestShardedCacheAppend(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+
+	if n, err := cache.Append("log", "hello"); err != nil || n != 5 {
+		t.Fatalf("expected Append to create the key with length 5, got %d, %v", n, err)
+	}
+	if n, err := cache.Append("log", " world"); err != nil || n != 11 {
+		t.Fatalf("expected length 11, got %d, %v", n, err)
+	}
+	if v, _ := cache.Get("log"); v != "hello world" {
+		t.Fatalf("expected 'hello world', got %q", v)
+	}
+	if got, want := cache.MemoryUsage(), int64(len("log")+len("hello world"))+entryOverhead; got != want {
+		t.Fatalf("expected memory usage %d, got %d", want, got)
+	}
+}
+
+func TestShardedCacheAppendRespectsMaxBytes(t *testing.T) {
+	budget := int64(2 * (entryOverhead + 2))
+	cache := NewShardedCache(WithShardCount(1), WithMaxBytes(budget))
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+
+	cache.Append("b", "grown")
+	if cache.Exists("a") {
+		t.Fatal("expected growth from Append to evict the least recently used key")
+	}
+	if got := cache.MemoryUsage(); got > budget {
+		t.Fatalf("expected memory usage within %d, got %d", budget, got)
+	}
+}
+
+func TestShardedCacheGetDelRace(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	cache.Set("token", "secret")
+
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.GetDel("token")
+			if err == nil {
+				if v != "secret" {
+					t.Errorf("expected 'secret', got %q", v)
+				}
+				wins.Add(1)
+			} else if !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("expected key not found error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := wins.Load(); n != 1 {
+		t.Fatalf("expected exactly one GetDel to succeed, got %d", n)
+	}
+	if cache.Exists("token") {
+		t.Fatal("expected token to be removed")
+	}
+}
+
+// keyPair returns two keys that hash to the same shard if same is true, or to
+// different shards otherwise.
+func keyPair(sc *ShardedCache, same bool) (string, string) {
+	first := "staging"
+	for i := 0; ; i++ {
+		second := fmt.Sprintf("live-%d", i)
+		if (sc.shardIndex(first) == sc.shardIndex(second)) == same {
+			return first, second
+		}
+	}
+}
+
+func TestShardedCacheRename(t *testing.T) {
+	for _, same := range []bool{true, false} {
+		cache := NewShardedCache(WithShardCount(8))
+		oldKey, newKey := keyPair(cache, same)
+
+		cache.SetWithTTL(oldKey, "warm", 30*time.Millisecond)
+		cache.Set(newKey, "stale")
+
+		if err := cache.Rename(oldKey, newKey); err != nil {
+			t.Fatalf("same shard=%v: unexpected error: %v", same, err)
+		}
+		if cache.Exists(oldKey) {
+			t.Fatalf("same shard=%v: expected old key to be removed", same)
+		}
+		if v, err := cache.Get(newKey); err != nil || v != "warm" {
+			t.Fatalf("same shard=%v: expected new key to hold 'warm', got %q, %v", same, v, err)
+		}
+		if n := cache.Len(); n != 1 {
+			t.Fatalf("same shard=%v: expected 1 entry after rename, got %d", same, n)
+		}
+
+		// The TTL moves with the entry.
+		time.Sleep(50 * time.Millisecond)
+		if cache.Exists(newKey) {
+			t.Fatalf("same shard=%v: expected renamed key to keep its TTL", same)
+		}
+	}
+}
+
+func TestShardedCacheRenameMissing(t *testing.T) {
+	cache := NewShardedCache()
+	if err := cache.Rename("missing", "other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound renaming a missing key, got %v", err)
+	}
+}
+
+func TestShardedCacheRenameConcurrent(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8))
+	a, b := keyPair(cache, false)
+	cache.Set(a, "v")
+
+	// Renaming back and forth in opposite directions must not deadlock.
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			from, to := a, b
+			if w == 1 {
+				from, to = b, a
+			}
+			for i := 0; i < 1000; i++ {
+				cache.Rename(from, to)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("expected exactly one entry after concurrent renames, got %d", n)
+	}
+}
+
+func TestShardedCachePeekDoesNotPromote(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+	cache.Set("cold", "1")
+	cache.Set("warm", "2")
+
+	if v, err := cache.Peek("cold"); err != nil || v != "1" {
+		t.Fatalf("expected Peek to return '1', got %q, %v", v, err)
+	}
+	if _, err := cache.Peek("missing"); err == nil {
+		t.Fatal("expected Peek on a missing key to return an error")
+	}
+
+	cache.Set("new", "3")
+	if cache.Exists("cold") {
+		t.Fatal("expected Peek not to save the cold key from eviction")
+	}
+}
+
+func TestShardedCacheTouchPromotes(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+	cache.Set("cold", "1")
+	cache.Set("warm", "2")
+
+	if !cache.Touch("cold") {
+		t.Fatal("expected Touch to report an existing key")
+	}
+	if cache.Touch("missing") {
+		t.Fatal("expected Touch to report a missing key")
+	}
+
+	cache.Set("new", "3")
+	if !cache.Exists("cold") {
+		t.Fatal("expected touched key to survive eviction")
+	}
+	if cache.Exists("warm") {
+		t.Fatal("expected untouched key to be evicted instead")
+	}
+}
+
+func TestShardedCacheBytesRoundTrip(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	value := []byte{0x00, 0xff, '\n', 'a', 0x00}
+	cache.SetBytes("blob", value)
+
+	got, err := cache.GetBytes("blob")
+	if err != nil {
+		t.Fatalf("expected key 'blob' to exist, got error: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("expected %v, got %v", value, got)
+	}
+	if s, _ := cache.Get("blob"); s != string(value) {
+		t.Fatalf("expected string view %q, got %q", value, s)
+	}
+
+	cache.Set("text", "hello")
+	if b, _ := cache.GetBytes("text"); string(b) != "hello" {
+		t.Fatalf("expected bytes view of a string value, got %q", b)
+	}
+}
+
+func TestShardedCacheCopyOnRead(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	cache.SetBytes("blob", []byte("original"))
+
+	got, _ := cache.GetBytes("blob")
+	copy(got, "XXXXXXXX")
+	_ = append(got, "more"...)
+
+	if again, _ := cache.GetBytes("blob"); string(again) != "original" {
+		t.Fatalf("expected cache to be protected from caller mutation, got %q", again)
+	}
+}
+
+func TestShardedCacheNoCopyOnRead(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithCopyOnRead(false))
+	cache.SetBytes("blob", []byte("original"))
+
+	first, _ := cache.GetBytes("blob")
+	second, _ := cache.GetBytes("blob")
+	if &first[0] != &second[0] {
+		t.Fatal("expected GetBytes to return the internal slice without copying")
+	}
+
+	// Appending to the returned slice must not write into the cache's storage.
+	cache.Append("blob", "!")
+	_ = append(first, '?')
+	if v, _ := cache.Get("blob"); v != "original!" {
+		t.Fatalf("expected 'original!', got %q", v)
+	}
+}
+
+func TestShardedCacheMaxValueBytes(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithMaxValueBytes(5))
+	if got := cache.MaxValueBytes(); got != 5 {
+		t.Fatalf("expected a limit of 5, got %d", got)
+	}
+
+	if err := cache.SetE("ok", "12345"); err != nil {
+		t.Fatalf("expected value at the limit to be stored, got %v", err)
+	}
+	if err := cache.SetE("big", "123456"); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if err := cache.SetWithTTLE("big", "123456", time.Minute); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge from SetWithTTLE, got %v", err)
+	}
+	cache.Set("big", "123456")
+	if cache.Exists("big") {
+		t.Fatal("expected oversized value to be dropped by Set")
+	}
+	if cache.SetNX("big", "123456") {
+		t.Fatal("expected SetNX to reject an oversized value")
+	}
+	if _, err := cache.CompareAndSwap("ok", "12345", "123456"); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge from CompareAndSwap, got %v", err)
+	}
+}
+
+func TestShardedCacheAppendMaxValueBytes(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4), WithMaxValueBytes(5))
+	cache.Set("k", "abc")
+
+	if n, err := cache.Append("k", "de"); err != nil || n != 5 {
+		t.Fatalf("expected append up to the limit to succeed, got %d, %v", n, err)
+	}
+	if n, err := cache.Append("k", "f"); !errors.Is(err, ErrValueTooLarge) || n != 5 {
+		t.Fatalf("expected ErrValueTooLarge with length 5, got %d, %v", n, err)
+	}
+	if v, _ := cache.Get("k"); v != "abcde" {
+		t.Fatalf("expected value to be unchanged, got %q", v)
+	}
+	if _, err := cache.Append("new", "toolong"); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge for a new key, got %v", err)
+	}
+	if cache.Exists("new") {
+		t.Fatal("expected rejected append not to create the key")
+	}
+}
+
+func TestShardedCacheMissReturnsErrKeyNotFound(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	cache.SetWithTTL("expired", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	for _, key := range []string{"missing", "expired"} {
+		if _, err := cache.Get(key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Get(%q): expected ErrKeyNotFound, got %v", key, err)
+		}
+		if _, err := cache.GetBytes(key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("GetBytes(%q): expected ErrKeyNotFound, got %v", key, err)
+		}
+		if _, err := cache.GetDel(key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("GetDel(%q): expected ErrKeyNotFound, got %v", key, err)
+		}
+	}
+}
+
+func BenchmarkConcurrentReads(b *testing.B) {
+	const readers = 32
+	keys := benchmarkKeys(1024)
+	for _, bc := range []struct {
+		name      string
+		readHeavy bool
+	}{
+		{"mutex", false},
+		{"read-heavy", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := NewShardedCache(WithShardCapacity(0), WithReadHeavy(bc.readHeavy))
+			for _, key := range keys {
+				cache.Set(key, "value")
+			}
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					for i := r; i < b.N; i += readers {
+						cache.Get(keys[i%len(keys)])
+					}
+				}(r)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestShardedCacheWriteThrough(t *testing.T) {
+	backend := map[string]string{}
+	var cache *ShardedCache
+	cache = NewShardedCache(
+		WithShardCount(1),
+		WithWriteThrough(func(key, value string) error {
+			// The hook runs before the insert and without the shard lock,
+			// so reading the cache here sees the previous value.
+			if cache.Exists(key) {
+				t.Errorf("expected backend write before %q reached the cache", key)
+			}
+			backend[key] = value
+			return nil
+		}),
+		WithDeleteThrough(func(key string) error {
+			if !cache.Exists(key) {
+				t.Errorf("expected backend delete before %q left the cache", key)
+			}
+			delete(backend, key)
+			return nil
+		}),
+	)
+
+	if err := cache.SetE("k", "v"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if backend["k"] != "v" {
+		t.Fatalf("expected backend to hold 'v', got %q", backend["k"])
+	}
+	if err := cache.DeleteE("k"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := backend["k"]; ok || cache.Exists("k") {
+		t.Fatal("expected key to be removed from both backend and cache")
+	}
+}
+
+func TestShardedCacheWriteThroughFailure(t *testing.T) {
+	failure := errors.New("backend down")
+	fail := false
+	cache := NewShardedCache(
+		WithWriteThrough(func(key, value string) error {
+			if fail {
+				return failure
+			}
+			return nil
+		}),
+		WithDeleteThrough(func(key string) error { return failure }),
+	)
+	cache.Set("k", "old")
+
+	fail = true
+	if err := cache.SetE("k", "new"); !errors.Is(err, failure) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	cache.Set("k", "new")
+	if v, _ := cache.Get("k"); v != "old" {
+		t.Fatalf("expected failed write to leave 'old', got %q", v)
+	}
+	if err := cache.DeleteE("k"); !errors.Is(err, failure) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if !cache.Exists("k") {
+		t.Fatal("expected failed delete to keep the key")
+	}
+}
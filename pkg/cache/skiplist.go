@@ -0,0 +1,171 @@
+package cache
+
+import "math/rand/v2"
+
+// skiplistMaxLevel bounds the height of a skiplist node, which is plenty for
+// 4^32 members at skiplistP.
+const skiplistMaxLevel = 32
+
+// skiplistP is the probability that a node reaching one level also reaches
+// the next.
+const skiplistP = 0.25
+
+// skiplist orders the members of a sorted set by score, then by member. Each
+// link records how many nodes it skips, so ranks are found in O(log n) too.
+type skiplist struct {
+	head   *znode // Sentinel holding skiplistMaxLevel links.
+	tail   *znode
+	length int
+	level  int // Number of levels in use.
+}
+
+type znode struct {
+	member string
+	score  float64
+	prev   *znode // Previous node on level 0, or nil for the first.
+	levels []zlevel
+}
+
+type zlevel struct {
+	next *znode
+	span int // Nodes between this one and next on level 0, counting next.
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{head: &znode{levels: make([]zlevel, skiplistMaxLevel)}, level: 1}
+}
+
+// before reports whether n sorts before score and member.
+func (n *znode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// insert adds member with score, which must not already be in the list.
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*znode
+	var rank [skiplistMaxLevel]int
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+			sl.head.levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+	n := &znode{member: member, score: score, levels: make([]zlevel, level)}
+	for i := 0; i < level; i++ {
+		n.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = n
+		n.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+	if update[0] != sl.head {
+		n.prev = update[0]
+	}
+	if next := n.levels[0].next; next != nil {
+		next.prev = n
+	} else {
+		sl.tail = n
+	}
+	sl.length++
+}
+
+// delete removes member with score, reporting whether it was present.
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*znode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+	n := x.levels[0].next
+	if n == nil || n.score != score || n.member != member {
+		return false
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].next == n {
+			update[i].levels[i].span += n.levels[i].span - 1
+			update[i].levels[i].next = n.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if next := n.levels[0].next; next != nil {
+		next.prev = n.prev
+	} else {
+		sl.tail = n.prev
+	}
+	for sl.level > 1 && sl.head.levels[sl.level-1].next == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// rank returns the 0-based position of member with score, which must be in
+// the list.
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.levels[i].next; next != nil && (next.before(score, member) || next.member == member); next = x.levels[i].next {
+			rank += x.levels[i].span
+			x = next
+		}
+		if x != sl.head && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the node at 0-based position rank, or nil if there is none.
+func (sl *skiplist) byRank(rank int) *znode {
+	traversed, x := 0, sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstAtLeast returns the first node scoring at least min, or nil if there
+// is none.
+func (sl *skiplist) firstAtLeast(min float64) *znode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.score < min {
+			x = x.levels[i].next
+		}
+	}
+	return x.levels[0].next
+}
@@ -0,0 +1,87 @@
+package cache
+
+import "sync/atomic"
+
+// Stats is a point-in-time snapshot of cache activity.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Sets      uint64
+	Deletes   uint64
+	Evictions uint64
+	StaleHits uint64 // Hits served from an expired entry; included in Hits.
+	Items     int
+
+	// BytesSaved is how many bytes compression saves across the stored
+	// values. See WithCompression.
+	BytesSaved int64
+}
+
+// ShardStats is a point-in-time snapshot of a single shard's activity.
+type ShardStats struct {
+	Items     int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// shardStats holds a shard's activity counters. They are updated atomically so
+// Stats can read them without taking the shard lock.
+type shardStats struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	sets      atomic.Uint64
+	deletes   atomic.Uint64
+	evictions atomic.Uint64
+	staleHits atomic.Uint64
+}
+
+// reset zeroes all counters.
+func (st *shardStats) reset() {
+	st.hits.Store(0)
+	st.misses.Store(0)
+	st.sets.Store(0)
+	st.deletes.Store(0)
+	st.evictions.Store(0)
+	st.staleHits.Store(0)
+}
+
+// Stats returns a snapshot of the cache's counters summed over all shards.
+// Counters are read independently, so the snapshot is not atomic.
+func (sc *ShardedCache) Stats() Stats {
+	var st Stats
+	for _, shard := range sc.shards {
+		st.Hits += shard.stats.hits.Load()
+		st.Misses += shard.stats.misses.Load()
+		st.Sets += shard.stats.sets.Load()
+		st.Deletes += shard.stats.deletes.Load()
+		st.Evictions += shard.stats.evictions.Load()
+		st.StaleHits += shard.stats.staleHits.Load()
+		st.Items += shard.len()
+		st.BytesSaved += shard.bytesSaved()
+	}
+	return st
+}
+
+// ShardStats returns a snapshot of each shard's counters, indexed by shard.
+// It is useful for spotting keys that concentrate on a few shards.
+func (sc *ShardedCache) ShardStats() []ShardStats {
+	stats := make([]ShardStats, len(sc.shards))
+	for i, shard := range sc.shards {
+		stats[i] = ShardStats{
+			Items:     shard.len(),
+			Hits:      shard.stats.hits.Load(),
+			Misses:    shard.stats.misses.Load(),
+			Evictions: shard.stats.evictions.Load(),
+		}
+	}
+	return stats
+}
+
+// ResetStats zeroes the hit, miss, set, delete, eviction, and stale hit counters.
+// The item count is unaffected.
+func (sc *ShardedCache) ResetStats() {
+	for _, shard := range sc.shards {
+		shard.stats.reset()
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import "testing"
+
+func TestShardedCacheStats(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(1), WithShardCapacity(2))
+
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Get("a")
+	cache.Get("missing")
+	cache.Set("c", "3") // evicts "b"
+	cache.Delete("c")
+	cache.Delete("missing")
+
+	want := Stats{Hits: 1, Misses: 1, Sets: 3, Deletes: 1, Evictions: 1, Items: 1}
+	if got := cache.Stats(); got != want {
+		t.Fatalf("expected stats %+v, got %+v", want, got)
+	}
+}
+
+func TestShardedCacheResetStats(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(4))
+	cache.Set("a", "1")
+	cache.Get("a")
+	cache.Get("missing")
+
+	cache.ResetStats()
+
+	want := Stats{Items: 1}
+	if got := cache.Stats(); got != want {
+		t.Fatalf("expected stats %+v after reset, got %+v", want, got)
+	}
+}
+
+func TestShardedCacheShardStats(t *testing.T) {
+	cache := NewShardedCache(WithShardCount(8))
+	keys := []string{"alpha", "beta", "gamma", "delta"}
+	for _, key := range keys {
+		cache.Set(key, "v")
+		cache.Get(key)
+	}
+	cache.Get("missing")
+
+	want := make([]ShardStats, 8)
+	for _, key := range append(keys, "missing") {
+		idx := cache.shardIndex(key)
+		if key == "missing" {
+			want[idx].Misses++
+			continue
+		}
+		want[idx].Items++
+		want[idx].Hits++
+	}
+
+	got := cache.ShardStats()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d shard stats, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("shard %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
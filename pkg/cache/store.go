@@ -0,0 +1,32 @@
+package cache
+
+import "time"
+
+// Store is the key-value interface shared by Cache and ShardedCache, so code
+// that only stores, fetches, and expires string values can run on either.
+//
+// SetWithTTL and SetNXWithTTL treat ttl alike on both: DefaultExpiration
+// (zero) applies the store's default TTL, which a Cache never has and a
+// ShardedCache has only with WithDefaultTTL, and NoExpiration stores the value
+// without expiration. Len may count expired entries not yet removed.
+// MaxValueBytes is the largest value the store accepts, zero meaning any size.
+type Store interface {
+	Set(key, value string)
+	SetWithTTL(key, value string, ttl time.Duration)
+	SetNX(key, value string) bool
+	SetNXWithTTL(key, value string, ttl time.Duration) bool
+	Get(key string) (string, error)
+	GetDel(key string) (string, error)
+	Expire(key string, ttl time.Duration) bool
+	Exists(key string) bool
+	Delete(key string)
+	Keys() []string
+	Clear()
+	Len() int
+	MaxValueBytes() int
+}
+
+var (
+	_ Store = (*Cache)(nil)
+	_ Store = (*ShardedCache)(nil)
+)
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// stores returns one of each Store implementation, closed when the test ends.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	sc := NewShardedCache()
+	t.Cleanup(sc.Close)
+	return map[string]Store{"Cache": NewCache(), "ShardedCache": sc}
+}
+
+func TestStore(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.Set("a", "1")
+			if got, err := s.Get("a"); err != nil || got != "1" {
+				t.Fatalf("expected a=1, got %q, %v", got, err)
+			}
+			if s.SetNX("a", "2") || !s.SetNX("b", "2") {
+				t.Fatal("expected SetNX to store only the absent key")
+			}
+			if !s.Exists("b") || s.Len() != 2 {
+				t.Fatalf("expected 2 keys, got %d", s.Len())
+			}
+			if keys := s.Keys(); !slices.Equal(slices.Sorted(slices.Values(keys)), []string{"a", "b"}) {
+				t.Fatalf("expected keys a and b, got %v", keys)
+			}
+			if got, err := s.GetDel("b"); err != nil || got != "2" || s.Exists("b") {
+				t.Fatalf("expected GetDel to return and remove b, got %q, %v", got, err)
+			}
+			s.Delete("a")
+			if _, err := s.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("expected ErrKeyNotFound after Delete, got %v", err)
+			}
+
+			s.SetWithTTL("short", "v", 20*time.Millisecond)
+			s.Set("kept", "v")
+			if !s.Expire("kept", 20*time.Millisecond) || !s.Expire("kept", 0) || s.Expire("missing", time.Second) {
+				t.Fatal("expected Expire to report whether the key exists")
+			}
+			time.Sleep(40 * time.Millisecond)
+			if s.Exists("short") {
+				t.Fatal("expected the entry to expire")
+			}
+			if !s.Exists("kept") {
+				t.Fatal("expected Expire with zero to remove the expiration")
+			}
+			s.Clear()
+			if s.Exists("kept") || s.Len() != 0 {
+				t.Fatalf("expected Clear to remove every key, got %d", s.Len())
+			}
+		})
+	}
+}
+
+// Without a default TTL, both stores keep a value set with DefaultExpiration
+// or NoExpiration.
+func TestStoreZeroTTL(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s.SetWithTTL("default", "v", DefaultExpiration)
+			s.SetWithTTL("never", "v", NoExpiration)
+			if !s.SetNXWithTTL("nx", "v", DefaultExpiration) {
+				t.Fatal("expected SetNXWithTTL to store the absent key")
+			}
+			time.Sleep(time.Millisecond)
+			for _, key := range []string{"default", "never", "nx"} {
+				if !s.Exists(key) {
+					t.Fatalf("expected %s not to expire", key)
+				}
+			}
+		})
+	}
+}
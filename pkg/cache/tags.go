@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"container/list"
+	"slices"
+)
+
+// SetWithTags is like Set but also attaches tags to the entry, so that
+// InvalidateTag can remove it together with every other entry sharing the tag.
+// Writes that replace the entry, such as Set, drop its tags; in-place updates
+// such as Append, Increment, and CompareAndSwap keep them. Tags count towards
+// the entry's size for WithMaxBytes.
+func (sc *ShardedCache) SetWithTags(key, value string, tags ...string) {
+	sc.setWithTTL(key, []byte(value), DefaultExpiration, slices.Clone(tags))
+}
+
+// SetWithTagsE is like SetWithTags but returns ErrValueTooLarge instead of
+// silently dropping a value over the WithMaxValueBytes limit.
+func (sc *ShardedCache) SetWithTagsE(key, value string, tags ...string) error {
+	return sc.setWithTTL(key, []byte(value), DefaultExpiration, slices.Clone(tags))
+}
+
+// InvalidateTag removes every entry carrying tag, locking one shard at a time,
+// and returns the number of entries removed. Each shard indexes the tags of its
+// own entries under its lock, so entries that are deleted, evicted, or expire
+// leave the index as they leave the shard. Like DeleteByPrefix, it is not
+// atomic across shards.
+func (sc *ShardedCache) InvalidateTag(tag string) int {
+	return len(sc.InvalidateTagKeys(tag))
+}
+
+// InvalidateTagKeys is like InvalidateTag but returns the removed keys, for
+// callers that log each deletion.
+func (sc *ShardedCache) InvalidateTagKeys(tag string) []string {
+	var removed []string
+	for _, shard := range sc.shards {
+		removed = shard.invalidateTag(removed, tag)
+	}
+	return removed
+}
+
+// invalidateTag removes the shard's entries carrying tag, appending their keys
+// to dst.
+func (s *Shard) invalidateTag(dst []string, tag string) []string {
+	s.mu.Lock()
+	defer s.unlock()
+
+	for key := range s.tags[tag] {
+		// removeElement drops key from s.tags[tag], which is safe mid-range.
+		s.removeElement(s.data[key])
+		s.stats.deletes.Add(1)
+		dst = append(dst, key)
+	}
+	return dst
+}
+
+// tagLocked replaces the tags of the entry held by elem and returns the entries
+// evicted because it grew. The caller must hold the shard lock.
+func (s *Shard) tagLocked(elem *list.Element, tags []string) []entry {
+	ent := elem.Value.(*entry)
+	s.bytes -= ent.size()
+	s.untagLocked(ent)
+	ent.tags = tags
+	s.indexTagsLocked(ent)
+	s.bytes += ent.size()
+	return s.evictOverflow(elem)
+}
+
+// indexTagsLocked adds ent's key to the index of each of its tags. The caller
+// must hold the shard lock.
+func (s *Shard) indexTagsLocked(ent *entry) {
+	if len(ent.tags) == 0 {
+		return
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range ent.tags {
+		keys := s.tags[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[ent.key] = struct{}{}
+	}
+}
+
+// untagLocked removes ent's key from the index of each of its tags, dropping
+// tags left without keys. The caller must hold the shard lock.
+func (s *Shard) untagLocked(ent *entry) {
+	for _, tag := range ent.tags {
+		keys := s.tags[tag]
+		delete(keys, ent.key)
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// taggedKeys returns the number of key references held by the tag indexes.
+func taggedKeys(c *ShardedCache) int {
+	n := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for _, keys := range shard.tags {
+			n += len(keys)
+		}
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+func TestInvalidateTag(t *testing.T) {
+	c := NewShardedCache(WithShardCount(4))
+	for i := 0; i < 20; i++ {
+		c.SetWithTags(fmt.Sprintf("product:%d", i), "v", "catalog", fmt.Sprintf("product-%d", i))
+	}
+	c.SetWithTags("home", "v", "catalog", "pages")
+	c.Set("untagged", "v")
+
+	if n := c.InvalidateTag("product-3"); n != 1 || c.Exists("product:3") {
+		t.Fatalf("expected product:3 alone to be removed, got %d", n)
+	}
+	if keys := c.InvalidateTagKeys("pages"); len(keys) != 1 || keys[0] != "home" {
+		t.Fatalf("expected home to be removed, got %v", keys)
+	}
+	if n := c.InvalidateTag("catalog"); n != 19 {
+		t.Fatalf("expected the remaining 19 catalog entries removed, got %d", n)
+	}
+	if c.Len() != 1 || !c.Exists("untagged") {
+		t.Fatalf("expected only the untagged key to survive, got %d keys", c.Len())
+	}
+	if n := c.InvalidateTag("catalog"); n != 0 {
+		t.Fatalf("expected a second invalidation to remove nothing, got %d", n)
+	}
+	if n := taggedKeys(c); n != 0 {
+		t.Fatalf("expected an empty tag index, got %d references", n)
+	}
+}
+
+func TestTagsDroppedWithEntry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := NewShardedCache(WithShardCount(1), WithShardCapacity(2), WithClock(clock.Now), WithDefaultTTL(time.Minute))
+
+	c.SetWithTags("a", "v", "t")
+	c.SetWithTags("b", "v", "t")
+	c.SetWithTags("c", "v", "t") // Evicts a.
+	if c.Exists("a") || taggedKeys(c) != 2 {
+		t.Fatalf("expected the evicted key to leave the index, got %d references", taggedKeys(c))
+	}
+
+	c.Set("b", "plain")
+	if n := c.InvalidateTag("t"); n != 1 || !c.Exists("b") {
+		t.Fatalf("expected a plain Set to drop b's tags, got %d removed", n)
+	}
+
+	c.SetWithTags("d", "v", "t")
+	c.Delete("d")
+	c.SetWithTags("e", "v", "t")
+	c.Rename("e", "f")
+	if n := taggedKeys(c); n != 1 {
+		t.Fatalf("expected only the renamed key in the index, got %d references", n)
+	}
+	clock.Advance(2 * time.Minute)
+	if removed := c.DeleteExpired(); removed == 0 || taggedKeys(c) != 0 {
+		t.Fatalf("expected expired keys to leave the index, got %d references", taggedKeys(c))
+	}
+
+	c.SetWithTags("g", "v", "t")
+	c.Clear()
+	if n := taggedKeys(c); n != 0 {
+		t.Fatalf("expected Clear to empty the index, got %d references", n)
+	}
+}
+
+func TestTagsKeepInPlaceUpdates(t *testing.T) {
+	c := NewShardedCache()
+	c.SetWithTags("counter", "1", "stats")
+	c.Increment("counter", 1)
+	c.Append("counter", "0")
+	if n := c.InvalidateTag("stats"); n != 1 {
+		t.Fatalf("expected in-place updates to keep the tag, got %d removed", n)
+	}
+}
+
+func TestTagsCountTowardsMaxBytes(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1))
+	c.Set("k", "v")
+	plain := c.MemoryUsage()
+	c.SetWithTags("k", "v", "tag")
+	if got := c.MemoryUsage(); got != plain+int64(len("tag")) {
+		t.Fatalf("expected tags to add %d bytes to %d, got %d", len("tag"), plain, got)
+	}
+	c.Delete("k")
+	if got := c.MemoryUsage(); got != 0 {
+		t.Fatalf("expected no memory in use after delete, got %d", got)
+	}
+}
+
+func TestSetWithTagsE(t *testing.T) {
+	c := NewShardedCache(WithMaxValueBytes(2))
+	if err := c.SetWithTagsE("k", "too large", "t"); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if c.Exists("k") || taggedKeys(c) != 0 {
+		t.Fatal("expected nothing stored or indexed")
+	}
+}
+
+func TestTagsConcurrent(t *testing.T) {
+	c := NewShardedCache(WithShardCount(4), WithShardCapacity(8))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("k%d", i%50)
+				switch (i + w) % 4 {
+				case 0:
+					c.SetWithTags(key, "v", "a", "b")
+				case 1:
+					c.InvalidateTag("a")
+				case 2:
+					c.Rename(key, fmt.Sprintf("k%d", (i+1)%50))
+				default:
+					c.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	c.InvalidateTag("a")
+	if n := taggedKeys(c); n != 0 {
+		t.Fatalf("expected an empty index after invalidating every tag, got %d references", n)
+	}
+}
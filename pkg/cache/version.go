@@ -0,0 +1,60 @@
+package cache
+
+// GetWithVersion is like GetFlagged but returns the key's version instead of
+// its flags. Every write to a key, whatever its kind, gives it a version
+// greater than any it had before, so a caller can tell whether the key was
+// written since it last looked; see Version.
+func (sc *ShardedCache) GetWithVersion(key string) (string, uint64, error) {
+	value, version, ok := sc.getShard(key).getWithVersion(key)
+	if !ok {
+		return "", 0, ErrKeyNotFound
+	}
+	return string(value), version, nil
+}
+
+// Version returns the version of key's unexpired entry, of any kind, or zero
+// if there is none. It does not affect the entry's LRU position or access
+// statistics.
+func (sc *ShardedCache) Version(key string) uint64 {
+	return sc.getShard(key).versionOf(key)
+}
+
+// bump gives ent the shard's next version after a write. The caller must hold
+// the shard lock.
+func (s *Shard) bump(ent *entry) {
+	s.version++
+	ent.version = s.version
+}
+
+// getWithVersion returns key's unexpired value and version, promoting the
+// entry and counting a hit or miss. Keys holding values other than strings
+// are missing.
+func (s *Shard) getWithVersion(key string) ([]byte, uint64, bool) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, ok := s.live(key, now.UnixNano())
+	if !ok || elem.Value.(*entry).kind != kindString {
+		s.stats.misses.Add(1)
+		return nil, 0, false
+	}
+	ent := elem.Value.(*entry)
+	if s.slidingTTL && ent.ttl > 0 {
+		ent.expiresAt = now.Add(ent.ttl).UnixNano()
+	}
+	s.hit(elem)
+	return ent.plain(), ent.version, true
+}
+
+// versionOf returns the version of key's unexpired entry, or zero.
+func (s *Shard) versionOf(key string) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.data[key]
+	if !ok || elem.Value.(*entry).expired(s.clock().UnixNano()) {
+		return 0
+	}
+	return elem.Value.(*entry).version
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetWithVersion(t *testing.T) {
+	c := NewShardedCache()
+	if _, _, err := c.GetWithVersion("k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	c.Set("k", "1")
+	v, first, err := c.GetWithVersion("k")
+	if err != nil || v != "1" || first == 0 {
+		t.Fatalf("expected 1 with a version, got %q, %d, %v", v, first, err)
+	}
+	if _, again, _ := c.GetWithVersion("k"); again != first {
+		t.Fatalf("expected a read to keep version %d, got %d", first, again)
+	}
+	if _, err := c.Increment("k", 1); err != nil {
+		t.Fatal(err)
+	}
+	v, second, _ := c.GetWithVersion("k")
+	if v != "2" || second <= first {
+		t.Fatalf("expected 2 with a version past %d, got %q, %d", first, v, second)
+	}
+
+	// Deleting and recreating a key never brings back an old version.
+	c.Delete("k")
+	if got := c.Version("k"); got != 0 {
+		t.Fatalf("expected a deleted key to have version 0, got %d", got)
+	}
+	c.Set("k", "2")
+	if _, third, _ := c.GetWithVersion("k"); third <= second {
+		t.Fatalf("expected a version past %d, got %d", second, third)
+	}
+}
+
+func TestVersionBumps(t *testing.T) {
+	c := NewShardedCache()
+	for _, tc := range []struct {
+		name  string
+		write func()
+	}{
+		{"Set", func() { c.Set("k", "v") }},
+		{"Append", func() { c.Append("k", "x") }},
+		{"CompareAndSwap", func() { c.CompareAndSwap("k", "vx", "w") }},
+		{"Expire", func() { c.Expire("k", time.Minute) }},
+		{"SetBit", func() { c.SetBit("k", 0, true) }},
+		{"HSet", func() { c.Delete("k"); c.HSet("k", "f", "v") }},
+		{"HDel", func() { c.HSet("k", "g", "v"); c.HDel("k", "f") }},
+		{"HIncrBy", func() { c.HIncrBy("k", "n", 1) }},
+	} {
+		before := c.Version("k")
+		tc.write()
+		if after := c.Version("k"); after <= before {
+			t.Fatalf("%s: expected the version to move past %d, got %d", tc.name, before, after)
+		}
+	}
+
+	c.HGetAll("k")
+	before := c.Version("k")
+	c.HGet("k", "g")
+	if after := c.Version("k"); after != before {
+		t.Fatalf("expected reads to keep version %d, got %d", before, after)
+	}
+	if _, _, err := c.GetWithVersion("k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected a hash to be missing for GetWithVersion, got %v", err)
+	}
+}
+
+func TestVersionExpired(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := NewShardedCache(WithClock(clock.Now))
+	c.SetWithTTL("k", "v", time.Second)
+	if c.Version("k") == 0 {
+		t.Fatal("expected a version for a live key")
+	}
+	clock.Advance(2 * time.Second)
+	if got := c.Version("k"); got != 0 {
+		t.Fatalf("expected an expired key to have version 0, got %d", got)
+	}
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Write-behind defaults and retry tuning.
+const (
+	defaultWriteBehindBatch    = 100
+	defaultWriteBehindInterval = time.Second
+	writeBehindQueueFactor     = 4 // Queue capacity in batches.
+	writeBehindRetries         = 5
+	writeBehindBackoff         = 10 * time.Millisecond
+)
+
+// KV is a key-value pair handed to a write-behind flush callback.
+type KV struct {
+	Key   string
+	Value string
+}
+
+// writeBehind queues writes for asynchronous delivery to a backing store.
+type writeBehind struct {
+	flush     func(batch []KV) error
+	batchSize int
+	interval  time.Duration
+	queue     chan KV
+	pending   atomic.Int64
+	dropped   atomic.Int64
+
+	// mu orders writes against Close: enqueue holds it for reading until its
+	// write is queued, and Close takes it to set closed, so every write is
+	// either queued before the final drain or dropped.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// WithWriteBehind stores values in the cache immediately and delivers them to
+// flush in the background, in batches of up to batchSize or every interval,
+// whichever comes first. Within a batch only the last write to each key is
+// kept. A failing batch is retried with exponential backoff and dropped after
+// a few attempts; DroppedWrites counts the writes lost that way. Close flushes
+// everything queued before it returns. Like
+// WithWriteThrough, only Set, SetE, SetWithTTL, and SetBytes are queued.
+func WithWriteBehind(flush func(batch []KV) error, batchSize int, interval time.Duration) Option {
+	return func(sc *ShardedCache) {
+		if flush == nil {
+			return
+		}
+		if batchSize <= 0 {
+			batchSize = defaultWriteBehindBatch
+		}
+		if interval <= 0 {
+			interval = defaultWriteBehindInterval
+		}
+		sc.writeBehind = &writeBehind{
+			flush:     flush,
+			batchSize: batchSize,
+			interval:  interval,
+			queue:     make(chan KV, writeBehindQueueFactor*batchSize),
+		}
+	}
+}
+
+// PendingWrites returns the number of write-behind writes that have not been
+// flushed yet, or zero if write-behind is not configured.
+func (sc *ShardedCache) PendingWrites() int {
+	if sc.writeBehind == nil {
+		return 0
+	}
+	return int(sc.writeBehind.pending.Load())
+}
+
+// DroppedWrites returns the number of write-behind writes that were never
+// delivered, because their batch failed every attempt or they were made after
+// Close, or zero if write-behind is not configured.
+func (sc *ShardedCache) DroppedWrites() int {
+	if sc.writeBehind == nil {
+		return 0
+	}
+	return int(sc.writeBehind.dropped.Load())
+}
+
+// enqueue hands a write to the flusher, blocking while the queue is full.
+// Writes made after Close are dropped.
+func (sc *ShardedCache) enqueue(key, value string) {
+	w := sc.writeBehind
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Add(1)
+		return
+	}
+	w.pending.Add(1)
+	w.queue <- KV{Key: key, Value: value}
+}
+
+// close stops enqueue from queueing writes, once those in progress are
+// queued.
+func (w *writeBehind) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+}
+
+// runWriteBehind batches queued writes until the cache is closed, then drains
+// the queue.
+func (sc *ShardedCache) runWriteBehind() {
+	defer sc.wg.Done()
+	w := sc.writeBehind
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]KV, 0, w.batchSize)
+	for {
+		select {
+		case kv := <-w.queue:
+			batch = append(batch, kv)
+			if len(batch) >= w.batchSize {
+				w.deliver(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.deliver(batch)
+				batch = batch[:0]
+			}
+		case <-sc.stop:
+			for {
+				select {
+				case kv := <-w.queue:
+					batch = append(batch, kv)
+					if len(batch) >= w.batchSize {
+						w.deliver(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						w.deliver(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver flushes batch, keeping only the last write per key, and retries
+// failures with exponential backoff. A batch that fails every attempt is
+// counted in dropped.
+func (w *writeBehind) deliver(batch []KV) {
+	defer w.pending.Add(-int64(len(batch)))
+	writes := lastWrites(batch)
+	backoff := writeBehindBackoff
+	for attempt := 0; attempt < writeBehindRetries; attempt++ {
+		if w.flush(writes) == nil {
+			return
+		}
+		if attempt < writeBehindRetries-1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	w.dropped.Add(int64(len(batch)))
+}
+
+// lastWrites returns the last write to each key in batch, ordered by the
+// position of that write.
+func lastWrites(batch []KV) []KV {
+	seen := make(map[string]struct{}, len(batch))
+	writes := make([]KV, 0, len(batch))
+	for i := len(batch) - 1; i >= 0; i-- {
+		if _, ok := seen[batch[i].Key]; ok {
+			continue
+		}
+		seen[batch[i].Key] = struct{}{}
+		writes = append(writes, batch[i])
+	}
+	for i, j := 0, len(writes)-1; i < j; i, j = i+1, j-1 {
+		writes[i], writes[j] = writes[j], writes[i]
+	}
+	return writes
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects write-behind batches.
+type recorder struct {
+	mu      sync.Mutex
+	batches [][]KV
+}
+
+func (r *recorder) flush(batch []KV) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, batch)
+	return nil
+}
+
+func (r *recorder) writes() []KV {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []KV
+	for _, b := range r.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func TestWriteBehindLastWriteWins(t *testing.T) {
+	var rec recorder
+	cache := NewShardedCache(WithWriteBehind(rec.flush, 10, time.Hour))
+	cache.Set("a", "1")
+	cache.Set("b", "1")
+	cache.Set("a", "2")
+	cache.Set("a", "3")
+
+	if v, _ := cache.Get("a"); v != "3" {
+		t.Fatalf("expected cache to be updated immediately, got %q", v)
+	}
+	cache.Close()
+
+	got := rec.writes()
+	want := []KV{{"b", "1"}, {"a", "3"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWriteBehindBatchesAndDrainsOnClose(t *testing.T) {
+	var rec recorder
+	cache := NewShardedCache(WithWriteBehind(rec.flush, 5, time.Hour))
+	for i := 0; i < 23; i++ {
+		cache.Set(strconv.Itoa(i), "v")
+	}
+	cache.Close()
+
+	if n := len(rec.writes()); n != 23 {
+		t.Fatalf("expected all 23 writes to be flushed on Close, got %d", n)
+	}
+	for _, b := range rec.batches {
+		if len(b) > 5 {
+			t.Fatalf("expected batches of at most 5, got %d", len(b))
+		}
+	}
+	if n := cache.PendingWrites(); n != 0 {
+		t.Fatalf("expected no pending writes after Close, got %d", n)
+	}
+}
+
+func TestWriteBehindFlushesOnInterval(t *testing.T) {
+	var rec recorder
+	cache := NewShardedCache(WithWriteBehind(rec.flush, 100, 10*time.Millisecond))
+	defer cache.Close()
+
+	cache.Set("k", "v")
+	deadline := time.Now().Add(time.Second)
+	for len(rec.writes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the interval to trigger a flush")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := cache.PendingWrites(); n != 0 {
+		t.Fatalf("expected no pending writes after a flush, got %d", n)
+	}
+}
+
+func TestWriteBehindRetriesFailedBatches(t *testing.T) {
+	var rec recorder
+	attempts := 0
+	cache := NewShardedCache(WithWriteBehind(func(batch []KV) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("backend down")
+		}
+		return rec.flush(batch)
+	}, 10, time.Hour))
+	cache.Set("k", "v")
+	cache.Close()
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+	if got := rec.writes(); len(got) != 1 || got[0] != (KV{"k", "v"}) {
+		t.Fatalf("expected the retried write to be delivered, got %v", got)
+	}
+}
+
+func TestWriteBehindCountsDroppedBatches(t *testing.T) {
+	cache := NewShardedCache(WithWriteBehind(func([]KV) error {
+		return errors.New("backend down")
+	}, 10, time.Hour))
+	cache.Set("a", "1")
+	cache.Set("b", "2")
+	cache.Close()
+
+	if got := cache.DroppedWrites(); got != 2 {
+		t.Fatalf("expected 2 dropped writes, got %d", got)
+	}
+	if got := cache.PendingWrites(); got != 0 {
+		t.Fatalf("expected nothing pending, got %d", got)
+	}
+}
+
+func TestWriteBehindCloseRacesWrites(t *testing.T) {
+	var rec recorder
+	cache := NewShardedCache(WithWriteBehind(rec.flush, 4, time.Hour))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.Set(strconv.Itoa(i)+"-"+strconv.Itoa(j), "v")
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	cache.Close()
+	wg.Wait()
+
+	// Every write was either delivered before Close returned or dropped.
+	if got := len(rec.writes()) + cache.DroppedWrites(); got != 800 {
+		t.Fatalf("expected 800 writes delivered or dropped, got %d", got)
+	}
+	if got := cache.PendingWrites(); got != 0 {
+		t.Fatalf("expected nothing pending, got %d", got)
+	}
+}
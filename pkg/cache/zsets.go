@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"math"
+	"time"
+)
+
+// zmemberOverhead approximates the per-member bookkeeping cost of a sorted set
+// in bytes: its map slot, skiplist node, and an average number of links. The
+// member string itself is shared by the two.
+const zmemberOverhead = 96
+
+// zmemberSize returns the approximate size of one sorted set member in bytes.
+func zmemberSize(member string) int64 {
+	return int64(len(member)) + zmemberOverhead
+}
+
+// ZMember is a member of a sorted set along with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// zset is a sorted set: scores maps each member to its score, and sl orders
+// the members by score, breaking ties by member so that ranges are
+// deterministic.
+type zset struct {
+	scores map[string]float64
+	sl     *skiplist
+}
+
+func newZSet() *zset {
+	return &zset{scores: make(map[string]float64), sl: newSkiplist()}
+}
+
+// ZAdd sets the score of each of members in the sorted set stored at key,
+// creating the set with the default TTL if key is missing, and returns how
+// many members are new. Updating a member's score repositions it. The whole
+// set is one entry for LRU and capacity purposes. It returns ErrWrongType if
+// key holds another kind of value, ErrInvalidScore for a NaN score, and
+// ErrValueTooLarge if a member exceeds the WithMaxValueBytes limit; any of
+// these leaves the set unchanged. Like HSet, sorted set writes do not call the
+// write-through function.
+func (sc *ShardedCache) ZAdd(key string, members ...ZMember) (int, error) {
+	for _, m := range members {
+		if math.IsNaN(m.Score) {
+			return 0, ErrInvalidScore
+		}
+		if sc.tooLarge([]byte(m.Member)) {
+			return 0, ErrValueTooLarge
+		}
+	}
+	added, evicted, err := sc.getShard(key).zadd(key, members, sc.resolveTTL(DefaultExpiration))
+	sc.notifyEvicted(evicted)
+	return added, err
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many
+// were present. Removing the last member deletes the key.
+func (sc *ShardedCache) ZRem(key string, members ...string) (int, error) {
+	return sc.getShard(key).zrem(key, members)
+}
+
+// ZScore returns the score of member in the sorted set stored at key. It
+// returns ErrKeyNotFound if the key or the member is missing.
+func (sc *ShardedCache) ZScore(key, member string) (float64, error) {
+	var score float64
+	var ok bool
+	err := sc.getShard(key).viewZSet(key, func(z *zset) {
+		if z != nil {
+			score, ok = z.scores[member]
+		}
+	})
+	if err == nil && !ok {
+		err = ErrKeyNotFound
+	}
+	return score, err
+}
+
+// ZRank returns the 0-based position of member in the sorted set stored at
+// key, ordered from the lowest score. It returns ErrKeyNotFound if the key or
+// the member is missing.
+func (sc *ShardedCache) ZRank(key, member string) (int, error) {
+	rank := -1
+	err := sc.getShard(key).viewZSet(key, func(z *zset) {
+		if z == nil {
+			return
+		}
+		if score, ok := z.scores[member]; ok {
+			rank = z.sl.rank(score, member)
+		}
+	})
+	if err == nil && rank < 0 {
+		err = ErrKeyNotFound
+	}
+	return rank, err
+}
+
+// ZCard returns the number of members in the sorted set stored at key, or 0 if
+// key is missing.
+func (sc *ShardedCache) ZCard(key string) (int, error) {
+	var n int
+	err := sc.getShard(key).viewZSet(key, func(z *zset) {
+		if z != nil {
+			n = len(z.scores)
+		}
+	})
+	return n, err
+}
+
+// ZRange returns the members of the sorted set stored at key, with their
+// scores, from rank start to rank stop inclusive, ordered from the lowest
+// score. Indexes follow LRange: negative ones count back from the end. A
+// missing key yields an empty slice.
+func (sc *ShardedCache) ZRange(key string, start, stop int) ([]ZMember, error) {
+	members := []ZMember{}
+	err := sc.getShard(key).viewZSet(key, func(z *zset) {
+		if z == nil {
+			return
+		}
+		from, to := listRange(start, stop, z.sl.length)
+		if from == to {
+			return
+		}
+		members = make([]ZMember, 0, to-from)
+		for n := z.sl.byRank(from); len(members) < to-from; n = n.levels[0].next {
+			members = append(members, ZMember{n.member, n.score})
+		}
+	})
+	return members, err
+}
+
+// ZRangeByScore returns the members of the sorted set stored at key scoring
+// between min and max inclusive, with their scores, ordered from the lowest
+// score. Use math.Inf for an open-ended range.
+func (sc *ShardedCache) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	members := []ZMember{}
+	err := sc.getShard(key).viewZSet(key, func(z *zset) {
+		if z == nil {
+			return
+		}
+		for n := z.sl.firstAtLeast(min); n != nil && n.score <= max; n = n.levels[0].next {
+			members = append(members, ZMember{n.member, n.score})
+		}
+	})
+	return members, err
+}
+
+// zadd sets the scores of members in the sorted set at key and returns how
+// many are new.
+func (s *Shard) zadd(key string, members []ZMember, ttl time.Duration) (int, []entry, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, evicted, err := s.objectFor(key, kindZSet, ttl, func() any { return newZSet() })
+	if err != nil {
+		return 0, nil, err
+	}
+	z := elem.Value.(*entry).object.(*zset)
+	added := 0
+	var delta int64
+	for _, m := range members {
+		old, ok := z.scores[m.Member]
+		if ok && old == m.Score {
+			continue
+		}
+		if ok {
+			z.sl.delete(old, m.Member)
+		} else {
+			delta += zmemberSize(m.Member)
+			added++
+		}
+		z.scores[m.Member] = m.Score
+		z.sl.insert(m.Score, m.Member)
+	}
+	s.stats.sets.Add(1)
+	return added, append(evicted, s.resizeObject(elem, delta)...), nil
+}
+
+// zrem removes members from the sorted set at key, deleting the key once the
+// set is empty.
+func (s *Shard) zrem(key string, members []string) (int, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	elem, err := s.liveObject(key, kindZSet, s.clock().UnixNano())
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	z := elem.Value.(*entry).object.(*zset)
+	removed := 0
+	var delta int64
+	for _, member := range members {
+		if score, ok := z.scores[member]; ok {
+			delete(z.scores, member)
+			z.sl.delete(score, member)
+			delta -= zmemberSize(member)
+			removed++
+		}
+	}
+	if len(z.scores) == 0 {
+		s.removeElement(elem)
+		s.stats.deletes.Add(1)
+		return removed, nil
+	}
+	s.resizeObject(elem, delta) // Shrinking never evicts.
+	return removed, nil
+}
+
+// viewZSet is viewSet for sorted sets.
+func (s *Shard) viewZSet(key string, fn func(z *zset)) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.clock()
+	elem, err := s.liveObject(key, kindZSet, now.UnixNano())
+	if err == ErrKeyNotFound {
+		s.stats.misses.Add(1)
+		fn(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.readObject(elem, now)
+	fn(elem.Value.(*entry).object.(*zset))
+	return nil
+}
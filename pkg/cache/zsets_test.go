@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSortedSetOperations(t *testing.T) {
+	c := NewShardedCache()
+	n, err := c.ZAdd("board", ZMember{"ada", 30}, ZMember{"bob", 10}, ZMember{"cy", 20})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 new members, got %d, %v", n, err)
+	}
+	if score, err := c.ZScore("board", "cy"); err != nil || score != 20 {
+		t.Fatalf("expected 20, got %v, %v", score, err)
+	}
+	if rank, err := c.ZRank("board", "ada"); err != nil || rank != 2 {
+		t.Fatalf("expected rank 2, got %d, %v", rank, err)
+	}
+	if _, err := c.ZRank("board", "dee"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound for a missing member, got %v", err)
+	}
+
+	// Raising bob's score moves him to the top.
+	if n, _ := c.ZAdd("board", ZMember{"bob", 40}); n != 0 {
+		t.Fatalf("expected an update not to count as new, got %d", n)
+	}
+	want := []ZMember{{"cy", 20}, {"ada", 30}, {"bob", 40}}
+	if got, _ := c.ZRange("board", 0, -1); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, _ := c.ZRange("board", -2, -1); !slices.Equal(got, want[1:]) {
+		t.Fatalf("expected %v, got %v", want[1:], got)
+	}
+	if got, _ := c.ZRangeByScore("board", 25, math.Inf(1)); !slices.Equal(got, want[1:]) {
+		t.Fatalf("expected %v, got %v", want[1:], got)
+	}
+	if got, err := c.ZRange("missing", 0, -1); err != nil || got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty slice for a missing key, got %v, %v", got, err)
+	}
+
+	if n, _ := c.ZRem("board", "ada", "dee"); n != 1 {
+		t.Fatalf("expected one member removed, got %d", n)
+	}
+	if n, _ := c.ZCard("board"); n != 2 {
+		t.Fatalf("expected 2 members, got %d", n)
+	}
+	c.ZRem("board", "bob", "cy")
+	if c.Exists("board") {
+		t.Fatal("expected removing the last member to delete the key")
+	}
+}
+
+func TestSortedSetTiesOrderByMember(t *testing.T) {
+	c := NewShardedCache()
+	c.ZAdd("z", ZMember{"c", 1}, ZMember{"a", 1}, ZMember{"b", 1}, ZMember{"z", 0})
+	got, _ := c.ZRange("z", 0, -1)
+	want := []ZMember{{"z", 0}, {"a", 1}, {"b", 1}, {"c", 1}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if rank, _ := c.ZRank("z", "b"); rank != 2 {
+		t.Fatalf("expected b at rank 2, got %d", rank)
+	}
+}
+
+func TestSortedSetErrors(t *testing.T) {
+	c := NewShardedCache(WithMaxValueBytes(4))
+	c.Set("str", "v")
+	if _, err := c.ZAdd("str", ZMember{"m", 1}); err != ErrWrongType {
+		t.Fatalf("expected ZAdd on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.ZRange("str", 0, -1); err != ErrWrongType {
+		t.Fatalf("expected ZRange on a string to fail with ErrWrongType, got %v", err)
+	}
+	if _, err := c.ZAdd("z", ZMember{"m", math.NaN()}); err != ErrInvalidScore {
+		t.Fatalf("expected ErrInvalidScore, got %v", err)
+	}
+	if _, err := c.ZAdd("z", ZMember{"m", 1}, ZMember{"too large", 2}); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if c.Exists("z") {
+		t.Fatal("expected a rejected ZAdd to store nothing")
+	}
+	c.ZAdd("z", ZMember{"m", 1})
+	if _, err := c.SCard("z"); err != ErrWrongType {
+		t.Fatalf("expected SCard on a sorted set to fail with ErrWrongType, got %v", err)
+	}
+}
+
+func TestSortedSetMemoryUsage(t *testing.T) {
+	c := NewShardedCache(WithShardCount(1))
+	c.ZAdd("z", ZMember{"a", 1})
+	one := c.MemoryUsage()
+	c.ZAdd("z", ZMember{"a", 2}, ZMember{"bc", 3})
+	if got := c.MemoryUsage(); got != one+zmemberSize("bc") {
+		t.Fatalf("expected only the new member to add bytes, got %d from %d", got, one)
+	}
+	c.ZRem("z", "a", "bc")
+	if got := c.MemoryUsage(); got != 0 {
+		t.Fatalf("expected no memory in use after the set is gone, got %d", got)
+	}
+}
+
+// TestSkiplistMatchesSortedSlice checks the skiplist against a sorted slice
+// through a random mix of inserts, score updates, and deletes.
+func TestSkiplistMatchesSortedSlice(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	z := newZSet()
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprint(r.IntN(500))
+		old, ok := z.scores[member]
+		if ok {
+			z.sl.delete(old, member)
+			delete(z.scores, member)
+		}
+		if r.IntN(3) > 0 {
+			score := float64(r.IntN(50))
+			z.scores[member] = score
+			z.sl.insert(score, member)
+		}
+	}
+
+	want := make([]ZMember, 0, len(z.scores))
+	for member, score := range z.scores {
+		want = append(want, ZMember{member, score})
+	}
+	slices.SortFunc(want, func(a, b ZMember) int {
+		return cmp.Or(cmp.Compare(a.Score, b.Score), strings.Compare(a.Member, b.Member))
+	})
+	if z.sl.length != len(want) {
+		t.Fatalf("expected %d nodes, got %d", len(want), z.sl.length)
+	}
+	for i, m := range want {
+		n := z.sl.byRank(i)
+		if n == nil || n.member != m.Member || n.score != m.Score {
+			t.Fatalf("rank %d: expected %v, got %+v", i, m, n)
+		}
+		if rank := z.sl.rank(m.Score, m.Member); rank != i {
+			t.Fatalf("%s: expected rank %d, got %d", m.Member, i, rank)
+		}
+	}
+	if z.sl.byRank(len(want)) != nil {
+		t.Fatal("expected no node past the end")
+	}
+	if len(want) > 0 && (z.sl.tail.member != want[len(want)-1].Member || z.sl.head.levels[0].next.prev != nil) {
+		t.Fatal("expected the tail and back links to match")
+	}
+}
+
+func BenchmarkZAdd(b *testing.B) {
+	const size = 100_000
+	c := NewShardedCache()
+	for i := 0; i < size; i++ {
+		c.ZAdd("board", ZMember{fmt.Sprint(i), float64(i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.ZAdd("board", ZMember{fmt.Sprint(i % size), float64(size - i)})
+	}
+}
+
+func BenchmarkZRank(b *testing.B) {
+	const size = 100_000
+	c := NewShardedCache()
+	for i := 0; i < size; i++ {
+		c.ZAdd("board", ZMember{fmt.Sprint(i), float64(i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.ZRank("board", fmt.Sprint(i%size))
+	}
+}
+
+func BenchmarkZRangeByScore(b *testing.B) {
+	const size = 100_000
+	c := NewShardedCache()
+	for i := 0; i < size; i++ {
+		c.ZAdd("board", ZMember{fmt.Sprint(i), float64(i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lo := float64(i % (size - 100))
+		c.ZRangeByScore("board", lo, lo+99)
+	}
+}
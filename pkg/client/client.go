@@ -0,0 +1,523 @@
+// Package client is a Go client for the cache server's line protocol, the
+// default -protocol of its TCP listener.
+//
+//	c := client.New("localhost:8080", client.WithPassword("secret"))
+//	defer c.Close()
+//	if err := c.Set(ctx, "greeting", "hello", time.Minute); err != nil {
+//		...
+//	}
+//	v, err := c.Get(ctx, "greeting")
+//	if errors.Is(err, client.ErrKeyNotFound) {
+//		...
+//	}
+//
+// Values are sent as data blocks, so they may hold any bytes. Keys may not
+// contain whitespace, which separates a command's arguments.
+//
+// A Ring shards keys across several servers, with a Client for each.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Errors returned by the client.
+var (
+	// ErrKeyNotFound is returned by Get for a key that is not set.
+	ErrKeyNotFound = errors.New("client: key not found")
+	// ErrInvalidKey is returned for a key the line protocol cannot carry.
+	ErrInvalidKey = errors.New("client: key must be non-empty and contain no whitespace")
+	// ErrClosed is returned by calls on a closed Client.
+	ErrClosed = errors.New("client: closed")
+)
+
+// ServerError is an error reply from the server, other than one with its own
+// error value such as ErrKeyNotFound.
+type ServerError struct {
+	Message string // The reply without its "ERROR: " prefix.
+}
+
+func (e *ServerError) Error() string { return "client: server error: " + e.Message }
+
+// serverError maps an error reply to the client's errors.
+func serverError(message string) error {
+	if message == "key not found" {
+		return ErrKeyNotFound
+	}
+	return &ServerError{Message: message}
+}
+
+type options struct {
+	user        string
+	password    string
+	tls         *tls.Config
+	poolSize    int
+	minIdle     int
+	dialTimeout time.Duration
+	retries     int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	addrs       []string
+	vnodes      int
+	healthCheck time.Duration
+	rehash      bool
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithPassword authenticates every connection with AUTH password, as the
+// server's default user.
+func WithPassword(password string) Option {
+	return func(o *options) {
+		o.user, o.password = "", password
+	}
+}
+
+// WithUser authenticates every connection with AUTH user password, for a
+// server with a -users-file.
+func WithUser(user, password string) Option {
+	return func(o *options) {
+		o.user, o.password = user, password
+	}
+}
+
+// WithTLS connects over TLS with config. If config has no ServerName, the
+// host in the server address is verified.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tls = config
+	}
+}
+
+// WithPoolSize caps the client's open connections at n, 10 by default. Calls
+// wait for a free connection once n are checked out.
+func WithPoolSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.poolSize = n
+		}
+	}
+}
+
+// WithMinIdle keeps at least n connections open, dialing them in the
+// background from New on, so calls need not wait for a dial.
+func WithMinIdle(n int) Option {
+	return func(o *options) {
+		o.minIdle = n
+	}
+}
+
+// WithDialTimeout caps how long dialing and authenticating a connection may
+// take, 5s by default.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.dialTimeout = d
+		}
+	}
+}
+
+// Client is a pool of connections to a cache server. It is safe for
+// concurrent use: each call checks out a connection for one request and reply
+// exchange and then returns it. Connections are dialed as calls need them, and
+// a connection that breaks is discarded.
+type Client struct {
+	addrs   []string     // The server address, then any WithAddresses.
+	current atomic.Int64 // Index in addrs of the address to dial.
+	opts    options
+	pool    *pool
+}
+
+// New returns a client for the server at addr. Unless WithMinIdle is given,
+// it does not connect until the first call.
+func New(addr string, opts ...Option) *Client {
+	c := &Client{opts: options{
+		poolSize:    defaultPoolSize,
+		dialTimeout: defaultDialTimeout,
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+	}}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	c.addrs = append([]string{addr}, c.opts.addrs...)
+	c.pool = newPool(c.dial, c.opts.poolSize, c.opts.minIdle)
+	return c
+}
+
+// dial connects to the current address within the dial timeout, failing over
+// to the next address if it cannot.
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.dialTimeout)
+	defer cancel()
+	addr := c.address()
+	cn, err := dial(ctx, addr, &c.opts)
+	if err != nil && !isReply(err) {
+		c.failover(addr)
+	}
+	return cn, err
+}
+
+// Close closes the client's connections. Later calls return ErrClosed.
+func (c *Client) Close() error {
+	return c.pool.close()
+}
+
+// Ping checks that the server is answering.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, true, func(cn *conn) error {
+		cn.writeCommand("PING")
+		return cn.expect("PONG")
+	})
+}
+
+// Get returns the value of key, or ErrKeyNotFound.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	var value string
+	err := c.do(ctx, true, func(cn *conn) error {
+		cn.writeCommand("GET", key)
+		var err error
+		value, err = cn.readValue()
+		return err
+	})
+	return value, err
+}
+
+// Set stores value under key, expiring after ttl if it is positive. TTLs are
+// rounded up to whole milliseconds.
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return c.do(ctx, false, func(cn *conn) error {
+		cn.writeSet(key, value, ttl)
+		return cn.expect("OK")
+	})
+}
+
+// Delete removes key. Deleting a key that is not set is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return c.do(ctx, false, func(cn *conn) error {
+		cn.writeCommand("DEL", key)
+		return cn.expect("OK")
+	})
+}
+
+// Exists returns how many of keys are set.
+func (c *Client) Exists(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			return 0, err
+		}
+	}
+	var n int64
+	err := c.do(ctx, true, func(cn *conn) error {
+		cn.writeCommand(append([]string{"EXISTS"}, keys...)...)
+		var err error
+		n, err = cn.readInt()
+		return err
+	})
+	return int(n), err
+}
+
+// TTL returns how long key has left before it expires, rounded up to whole
+// milliseconds, or 0 if it never expires. It returns ErrKeyNotFound for a key
+// that is not set.
+func (c *Client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	var ms int64
+	err := c.do(ctx, true, func(cn *conn) error {
+		cn.writeCommand("PTTL", key)
+		var err error
+		ms, err = cn.readInt()
+		return err
+	})
+	switch {
+	case err != nil:
+		return 0, err
+	case ms == -2:
+		return 0, ErrKeyNotFound
+	case ms < 0:
+		return 0, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Incr adds one to the integer stored under key, which is taken to be 0 if it
+// is not set, and returns the result.
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := c.do(ctx, false, func(cn *conn) error {
+		cn.writeCommand("INCR", key)
+		var err error
+		n, err = cn.readInt()
+		return err
+	})
+	return n, err
+}
+
+// Append appends suffix to the value of key, setting it if it is not set, and
+// returns the value's new length.
+func (c *Client) Append(ctx context.Context, key, suffix string) (int, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := c.do(ctx, false, func(cn *conn) error {
+		cn.writeData(suffix, "APPEND", key)
+		var err error
+		n, err = cn.readInt()
+		return err
+	})
+	return int(n), err
+}
+
+// do runs one request and reply exchange on a pooled connection within ctx,
+// retrying it after a network error as WithRetries allows. An exchange that
+// is not idempotent is only retried if its request was never written.
+func (c *Client) do(ctx context.Context, idempotent bool, exchange func(*conn) error) error {
+	for attempt := 0; ; attempt++ {
+		written, err := c.try(ctx, exchange)
+		if err == nil || attempt >= c.opts.retries || (written && !idempotent) || !retryable(ctx, err) {
+			return err
+		}
+		if err := c.backoff(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// try runs exchange once, reporting whether the request may have been
+// written. A connection left out of sync by a failure is discarded, and the
+// client fails over from its address.
+func (c *Client) try(ctx context.Context, exchange func(*conn) error) (written bool, err error) {
+	if err := ctxErr(ctx); err != nil {
+		return false, err
+	}
+	cn, err := c.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	err = cn.run(ctx, exchange)
+	if err != nil && !isReply(err) {
+		c.pool.discard(cn)
+		c.failover(cn.addr)
+		if ctxErr := ctxErr(ctx); ctxErr != nil {
+			return true, ctxErr
+		}
+		return true, err
+	}
+	c.pool.put(cn)
+	return true, err
+}
+
+// get checks out a pooled connection to the current address, discarding
+// connections to addresses the client has failed over from.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	for {
+		cn, err := c.pool.get(ctx)
+		if err != nil || cn.addr == c.address() {
+			return cn, err
+		}
+		c.pool.discard(cn)
+	}
+}
+
+// ctxErr returns ctx's error, or context.DeadlineExceeded once its deadline
+// has passed: the socket deadline can expire before ctx's timer fires.
+func ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// isReply reports whether err came from a well-formed error reply, which
+// leaves the connection usable.
+func isReply(err error) bool {
+	var serverErr *ServerError
+	return errors.Is(err, ErrKeyNotFound) || errors.As(err, &serverErr)
+}
+
+// checkKey rejects keys the line protocol would split or misread.
+func checkKey(key string) error {
+	if key == "" || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// conn is a connection to the server.
+type conn struct {
+	addr string
+	nc   net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// dial connects to addr and authenticates, within ctx.
+func dial(ctx context.Context, addr string, opts *options) (*conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.tls != nil {
+		config := opts.tls
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(nc, config)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	cn := &conn{addr: addr, nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if opts.password != "" {
+		err := cn.run(ctx, func(cn *conn) error {
+			if opts.user != "" {
+				cn.writeCommand("AUTH", opts.user, opts.password)
+			} else {
+				cn.writeCommand("AUTH", opts.password)
+			}
+			return cn.expect("OK")
+		})
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("client: AUTH: %w", err)
+		}
+	}
+	return cn, nil
+}
+
+// run writes a request and reads its reply with exchange, flushing the
+// request before the first read. It gives up at ctx's deadline or when ctx is
+// canceled.
+func (cn *conn) run(ctx context.Context, exchange func(*conn) error) error {
+	deadline, _ := ctx.Deadline() // The zero time, for no deadline.
+	cn.nc.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { cn.nc.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	return exchange(cn)
+}
+
+// writeCommand buffers a command line.
+func (cn *conn) writeCommand(args ...string) {
+	cn.w.WriteString(strings.Join(args, " "))
+	cn.w.WriteString("\r\n")
+}
+
+// writeData buffers a command whose last argument is value, sent as a data
+// block.
+func (cn *conn) writeData(value string, args ...string) {
+	cn.writeCommand(append(args, "$"+strconv.Itoa(len(value)))...)
+	cn.w.WriteString(value)
+	cn.w.WriteString("\r\n")
+}
+
+// writeSet buffers a SET of key, or a PSETEX if ttl is positive.
+func (cn *conn) writeSet(key, value string, ttl time.Duration) {
+	if ttl > 0 {
+		ms := (ttl + time.Millisecond - 1) / time.Millisecond
+		cn.writeData(value, "PSETEX", key, strconv.FormatInt(int64(ms), 10))
+	} else {
+		cn.writeData(value, "SET", key)
+	}
+}
+
+// readLine flushes the buffered commands and reads a reply line without its
+// terminator. An error reply is returned as an error.
+func (cn *conn) readLine() (string, error) {
+	if cn.w.Buffered() > 0 {
+		if err := cn.w.Flush(); err != nil {
+			return "", err
+		}
+	}
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if message, ok := strings.CutPrefix(line, "ERROR: "); ok {
+		return "", serverError(message)
+	}
+	return line, nil
+}
+
+// expect reads a reply line that must be want.
+func (cn *conn) expect(want string) error {
+	line, err := cn.readLine()
+	if err != nil {
+		return err
+	}
+	if line != want {
+		return fmt.Errorf("client: expected %q, got %q", want, line)
+	}
+	return nil
+}
+
+// readInt reads an integer reply.
+func (cn *conn) readInt() (int64, error) {
+	line, err := cn.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("client: expected an integer, got %q", line)
+	}
+	return n, nil
+}
+
+// readValue reads a "$<nbytes>" header and the value that follows it.
+func (cn *conn) readValue() (string, error) {
+	header, err := cn.readLine()
+	if err != nil {
+		return "", err
+	}
+	digits, ok := strings.CutPrefix(header, "$")
+	n, err := strconv.Atoi(digits)
+	if !ok || err != nil || n < 0 {
+		return "", fmt.Errorf("client: expected a value, got %q", header)
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(cn.r, data); err != nil {
+		return "", err
+	}
+	if string(data[n:]) != "\r\n" {
+		return "", errors.New("client: value is not followed by CRLF")
+	}
+	return string(data[:n]), nil
+}
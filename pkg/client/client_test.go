@@ -0,0 +1,323 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverBin is the cache server, built once by TestMain.
+var serverBin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "client-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	serverBin = filepath.Join(dir, "server")
+	build := exec.Command("go", "build", "-o", serverBin, "github.com/vlkhvnn/inmemcache/cmd/server")
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building the server: %v\n%s", err, out)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// freeAddr returns a loopback address with a port nothing is listening on.
+func freeAddr(t testing.TB) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer runs the server with args on a free port until the test ends
+// and returns its address once it accepts connections.
+func startServer(t testing.TB, args ...string) string {
+	t.Helper()
+	return startServerAt(t, freeAddr(t), args...)
+}
+
+// startServerAt runs the server with args on addr, as startServer does.
+func startServerAt(t testing.TB, addr string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(serverBin, append([]string{"-tcp", addr, "-metrics", freeAddr(t)}, args...)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newClient returns a client for addr, closed when the test ends.
+func newClient(t testing.TB, addr string, opts ...Option) *Client {
+	t.Helper()
+	c := New(addr, opts...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestGetSetDelete(t *testing.T) {
+	c := newClient(t, startServer(t))
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "greeting", "hello world", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "greeting"); err != nil || v != "hello world" {
+		t.Fatalf("expected hello world, got %q, %v", v, err)
+	}
+	// Values are framed, so they may hold anything.
+	binary := "line one\r\nERROR: not really\n$5\x00"
+	if err := c.Set(ctx, "binary", binary, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "binary"); err != nil || v != binary {
+		t.Fatalf("expected the binary value back, got %q, %v", v, err)
+	}
+	if err := c.Set(ctx, "empty", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "empty"); err != nil || v != "" {
+		t.Fatalf("expected an empty value, got %q, %v", v, err)
+	}
+
+	if err := c.Delete(ctx, "greeting"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "greeting"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound after Delete, got %v", err)
+	}
+	// The connection is still in sync after an error reply.
+	if v, err := c.Get(ctx, "binary"); err != nil || v != binary {
+		t.Fatalf("expected the binary value after a miss, got %q, %v", v, err)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	c := newClient(t, startServer(t))
+	ctx := context.Background()
+
+	if n, err := c.Incr(ctx, "n"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d, %v", n, err)
+	}
+	if n, err := c.Incr(ctx, "n"); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d, %v", n, err)
+	}
+	if n, err := c.Append(ctx, "s", "a b"); err != nil || n != 3 {
+		t.Fatalf("expected 3, got %d, %v", n, err)
+	}
+	if n, err := c.Append(ctx, "s", "\r\n"); err != nil || n != 5 {
+		t.Fatalf("expected 5, got %d, %v", n, err)
+	}
+	if v, _ := c.Get(ctx, "s"); v != "a b\r\n" {
+		t.Fatalf("expected the suffixes appended verbatim, got %q", v)
+	}
+	if n, err := c.Exists(ctx, "n", "s", "missing"); err != nil || n != 2 {
+		t.Fatalf("expected 2 keys to exist, got %d, %v", n, err)
+	}
+	if _, err := c.Incr(ctx, "s"); err == nil {
+		t.Fatal("expected INCR of a non-integer to fail")
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	c := newClient(t, startServer(t))
+	ctx := context.Background()
+	if err := c.Set(ctx, "k", "v", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "k"); err != nil || v != "v" {
+		t.Fatalf("expected v before the TTL, got %q, %v", v, err)
+	}
+	if ttl, err := c.TTL(ctx, "k"); err != nil || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Fatalf("expected up to 50ms left, got %v, %v", ttl, err)
+	}
+	c.Set(ctx, "forever", "v", 0)
+	if ttl, err := c.TTL(ctx, "forever"); err != nil || ttl != 0 {
+		t.Fatalf("expected no TTL, got %v, %v", ttl, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.TTL(ctx, "k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected TTL of an expired key to return ErrKeyNotFound, got %v", err)
+	}
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected the key to expire, got %v", err)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	addr := startServer(t, "-max-value-bytes", "4")
+	c := newClient(t, addr)
+	ctx := context.Background()
+	var serverErr *ServerError
+	if err := c.Set(ctx, "k", "too long", 0); !errors.As(err, &serverErr) || serverErr.Message != "value too large" {
+		t.Fatalf("expected a value too large error, got %v", err)
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("expected the connection to survive the error, got %v", err)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	c := newClient(t, "127.0.0.1:1") // Never dialed.
+	for _, key := range []string{"", "two words", "line\nbreak"} {
+		if _, err := c.Get(context.Background(), key); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("%q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	addr := startServer(t, "-auth", "-password", "hunter2")
+	ctx := context.Background()
+
+	var serverErr *ServerError
+	if err := newClient(t, addr).Ping(ctx); err != nil {
+		t.Fatalf("expected PING to work before AUTH, got %v", err)
+	}
+	if _, err := newClient(t, addr).Get(ctx, "k"); !errors.As(err, &serverErr) || !strings.HasPrefix(serverErr.Message, "Authentication required") {
+		t.Fatalf("expected authentication to be required, got %v", err)
+	}
+	if err := newClient(t, addr, WithPassword("wrong")).Ping(ctx); err == nil || !strings.Contains(err.Error(), "AUTH") {
+		t.Fatalf("expected a wrong password to fail, got %v", err)
+	}
+	c := newClient(t, addr, WithPassword("hunter2"))
+	if err := c.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatalf("expected an authenticated Set to work, got %v", err)
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 to dir and returns
+// the file paths and a pool trusting it.
+func writeCert(t *testing.T, dir string) (certPath, keyPath string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cache"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ := x509.ParseCertificate(der)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certPath, keyPath, pool
+}
+
+func TestTLS(t *testing.T) {
+	certPath, keyPath, pool := writeCert(t, t.TempDir())
+	addr := startServer(t, "-tls", "-cert", certPath, "-key", keyPath)
+	c := newClient(t, addr, WithTLS(&tls.Config{RootCAs: pool}))
+	ctx := context.Background()
+	if err := c.Set(ctx, "k", "over TLS", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "k"); err != nil || v != "over TLS" {
+		t.Fatalf("expected the value over TLS, got %q, %v", v, err)
+	}
+	if err := newClient(t, addr, WithTLS(&tls.Config{})).Ping(ctx); err == nil {
+		t.Fatal("expected an untrusted certificate to be rejected")
+	}
+}
+
+func TestContext(t *testing.T) {
+	c := newClient(t, startServer(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Ping(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// A server that never replies is given up on at the deadline.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	silent := newClient(t, ln.Addr().String())
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := silent.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	c := New(startServer(t))
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := c.Ping(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
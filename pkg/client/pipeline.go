@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"time"
+)
+
+// Pipeline queues commands to send in one batch, so that many commands cost
+// one round trip:
+//
+//	p := c.Pipeline()
+//	p.Get("a")
+//	p.Set("b", "1", 0)
+//	results, err := p.Exec(ctx)
+//
+// A Pipeline is not safe for concurrent use.
+type Pipeline struct {
+	c    *Client
+	cmds []pipelined
+}
+
+// Result is the outcome of one pipelined command.
+type Result struct {
+	Value string // The value read by Get.
+	Err   error  // The command's error, such as ErrKeyNotFound from Get.
+}
+
+// pipelined is a queued command: how to write it, and how to read its reply.
+// A command with err set is not sent.
+type pipelined struct {
+	write func(*conn)
+	read  func(*conn) (string, error)
+	err   error
+}
+
+// Pipeline returns an empty pipeline that runs on c's connections.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Len returns the number of queued commands.
+func (p *Pipeline) Len() int { return len(p.cmds) }
+
+// Ping queues a PING.
+func (p *Pipeline) Ping() {
+	p.cmds = append(p.cmds, pipelined{
+		write: func(cn *conn) { cn.writeCommand("PING") },
+		read:  expectReply("PONG"),
+	})
+}
+
+// Get queues a read of key. Its result holds the value.
+func (p *Pipeline) Get(key string) {
+	p.cmds = append(p.cmds, pipelined{
+		write: func(cn *conn) { cn.writeCommand("GET", key) },
+		read:  (*conn).readValue,
+		err:   checkKey(key),
+	})
+}
+
+// Set queues storing value under key, as Client.Set does.
+func (p *Pipeline) Set(key, value string, ttl time.Duration) {
+	p.cmds = append(p.cmds, pipelined{
+		write: func(cn *conn) { cn.writeSet(key, value, ttl) },
+		read:  expectReply("OK"),
+		err:   checkKey(key),
+	})
+}
+
+// Delete queues removing key.
+func (p *Pipeline) Delete(key string) {
+	p.cmds = append(p.cmds, pipelined{
+		write: func(cn *conn) { cn.writeCommand("DEL", key) },
+		read:  expectReply("OK"),
+		err:   checkKey(key),
+	})
+}
+
+// Exec sends the queued commands over one connection, flushing once, and
+// returns their results in order. An error reply to one command is its
+// result's Err and does not affect the others. Exec returns an error only if
+// the exchange itself failed, in which case the commands without a reply have
+// that error as their Err: they may or may not have run. The pipeline is
+// empty afterwards.
+func (p *Pipeline) Exec(ctx context.Context) ([]Result, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	results := make([]Result, len(cmds))
+	var sent []int // Indexes of the commands sent, in order.
+	for i, cmd := range cmds {
+		if cmd.err != nil {
+			results[i].Err = cmd.err
+		} else {
+			sent = append(sent, i)
+		}
+	}
+	if len(sent) == 0 {
+		return results, nil
+	}
+
+	read := 0
+	err := p.c.do(ctx, false, func(cn *conn) error {
+		// Write while reading, so a long pipeline cannot deadlock with the
+		// server blocked on sending replies that are not being read. The
+		// writer has its own buffer, leaving cn's for the reader.
+		written := make(chan error, 1)
+		go func() {
+			wc := &conn{nc: cn.nc, w: bufio.NewWriter(cn.nc)}
+			for _, i := range sent {
+				cmds[i].write(wc)
+			}
+			written <- wc.w.Flush()
+		}()
+		for _, i := range sent {
+			value, err := cmds[i].read(cn)
+			if err != nil && !isReply(err) {
+				return err // The connection is discarded, which unblocks the writer.
+			}
+			results[i] = Result{Value: value, Err: err}
+			read++
+		}
+		return <-written
+	})
+	if err != nil {
+		for _, i := range sent[read:] {
+			results[i].Err = err
+		}
+	}
+	return results, err
+}
+
+// expectReply returns a reader for a reply line that must be want.
+func expectReply(want string) func(*conn) (string, error) {
+	return func(cn *conn) (string, error) {
+		return "", cn.expect(want)
+	}
+}
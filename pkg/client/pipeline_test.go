@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	c := newClient(t, startServer(t, "-max-value-bytes", "1024"))
+	ctx := context.Background()
+
+	p := c.Pipeline()
+	p.Set("a", "1", 0)
+	p.Set("b", "line\r\nbreak", time.Minute)
+	p.Set("big", strings.Repeat("x", 2048), 0) // Refused mid-pipeline.
+	p.Set("bad key", "v", 0)                   // Never sent.
+	p.Get("a")
+	p.Get("missing")
+	p.Get("b")
+	p.Delete("a")
+	p.Get("a")
+	p.Ping()
+	if p.Len() != 10 {
+		t.Fatalf("expected 10 queued commands, got %d", p.Len())
+	}
+	results, err := p.Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serverErr *ServerError
+	for i, check := range []func(Result) bool{
+		func(r Result) bool { return r.Err == nil },
+		func(r Result) bool { return r.Err == nil },
+		func(r Result) bool { return errors.As(r.Err, &serverErr) && serverErr.Message == "value too large" },
+		func(r Result) bool { return errors.Is(r.Err, ErrInvalidKey) },
+		func(r Result) bool { return r.Err == nil && r.Value == "1" },
+		func(r Result) bool { return errors.Is(r.Err, ErrKeyNotFound) },
+		func(r Result) bool { return r.Err == nil && r.Value == "line\r\nbreak" },
+		func(r Result) bool { return r.Err == nil },
+		func(r Result) bool { return errors.Is(r.Err, ErrKeyNotFound) },
+		func(r Result) bool { return r.Err == nil },
+	} {
+		if !check(results[i]) {
+			t.Fatalf("command %d: unexpected result %+v", i, results[i])
+		}
+	}
+	if p.Len() != 0 {
+		t.Fatalf("expected Exec to empty the pipeline, %d commands are queued", p.Len())
+	}
+	if results, err := p.Exec(ctx); err != nil || len(results) != 0 {
+		t.Fatalf("expected an empty pipeline to do nothing, got %v, %v", results, err)
+	}
+	// The connection is still in sync for ordinary calls.
+	if v, err := c.Get(ctx, "b"); err != nil || v != "line\r\nbreak" {
+		t.Fatalf("expected b after the pipeline, got %q, %v", v, err)
+	}
+}
+
+func TestPipelineLong(t *testing.T) {
+	c := newClient(t, startServer(t), WithPoolSize(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Far more data than the socket buffers hold in either direction, so
+	// neither side may wait for the other to finish.
+	value := strings.Repeat("v", 1024)
+	p := c.Pipeline()
+	for i := 0; i < 5000; i++ {
+		p.Set(fmt.Sprintf("k%d", i), value, 0)
+		p.Get(fmt.Sprintf("k%d", i))
+	}
+	results, err := p.Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r.Err != nil || (i%2 == 1 && r.Value != value) {
+			t.Fatalf("command %d: unexpected result %v", i, r.Err)
+		}
+	}
+}
+
+func TestPipelineFailure(t *testing.T) {
+	// A server that never replies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c := newClient(t, ln.Addr().String())
+	p := c.Pipeline()
+	p.Get("a")
+	p.Get("bad key")
+	p.Get("b")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results, err := p.Exec(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if !errors.Is(results[0].Err, context.DeadlineExceeded) || !errors.Is(results[1].Err, ErrInvalidKey) || !errors.Is(results[2].Err, context.DeadlineExceeded) {
+		t.Fatalf("expected the unanswered commands to carry the error, got %+v", results)
+	}
+}
+
+// benchmarkGets reads 1000 keys per iteration with get.
+func benchmarkGets(b *testing.B, get func(ctx context.Context, c *Client, keys []string) error) {
+	c := newClient(b, startServer(b))
+	ctx := context.Background()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if err := c.Set(ctx, keys[i], "value", 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := get(ctx, c, keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetSequential(b *testing.B) {
+	benchmarkGets(b, func(ctx context.Context, c *Client, keys []string) error {
+		for _, key := range keys {
+			if _, err := c.Get(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func BenchmarkGetPipelined(b *testing.B) {
+	benchmarkGets(b, func(ctx context.Context, c *Client, keys []string) error {
+		p := c.Pipeline()
+		for _, key := range keys {
+			p.Get(key)
+		}
+		results, err := p.Exec(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				return r.Err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPoolTimeout is returned when every pooled connection stayed checked out
+// until the call's context was done. The error also wraps the context's.
+var ErrPoolTimeout = errors.New("client: timed out waiting for a pooled connection")
+
+// Pool defaults.
+const (
+	defaultPoolSize    = 10
+	defaultDialTimeout = 5 * time.Second
+)
+
+// pool holds up to size connections, dialing them as calls need them and
+// keeping at least minIdle open.
+type pool struct {
+	dial    func(context.Context) (*conn, error)
+	size    int
+	minIdle int
+
+	mu     sync.Mutex
+	idle   []*conn
+	open   int           // Connections idle, checked out, or being dialed.
+	freed  chan struct{} // Closed when a connection is returned or discarded.
+	closed bool
+}
+
+func newPool(dial func(context.Context) (*conn, error), size, minIdle int) *pool {
+	p := &pool{dial: dial, size: size, minIdle: min(minIdle, size), freed: make(chan struct{})}
+	p.mu.Lock()
+	p.fillLocked()
+	p.mu.Unlock()
+	return p
+}
+
+// get checks out an idle connection, or dials one if fewer than size are
+// open. Otherwise it waits for one to be returned until ctx is done.
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if n := len(p.idle); n > 0 {
+			cn := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.mu.Unlock()
+			return cn, nil
+		}
+		if p.open < p.size {
+			p.open++
+			p.mu.Unlock()
+			cn, err := p.dial(ctx)
+			if err != nil {
+				p.mu.Lock()
+				p.open--
+				p.notifyLocked()
+				p.mu.Unlock()
+				return nil, err
+			}
+			return cn, nil
+		}
+		freed := p.freed
+		p.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrPoolTimeout, ctx.Err())
+		}
+	}
+}
+
+// put returns a connection that is still in sync with the server.
+func (p *pool) put(cn *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		cn.nc.Close()
+		p.open--
+		return
+	}
+	p.idle = append(p.idle, cn)
+	p.notifyLocked()
+}
+
+// discard closes a broken connection. A replacement is dialed when a call
+// needs it, or now if that leaves fewer than minIdle open.
+func (p *pool) discard(cn *conn) {
+	cn.nc.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open--
+	p.notifyLocked()
+	p.fillLocked()
+}
+
+// close closes the idle connections, and checked out ones as they come back.
+func (p *pool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	var err error
+	for _, cn := range p.idle {
+		err = errors.Join(err, cn.nc.Close())
+	}
+	p.open -= len(p.idle)
+	p.idle = nil
+	p.notifyLocked()
+	return err
+}
+
+// notifyLocked wakes the calls waiting for a connection. The caller must hold
+// p.mu.
+func (p *pool) notifyLocked() {
+	close(p.freed)
+	p.freed = make(chan struct{})
+}
+
+// fillLocked dials connections in the background until minIdle are open. The
+// caller must hold p.mu.
+func (p *pool) fillLocked() {
+	for !p.closed && p.open < p.minIdle {
+		p.open++
+		go func() {
+			cn, err := p.dial(context.Background())
+			if err != nil {
+				p.mu.Lock()
+				p.open--
+				p.notifyLocked()
+				p.mu.Unlock()
+				return
+			}
+			p.put(cn)
+		}()
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countDials wraps c's dialer to count the connections it dials.
+func countDials(c *Client) *atomic.Int32 {
+	var n atomic.Int32
+	dial := c.pool.dial
+	c.pool.dial = func(ctx context.Context) (*conn, error) {
+		n.Add(1)
+		return dial(ctx)
+	}
+	return &n
+}
+
+func TestPoolStress(t *testing.T) {
+	c := newClient(t, startServer(t), WithPoolSize(8))
+	dials := countDials(c)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key, value := fmt.Sprintf("k%d", i), fmt.Sprintf("v%d-%d", i, j)
+				if err := c.Set(ctx, key, value, 0); err != nil {
+					errs <- err
+					return
+				}
+				if got, err := c.Get(ctx, key); err != nil || got != value {
+					errs <- fmt.Errorf("%s: expected %q, got %q, %v", key, value, got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if n := dials.Load(); n > 8 {
+		t.Fatalf("expected at most 8 connections, dialed %d", n)
+	}
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	if c.pool.open > 8 || len(c.pool.idle) != c.pool.open {
+		t.Fatalf("expected every connection back in the pool, got %d idle of %d open", len(c.pool.idle), c.pool.open)
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	c := newClient(t, startServer(t), WithPoolSize(1))
+	held, err := c.pool.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = c.Ping(ctx)
+	if !errors.Is(err, ErrPoolTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrPoolTimeout with the exhausted pool, got %v", err)
+	}
+
+	// A waiting call gets the connection once it is returned.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.pool.put(held)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("expected the returned connection to be used, got %v", err)
+	}
+}
+
+func TestPoolDiscardsBrokenConnections(t *testing.T) {
+	c := newClient(t, startServer(t), WithPoolSize(2))
+	dials := countDials(c)
+	ctx := context.Background()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Break the idle connection, as a server restart would.
+	c.pool.idle[0].nc.Close()
+	if err := c.Ping(ctx); err == nil {
+		t.Fatal("expected the broken connection to fail")
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("expected a new connection to be dialed, got %v", err)
+	}
+	if n := dials.Load(); n != 2 || c.pool.open != 1 {
+		t.Fatalf("expected the broken connection to be replaced, got %d dials and %d open", n, c.pool.open)
+	}
+}
+
+func TestPoolMinIdle(t *testing.T) {
+	c := New(startServer(t), WithPoolSize(4), WithMinIdle(3))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.pool.mu.Lock()
+		idle := len(c.pool.idle)
+		c.pool.mu.Unlock()
+		if idle == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 idle connections, got %d", idle)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.pool.open != 0 {
+		t.Fatalf("expected Close to close the idle connections, %d are open", c.pool.open)
+	}
+	if err := c.Ping(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// The listener completes TCP handshakes but never answers the TLS one.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c := newClient(t, ln.Addr().String(), WithTLS(&tls.Config{}), WithDialTimeout(50*time.Millisecond))
+	start := time.Now()
+	if err := c.Ping(context.Background()); err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("expected the dial to time out quickly, got %v after %v", err, time.Since(start))
+	}
+}
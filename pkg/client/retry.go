@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Retry defaults.
+const (
+	defaultMinBackoff = 8 * time.Millisecond
+	defaultMaxBackoff = 512 * time.Millisecond
+)
+
+// WithRetries retries a call up to n times after a network error, 0 by
+// default. Reads (Ping, Get, Exists and TTL) are retried however they failed.
+// Writes, including pipelines, are only retried if their request was never
+// written, as when no connection could be dialed, since the server may have
+// run them.
+func WithRetries(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.retries = n
+		}
+	}
+}
+
+// WithBackoff bounds the delay before each retry, 8ms to 512ms by default.
+// The delay doubles from minDelay with each retry of a call up to maxDelay,
+// and a random part of up to half of it is taken off, so clients that failed
+// together do not retry together.
+func WithBackoff(minDelay, maxDelay time.Duration) Option {
+	return func(o *options) {
+		if minDelay > 0 && maxDelay >= minDelay {
+			o.minBackoff, o.maxBackoff = minDelay, maxDelay
+		}
+	}
+}
+
+// WithAddresses adds servers to fail over to. After a network error the
+// client moves on to the next address, in order and wrapping around, and
+// drops its connections to the one that failed.
+func WithAddresses(addrs ...string) Option {
+	return func(o *options) {
+		o.addrs = append(o.addrs, addrs...)
+	}
+}
+
+// address returns the address connections are dialed to.
+func (c *Client) address() string {
+	return c.addrs[c.current.Load()]
+}
+
+// failover moves the client on from addr to the next address, unless
+// another call already has.
+func (c *Client) failover(addr string) {
+	i := c.current.Load()
+	if c.addrs[i] == addr {
+		c.current.CompareAndSwap(i, (i+1)%int64(len(c.addrs)))
+	}
+}
+
+// retryable reports whether a call that failed with err may be retried
+// within ctx: err must be a network error rather than an error reply, and ctx
+// must not be done.
+func retryable(ctx context.Context, err error) bool {
+	return !isReply(err) && !errors.Is(err, ErrClosed) && ctxErr(ctx) == nil
+}
+
+// backoff waits out the jittered delay before retry attempt+1, returning
+// early with ctx's error if ctx is done first.
+func (c *Client) backoff(ctx context.Context, attempt int) error {
+	d := c.opts.maxBackoff
+	if attempt < 32 {
+		d = min(d, c.opts.minBackoff<<attempt)
+	}
+	d -= rand.N(d/2 + 1)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyProxy forwards connections to a server, except that it closes the
+// first drops connections it accepts without reading from them.
+type flakyProxy struct {
+	ln       net.Listener
+	target   string
+	drops    int32
+	accepted atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// startFlakyProxy proxies to target until the test ends, or until stop.
+func startFlakyProxy(t *testing.T, target string, drops int) *flakyProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &flakyProxy{ln: ln, target: target, drops: int32(drops)}
+	t.Cleanup(p.stop)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if p.accepted.Add(1) <= p.drops {
+				conn.Close()
+				continue
+			}
+			go p.forward(conn)
+		}
+	}()
+	return p
+}
+
+func (p *flakyProxy) addr() string { return p.ln.Addr().String() }
+
+func (p *flakyProxy) forward(conn net.Conn) {
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, conn, upstream)
+	p.mu.Unlock()
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+// stop closes the listener and every proxied connection, as a server going
+// down would.
+func (p *flakyProxy) stop() {
+	p.ln.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func TestRetryReads(t *testing.T) {
+	addr := startServer(t)
+	ctx := context.Background()
+	if err := newClient(t, addr).Set(ctx, "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := startFlakyProxy(t, addr, 2)
+	c := newClient(t, proxy.addr(), WithRetries(3), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	if v, err := c.Get(ctx, "k"); err != nil || v != "v" {
+		t.Fatalf("expected the Get to be retried past the dropped connections, got %q, %v", v, err)
+	}
+	if n := proxy.accepted.Load(); n != 3 {
+		t.Fatalf("expected 3 connections, got %d", n)
+	}
+
+	// Without retries the error surfaces.
+	proxy = startFlakyProxy(t, addr, 1)
+	c = newClient(t, proxy.addr())
+	if _, err := c.Exists(ctx, "k"); err == nil || isReply(err) {
+		t.Fatalf("expected a network error, got %v", err)
+	}
+	if n, err := c.Exists(ctx, "k"); err != nil || n != 1 {
+		t.Fatalf("expected the next call to reconnect, got %d, %v", n, err)
+	}
+
+	// Error replies are not retried.
+	proxy = startFlakyProxy(t, addr, 0)
+	c = newClient(t, proxy.addr(), WithRetries(3))
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if n := proxy.accepted.Load(); n != 1 {
+		t.Fatalf("expected one connection, got %d", n)
+	}
+}
+
+func TestRetryWrites(t *testing.T) {
+	addr := startServer(t)
+	ctx := context.Background()
+	direct := newClient(t, addr)
+
+	// The INCR was written to a connection that then dropped, so it may have
+	// run, and is not retried.
+	proxy := startFlakyProxy(t, addr, 1)
+	c := newClient(t, proxy.addr(), WithRetries(3), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	if _, err := c.Incr(ctx, "counter"); err == nil {
+		t.Fatal("expected the INCR to fail")
+	}
+	if n := proxy.accepted.Load(); n != 1 {
+		t.Fatalf("expected the INCR not to be retried, got %d connections", n)
+	}
+	if _, err := direct.Get(ctx, "counter"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected the dropped INCR not to have run, got %v", err)
+	}
+
+	// A write that could not be sent at all is retried.
+	c = newClient(t, freeAddr(t), WithAddresses(addr), WithRetries(1), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	if n, err := c.Incr(ctx, "counter"); err != nil || n != 1 {
+		t.Fatalf("expected the INCR to be retried on the next address, got %d, %v", n, err)
+	}
+	if n, err := c.Append(ctx, "log", "a"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d, %v", n, err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	primary, standby := startServer(t), startServer(t)
+	ctx := context.Background()
+	newClient(t, primary).Set(ctx, "k", "primary", 0)
+	newClient(t, standby).Set(ctx, "k", "standby", 0)
+
+	proxy := startFlakyProxy(t, primary, 0)
+	c := newClient(t, proxy.addr(), WithAddresses(standby), WithRetries(1), WithPoolSize(4), WithMinIdle(4))
+	if v, err := c.Get(ctx, "k"); err != nil || v != "primary" {
+		t.Fatalf("expected the primary's value, got %q, %v", v, err)
+	}
+
+	// The primary goes down: the broken connection costs one retry, and the
+	// other idle connections to it are dropped rather than tried.
+	proxy.stop()
+	for i := 0; i < 10; i++ {
+		if v, err := c.Get(ctx, "k"); err != nil || v != "standby" {
+			t.Fatalf("Get %d: expected the standby's value, got %q, %v", i, v, err)
+		}
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	c := newClient(t, freeAddr(t), WithRetries(1000), WithBackoff(10*time.Millisecond, 100*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected the deadline to cut the retries short, took %v", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New("127.0.0.1:1", WithBackoff(10*time.Millisecond, 40*time.Millisecond))
+	defer c.Close()
+	ctx := context.Background()
+	for attempt, want := range []time.Duration{10, 20, 40, 40} {
+		want *= time.Millisecond
+		start := time.Now()
+		c.backoff(ctx, attempt)
+		if d := time.Since(start); d < want/2 || d > want+50*time.Millisecond {
+			t.Fatalf("attempt %d: expected a delay between %v and %v, got %v", attempt, want/2, want, d)
+		}
+	}
+}
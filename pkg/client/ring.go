@@ -0,0 +1,425 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ring errors.
+var (
+	// ErrNoNodes is returned by calls on a Ring with no addresses.
+	ErrNoNodes = errors.New("client: ring has no nodes")
+	// ErrNodeDown is returned for a key whose node the health check has
+	// ejected, unless WithRehash moves its keys to other nodes.
+	ErrNodeDown = errors.New("client: node is down")
+)
+
+// Ring defaults.
+const (
+	defaultVirtualNodes = 160
+	defaultHealthCheck  = time.Second
+)
+
+// WithVirtualNodes places each of a Ring's nodes at n points on the ring, 160
+// by default. More points spread keys more evenly across the nodes.
+func WithVirtualNodes(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.vnodes = n
+		}
+	}
+}
+
+// WithHealthCheck pings each of a Ring's nodes every interval, 1s by
+// default, ejecting a node that fails until it answers again. An interval of
+// 0 turns health checking off.
+func WithHealthCheck(interval time.Duration) Option {
+	return func(o *options) {
+		if interval >= 0 {
+			o.healthCheck = interval
+		}
+	}
+}
+
+// WithRehash moves the keys of a node the health check ejects to the next
+// nodes on the ring while it is down. By default they stay with it, and calls
+// for them fail fast with ErrNodeDown.
+func WithRehash(rehash bool) Option {
+	return func(o *options) {
+		o.rehash = rehash
+	}
+}
+
+// Ring shards keys across several servers by consistent hashing: each server
+// is placed at many points on a ring of hashes, and a key belongs to the
+// server at the first point at or after the key's hash. Adding or removing a
+// server only moves the keys between it and its neighbours.
+//
+//	r := client.NewRing([]string{"cache1:8080", "cache2:8080", "cache3:8080"})
+//	defer r.Close()
+//	err := r.Set(ctx, "greeting", "hello", time.Minute)
+//
+// Every client on the same addresses and WithVirtualNodes maps keys the same
+// way. A Ring is safe for concurrent use.
+type Ring struct {
+	opts    []Option
+	vnodes  int
+	rehash  bool
+	stop    chan struct{}
+	checked chan struct{} // Closed when the health check returns.
+
+	mu     sync.RWMutex
+	nodes  map[string]*ringNode
+	points []ringPoint // Sorted by hash.
+	closed bool
+}
+
+// ringNode is one server of a Ring.
+type ringNode struct {
+	addr   string
+	client *Client
+	down   bool // Ejected by the health check; guarded by Ring.mu.
+}
+
+// ringPoint is one of a node's places on the ring.
+type ringPoint struct {
+	hash uint64
+	node *ringNode
+}
+
+// NewRing returns a ring of the servers at addrs. The options apply to the
+// client of each server as well as to the ring.
+func NewRing(addrs []string, opts ...Option) *Ring {
+	o := options{vnodes: defaultVirtualNodes, healthCheck: defaultHealthCheck}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := &Ring{
+		opts:    opts,
+		vnodes:  o.vnodes,
+		rehash:  o.rehash,
+		stop:    make(chan struct{}),
+		checked: make(chan struct{}),
+		nodes:   make(map[string]*ringNode),
+	}
+	r.SetAddrs(addrs)
+	if o.healthCheck > 0 {
+		go r.checkHealth(o.healthCheck)
+	} else {
+		close(r.checked)
+	}
+	return r
+}
+
+// SetAddrs replaces the ring's servers with those at addrs, keeping the
+// connections to servers that stay. Only the keys of servers added or
+// removed change hands. Calls in flight to a removed server may fail with
+// ErrClosed.
+func (r *Ring) SetAddrs(addrs []string) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	var removed []*ringNode
+	keep := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+		if r.nodes[addr] == nil {
+			r.nodes[addr] = &ringNode{addr: addr, client: New(addr, r.opts...)}
+		}
+	}
+	for addr, n := range r.nodes {
+		if !keep[addr] {
+			delete(r.nodes, addr)
+			removed = append(removed, n)
+		}
+	}
+	r.buildLocked()
+	r.mu.Unlock()
+	for _, n := range removed {
+		n.client.Close()
+	}
+}
+
+// Addrs returns the addresses of the ring's servers, sorted.
+func (r *Ring) Addrs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	addrs := make([]string, 0, len(r.nodes))
+	for addr := range r.nodes {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+// buildLocked places the nodes on the ring. With WithRehash, ejected nodes
+// are left off, unless every node is down. The caller must hold r.mu.
+func (r *Ring) buildLocked() {
+	live := 0
+	for _, n := range r.nodes {
+		if !n.down {
+			live++
+		}
+	}
+	skipDown := r.rehash && live > 0
+	r.points = r.points[:0]
+	for _, n := range r.nodes {
+		if skipDown && n.down {
+			continue
+		}
+		for i := range r.vnodes {
+			r.points = append(r.points, ringPoint{hash: hashKey(n.addr + "-" + strconv.Itoa(i)), node: n})
+		}
+	}
+	// Break ties by address, so every client orders the points the same way.
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), strings.Compare(a.node.addr, b.node.addr))
+	})
+}
+
+// Addr returns the address of the server key belongs to, or "" if the ring
+// has no servers.
+func (r *Ring) Addr(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if n := r.ownerLocked(key); n != nil {
+		return n.addr
+	}
+	return ""
+}
+
+// ownerLocked returns the node key belongs to, or nil if there are none. The
+// caller must hold r.mu.
+func (r *Ring) ownerLocked(key string) *ringNode {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := hashKey(key)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int { return cmp.Compare(p.hash, h) })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// client returns the client of the server key belongs to.
+func (r *Ring) client(key string) (*Client, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrClosed
+	}
+	n := r.ownerLocked(key)
+	switch {
+	case n == nil:
+		return nil, ErrNoNodes
+	case n.down && !r.rehash:
+		return nil, fmt.Errorf("%w: %s", ErrNodeDown, n.addr)
+	}
+	return n.client, nil
+}
+
+// Get returns the value of key from its server, or ErrKeyNotFound.
+func (r *Ring) Get(ctx context.Context, key string) (string, error) {
+	c, err := r.client(key)
+	if err != nil {
+		return "", err
+	}
+	return c.Get(ctx, key)
+}
+
+// Set stores value under key on its server, as Client.Set does.
+func (r *Ring) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	c, err := r.client(key)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, key, value, ttl)
+}
+
+// Delete removes key from its server.
+func (r *Ring) Delete(ctx context.Context, key string) error {
+	c, err := r.client(key)
+	if err != nil {
+		return err
+	}
+	return c.Delete(ctx, key)
+}
+
+// MGet returns the values of the keys that are set, reading them with one
+// pipeline per server, all in parallel. If some servers fail, it returns the
+// values from the others along with their errors, joined.
+func (r *Ring) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	var mu sync.Mutex
+	err := r.pipeline(ctx, keys, func(p *Pipeline, key string) {
+		p.Get(key)
+	}, func(key string, res Result) error {
+		switch {
+		case errors.Is(res.Err, ErrKeyNotFound):
+			return nil
+		case res.Err != nil:
+			return res.Err
+		}
+		mu.Lock()
+		values[key] = res.Value
+		mu.Unlock()
+		return nil
+	})
+	return values, err
+}
+
+// MSet stores every value in pairs under its key, as Set does, with one
+// pipeline per server, all in parallel. If some servers fail, it returns
+// their errors, joined; the pairs of the other servers are stored.
+func (r *Ring) MSet(ctx context.Context, pairs map[string]string, ttl time.Duration) error {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	return r.pipeline(ctx, keys, func(p *Pipeline, key string) {
+		p.Set(key, pairs[key], ttl)
+	}, func(key string, res Result) error {
+		return res.Err
+	})
+}
+
+// pipeline groups keys by server and, for each server in parallel, queues
+// one command per key with queue, runs them in one pipeline, and passes each
+// key's result to handle. It returns the errors of the servers and of
+// handle, joined; each server's first error is kept.
+func (r *Ring) pipeline(ctx context.Context, keys []string, queue func(*Pipeline, string), handle func(string, Result) error) error {
+	groups := make(map[*Client][]string)
+	var errs []error
+	for _, key := range keys {
+		c, err := r.client(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		groups[c] = append(groups[c], key)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for c, keys := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := c.Pipeline()
+			for _, key := range keys {
+				queue(p, key)
+			}
+			results, err := p.Exec(ctx)
+			for i, res := range results {
+				if herr := handle(keys[i], res); err == nil {
+					err = herr
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", c.address(), err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// checkHealth pings every node each interval until Close, ejecting those
+// that fail and restoring those that answer.
+func (r *Ring) checkHealth(interval time.Duration) {
+	defer close(r.checked)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+		r.mu.RLock()
+		nodes := make([]*ringNode, 0, len(r.nodes))
+		for _, n := range r.nodes {
+			nodes = append(nodes, n)
+		}
+		r.mu.RUnlock()
+
+		up := make([]bool, len(nodes))
+		var wg sync.WaitGroup
+		for i, n := range nodes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				defer cancel()
+				up[i] = n.client.Ping(ctx) == nil
+			}()
+		}
+		wg.Wait()
+
+		r.mu.Lock()
+		changed := false
+		for i, n := range nodes {
+			if r.nodes[n.addr] == n && n.down == up[i] {
+				n.down = !up[i]
+				changed = true
+			}
+		}
+		if changed && r.rehash {
+			r.buildLocked()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Close stops the health check and closes the connections to every server.
+// Later calls return ErrClosed.
+func (r *Ring) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	nodes := r.nodes
+	r.nodes = nil
+	r.points = nil
+	r.mu.Unlock()
+	close(r.stop)
+	<-r.checked
+	for _, n := range nodes {
+		n.client.Close()
+	}
+	return nil
+}
+
+// hashKey hashes s with 64-bit FNV-1a, mixed with the MurmurHash3 finalizer
+// so that strings differing only in their last bytes, such as a node's point
+// labels, land far apart.
+func hashKey(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newRing returns a ring of addrs, closed when the test ends.
+func newRing(t *testing.T, addrs []string, opts ...Option) *Ring {
+	t.Helper()
+	r := NewRing(addrs, opts...)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// owners maps each of n keys to its server on r.
+func owners(r *Ring, n int) map[string]string {
+	m := make(map[string]string, n)
+	for i := range n {
+		key := "key" + strconv.Itoa(i)
+		m[key] = r.Addr(key)
+	}
+	return m
+}
+
+func TestRingRouting(t *testing.T) {
+	ctx := context.Background()
+	addrs := []string{startServer(t), startServer(t), startServer(t)}
+	r := newRing(t, addrs, WithHealthCheck(0))
+
+	for i := range 60 {
+		key := "key" + strconv.Itoa(i)
+		if err := r.Set(ctx, key, "v"+strconv.Itoa(i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each key is on its owner and nowhere else.
+	for _, addr := range addrs {
+		c := newClient(t, addr)
+		for key, owner := range owners(r, 60) {
+			_, err := c.Get(ctx, key)
+			if (owner == addr) != (err == nil) {
+				t.Fatalf("%s: owned by %s, got %v from %s", key, owner, err, addr)
+			}
+		}
+	}
+	if v, err := r.Get(ctx, "key7"); err != nil || v != "v7" {
+		t.Fatalf("expected v7, got %q, %v", v, err)
+	}
+	if err := r.Delete(ctx, "key7"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "key7"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound once deleted, got %v", err)
+	}
+	if err := r.Set(ctx, "bad key", "v", 0); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestRingMGetMSet(t *testing.T) {
+	ctx := context.Background()
+	r := newRing(t, []string{startServer(t), startServer(t), startServer(t)}, WithHealthCheck(0))
+
+	pairs := make(map[string]string)
+	keys := []string{"missing"}
+	for i := range 100 {
+		key := "key" + strconv.Itoa(i)
+		pairs[key] = "v" + strconv.Itoa(i)
+		keys = append(keys, key)
+	}
+	if err := r.MSet(ctx, pairs, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	values, err := r.MGet(ctx, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != len(pairs) {
+		t.Fatalf("expected %d values, got %d", len(pairs), len(values))
+	}
+	for key, want := range pairs {
+		if values[key] != want {
+			t.Fatalf("%s: expected %q, got %q", key, want, values[key])
+		}
+	}
+	if ttl, err := newClient(t, r.Addr("key1")).TTL(ctx, "key1"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected MSet to apply the TTL, got %v, %v", ttl, err)
+	}
+}
+
+func TestRingDistribution(t *testing.T) {
+	addrs := []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080"}
+	r := newRing(t, addrs, WithHealthCheck(0))
+	const keys = 100000
+	counts := make(map[string]int)
+	for _, owner := range owners(r, keys) {
+		counts[owner]++
+	}
+	mean := float64(keys) / float64(len(addrs))
+	for _, addr := range addrs {
+		if imbalance := (float64(counts[addr]) - mean) / mean; imbalance > 0.1 || imbalance < -0.1 {
+			t.Errorf("%s: expected within 10%% of %.0f keys, got %d", addr, mean, counts[addr])
+		}
+	}
+}
+
+func TestRingSetAddrs(t *testing.T) {
+	addrs := []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080"}
+	r := newRing(t, addrs, WithHealthCheck(0))
+	const keys = 10000
+	before := owners(r, keys)
+
+	// Adding a node only moves keys to it, about a quarter of them.
+	r.SetAddrs(append(addrs, "10.0.0.4:8080"))
+	moved := 0
+	for key, owner := range owners(r, keys) {
+		if owner != before[key] {
+			moved++
+			if owner != "10.0.0.4:8080" {
+				t.Fatalf("%s moved from %s to %s, not to the new node", key, before[key], owner)
+			}
+		}
+	}
+	if moved < keys/5 || moved > keys*3/10 {
+		t.Fatalf("expected about a quarter of the keys to move, got %d of %d", moved, keys)
+	}
+
+	// Removing a node only moves its keys.
+	four := owners(r, keys)
+	r.SetAddrs([]string{"10.0.0.1:8080", "10.0.0.3:8080", "10.0.0.4:8080"})
+	for key, owner := range owners(r, keys) {
+		if four[key] != "10.0.0.2:8080" && owner != four[key] {
+			t.Fatalf("%s moved from %s to %s though its node stayed", key, four[key], owner)
+		}
+	}
+	if got := fmt.Sprint(r.Addrs()); got != "[10.0.0.1:8080 10.0.0.3:8080 10.0.0.4:8080]" {
+		t.Fatalf("expected three addresses, got %s", got)
+	}
+}
+
+// keyOwnedBy returns a key r maps to addr.
+func keyOwnedBy(t *testing.T, r *Ring, addr string) string {
+	t.Helper()
+	for i := range 1000 {
+		if key := "key" + strconv.Itoa(i); r.Addr(key) == addr {
+			return key
+		}
+	}
+	t.Fatalf("no key maps to %s", addr)
+	return ""
+}
+
+// waitForNode waits for the health check to mark addr down or up.
+func waitForNode(t *testing.T, r *Ring, addr string, down bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.RLock()
+		n := r.nodes[addr]
+		done := n != nil && n.down == down
+		r.mu.RUnlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be marked down=%t", addr, down)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRingEjection(t *testing.T) {
+	ctx := context.Background()
+	live, dead := startServer(t), freeAddr(t)
+	r := newRing(t, []string{live, dead}, WithHealthCheck(20*time.Millisecond), WithDialTimeout(100*time.Millisecond))
+	key := keyOwnedBy(t, r, dead)
+
+	waitForNode(t, r, dead, true)
+	if err := r.Set(ctx, key, "v", 0); !errors.Is(err, ErrNodeDown) {
+		t.Fatalf("expected ErrNodeDown for a key on the ejected node, got %v", err)
+	}
+	values, err := r.MGet(ctx, key, keyOwnedBy(t, r, live))
+	if !errors.Is(err, ErrNodeDown) || len(values) != 0 {
+		t.Fatalf("expected MGet to report the ejected node, got %v, %v", values, err)
+	}
+
+	// The node is restored once it answers again.
+	startServerAt(t, dead)
+	waitForNode(t, r, dead, false)
+	if err := r.Set(ctx, key, "v", 0); err != nil {
+		t.Fatalf("expected the node to be restored, got %v", err)
+	}
+}
+
+func TestRingRehash(t *testing.T) {
+	ctx := context.Background()
+	live, dead := startServer(t), freeAddr(t)
+	r := newRing(t, []string{live, dead}, WithHealthCheck(20*time.Millisecond), WithDialTimeout(100*time.Millisecond), WithRehash(true))
+	key := keyOwnedBy(t, r, dead)
+
+	waitForNode(t, r, dead, true)
+	if got := r.Addr(key); got != live {
+		t.Fatalf("expected %s to move to %s, got %s", key, live, got)
+	}
+	if err := r.Set(ctx, key, "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := newClient(t, live).Get(ctx, key); err != nil || v != "v" {
+		t.Fatalf("expected the key on the live node, got %q, %v", v, err)
+	}
+
+	startServerAt(t, dead)
+	waitForNode(t, r, dead, false)
+	if got := r.Addr(key); got != dead {
+		t.Fatalf("expected %s back on %s, got %s", key, dead, got)
+	}
+}
+
+func TestRingClosed(t *testing.T) {
+	r := NewRing([]string{"10.0.0.1:8080"})
+	r.Close()
+	if err := r.Set(context.Background(), "k", "v", 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := newRing(t, nil).Get(context.Background(), "k"); !errors.Is(err, ErrNoNodes) {
+		t.Fatalf("expected ErrNoNodes, got %v", err)
+	}
+}
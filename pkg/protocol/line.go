@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrEmptyCommand is returned by ParseCommand for a line with no words.
+// Servers skip such lines without replying.
+var ErrEmptyCommand = errors.New("empty command")
+
+// ErrStatusLineBreak is returned by WriteReply for a status reply containing
+// LF, which would be read as more than one reply. Values that may contain one
+// must be sent with Bulk.
+var ErrStatusLineBreak = errors.New("status reply contains a line break")
+
+// Command is a command of the line protocol: a single line of words separated
+// by whitespace, the first of which names the command.
+type Command struct {
+	Name string   // The first word, upper-cased.
+	Args []string // Every word as sent, the first included.
+}
+
+// ParseCommand parses a line protocol command from line, which may still end
+// in its LF or CRLF terminator. Words are kept byte for byte, so they may hold
+// invalid UTF-8 or NULs; keys and values that contain whitespace must be sent
+// in a data block instead, which the caller reads separately.
+func ParseCommand(line []byte) (Command, error) {
+	args := strings.Fields(string(line))
+	if len(args) == 0 {
+		return Command{}, ErrEmptyCommand
+	}
+	return Command{Name: strings.ToUpper(args[0]), Args: args}, nil
+}
+
+// AppendCommand appends c to dst as a CRLF-terminated line that ParseCommand
+// reads back as c.
+func AppendCommand(dst []byte, c Command) []byte {
+	for i, arg := range c.Args {
+		if i > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, arg...)
+	}
+	return append(dst, "\r\n"...)
+}
+
+// ReplyKind is the framing of a line protocol reply.
+type ReplyKind int
+
+const (
+	ReplyStatus ReplyKind = iota // A line of text, such as OK or PONG.
+	ReplyError                   // "ERROR: <message>"
+	ReplyBulk                    // "$<nbytes>\r\n<value>\r\n", binary safe.
+	ReplyNil                     // "(nil)", for a missing value.
+)
+
+// Reply is a reply of the line protocol.
+type Reply struct {
+	Kind ReplyKind
+	Text string
+}
+
+// Nil is the reply for a missing value.
+var Nil = Reply{Kind: ReplyNil}
+
+// Status returns a status reply. s must not contain LF; WriteReply refuses
+// to write it if it does.
+func Status(s string) Reply { return Reply{Kind: ReplyStatus, Text: s} }
+
+// Integer returns a status reply holding n in decimal.
+func Integer(n int64) Reply { return Status(strconv.FormatInt(n, 10)) }
+
+// Error returns an error reply. Line breaks in msg are replaced with spaces
+// when it is written.
+func Error(msg string) Reply { return Reply{Kind: ReplyError, Text: msg} }
+
+// Bulk returns a reply carrying value in a data block, so clients can read
+// values that contain newlines.
+func Bulk(value string) Reply { return Reply{Kind: ReplyBulk, Text: value} }
+
+// WriteReply writes r to w in the line protocol's framing. It writes nothing
+// and returns ErrStatusLineBreak for a status reply containing LF.
+func WriteReply(w io.Writer, r Reply) error {
+	var line string
+	switch r.Kind {
+	case ReplyError:
+		line = "ERROR: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(r.Text) + "\n"
+	case ReplyBulk:
+		line = "$" + strconv.Itoa(len(r.Text)) + "\r\n" + r.Text + "\r\n"
+	case ReplyNil:
+		line = "(nil)\n"
+	default:
+		if strings.Contains(r.Text, "\n") {
+			return ErrStatusLineBreak
+		}
+		line = r.Text + "\n"
+	}
+	_, err := io.WriteString(w, line)
+	return err
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	for input, want := range map[string][]string{
+		"GET key\r\n":           {"GET", "key"},
+		"  set\tkey   value \n": {"set", "key", "value"},
+		"get k\x00ey":           {"get", "k\x00ey"},
+		"GET \xff\xfe":          {"GET", "\xff\xfe"},
+	} {
+		cmd, err := ParseCommand([]byte(input))
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if !reflect.DeepEqual(cmd.Args, want) || cmd.Name != strings.ToUpper(want[0]) {
+			t.Fatalf("%q: expected %q, got %s %q", input, want, cmd.Name, cmd.Args)
+		}
+	}
+	for _, input := range []string{"", "\r\n", " \t "} {
+		if _, err := ParseCommand([]byte(input)); err != ErrEmptyCommand {
+			t.Fatalf("%q: expected ErrEmptyCommand, got %v", input, err)
+		}
+	}
+}
+
+func TestWriteReply(t *testing.T) {
+	var buf bytes.Buffer
+	for _, r := range []Reply{Status("OK"), Integer(-42), Error("bad\r\nthing"), Bulk("a\r\nb"), Bulk(""), Nil} {
+		if err := WriteReply(&buf, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "OK\n-42\nERROR: bad  thing\n$4\r\na\r\nb\r\n$0\r\n\r\n(nil)\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	if err := WriteReply(&buf, Status("k a\nb")); err != ErrStatusLineBreak || buf.Len() != 0 {
+		t.Fatalf("expected ErrStatusLineBreak and nothing written, got %v and %q", err, buf.String())
+	}
+}
+
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{
+		"GET key",
+		"SET key value\r\n",
+		"SET key $5",
+		"SET",
+		"MSET a",
+		"get k\x00ey v\x00",
+		"SET \xff\xfe \xc3\x28",
+		"SET key " + strings.Repeat("v", 1<<16),
+		strings.Repeat("k", 1<<16),
+		" PING x",
+		"",
+		"\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		cmd, err := ParseCommand(line)
+		if err != nil {
+			return
+		}
+		if len(cmd.Args) == 0 || cmd.Name != strings.ToUpper(cmd.Args[0]) {
+			t.Fatalf("%q: parsed as %s %q", line, cmd.Name, cmd.Args)
+		}
+		encoded := AppendCommand(nil, cmd)
+		again, err := ParseCommand(encoded)
+		if err != nil {
+			t.Fatalf("%q: re-parsing %q: %v", line, encoded, err)
+		}
+		if !reflect.DeepEqual(again, cmd) {
+			t.Fatalf("%q: round-tripped %q as %q", line, cmd.Args, again.Args)
+		}
+	})
+}
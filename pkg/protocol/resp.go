@@ -0,0 +1,209 @@
+// Package protocol implements the wire protocols the server speaks: its own
+// line protocol, and the Redis serialization protocol (RESP2), so the server
+// can be driven by redis-cli and existing Redis client libraries.
+//
+// RESP commands arrive either as arrays of bulk strings, which is what client
+// libraries send, or as inline commands: a plain line of space-separated words,
+// as typed into telnet.
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits on what a client may send, so a bad length cannot trigger a huge
+// allocation. They match Redis's defaults.
+const (
+	maxBulkLen   = 512 << 20
+	maxArrayLen  = 1 << 20
+	maxInlineLen = 64 << 10
+)
+
+// ErrProtocol is wrapped by the error ReadCommand returns for malformed input.
+// The connection cannot be resynchronized after it, so callers should reply
+// with an error and close it.
+var ErrProtocol = errors.New("protocol error")
+
+// Reader reads commands from a client connection.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadCommand reads the next command and returns its arguments, the first of
+// which is the command name. Blank inline lines are skipped. It returns io.EOF
+// when the client closes the connection between commands.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		b, err := r.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '*' {
+			args, err := r.readArray()
+			if err != nil || len(args) > 0 {
+				return args, err
+			}
+			continue
+		}
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if args := strings.Fields(line); len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// Buffered returns the number of bytes already read from the connection but
+// not yet consumed. Servers flush replies once it drops to zero, so a
+// pipelined batch of commands is answered with a single write.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// readArray reads an array of bulk strings.
+func (r *Reader) readArray() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArrayLen {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([]string, n)
+	for i := range args {
+		if args[i], err = r.readBulk(); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string.
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got %q", ErrProtocol, line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkLen {
+		return "", fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return string(buf[:n]), nil
+}
+
+// readLine reads a line terminated by LF, dropping the terminator and any CR
+// before it.
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.r.ReadLine()
+		if err != nil {
+			if len(line) > 0 {
+				return "", unexpectedEOF(err)
+			}
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLen {
+			return "", fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// unexpectedEOF converts an EOF in the middle of a command into
+// io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Writer encodes replies to a client. Replies are buffered until Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteSimpleString writes a status reply such as OK or PONG. s must not
+// contain CR or LF.
+func (w *Writer) WriteSimpleString(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// WriteError writes an error reply. By convention msg starts with an error
+// code such as ERR or NOAUTH. Line breaks in msg are replaced with spaces.
+func (w *Writer) WriteError(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+	w.w.WriteString("\r\n")
+}
+
+// WriteInteger writes an integer reply.
+func (w *Writer) WriteInteger(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+// WriteBulkString writes a binary-safe string reply.
+func (w *Writer) WriteBulkString(s string) {
+	w.w.WriteByte('$')
+	w.w.WriteString(strconv.Itoa(len(s)))
+	w.w.WriteString("\r\n")
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// WriteNull writes the null bulk string, which clients read as a missing key.
+func (w *Writer) WriteNull() {
+	w.w.WriteString("$-1\r\n")
+}
+
+// WriteArrayHeader starts an array reply of n elements, which the caller then
+// writes individually.
+func (w *Writer) WriteArrayHeader(n int) {
+	w.w.WriteByte('*')
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+// Flush writes any buffered replies to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
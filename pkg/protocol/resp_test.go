@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$12\r\nhello\r\nworld\r\n" +
+		"\r\n" +
+		"PING  hello\r\n" +
+		"GET key\n" +
+		"*2\r\n$3\r\nGET\r\n$0\r\n\r\n"
+	r := NewReader(strings.NewReader(input))
+	want := [][]string{
+		{"SET", "key", "hello\r\nworld"},
+		{"PING", "hello"},
+		{"GET", "key"},
+		{"GET", ""},
+	}
+	for i, w := range want {
+		got, err := r.ReadCommand()
+		if err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Fatalf("command %d: expected %q, got %q", i, w, got)
+		}
+	}
+	if _, err := r.ReadCommand(); err != io.EOF {
+		t.Fatalf("expected io.EOF at the end, got %v", err)
+	}
+}
+
+func TestReadCommandMalformed(t *testing.T) {
+	for name, input := range map[string]string{
+		"bad count":      "*x\r\n",
+		"huge count":     "*99999999\r\n",
+		"missing dollar": "*1\r\n:3\r\n",
+		"bad length":     "*1\r\n$-5\r\n",
+		"unterminated":   "*1\r\n$3\r\nGETXX",
+		"long line":      strings.Repeat("a", maxInlineLen+1) + "\r\n",
+	} {
+		_, err := NewReader(strings.NewReader(input)).ReadCommand()
+		if !errors.Is(err, ErrProtocol) {
+			t.Errorf("%s: expected ErrProtocol, got %v", name, err)
+		}
+	}
+	_, err := NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n")).ReadCommand()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated command, got %v", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteSimpleString("OK")
+	w.WriteError("ERR bad\r\nthing")
+	w.WriteInteger(-42)
+	w.WriteBulkString("a\r\nb")
+	w.WriteNull()
+	w.WriteArrayHeader(2)
+	w.WriteBulkString("x")
+	w.WriteBulkString("")
+	if buf.Len() != 0 {
+		t.Fatal("expected replies to be buffered until Flush")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "+OK\r\n-ERR bad  thing\r\n:-42\r\n$4\r\na\r\nb\r\n$-1\r\n*2\r\n$1\r\nx\r\n$0\r\n\r\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
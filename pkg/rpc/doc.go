@@ -0,0 +1,5 @@
+// Package rpc serves a ShardedCache over gRPC. The message and service types
+// are generated from cache.proto; Server implements the service.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cache.proto
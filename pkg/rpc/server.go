@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements CacheServer on top of a ShardedCache.
+type Server struct {
+	UnimplementedCacheServer
+
+	cache *cache.ShardedCache
+	// OnWrite, if set, is called with a record of each successful Set or
+	// Delete, for example to append it to the append-only file.
+	OnWrite func(rec aof.Record)
+	// Hidden, if set, reports keys that Scan leaves out.
+	Hidden func(key string) bool
+}
+
+// NewServer returns a Server backed by c.
+func NewServer(c *cache.ShardedCache) *Server {
+	return &Server{cache: c}
+}
+
+// Get returns the value for a key, failing with NotFound if it is missing or
+// expired.
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	value, err := s.cache.GetBytes(req.Key)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, status.Errorf(codes.NotFound, "key %q not found", req.Key)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &GetResponse{Key: req.Key, Value: value, Found: true}, nil
+}
+
+// Set stores a value, expiring it after ttl_ms if that is positive and
+// applying the cache's default TTL otherwise.
+func (s *Server) Set(ctx context.Context, req *SetRequest) (*SetResponse, error) {
+	if req.TtlMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl_ms must not be negative")
+	}
+	ttl := time.Duration(req.TtlMs) * time.Millisecond
+	rec := aof.Record{Op: aof.OpSet, Key: req.Key, Value: string(req.Value)}
+	if ttl > 0 {
+		rec.ExpireAt = time.Now().Add(ttl)
+	}
+	err := s.cache.SetWithTTLE(req.Key, string(req.Value), ttl)
+	if errors.Is(err, cache.ErrValueTooLarge) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.written(rec)
+	return &SetResponse{}, nil
+}
+
+// Delete removes a key and reports whether it was present.
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	deleted := s.cache.MDel(req.Key) > 0
+	if deleted {
+		s.written(aof.Record{Op: aof.OpDel, Key: req.Key})
+	}
+	return &DeleteResponse{Deleted: deleted}, nil
+}
+
+// Scan streams the entries whose key starts with the requested prefix. Entries
+// are copied one shard at a time, so the stream is not an atomic snapshot.
+func (s *Server) Scan(req *ScanRequest, stream Cache_ScanServer) error {
+	var err error
+	s.cache.ForEach(func(key, value string) bool {
+		if !strings.HasPrefix(key, req.Prefix) || s.Hidden != nil && s.Hidden(key) {
+			return true
+		}
+		err = stream.Send(&Entry{Key: key, Value: []byte(value)})
+		return err == nil
+	})
+	return err
+}
+
+// BatchGet answers each GetRequest on the stream in order until the client
+// closes its side. Misses are reported with found unset rather than failing
+// the stream.
+func (s *Server) BatchGet(stream Cache_BatchGetServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp := &GetResponse{Key: req.Key}
+		if value, err := s.cache.GetBytes(req.Key); err == nil {
+			resp.Value, resp.Found = value, true
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// written reports a successful write to OnWrite.
+func (s *Server) written(rec aof.Record) {
+	if s.OnWrite != nil {
+		s.OnWrite(rec)
+	}
+}
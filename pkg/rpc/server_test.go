@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient serves srv over an in-memory listener until the test ends and
+// returns a client connected to it.
+func newTestClient(t *testing.T, srv *Server) CacheClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	RegisterCacheServer(gs, srv)
+	go gs.Serve(ln)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewCacheClient(conn)
+}
+
+func TestServerGetSetDelete(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	var writes []aof.Record
+	srv := NewServer(c)
+	srv.OnWrite = func(rec aof.Record) { writes = append(writes, rec) }
+	client := newTestClient(t, srv)
+	ctx := context.Background()
+
+	value := []byte{0, 1, '\r', '\n', 0xff}
+	if _, err := client.Set(ctx, &SetRequest{Key: "k", Value: value, TtlMs: 60000}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	resp, err := client.Get(ctx, &GetRequest{Key: "k"})
+	if err != nil || !resp.Found || string(resp.Value) != string(value) {
+		t.Fatalf("Get: expected %q, got %v, %v", value, resp, err)
+	}
+	if _, at, _ := c.PeekWithExpiry("k"); time.Until(at) > time.Minute || time.Until(at) < 59*time.Second {
+		t.Fatalf("expected k to expire in a minute, expires at %v", at)
+	}
+
+	if _, err := client.Get(ctx, &GetRequest{Key: "missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound for a missing key, got %v", err)
+	}
+	if _, err := client.Set(ctx, &SetRequest{Key: "k", TtlMs: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a negative TTL, got %v", err)
+	}
+
+	if resp, err := client.Delete(ctx, &DeleteRequest{Key: "k"}); err != nil || !resp.Deleted {
+		t.Fatalf("Delete: expected deleted, got %v, %v", resp, err)
+	}
+	if resp, err := client.Delete(ctx, &DeleteRequest{Key: "k"}); err != nil || resp.Deleted {
+		t.Fatalf("second Delete: expected nothing deleted, got %v, %v", resp, err)
+	}
+	if len(writes) != 2 || writes[0].Op != aof.OpSet || writes[0].ExpireAt.IsZero() || writes[1].Op != aof.OpDel {
+		t.Fatalf("expected a set and a delete to be reported, got %+v", writes)
+	}
+}
+
+func TestServerSetTooLarge(t *testing.T) {
+	c := cache.NewShardedCache(cache.WithMaxValueBytes(4))
+	defer c.Close()
+	client := newTestClient(t, NewServer(c))
+
+	_, err := client.Set(context.Background(), &SetRequest{Key: "k", Value: []byte("too large")})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if c.Exists("k") {
+		t.Fatal("expected nothing stored")
+	}
+}
+
+func TestServerScan(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	for _, key := range []string{"foo:1", "foo:2", "bar"} {
+		c.Set(key, "v-"+key)
+	}
+	client := newTestClient(t, NewServer(c))
+
+	stream, err := client.Scan(context.Background(), &ScanRequest{Prefix: "foo:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for {
+		ent, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if string(ent.Value) != "v-"+ent.Key {
+			t.Fatalf("expected %q for %s, got %q", "v-"+ent.Key, ent.Key, ent.Value)
+		}
+		keys = append(keys, ent.Key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "foo:1" || keys[1] != "foo:2" {
+		t.Fatalf("expected foo:1 and foo:2, got %v", keys)
+	}
+}
+
+func TestServerBatchGet(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("a", "1")
+	c.Set("c", "3")
+	client := newTestClient(t, NewServer(c))
+
+	stream, err := client.BatchGet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := stream.Send(&GetRequest{Key: key}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	stream.CloseSend()
+
+	want := []struct {
+		key, value string
+		found      bool
+	}{{"a", "1", true}, {"b", "", false}, {"c", "3", true}}
+	for _, w := range want {
+		resp, err := stream.Recv()
+		if err != nil || resp.Key != w.key || string(resp.Value) != w.value || resp.Found != w.found {
+			t.Fatalf("expected %+v, got %v, %v", w, resp, err)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("expected the stream to end, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// logWrite appends rec to the append-only file, if one is configured, feeds
+// it to replicas, and publishes the keyspace events it implies. The caller
+// holds logMu from applying the write to the cache until logWrite returns, so
+// that concurrent writes reach the log and replicas in the order they were
+// applied.
+func (s *Server) logWrite(rec aof.Record) {
+	s.publishWriteEvent(rec)
+	s.appendToLog(rec)
+	s.replFeed.record(rec)
+}
+
+// appendToLog appends rec to the append-only file, if one is configured.
+func (s *Server) appendToLog(rec aof.Record) {
+	if s.appendLog == nil {
+		return
+	}
+	if err := s.appendLog.Append(rec); err != nil {
+		slog.Error("AOF append failed", "err", err)
+	}
+}
+
+// setRecord returns the record of a write that just stored value under key,
+// with the expiration the entry got, which may be the cache's default TTL
+// or, for a write that keeps it, the entry's old one.
+func (s *Server) setRecord(key, value string) aof.Record {
+	rec := aof.Record{Op: aof.OpSet, Key: key, Value: value}
+	if s.cache != nil {
+		rec.ExpireAt, _ = s.cache.ExpireTime(key)
+	}
+	return rec
+}
+
+// logCurrent records key's current value and expiration, for writes that
+// change an entry in place, and publishes event for it. A key the write left
+// empty, such as a hash whose last field was removed, is recorded as deleted.
+// The caller holds logMu, as for logWrite.
+func (s *Server) logCurrent(key, event string) {
+	if s.config.notifyEvents {
+		s.publishKeyEvent(event, key)
+	}
+	if !s.cache.Exists(key) {
+		s.logWrite(aof.Record{Op: aof.OpDel, Key: key})
+		return
+	}
+	if s.appendLog == nil && !s.replFeed.recording() {
+		return // Spare serializing a large object that nothing would read.
+	}
+	if rec, ok := s.currentRecord(key); ok {
+		s.appendToLog(rec)
+		s.replFeed.record(rec)
+	}
+}
+
+// currentRecord returns a record that stores key's current value and
+// expiration: an OpSet for a string, and an OpRestore of its Dump payload for
+// any other kind. It reports false if key is missing.
+func (s *Server) currentRecord(key string) (aof.Record, bool) {
+	value, expireAt, err := s.cache.PeekWithExpiry(key)
+	if err == nil {
+		return aof.Record{Op: aof.OpSet, Key: key, Value: value, ExpireAt: expireAt}, true
+	}
+	if !errors.Is(err, cache.ErrWrongType) {
+		return aof.Record{}, false
+	}
+	payload, err := s.cache.Dump(key)
+	if err != nil {
+		return aof.Record{}, false
+	}
+	expireAt, _ = s.cache.ExpireTime(key)
+	return aof.Record{Op: aof.OpRestore, Key: key, Value: string(payload), ExpireAt: expireAt}, true
+}
+
+// aofRewriteMinSize is the smallest log that is rewritten automatically, so a
+// small log is not compacted every time it doubles.
+const aofRewriteMinSize = 1 << 20
+
+// rewriteAOF compacts the append-only file and logs the new size.
+func (s *Server) rewriteAOF() error {
+	start := time.Now()
+	if err := s.appendLog.Rewrite(); err != nil {
+		return err
+	}
+	_, size := s.appendLog.Size()
+	slog.Info("rewrote AOF", "bytes", size, "duration", time.Since(start))
+	return nil
+}
+
+// autoRewriteAOF checks the log size every second until stop is closed and
+// rewrites the log once it reaches multiple times its size after the last
+// rewrite.
+func (s *Server) autoRewriteAOF(multiple float64, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current, rewritten := s.appendLog.Size()
+			if current < aofRewriteMinSize || float64(current) < multiple*float64(rewritten) {
+				continue
+			}
+			if err := s.rewriteAOF(); err != nil && !errors.Is(err, aof.ErrRewriteInProgress) {
+				slog.Error("AOF rewrite failed", "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// replayAOF applies every record in the log at path to c and returns the
+// number of records replayed. A damaged tail left by a crash is truncated with
+// a warning.
+func replayAOF(path string, c *cache.ShardedCache) (int, error) {
+	replayed := 0
+	discarded, err := aof.Replay(path, func(rec aof.Record) {
+		replayed++
+		applyRecord(c, rec)
+	})
+	if discarded > 0 {
+		slog.Warn("truncated damaged records at the end of the AOF", "bytes", discarded, "path", path)
+	}
+	return replayed, err
+}
+
+// applyRecord applies a write from the AOF or a primary to c.
+func applyRecord(c *cache.ShardedCache, rec aof.Record) {
+	switch rec.Op {
+	case aof.OpSet, aof.OpRestore:
+		ttl := cache.NoExpiration
+		if !rec.ExpireAt.IsZero() {
+			if ttl = time.Until(rec.ExpireAt); ttl <= 0 {
+				c.Delete(rec.Key)
+				return
+			}
+		}
+		if rec.Op == aof.OpRestore {
+			// The payload's own lifetime is relative to when it was logged,
+			// so the record's expiration overrides it.
+			if err := c.Restore(rec.Key, []byte(rec.Value), max(ttl, 0), true); err != nil {
+				slog.Warn("skipping a record that does not restore", "key", rec.Key, "err", err)
+			}
+			return
+		}
+		c.SetWithTTL(rec.Key, rec.Value, ttl)
+	case aof.OpDel:
+		c.Delete(rec.Key)
+	case aof.OpAppend:
+		c.Append(rec.Key, rec.Value)
+	case aof.OpRename:
+		c.Rename(rec.Key, rec.Value)
+	case aof.OpFlush:
+		c.Clear()
+	case aof.OpDelPrefix:
+		c.DeleteByPrefix(rec.Key)
+	}
+}
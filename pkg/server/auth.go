@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var authFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mycache_auth_failures_total",
+	Help: "Total number of failed AUTH attempts, by outcome: invalid credentials, or refused because the client's IP is banned",
+}, []string{"outcome"})
+
+func init() {
+	prometheus.MustRegister(authFailuresTotal)
+}
+
+// AUTH errors.
+var (
+	errAuthInvalid  = errors.New("invalid password")
+	errAuthBanned   = errors.New("too many failed AUTH attempts; try again later")
+	errAuthRequired = errors.New("authentication required")
+	errNoPerm       = errors.New("NOPERM") // Wrapped by the errors of commands the user may not run.
+)
+
+// authLimiter counts recent AUTH failures by client IP. A server bans IPs that
+// fail AUTH -auth-max-failures times within -auth-failure-window, for
+// -auth-ban-time, so passwords cannot be guessed as fast as a client can
+// reconnect.
+type authLimiter struct {
+	maxFails int // Zero or less never bans.
+	window   time.Duration
+	banTime  time.Duration
+
+	mu        sync.Mutex
+	ips       map[string]*authFailures
+	lastSweep time.Time
+}
+
+// authFailures are the recent AUTH failures from one IP.
+type authFailures struct {
+	count       int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+func newAuthLimiter(maxFails int, window, banTime time.Duration) *authLimiter {
+	return &authLimiter{maxFails: maxFails, window: window, banTime: banTime, ips: make(map[string]*authFailures)}
+}
+
+// banned reports whether ip may not attempt AUTH at now.
+func (l *authLimiter) banned(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.ips[ip]
+	return f != nil && now.Before(f.bannedUntil)
+}
+
+// fail records a failed AUTH from ip at now and reports whether it got ip
+// banned.
+func (l *authLimiter) fail(ip string, now time.Time) bool {
+	if l.maxFails <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	f := l.ips[ip]
+	if f == nil || now.Sub(f.windowStart) > l.window {
+		f = &authFailures{windowStart: now}
+		l.ips[ip] = f
+	}
+	f.count++
+	if f.count < l.maxFails {
+		return false
+	}
+	f.bannedUntil = now.Add(l.banTime)
+	f.count, f.windowStart = 0, f.bannedUntil
+	return true
+}
+
+// succeed forgets the failures from ip.
+func (l *authLimiter) succeed(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.ips, ip)
+}
+
+// sweep drops IPs with no ban and no failures within the window, at most
+// once per window, so IPs that fail once and leave do not pile up. The
+// caller must hold l.mu.
+func (l *authLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for ip, f := range l.ips {
+		if !now.Before(f.bannedUntil) && now.Sub(f.windowStart) > l.window {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+// checkAuth authenticates the arguments of an AUTH command from addr, as
+// authenticate does, subject to the server's AUTH bans, and logs the outcome to logger.
+// Failures are counted in mycache_auth_failures_total.
+func (s *Server) checkAuth(logger *slog.Logger, addr string, args []string) (permission, error) {
+	perm, err := s.verifyAuth(logger, addr, args)
+	if err == nil {
+		logger.Info("authenticated", "method", "password", "user", authUser(args), "permission", perm.String())
+	}
+	return perm, err
+}
+
+// verifyAuth is checkAuth without logging successes, which the listeners
+// that authenticate every request would log once per request.
+func (s *Server) verifyAuth(logger *slog.Logger, addr string, args []string) (permission, error) {
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		ip = addr
+	}
+	user := authUser(args)
+	now := time.Now()
+	if s.authThrottle.banned(ip, now) {
+		authFailuresTotal.WithLabelValues("banned").Inc()
+		logger.Warn("auth failed", "user", user, "outcome", "banned")
+		return permNone, errAuthBanned
+	}
+	perm, ok := s.authenticate(args)
+	if !ok {
+		authFailuresTotal.WithLabelValues("invalid").Inc()
+		logger.Warn("auth failed", "user", user, "outcome", "invalid")
+		if s.authThrottle.fail(ip, now) {
+			logger.Warn("banning IP from AUTH", "ip", ip, "failures", s.authThrottle.maxFails, "ban", s.authThrottle.banTime)
+		}
+		return permNone, errAuthInvalid
+	}
+	s.authThrottle.succeed(ip)
+	return perm, nil
+}
+
+// authUser returns the user the arguments of AUTH log in as.
+func authUser(args []string) string {
+	if len(args) == 2 {
+		return args[0]
+	}
+	return "default"
+}
+
+// authorize checks that a request to the HTTP, gRPC, or debug listener from
+// addr, with the Authorization header value header, may run command. Its
+// credentials are either "Bearer <password>", which logs in as AUTH
+// <password> does, or HTTP Basic credentials for a user of the -users-file.
+// They are checked as AUTH checks them, bans included, and the user must have
+// the permission command needs.
+func (s *Server) authorize(addr, header, command string) error {
+	if !s.authEnabled.Load() {
+		return nil
+	}
+	args, ok := authorizationArgs(header)
+	if !ok {
+		return errAuthRequired
+	}
+	perm, err := s.verifyAuth(slog.Default(), addr, args)
+	if err != nil {
+		return err
+	}
+	if !perm.allows(command) {
+		return fmt.Errorf("%w %s requires the %s permission", errNoPerm, command, required(command))
+	}
+	return nil
+}
+
+// authorizationArgs returns the AUTH arguments an Authorization header value
+// carries: the password of "Bearer <password>", or the user and password of
+// "Basic <credentials>".
+func authorizationArgs(header string) ([]string, bool) {
+	scheme, credentials, _ := strings.Cut(header, " ")
+	switch {
+	case scheme == "Bearer":
+		return []string{credentials}, true
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return nil, false
+		}
+		name, password, ok := strings.Cut(string(decoded), ":")
+		return []string{name, password}, ok
+	}
+	return nil, false
+}
+
+// loadPassword replaces the server's password, which may have come from the
+// MYCACHE_PASSWORD environment variable through -password, with the contents
+// of -password-file, so the password need not appear in the process list. A
+// trailing newline in the file is ignored.
+func (s *Server) loadPassword() error {
+	if s.config.passwordFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.config.passwordFile)
+	if err != nil {
+		return fmt.Errorf("read password file: %w", err)
+	}
+	s.password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
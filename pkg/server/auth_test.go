@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestAuthBruteForceBan(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+
+	invalid := testutil.ToFloat64(authFailuresTotal.WithLabelValues("invalid"))
+	banned := testutil.ToFloat64(authFailuresTotal.WithLabelValues("banned"))
+	srv := startServer(t, WithCache(c), WithPassword("hunter2"), withConfig(func(c *Config) { c.authMaxFails = 3 }))
+	attempt := func(password string) string {
+		conn := dial(t, srv)
+		return configCommand(t, conn, bufio.NewReader(conn), "AUTH "+password)
+	}
+	for i, guess := range []string{"password", "123456", "letmein"} {
+		if got := attempt(guess); got != "ERROR: Invalid password" {
+			t.Fatalf("guess %d: expected a failure, got %q", i, got)
+		}
+	}
+	// Even the right password is refused while the IP is banned.
+	if got := attempt("hunter2"); got != "ERROR: too many failed AUTH attempts; try again later" {
+		t.Fatalf("expected the IP to be banned, got %q", got)
+	}
+	if got := testutil.ToFloat64(authFailuresTotal.WithLabelValues("invalid")) - invalid; got != 3 {
+		t.Fatalf("expected 3 invalid attempts counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(authFailuresTotal.WithLabelValues("banned")) - banned; got != 1 {
+		t.Fatalf("expected 1 banned attempt counted, got %v", got)
+	}
+}
+
+func TestAuthLimiterWindow(t *testing.T) {
+	l := newAuthLimiter(5, time.Minute, 5*time.Minute)
+	now := time.Now()
+	for i := 1; i < l.maxFails; i++ {
+		if l.fail("10.0.0.1", now) {
+			t.Fatalf("failure %d: expected no ban yet", i)
+		}
+	}
+	// Failures outside the window start over.
+	now = now.Add(l.window + time.Second)
+	if l.fail("10.0.0.1", now) || l.banned("10.0.0.1", now) {
+		t.Fatal("expected old failures to be forgotten")
+	}
+	for i := 1; i < l.maxFails; i++ {
+		l.fail("10.0.0.1", now)
+	}
+	if !l.banned("10.0.0.1", now) || l.banned("10.0.0.2", now) {
+		t.Fatal("expected only the failing IP to be banned")
+	}
+	if l.banned("10.0.0.1", now.Add(l.banTime)) {
+		t.Fatal("expected the ban to end")
+	}
+
+	// A success forgets the failures, and idle IPs are swept.
+	l.succeed("10.0.0.1")
+	l.fail("10.0.0.3", now)
+	l.fail("10.0.0.4", now.Add(2*l.window))
+	if _, ok := l.ips["10.0.0.3"]; ok || len(l.ips) != 1 {
+		t.Fatalf("expected idle IPs to be swept, got %v", l.ips)
+	}
+}
+
+func TestLoadPassword(t *testing.T) {
+	config := NewConfig()
+	config.authPassword = "from-env"
+	srv := New(WithConfig(config))
+	if err := srv.loadPassword(); err != nil || srv.password != "from-env" {
+		t.Fatalf("expected the password to be kept without a file, got %q, %v", srv.password, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(path, []byte("from-file\n"), 0o600)
+	srv.config.passwordFile = path
+	if err := srv.loadPassword(); err != nil || srv.password != "from-file" {
+		t.Fatalf("expected the file password to win, got %q, %v", srv.password, err)
+	}
+	if srv.config.authPassword != "from-env" {
+		t.Fatalf("expected -password to be left alone, got %q", srv.config.authPassword)
+	}
+	config.passwordFile = path
+	srv = New(WithConfig(config), WithPassword("from-option"))
+	if err := srv.configure(); err != nil || srv.password != "from-option" {
+		t.Fatalf("expected WithPassword to win over the file, got %q, %v", srv.password, err)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// clientRegistry holds a client for every open connection on a server's TCP
+// listener, for CLIENT LIST and CLIENT KILL. Each connection
+// registers itself when it starts and unregisters when its handler returns,
+// so killing a client only closes its connection.
+type clientRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	byID   map[int64]*client
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{byID: make(map[int64]*client)}
+}
+
+// client is the metadata of a connection. Its connection goroutine updates
+// the fields under mu while CLIENT LIST reads them from other connections.
+type client struct {
+	id        int64
+	addr      string
+	conn      net.Conn
+	connected time.Time
+	log       *slog.Logger // Logs with the client's id and address.
+
+	mu            sync.Mutex
+	name          string
+	lastCommand   string
+	lastActive    time.Time
+	commands      int64
+	authenticated bool
+	db            int
+}
+
+// register adds a client for conn, logging the connection, and returns it.
+func (r *clientRegistry) register(conn net.Conn, authenticated bool) *client {
+	now := time.Now()
+	r.mu.Lock()
+	r.nextID++
+	cl := &client{
+		id:            r.nextID,
+		addr:          conn.RemoteAddr().String(),
+		conn:          conn,
+		connected:     now,
+		lastActive:    now,
+		authenticated: authenticated,
+	}
+	r.byID[cl.id] = cl
+	r.mu.Unlock()
+	cl.log = slog.With("conn_id", cl.id, "remote_addr", cl.addr)
+	cl.log.Info("connection opened")
+	return cl
+}
+
+// unregister removes cl, logging the connection's end. Only cl's own
+// connection goroutine calls it, once.
+func (r *clientRegistry) unregister(cl *client) {
+	r.mu.Lock()
+	delete(r.byID, cl.id)
+	r.mu.Unlock()
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.log.Info("connection closed", "duration", time.Since(cl.connected), "commands", cl.commands)
+}
+
+// list returns the registered clients, sorted by id.
+func (r *clientRegistry) list() []*client {
+	r.mu.Lock()
+	list := make([]*client, 0, len(r.byID))
+	for _, cl := range r.byID {
+		list = append(list, cl)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(list, func(a, b *client) int { return int(a.id - b.id) })
+	return list
+}
+
+// kill closes the connections of the clients match selects and returns how
+// many it closed. Each connection's read then fails, and its goroutine
+// unregisters it.
+func (r *clientRegistry) kill(match func(*client) bool) int {
+	var victims []*client
+	r.mu.Lock()
+	for _, cl := range r.byID {
+		if match(cl) {
+			victims = append(victims, cl)
+		}
+	}
+	r.mu.Unlock()
+	for _, cl := range victims {
+		cl.conn.Close()
+	}
+	return len(victims)
+}
+
+// noteCommand records that the client ran command in database db.
+func (cl *client) noteCommand(command string, db int) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.lastCommand = command
+	cl.lastActive = time.Now()
+	cl.commands++
+	cl.db = db
+}
+
+// setAuthenticated records that the client has authenticated.
+func (cl *client) setAuthenticated() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.authenticated = true
+}
+
+func (cl *client) setName(name string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.name = name
+}
+
+func (cl *client) getName() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.name
+}
+
+// writeClientList writes a count line followed by one line per client:
+//
+//	id=<id> addr=<addr> name=<name> age=<seconds> idle=<seconds> db=<db> cmd=<command> commands=<n> auth=<bool>
+func writeClientList(w io.Writer, list []*client) {
+	now := time.Now()
+	protocol.WriteReply(w, protocol.Integer(int64(len(list))))
+	for _, cl := range list {
+		cl.mu.Lock()
+		line := fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d db=%d cmd=%s commands=%d auth=%t",
+			cl.id, cl.addr, cl.name, int64(now.Sub(cl.connected).Seconds()), int64(now.Sub(cl.lastActive).Seconds()),
+			cl.db, strings.ToLower(cl.lastCommand), cl.commands, cl.authenticated)
+		cl.mu.Unlock()
+		protocol.WriteReply(w, protocol.Status(line))
+	}
+}
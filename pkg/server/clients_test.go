@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// clientLine returns the CLIENT LIST line for the connection from addr.
+func clientLine(t *testing.T, w io.Writer, r *bufio.Reader, addr string) string {
+	t.Helper()
+	var n int
+	if _, err := fmt.Sscan(configCommand(t, w, r, "CLIENT LIST"), &n); err != nil {
+		t.Fatal(err)
+	}
+	found := ""
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(line, " addr="+addr+" ") {
+			found = strings.TrimSuffix(line, "\n")
+		}
+	}
+	return found
+}
+
+func TestClientListAndKill(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	admin := dial(t, srv)
+	ar := bufio.NewReader(admin)
+	victim := dial(t, srv)
+	vr := bufio.NewReader(victim)
+
+	for _, tc := range []struct{ cmd, want string }{
+		{"CLIENT GETNAME", "(nil)"},
+		{"CLIENT SETNAME dashboard", "OK"},
+		{"CLIENT GETNAME", "dashboard"},
+		{"SELECT 5", "OK"},
+		{"SET k v", "OK"},
+	} {
+		if got := configCommand(t, victim, vr, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+
+	addr := victim.LocalAddr().String()
+	want := regexp.MustCompile(`^id=(\d+) addr=` + regexp.QuoteMeta(addr) + ` name=dashboard age=\d+ idle=\d+ db=5 cmd=set commands=5 auth=true$`)
+	line := clientLine(t, admin, ar, addr)
+	m := want.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("expected a line matching %s, got %q", want, line)
+	}
+
+	for _, tc := range []struct{ cmd, want string }{
+		{"CLIENT KILL ID x", "ERROR: client id must be an integer"},
+		{"CLIENT KILL ADDR 127.0.0.1:1", "0"},
+		{"CLIENT", "ERROR: CLIENT requires LIST, KILL ID <id>, KILL ADDR <addr>, SETNAME <name>, or GETNAME"},
+		{"CLIENT KILL ID " + m[1], "1"},
+	} {
+		if got := configCommand(t, admin, ar, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+	if _, err := vr.ReadString('\n'); err == nil {
+		t.Fatal("expected the killed connection to be closed")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for clientLine(t, admin, ar, addr) != "" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the killed client to leave CLIENT LIST")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientListShowsAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), WithPassword("hunter2"))
+	admin := dial(t, srv)
+	ar := bufio.NewReader(admin)
+	configCommand(t, admin, ar, "AUTH hunter2")
+	other := dial(t, srv)
+	configCommand(t, other, bufio.NewReader(other), "PING")
+
+	line := clientLine(t, admin, ar, other.LocalAddr().String())
+	if !strings.HasSuffix(line, " cmd=ping commands=1 auth=false") {
+		t.Fatalf("expected an unauthenticated client, got %q", line)
+	}
+	if line := clientLine(t, admin, ar, admin.LocalAddr().String()); !strings.HasSuffix(line, " auth=true") {
+		t.Fatalf("expected the authenticated client, got %q", line)
+	}
+}
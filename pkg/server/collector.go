@@ -0,0 +1,98 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// cacheCollector is a prometheus.Collector that reads the cache's statistics
+// at scrape time, so exported values are never stale.
+type cacheCollector struct {
+	cache *cache.ShardedCache
+
+	keys        *prometheus.Desc
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	sets        *prometheus.Desc
+	deletes     *prometheus.Desc
+	evictions   *prometheus.Desc
+	staleHits   *prometheus.Desc
+	memoryBytes *prometheus.Desc
+	savedBytes  *prometheus.Desc
+	pending     *prometheus.Desc
+	dropped     *prometheus.Desc
+
+	shardKeys      *prometheus.Desc
+	shardHits      *prometheus.Desc
+	shardMisses    *prometheus.Desc
+	shardEvictions *prometheus.Desc
+}
+
+// newCacheCollector creates a collector for the given cache.
+func newCacheCollector(c *cache.ShardedCache) *cacheCollector {
+	shardLabels := []string{"shard"}
+	return &cacheCollector{
+		cache:       c,
+		keys:        prometheus.NewDesc("mycache_keys", "Current number of keys in the cache", nil, nil),
+		hits:        prometheus.NewDesc("mycache_hits_total", "Total number of cache hits", nil, nil),
+		misses:      prometheus.NewDesc("mycache_misses_total", "Total number of cache misses", nil, nil),
+		sets:        prometheus.NewDesc("mycache_sets_total", "Total number of cache writes", nil, nil),
+		deletes:     prometheus.NewDesc("mycache_deletes_total", "Total number of keys deleted", nil, nil),
+		evictions:   prometheus.NewDesc("mycache_evictions_total", "Total number of entries evicted due to capacity", nil, nil),
+		staleHits:   prometheus.NewDesc("mycache_stale_hits_total", "Total number of hits served from expired entries", nil, nil),
+		memoryBytes: prometheus.NewDesc("mycache_memory_bytes", "Approximate memory used by cached entries", nil, nil),
+		savedBytes:  prometheus.NewDesc("mycache_compression_saved_bytes", "Bytes saved by storing values compressed", nil, nil),
+		pending:     prometheus.NewDesc("mycache_pending_writes", "Number of write-behind writes not yet flushed", nil, nil),
+		dropped:     prometheus.NewDesc("mycache_dropped_writes_total", "Total number of write-behind writes never delivered", nil, nil),
+
+		shardKeys:      prometheus.NewDesc("mycache_shard_keys", "Current number of keys in each cache shard", shardLabels, nil),
+		shardHits:      prometheus.NewDesc("mycache_shard_hits", "Number of cache hits served by each shard", shardLabels, nil),
+		shardMisses:    prometheus.NewDesc("mycache_shard_misses", "Number of cache misses in each shard", shardLabels, nil),
+		shardEvictions: prometheus.NewDesc("mycache_shard_evictions", "Number of entries evicted from each shard", shardLabels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (cc *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.keys
+	ch <- cc.hits
+	ch <- cc.misses
+	ch <- cc.sets
+	ch <- cc.deletes
+	ch <- cc.evictions
+	ch <- cc.staleHits
+	ch <- cc.memoryBytes
+	ch <- cc.savedBytes
+	ch <- cc.pending
+	ch <- cc.dropped
+	ch <- cc.shardKeys
+	ch <- cc.shardHits
+	ch <- cc.shardMisses
+	ch <- cc.shardEvictions
+}
+
+// Collect implements prometheus.Collector.
+func (cc *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	st := cc.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(cc.keys, prometheus.GaugeValue, float64(st.Items))
+	ch <- prometheus.MustNewConstMetric(cc.hits, prometheus.CounterValue, float64(st.Hits))
+	ch <- prometheus.MustNewConstMetric(cc.misses, prometheus.CounterValue, float64(st.Misses))
+	ch <- prometheus.MustNewConstMetric(cc.sets, prometheus.CounterValue, float64(st.Sets))
+	ch <- prometheus.MustNewConstMetric(cc.deletes, prometheus.CounterValue, float64(st.Deletes))
+	ch <- prometheus.MustNewConstMetric(cc.evictions, prometheus.CounterValue, float64(st.Evictions))
+	ch <- prometheus.MustNewConstMetric(cc.staleHits, prometheus.CounterValue, float64(st.StaleHits))
+	ch <- prometheus.MustNewConstMetric(cc.memoryBytes, prometheus.GaugeValue, float64(cc.cache.MemoryUsage()))
+	ch <- prometheus.MustNewConstMetric(cc.savedBytes, prometheus.GaugeValue, float64(st.BytesSaved))
+	ch <- prometheus.MustNewConstMetric(cc.pending, prometheus.GaugeValue, float64(cc.cache.PendingWrites()))
+	ch <- prometheus.MustNewConstMetric(cc.dropped, prometheus.CounterValue, float64(cc.cache.DroppedWrites()))
+
+	for i, s := range cc.cache.ShardStats() {
+		shard := strconv.Itoa(i)
+		ch <- prometheus.MustNewConstMetric(cc.shardKeys, prometheus.GaugeValue, float64(s.Items), shard)
+		ch <- prometheus.MustNewConstMetric(cc.shardHits, prometheus.GaugeValue, float64(s.Hits), shard)
+		ch <- prometheus.MustNewConstMetric(cc.shardMisses, prometheus.GaugeValue, float64(s.Misses), shard)
+		ch <- prometheus.MustNewConstMetric(cc.shardEvictions, prometheus.GaugeValue, float64(s.Evictions), shard)
+	}
+}
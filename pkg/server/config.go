@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// atomicBool is a boolean setting that CONFIG SET can change while
+// connections read it.
+type atomicBool struct{ atomic.Bool }
+
+func (b *atomicBool) String() string { return strconv.FormatBool(b.Load()) }
+
+// atomicDuration is a duration setting that CONFIG SET can change while
+// connections read it.
+type atomicDuration struct{ atomic.Int64 }
+
+// Get returns the current duration.
+func (d *atomicDuration) Get() time.Duration { return time.Duration(d.Load()) }
+func (d *atomicDuration) String() string     { return d.Get().String() }
+
+// configSetting is a setting CONFIG SET can change while the server runs.
+type configSetting struct {
+	get     func(s *Server) string
+	set     func(s *Server, value string) error
+	sharded bool // Only the sharded engine has the setting.
+}
+
+// runtimeConfig lists the settings CONFIG SET accepts, keyed by flag name.
+// CONFIG GET reads every other setting from the server's Config.
+var runtimeConfig = map[string]configSetting{
+	"auth": {
+		get: func(s *Server) string { return s.authEnabled.String() },
+		set: func(s *Server, value string) error {
+			enable, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("auth must be true or false")
+			}
+			if enable && s.config.memcachedAddr != "" {
+				return errors.New("auth cannot be enabled while -memcached-addr is serving unauthenticated clients")
+			}
+			s.authEnabled.Store(enable)
+			return nil
+		},
+	},
+	"idle-timeout": {
+		get: func(s *Server) string { return s.idleTimeout.String() },
+		set: func(s *Server, value string) error {
+			d, err := parseConfigDuration(value)
+			if err == nil {
+				s.idleTimeout.Store(int64(d))
+			}
+			return err
+		},
+	},
+	"slowlog-threshold": {
+		get: func(s *Server) string { return s.slowlog.threshold.String() },
+		set: func(s *Server, value string) error {
+			d, err := parseConfigDuration(value)
+			if err == nil {
+				s.slowlog.threshold.Store(int64(d))
+			}
+			return err
+		},
+	},
+	"default-ttl": {
+		get: func(s *Server) string { return s.cache.DefaultTTL().String() },
+		set: func(s *Server, value string) error {
+			d, err := parseConfigDuration(value)
+			if err == nil {
+				s.cache.SetDefaultTTL(d)
+			}
+			return err
+		},
+		sharded: true,
+	},
+	"max-bytes": {
+		get: func(s *Server) string { return strconv.FormatInt(s.cache.MaxBytes(), 10) },
+		set: func(s *Server, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("max-bytes must be a non-negative integer")
+			}
+			s.cache.SetMaxBytes(n)
+			return nil
+		},
+		sharded: true,
+	},
+}
+
+// configGet returns the current value of the setting named by its flag name.
+// The password is never returned. With the simple engine, the sharded
+// engine's settings read as configured.
+func (s *Server) configGet(name string) (string, error) {
+	if setting, ok := runtimeConfig[name]; ok && (!setting.sharded || s.cache != nil) {
+		return setting.get(s), nil
+	}
+	f := s.settings.Lookup(name)
+	if f == nil {
+		return "", fmt.Errorf("unknown config parameter %q", name)
+	}
+	if name == "password" {
+		return "", errors.New("password cannot be read")
+	}
+	return f.Value.String(), nil
+}
+
+// configSet changes a runtime setting, rejecting settings that are fixed at
+// startup.
+func (s *Server) configSet(name, value string) error {
+	setting, ok := runtimeConfig[name]
+	if !ok {
+		if s.settings.Lookup(name) == nil {
+			return fmt.Errorf("unknown config parameter %q", name)
+		}
+		return fmt.Errorf("%s cannot be changed at runtime; restart with -%s", name, name)
+	}
+	if setting.sharded && s.cache == nil {
+		return fmt.Errorf("%s requires -engine=sharded", name)
+	}
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return setting.set(s, value)
+}
+
+// parseConfigDuration parses a non-negative duration such as 30s.
+func parseConfigDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New("value must be a non-negative duration such as 30s")
+	}
+	return d, nil
+}
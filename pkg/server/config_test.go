@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// configCommand sends one command to w and returns its single-line reply.
+func configCommand(t *testing.T, w io.Writer, r *bufio.Reader, command string) string {
+	t.Helper()
+	fmt.Fprintln(w, command)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestConfigGetSet(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+
+	tests := []struct{ command, want string }{
+		{"CONFIG SET idle-timeout 90s", "OK"},
+		{"CONFIG GET idle-timeout", "idle-timeout 1m30s"},
+		{"CONFIG SET default-ttl 1m", "OK"},
+		{"CONFIG GET default-ttl", "default-ttl 1m0s"},
+		{"CONFIG SET max-bytes 4096", "OK"},
+		{"CONFIG GET max-bytes", "max-bytes 4096"},
+		{"CONFIG GET shards", "shards 16"},
+		{"CONFIG SET idle-timeout soon", "ERROR: value must be a non-negative duration such as 30s"},
+		{"CONFIG SET max-bytes -1", "ERROR: max-bytes must be a non-negative integer"},
+		{"CONFIG SET shards 4", "ERROR: shards cannot be changed at runtime; restart with -shards"},
+		{"CONFIG SET cert other.crt", "ERROR: cert cannot be changed at runtime; restart with -cert"},
+		{"CONFIG GET password", "ERROR: password cannot be read"},
+		{`CONFIG GET bogus`, `ERROR: unknown config parameter "bogus"`},
+		{"CONFIG", "ERROR: CONFIG requires GET <param> or SET <param> <value>"},
+	}
+	for _, tt := range tests {
+		if got := configCommand(t, conn, r, tt.command); got != tt.want {
+			t.Fatalf("%s: expected %q, got %q", tt.command, tt.want, got)
+		}
+	}
+	if srv.idleTimeout.Get() != 90*time.Second || c.DefaultTTL() != time.Minute || c.MaxBytes() != 4096 {
+		t.Fatalf("expected the settings to take effect, got %s, %s, %d", srv.idleTimeout.Get(), c.DefaultTTL(), c.MaxBytes())
+	}
+	if srv.config.idleTimeout != 0 {
+		t.Fatalf("expected -idle-timeout to be left alone, got %s", srv.config.idleTimeout)
+	}
+}
+
+func TestConfigSetAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), withConfig(func(c *Config) { c.authPassword = "hunter2" }))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+
+	if got := configCommand(t, conn, r, "CONFIG SET auth yes"); got != "ERROR: auth must be true or false" {
+		t.Fatalf("expected a validation error, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "CONFIG SET auth true"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+
+	// New connections now have to authenticate.
+	other := dial(t, srv)
+	or := bufio.NewReader(other)
+	if got := configCommand(t, other, or, "EXISTS k"); !strings.HasPrefix(got, "ERROR: Authentication required") {
+		t.Fatalf("expected authentication to be required, got %q", got)
+	}
+	if got := configCommand(t, other, or, "AUTH hunter2"); got != "OK" {
+		t.Fatalf("expected AUTH to succeed, got %q", got)
+	}
+	if srv.config.authEnabled {
+		t.Fatal("expected -auth to be left alone")
+	}
+}
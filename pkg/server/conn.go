@@ -0,0 +1,1704 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// Prometheus metrics.
+var (
+	reqCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mycache_requests_total",
+		Help: "Total number of requests processed",
+	}, []string{"command"})
+	errorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mycache_errors_total",
+		Help: "Total number of errors encountered",
+	}, []string{"command"})
+	processingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mycache_processing_seconds",
+		Help:    "Histogram of request processing durations",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command"})
+	// Size buckets from 16B to 16MB.
+	keyBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mycache_key_bytes",
+		Help:    "Histogram of the key sizes stored by SET",
+		Buckets: prometheus.ExponentialBuckets(16, 4, 11),
+	})
+	valueBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mycache_value_bytes",
+		Help:    "Histogram of the value sizes stored by SET",
+		Buckets: prometheus.ExponentialBuckets(16, 4, 11),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reqCounter)
+	prometheus.MustRegister(errorCounter)
+	prometheus.MustRegister(processingDuration)
+	prometheus.MustRegister(keyBytes)
+	prometheus.MustRegister(valueBytes)
+}
+
+// observeSet records the sizes of a key and value a SET stored.
+func observeSet(key, value string) {
+	keyBytes.Observe(float64(len(key)))
+	valueBytes.Observe(float64(len(value)))
+}
+
+// replyError reports a failed cache operation to the client, logs it, and
+// counts it against command. Misses get a fixed message so clients can match
+// on it, and are only logged at debug level.
+func replyError(w io.Writer, logger *slog.Logger, command string, err error) {
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		protocol.WriteReply(w, protocol.Error("key not found"))
+		logger.Debug("command failed", "command", command, "err", err)
+	} else {
+		if errors.Is(err, cache.ErrWrongType) {
+			protocol.WriteReply(w, protocol.Error("WRONGTYPE "+err.Error()))
+		} else {
+			protocol.WriteReply(w, protocol.Error(err.Error()))
+		}
+		logger.Info("command failed", "command", command, "err", err)
+	}
+	errorCounter.WithLabelValues(command).Inc()
+}
+
+// handleConnection processes a single connection. If authentication is enabled,
+// it requires an "AUTH <password>" command before any other commands are accepted.
+// It records metrics for each command processed.
+//
+// Commands are one line each, except the binary-safe "SET <key> $<nbytes>",
+// which is followed by exactly nbytes of raw value and a newline. GET replies
+// in the same framing, as "$<nbytes>\r\n<value>\r\n".
+func (s *Server) handleConnection(conn net.Conn) {
+	st := s.store // The engine, which serves simpleCommands.
+	c := s.cache  // Nil with the simple engine; only its commands use it.
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	timeouts := s.newConnTimeouts(conn)
+	defer timeouts.flush(w)
+	authenticated := !s.authEnabled.Load() // if auth is not enabled, consider the connection authenticated
+	var ks keyspace                        // The SELECTed database; connections start on 0.
+	perm := permAdmin                      // What the connection's user may run.
+	self := s.clients.register(conn, authenticated)
+	defer s.clients.unregister(self)
+	logger := self.log
+	timeouts.log = logger
+	if !authenticated {
+		timeouts.awaitCommand()
+		granted, ok, err := s.certPermission(conn)
+		if err != nil {
+			logger.Warn("TLS handshake failed", "err", err)
+			return
+		}
+		if ok {
+			authenticated, perm = true, granted
+			self.setAuthenticated()
+			logger.Info("authenticated", "method", "certificate")
+		}
+	}
+	limits := s.newConnLimits(conn)
+
+	// Once the connection subscribes or monitors, another goroutine writes
+	// published messages or fed commands to w too. out serializes them: this
+	// goroutine holds it except while waiting for the next command, so
+	// replies are never split.
+	var sub *subscriber
+	var mon *monitor
+	var out sync.Mutex
+	defer func() {
+		if sub != nil {
+			sub.close(s.pubsub)
+		}
+		if mon != nil {
+			s.monitors.remove(mon)
+		}
+	}()
+	addr := conn.RemoteAddr().String()
+	out.Lock()
+	defer out.Unlock()
+
+	slot := workerSlot{slots: s.workerSlots}
+	defer slot.release()
+	hold := roleHold{server: s}
+	defer hold.release()
+	share := txShare{mu: &s.txMu}
+	defer share.release()
+	var tx *transaction // From MULTI until EXEC or DISCARD.
+	var watches watchSet
+	defer func() {
+		if tx.replaying() {
+			tx.finish()
+		}
+	}()
+	queued := 0
+	for {
+		// While EXEC runs a transaction, its locks are held throughout.
+		if !tx.replaying() {
+			slot.release()
+			hold.release()
+			share.release()
+		}
+		// Replies are buffered while more pipelined commands are waiting, and
+		// flushed once the client has nothing more in flight or
+		// pipelineMaxQueued replies have piled up.
+		if queued > 0 && (r.Buffered() == 0 || queued >= pipelineMaxQueued) {
+			if err := timeouts.flush(w); err != nil {
+				return
+			}
+			queued = 0
+		}
+		timeouts.awaitCommand()
+		out.Unlock()
+		line, err := readLine(r, s.config.maxRequest)
+		out.Lock()
+		if tx.done(err) {
+			r, w = tx.finish()
+			tx = nil
+			queued++ // The transaction's replies are yet to be flushed.
+			continue
+		}
+		if errors.Is(err, errLineTooLong) {
+			replyError(w, logger, "request_too_large", err)
+			queued++
+			continue
+		}
+		if err != nil {
+			if err != io.EOF && !timeouts.timedOut(err) {
+				logger.Warn("connection error", "err", err)
+			}
+			return
+		}
+		timeouts.beginCommand()
+		slot.acquire()
+		if !tx.replaying() {
+			share.acquire()
+		}
+		start := time.Now()
+		cmd, err := protocol.ParseCommand([]byte(line))
+		if err != nil {
+			continue
+		}
+		parts, command := cmd.Args, cmd.Name
+		queued++
+		self.noteCommand(command, ks.db)
+		// A transaction's commands were rate limited as they were queued.
+		if !tx.replaying() {
+			if ok, disconnect := limits.allow(); !ok {
+				protocol.WriteReply(w, protocol.Error("rate limit exceeded"))
+				if disconnect {
+					return
+				}
+				continue
+			}
+		}
+
+		// Require authentication if enabled. PING is exempt so health checks
+		// work without credentials.
+		if s.authEnabled.Load() && !authenticated && command != "PING" {
+			if command != "AUTH" {
+				protocol.WriteReply(w, protocol.Error("Authentication required. Please use AUTH <password>"))
+				errorCounter.WithLabelValues("unauthenticated").Inc()
+				continue
+			}
+			granted, err := s.checkAuth(logger, addr, parts[1:])
+			if err != nil {
+				if errors.Is(err, errAuthBanned) {
+					protocol.WriteReply(w, protocol.Error(err.Error()))
+				} else {
+					protocol.WriteReply(w, protocol.Error("Invalid password"))
+				}
+				errorCounter.WithLabelValues("AUTH").Inc()
+				return // Close connection on failed auth.
+			}
+			authenticated, perm = true, granted
+			self.setAuthenticated()
+			protocol.WriteReply(w, protocol.Status("OK"))
+			s.countCommand("AUTH")
+			processingDuration.WithLabelValues("AUTH").Observe(time.Since(start).Seconds())
+			continue
+		}
+
+		if perm < permAdmin && !perm.allows(command) {
+			protocol.WriteReply(w, protocol.Error(fmt.Sprintf("NOPERM %s requires the %s permission", command, required(command))))
+			errorCounter.WithLabelValues("noperm").Inc()
+			tx.abort()
+			continue
+		}
+		if c == nil && !simpleCommands[command] {
+			protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s is not served by the simple engine; use -engine=sharded", command)))
+			errorCounter.WithLabelValues(command).Inc()
+			tx.abort()
+			continue
+		}
+		if !hold.admit(command) {
+			protocol.WriteReply(w, protocol.Error(errReadOnly.Error()))
+			errorCounter.WithLabelValues(command).Inc()
+			tx.abort()
+			continue
+		}
+
+		// A subscribed connection only manages its subscriptions.
+		if sub != nil && len(sub.channels) > 0 && command != "SUBSCRIBE" && command != "UNSUBSCRIBE" && command != "PING" {
+			protocol.WriteReply(w, protocol.Error("only SUBSCRIBE, UNSUBSCRIBE, and PING are allowed while subscribed"))
+			errorCounter.WithLabelValues(command).Inc()
+			continue
+		}
+		if mon != nil && command != "PING" && command != "QUIT" {
+			protocol.WriteReply(w, protocol.Error("only PING and QUIT are allowed while monitoring"))
+			errorCounter.WithLabelValues(command).Inc()
+			continue
+		}
+
+		// Between MULTI and EXEC, commands are queued as sent, data blocks
+		// and all, to be run by EXEC.
+		if tx != nil && !tx.replaying() && !txControl[command] {
+			if err := checkQueueable(command, parts); err != nil {
+				protocol.WriteReply(w, protocol.Error(err.Error()))
+				errorCounter.WithLabelValues(command).Inc()
+				tx.abort()
+				continue
+			}
+			var block *string
+			if n, ok := dataBlockLength(command, parts); ok {
+				value, err := readDataBlock(r, n, s.store.MaxValueBytes())
+				if err != nil {
+					if timeouts.timedOut(err) {
+						return
+					}
+					replyError(w, logger, command, err)
+					if errors.Is(err, cache.ErrValueTooLarge) {
+						tx.abort()
+						continue
+					}
+					return // The connection is out of sync with the client.
+				}
+				block = &value
+			}
+			tx.queue(command, line, block)
+			protocol.WriteReply(w, protocol.Status("QUEUED"))
+			continue
+		}
+		s.monitors.feed(ks.db, addr, parts)
+
+		// Process the command.
+		ks.mapKeys(command, parts)
+		switch command {
+		case "PING":
+			s.countCommand("PING")
+			if len(parts) > 1 {
+				protocol.WriteReply(w, protocol.Status(strings.Join(parts[1:], " ")))
+			} else {
+				protocol.WriteReply(w, protocol.Status("PONG"))
+			}
+		case "ECHO":
+			s.countCommand("ECHO")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("ECHO requires a message"))
+				errorCounter.WithLabelValues("ECHO").Inc()
+				continue
+			}
+			protocol.WriteReply(w, protocol.Status(strings.Join(parts[1:], " ")))
+		case "QUIT":
+			s.countCommand("QUIT")
+			protocol.WriteReply(w, protocol.Status("OK"))
+			processingDuration.WithLabelValues("QUIT").Observe(time.Since(start).Seconds())
+			return // The deferred flush sends the reply before closing.
+		case "MULTI":
+			s.countCommand("MULTI")
+			if tx != nil {
+				protocol.WriteReply(w, protocol.Error("MULTI calls can not be nested"))
+				errorCounter.WithLabelValues("MULTI").Inc()
+				continue
+			}
+			tx = &transaction{}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "WATCH", "UNWATCH":
+			s.countCommand(command)
+			switch {
+			case tx != nil:
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s inside MULTI is not allowed", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			case command == "UNWATCH":
+				watches = nil
+			case len(parts) < 2:
+				protocol.WriteReply(w, protocol.Error("WATCH requires at least one key"))
+				errorCounter.WithLabelValues("WATCH").Inc()
+				continue
+			default:
+				if watches == nil {
+					watches = make(watchSet)
+				}
+				watches.add(c, parts[1:])
+			}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "EXEC":
+			// EXEC replies with the number of queued commands, and then the
+			// commands run in order as they are read back from the queue.
+			s.countCommand("EXEC")
+			watched := watches
+			watches = nil
+			switch {
+			case tx == nil:
+				protocol.WriteReply(w, protocol.Error("EXEC without MULTI"))
+				errorCounter.WithLabelValues("EXEC").Inc()
+				continue
+			case tx.aborted:
+				tx = nil
+				protocol.WriteReply(w, protocol.Error(errExecAbort))
+				errorCounter.WithLabelValues("EXEC").Inc()
+				continue
+			}
+			r, w = tx.exec(r, w, &share)
+			// The watched keys are checked under txMu, so none can change
+			// between the check and the transaction.
+			if watched.changed(c) {
+				r, w = tx.finish()
+				tx = nil
+				protocol.WriteReply(w, protocol.Nil)
+				continue
+			}
+			// Hold the role for the whole transaction, taking it after txMu
+			// as every other command does.
+			if tx.writes && !hold.admitWrite() {
+				r, w = tx.finish()
+				tx = nil
+				protocol.WriteReply(w, protocol.Error(errReadOnly.Error()))
+				errorCounter.WithLabelValues("EXEC").Inc()
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(int64(tx.count)))
+		case "DISCARD":
+			s.countCommand("DISCARD")
+			if tx == nil {
+				protocol.WriteReply(w, protocol.Error("DISCARD without MULTI"))
+				errorCounter.WithLabelValues("DISCARD").Inc()
+				continue
+			}
+			tx, watches = nil, nil
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "SET", "PSETEX":
+			// PSETEX key milliseconds value also sets a TTL. Either takes
+			// the value as a data block if it is given as $<nbytes>.
+			s.countCommand(command)
+			valueAt := 2
+			if command == "PSETEX" {
+				valueAt = 3
+			}
+			if len(parts) <= valueAt {
+				if command == "PSETEX" {
+					protocol.WriteReply(w, protocol.Error("PSETEX requires key, milliseconds, and value"))
+				} else {
+					protocol.WriteReply(w, protocol.Error("SET requires key and value"))
+				}
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			var ttl time.Duration
+			if command == "PSETEX" {
+				ms, err := strconv.ParseInt(parts[2], 10, 64)
+				if err != nil || ms <= 0 {
+					protocol.WriteReply(w, protocol.Error("PSETEX requires a positive number of milliseconds"))
+					errorCounter.WithLabelValues(command).Inc()
+					continue
+				}
+				ttl = time.Duration(ms) * time.Millisecond
+			}
+			key := parts[1]
+			value := strings.Join(parts[valueAt:], " ")
+			if n, ok := parseLength(parts[valueAt]); ok && len(parts) == valueAt+1 {
+				var err error
+				if value, err = readDataBlock(r, n, s.store.MaxValueBytes()); err != nil {
+					if timeouts.timedOut(err) {
+						return
+					}
+					replyError(w, logger, command, err)
+					if errors.Is(err, cache.ErrValueTooLarge) {
+						continue
+					}
+					return // The connection is out of sync with the client.
+				}
+			}
+			s.logMu.Lock()
+			err := s.set(key, value, ttl)
+			if err == nil {
+				s.logWrite(s.setRecord(key, value))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			observeSet(ks.strip(key), value)
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "SETNX":
+			s.countCommand("SETNX")
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error("SETNX requires key and value"))
+				errorCounter.WithLabelValues("SETNX").Inc()
+				continue
+			}
+			value := strings.Join(parts[2:], " ")
+			s.logMu.Lock()
+			stored := st.SetNX(parts[1], value)
+			if stored {
+				s.logWrite(s.setRecord(parts[1], value))
+			}
+			s.logMu.Unlock()
+			if stored {
+				protocol.WriteReply(w, protocol.Integer(1))
+			} else {
+				protocol.WriteReply(w, protocol.Integer(0))
+			}
+		case "CAS":
+			s.countCommand("CAS")
+			if len(parts) != 4 {
+				protocol.WriteReply(w, protocol.Error("CAS requires key, old value, and new value"))
+				errorCounter.WithLabelValues("CAS").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			swapped, err := c.CompareAndSwap(parts[1], parts[2], parts[3])
+			if err == nil && swapped {
+				s.logWrite(s.setRecord(parts[1], parts[3]))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, "CAS", err)
+			} else if swapped {
+				protocol.WriteReply(w, protocol.Integer(1))
+			} else {
+				protocol.WriteReply(w, protocol.Integer(0))
+			}
+		case "LOCK", "LOCKRENEW":
+			// LOCK name ttl-ms token takes the lease on name if it is free,
+			// and LOCKRENEW name token ttl-ms extends it for its holder.
+			s.countCommand(command)
+			if len(parts) != 4 {
+				if command == "LOCK" {
+					protocol.WriteReply(w, protocol.Error("LOCK requires name, milliseconds, and token"))
+				} else {
+					protocol.WriteReply(w, protocol.Error("LOCKRENEW requires name, token, and milliseconds"))
+				}
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			ttlAt, token := 2, parts[3]
+			if command == "LOCKRENEW" {
+				ttlAt, token = 3, parts[2]
+			}
+			ms, err := strconv.ParseInt(parts[ttlAt], 10, 64)
+			if err != nil || ms <= 0 {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires a positive number of milliseconds", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			ttl := time.Duration(ms) * time.Millisecond
+			var held bool
+			s.logMu.Lock()
+			if command == "LOCK" {
+				held = c.SetNXWithTTL(parts[1], token, ttl)
+			} else {
+				held = c.ExpireIfEquals(parts[1], token, ttl)
+			}
+			if held {
+				s.logWrite(s.setRecord(parts[1], token))
+			}
+			s.logMu.Unlock()
+			if !held {
+				protocol.WriteReply(w, protocol.Integer(0))
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(1))
+		case "UNLOCK":
+			s.countCommand("UNLOCK")
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error("UNLOCK requires name and token"))
+				errorCounter.WithLabelValues("UNLOCK").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			deleted := c.DeleteIfEquals(parts[1], parts[2])
+			if deleted {
+				s.logWrite(aof.Record{Op: aof.OpDel, Key: parts[1]})
+			}
+			s.logMu.Unlock()
+			if !deleted {
+				protocol.WriteReply(w, protocol.Integer(0))
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(1))
+		case "INCR", "DECR", "INCRBY", "DECRBY":
+			s.countCommand(command)
+			byAmount := command == "INCRBY" || command == "DECRBY"
+			if (!byAmount && len(parts) != 2) || (byAmount && len(parts) != 3) {
+				if byAmount {
+					protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key and increment", command)))
+				} else {
+					protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key", command)))
+				}
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			delta := int64(1)
+			if byAmount {
+				var err error
+				if delta, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+					protocol.WriteReply(w, protocol.Error("increment is not an integer"))
+					errorCounter.WithLabelValues(command).Inc()
+					continue
+				}
+			}
+			if command == "DECR" || command == "DECRBY" {
+				delta = -delta
+			}
+			s.logMu.Lock()
+			n, err := c.Increment(parts[1], delta)
+			if err == nil {
+				s.logWrite(s.setRecord(parts[1], strconv.FormatInt(n, 10)))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(n))
+			}
+		case "APPEND":
+			s.countCommand("APPEND")
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error("APPEND requires key and value"))
+				errorCounter.WithLabelValues("APPEND").Inc()
+				continue
+			}
+			suffix := strings.Join(parts[2:], " ")
+			if n, ok := parseLength(parts[2]); ok && len(parts) == 3 {
+				var err error
+				if suffix, err = readDataBlock(r, n, s.store.MaxValueBytes()); err != nil {
+					if timeouts.timedOut(err) {
+						return
+					}
+					replyError(w, logger, "APPEND", err)
+					if errors.Is(err, cache.ErrValueTooLarge) {
+						continue
+					}
+					return // The connection is out of sync with the client.
+				}
+			}
+			s.logMu.Lock()
+			n, err := c.Append(parts[1], suffix)
+			if err == nil {
+				s.logWrite(aof.Record{Op: aof.OpAppend, Key: parts[1], Value: suffix})
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, "APPEND", err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "GET":
+			s.countCommand("GET")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("GET requires key"))
+				errorCounter.WithLabelValues("GET").Inc()
+				continue
+			}
+			key := parts[1]
+			value, err := st.Get(key)
+			if err != nil {
+				replyError(w, logger, "GET", err)
+			} else {
+				protocol.WriteReply(w, protocol.Bulk(value))
+			}
+		case "MGET":
+			// MGET replies with one value per requested key, in request
+			// order, as GET does: in a data block, or (nil) if missing.
+			s.countCommand("MGET")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("MGET requires at least one key"))
+				errorCounter.WithLabelValues("MGET").Inc()
+				continue
+			}
+			values := c.MGet(parts[1:]...)
+			for _, key := range parts[1:] {
+				if value, ok := values[key]; ok {
+					protocol.WriteReply(w, protocol.Bulk(value))
+				} else {
+					protocol.WriteReply(w, protocol.Nil)
+				}
+			}
+		case "MSET":
+			s.countCommand("MSET")
+			if len(parts) < 3 || len(parts)%2 != 1 {
+				protocol.WriteReply(w, protocol.Error("MSET requires key-value pairs"))
+				errorCounter.WithLabelValues("MSET").Inc()
+				continue
+			}
+			pairs := make(map[string]string, (len(parts)-1)/2)
+			var sizeErr error
+			for i := 1; i < len(parts); i += 2 {
+				if err := s.checkValueSize(parts[i+1]); err != nil {
+					sizeErr = err
+				}
+				pairs[parts[i]] = parts[i+1]
+			}
+			if sizeErr != nil {
+				replyError(w, logger, "MSET", sizeErr)
+				continue
+			}
+			s.logMu.Lock()
+			c.MSet(pairs)
+			for key, value := range pairs {
+				s.logWrite(s.setRecord(key, value))
+			}
+			s.logMu.Unlock()
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "GETDEL":
+			s.countCommand("GETDEL")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("GETDEL requires key"))
+				errorCounter.WithLabelValues("GETDEL").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			value, err := st.GetDel(parts[1])
+			if err == nil {
+				s.logWrite(aof.Record{Op: aof.OpDel, Key: parts[1]})
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, "GETDEL", err)
+			} else {
+				protocol.WriteReply(w, protocol.Bulk(value))
+			}
+		case "DEL":
+			s.countCommand("DEL")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("DEL requires key"))
+				errorCounter.WithLabelValues("DEL").Inc()
+				continue
+			}
+			key := parts[1]
+			s.logMu.Lock()
+			st.Delete(key)
+			s.logWrite(aof.Record{Op: aof.OpDel, Key: key})
+			s.logMu.Unlock()
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "DELPREFIX":
+			s.countCommand("DELPREFIX")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("DELPREFIX requires a prefix"))
+				errorCounter.WithLabelValues("DELPREFIX").Inc()
+				continue
+			}
+			if strings.Contains(parts[1], internalKeyPrefix) {
+				replyError(w, logger, command, errors.New("prefix must not contain NUL bytes"))
+				continue
+			}
+			prefix := ks.key(parts[1])
+			s.logMu.Lock()
+			removed := c.DeleteByPrefix(prefix)
+			s.logWrite(aof.Record{Op: aof.OpDelPrefix, Key: prefix})
+			s.logMu.Unlock()
+			protocol.WriteReply(w, protocol.Integer(int64(removed)))
+		case "SETTAGS":
+			// Tags are kept in memory only: the append-only file records
+			// the value, so keys come back untagged after a restart.
+			s.countCommand("SETTAGS")
+			if len(parts) < 4 {
+				protocol.WriteReply(w, protocol.Error("SETTAGS requires key, value, and at least one tag"))
+				errorCounter.WithLabelValues("SETTAGS").Inc()
+				continue
+			}
+			tags, err := ks.tags(parts[3:])
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			key, value := parts[1], parts[2]
+			s.logMu.Lock()
+			err = c.SetWithTagsE(key, value, tags...)
+			if err == nil {
+				s.logWrite(s.setRecord(key, value))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "INVALTAG":
+			s.countCommand("INVALTAG")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("INVALTAG requires a tag"))
+				errorCounter.WithLabelValues("INVALTAG").Inc()
+				continue
+			}
+			tags, err := ks.tags(parts[1:])
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			s.logMu.Lock()
+			removed := c.InvalidateTagKeys(tags[0])
+			for _, key := range removed {
+				s.logWrite(aof.Record{Op: aof.OpDel, Key: key})
+			}
+			s.logMu.Unlock()
+			protocol.WriteReply(w, protocol.Integer(int64(len(removed))))
+		case "HSET":
+			// Hashes, lists, sets, and sorted sets are logged whole after
+			// every write; see logCurrent.
+			s.countCommand("HSET")
+			if len(parts) < 4 {
+				protocol.WriteReply(w, protocol.Error("HSET requires key, field, and value"))
+				errorCounter.WithLabelValues("HSET").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			created, err := c.HSet(parts[1], parts[2], strings.Join(parts[3:], " "))
+			if err == nil {
+				s.logCurrent(parts[1], "hset")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else if created {
+				protocol.WriteReply(w, protocol.Integer(1))
+			} else {
+				protocol.WriteReply(w, protocol.Integer(0))
+			}
+		case "HGET":
+			s.countCommand("HGET")
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error("HGET requires key and field"))
+				errorCounter.WithLabelValues("HGET").Inc()
+				continue
+			}
+			value, err := c.HGet(parts[1], parts[2])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Bulk(value))
+			}
+		case "HGETALL":
+			// HGETALL replies with the number of fields, then each field
+			// and its value in data blocks, sorted by field.
+			s.countCommand("HGETALL")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("HGETALL requires key"))
+				errorCounter.WithLabelValues("HGETALL").Inc()
+				continue
+			}
+			fields, err := c.HGetAll(parts[1])
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(int64(len(fields))))
+			for _, field := range slices.Sorted(maps.Keys(fields)) {
+				protocol.WriteReply(w, protocol.Bulk(field))
+				protocol.WriteReply(w, protocol.Bulk(fields[field]))
+			}
+		case "HDEL":
+			s.countCommand("HDEL")
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error("HDEL requires key and at least one field"))
+				errorCounter.WithLabelValues("HDEL").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			removed, err := c.HDel(parts[1], parts[2:]...)
+			if removed > 0 {
+				s.logCurrent(parts[1], "hdel")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(removed)))
+			}
+		case "HINCRBY":
+			s.countCommand("HINCRBY")
+			if len(parts) != 4 {
+				protocol.WriteReply(w, protocol.Error("HINCRBY requires key, field, and increment"))
+				errorCounter.WithLabelValues("HINCRBY").Inc()
+				continue
+			}
+			delta, err := strconv.ParseInt(parts[3], 10, 64)
+			if err != nil {
+				protocol.WriteReply(w, protocol.Error("increment is not an integer"))
+				errorCounter.WithLabelValues("HINCRBY").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			n, err := c.HIncrBy(parts[1], parts[2], delta)
+			if err == nil {
+				s.logCurrent(parts[1], "hincrby")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(n))
+			}
+		case "LPUSH", "RPUSH":
+			s.countCommand(command)
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key and at least one value", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			push := c.RPush
+			if command == "LPUSH" {
+				push = c.LPush
+			}
+			s.logMu.Lock()
+			n, err := push(parts[1], parts[2:]...)
+			if err == nil {
+				s.logCurrent(parts[1], strings.ToLower(command))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "LPOP", "RPOP":
+			s.countCommand(command)
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			pop := c.RPop
+			if command == "LPOP" {
+				pop = c.LPop
+			}
+			s.logMu.Lock()
+			value, err := pop(parts[1])
+			if err == nil {
+				s.logCurrent(parts[1], strings.ToLower(command))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Bulk(value))
+			}
+		case "LRANGE", "LTRIM":
+			// LRANGE replies with the number of elements, then each element
+			// in a data block.
+			s.countCommand(command)
+			if len(parts) != 4 {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key, start, and stop", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			start, err1 := strconv.Atoi(parts[2])
+			stop, err2 := strconv.Atoi(parts[3])
+			if err1 != nil || err2 != nil {
+				protocol.WriteReply(w, protocol.Error("start and stop must be integers"))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			if command == "LTRIM" {
+				s.logMu.Lock()
+				err := c.LTrim(parts[1], start, stop)
+				if err == nil {
+					s.logCurrent(parts[1], "ltrim")
+				}
+				s.logMu.Unlock()
+				if err != nil {
+					replyError(w, logger, command, err)
+				} else {
+					protocol.WriteReply(w, protocol.Status("OK"))
+				}
+				continue
+			}
+			values, err := c.LRange(parts[1], start, stop)
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(int64(len(values))))
+			for _, value := range values {
+				protocol.WriteReply(w, protocol.Bulk(value))
+			}
+		case "LLEN":
+			s.countCommand("LLEN")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("LLEN requires key"))
+				errorCounter.WithLabelValues("LLEN").Inc()
+				continue
+			}
+			n, err := c.LLen(parts[1])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "SADD", "SREM":
+			s.countCommand(command)
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key and at least one member", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			update := c.SAdd
+			if command == "SREM" {
+				update = c.SRem
+			}
+			s.logMu.Lock()
+			n, err := update(parts[1], parts[2:]...)
+			if n > 0 {
+				s.logCurrent(parts[1], strings.ToLower(command))
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "SISMEMBER":
+			s.countCommand("SISMEMBER")
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error("SISMEMBER requires key and member"))
+				errorCounter.WithLabelValues("SISMEMBER").Inc()
+				continue
+			}
+			ok, err := c.SIsMember(parts[1], parts[2])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else if ok {
+				protocol.WriteReply(w, protocol.Integer(1))
+			} else {
+				protocol.WriteReply(w, protocol.Integer(0))
+			}
+		case "SCARD":
+			s.countCommand("SCARD")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("SCARD requires key"))
+				errorCounter.WithLabelValues("SCARD").Inc()
+				continue
+			}
+			n, err := c.SCard(parts[1])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "SMEMBERS", "SINTER", "SUNION":
+			// These reply like LRANGE, with members sorted.
+			s.countCommand(command)
+			var members []string
+			var err error
+			switch {
+			case command == "SMEMBERS" && len(parts) == 2:
+				members, err = c.SMembers(parts[1])
+			case command == "SINTER" && len(parts) == 3:
+				members, err = c.SInter(parts[1], parts[2])
+			case command == "SUNION" && len(parts) == 3:
+				members, err = c.SUnion(parts[1], parts[2])
+			case command == "SMEMBERS":
+				protocol.WriteReply(w, protocol.Error("SMEMBERS requires key"))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			default:
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires two keys", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			slices.Sort(members)
+			protocol.WriteReply(w, protocol.Integer(int64(len(members))))
+			for _, member := range members {
+				protocol.WriteReply(w, protocol.Bulk(member))
+			}
+		case "ZADD":
+			s.countCommand("ZADD")
+			if len(parts) < 4 || len(parts)%2 != 0 {
+				protocol.WriteReply(w, protocol.Error("ZADD requires key and score member pairs"))
+				errorCounter.WithLabelValues("ZADD").Inc()
+				continue
+			}
+			members := make([]cache.ZMember, 0, len(parts)/2-1)
+			for i := 2; i < len(parts); i += 2 {
+				score, err := strconv.ParseFloat(parts[i], 64)
+				if err != nil {
+					members = nil
+					break
+				}
+				members = append(members, cache.ZMember{Member: parts[i+1], Score: score})
+			}
+			if members == nil {
+				protocol.WriteReply(w, protocol.Error("score is not a number"))
+				errorCounter.WithLabelValues("ZADD").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			n, err := c.ZAdd(parts[1], members...)
+			if err == nil {
+				s.logCurrent(parts[1], "zadd")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "ZREM":
+			s.countCommand("ZREM")
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error("ZREM requires key and at least one member"))
+				errorCounter.WithLabelValues("ZREM").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			n, err := c.ZRem(parts[1], parts[2:]...)
+			if n > 0 {
+				s.logCurrent(parts[1], "zrem")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "ZSCORE", "ZRANK":
+			s.countCommand(command)
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key and member", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			if command == "ZRANK" {
+				rank, err := c.ZRank(parts[1], parts[2])
+				if err != nil {
+					replyError(w, logger, command, err)
+				} else {
+					protocol.WriteReply(w, protocol.Integer(int64(rank)))
+				}
+				continue
+			}
+			score, err := c.ZScore(parts[1], parts[2])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Status(strconv.FormatFloat(score, 'g', -1, 64)))
+			}
+		case "ZCARD":
+			s.countCommand("ZCARD")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("ZCARD requires key"))
+				errorCounter.WithLabelValues("ZCARD").Inc()
+				continue
+			}
+			n, err := c.ZCard(parts[1])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "ZRANGE", "ZRANGEBYSCORE":
+			// These reply like LRANGE, each member followed by a line with
+			// its score if WITHSCORES is given. ZRANGEBYSCORE accepts -inf
+			// and +inf.
+			s.countCommand(command)
+			withScores := len(parts) == 5 && strings.EqualFold(parts[4], "WITHSCORES")
+			if len(parts) != 4 && !withScores {
+				protocol.WriteReply(w, protocol.Error(fmt.Sprintf("%s requires key, start, and stop", command)))
+				errorCounter.WithLabelValues(command).Inc()
+				continue
+			}
+			var members []cache.ZMember
+			var err error
+			if command == "ZRANGE" {
+				start, err1 := strconv.Atoi(parts[2])
+				stop, err2 := strconv.Atoi(parts[3])
+				if err1 != nil || err2 != nil {
+					protocol.WriteReply(w, protocol.Error("start and stop must be integers"))
+					errorCounter.WithLabelValues(command).Inc()
+					continue
+				}
+				members, err = c.ZRange(parts[1], start, stop)
+			} else {
+				lo, err1 := strconv.ParseFloat(parts[2], 64)
+				hi, err2 := strconv.ParseFloat(parts[3], 64)
+				if err1 != nil || err2 != nil {
+					protocol.WriteReply(w, protocol.Error("min and max must be numbers"))
+					errorCounter.WithLabelValues(command).Inc()
+					continue
+				}
+				members, err = c.ZRangeByScore(parts[1], lo, hi)
+			}
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(int64(len(members))))
+			for _, m := range members {
+				protocol.WriteReply(w, protocol.Bulk(m.Member))
+				if withScores {
+					protocol.WriteReply(w, protocol.Status(strconv.FormatFloat(m.Score, 'g', -1, 64)))
+				}
+			}
+		case "SETBIT":
+			// The append-only file has no record for a single bit, so
+			// SETBIT logs the whole resulting value.
+			s.countCommand("SETBIT")
+			if len(parts) != 4 {
+				protocol.WriteReply(w, protocol.Error("SETBIT requires key, offset, and value"))
+				errorCounter.WithLabelValues("SETBIT").Inc()
+				continue
+			}
+			offset, err := strconv.Atoi(parts[2])
+			if err != nil || (parts[3] != "0" && parts[3] != "1") {
+				protocol.WriteReply(w, protocol.Error("offset must be an integer and value 0 or 1"))
+				errorCounter.WithLabelValues("SETBIT").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			old, err := c.SetBit(parts[1], offset, parts[3] == "1")
+			if err == nil {
+				s.logCurrent(parts[1], "setbit")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			if old {
+				protocol.WriteReply(w, protocol.Integer(1))
+			} else {
+				protocol.WriteReply(w, protocol.Integer(0))
+			}
+		case "GETBIT":
+			s.countCommand("GETBIT")
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error("GETBIT requires key and offset"))
+				errorCounter.WithLabelValues("GETBIT").Inc()
+				continue
+			}
+			offset, err := strconv.Atoi(parts[2])
+			if err != nil {
+				protocol.WriteReply(w, protocol.Error("offset must be an integer"))
+				errorCounter.WithLabelValues("GETBIT").Inc()
+				continue
+			}
+			bit, err := c.GetBit(parts[1], offset)
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else if bit {
+				protocol.WriteReply(w, protocol.Integer(1))
+			} else {
+				protocol.WriteReply(w, protocol.Integer(0))
+			}
+		case "BITCOUNT":
+			s.countCommand("BITCOUNT")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("BITCOUNT requires key"))
+				errorCounter.WithLabelValues("BITCOUNT").Inc()
+				continue
+			}
+			n, err := c.BitCount(parts[1])
+			if err != nil {
+				replyError(w, logger, command, err)
+			} else {
+				protocol.WriteReply(w, protocol.Integer(int64(n)))
+			}
+		case "SUBSCRIBE":
+			// SUBSCRIBE replies "subscribe <channel> <count>" for each
+			// channel, where count is the number of channels the
+			// connection is subscribed to. Published messages then arrive
+			// as "message <channel>" lines, each followed by its payload in
+			// a data block.
+			s.countCommand("SUBSCRIBE")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("SUBSCRIBE requires at least one channel"))
+				errorCounter.WithLabelValues("SUBSCRIBE").Inc()
+				continue
+			}
+			if sub == nil {
+				sub = newSubscriber()
+				go sub.deliver(w, &out, timeouts)
+			}
+			for _, channel := range parts[1:] {
+				protocol.WriteReply(w, protocol.Status("subscribe "+channel+" "+strconv.Itoa(s.pubsub.subscribe(sub, channel))))
+			}
+		case "UNSUBSCRIBE":
+			// UNSUBSCRIBE without channels leaves every channel. It replies
+			// like SUBSCRIBE, or "unsubscribe (nil) 0" if there was nothing
+			// to leave.
+			s.countCommand("UNSUBSCRIBE")
+			channels := parts[1:]
+			if len(channels) == 0 && sub != nil {
+				channels = sub.subscribed()
+			}
+			if len(channels) == 0 {
+				protocol.WriteReply(w, protocol.Status("unsubscribe (nil) 0"))
+				continue
+			}
+			for _, channel := range channels {
+				n := 0
+				if sub != nil {
+					n = s.pubsub.unsubscribe(sub, channel)
+				}
+				protocol.WriteReply(w, protocol.Status("unsubscribe "+channel+" "+strconv.Itoa(n)))
+			}
+		case "MONITOR":
+			// MONITOR replies OK and then streams every command the server
+			// processes, as formatted by monitorHub.feed.
+			s.countCommand("MONITOR")
+			if mon == nil {
+				mon = s.monitors.add()
+				go mon.deliver(w, &out, timeouts)
+			}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "PUBLISH":
+			s.countCommand("PUBLISH")
+			if len(parts) < 3 {
+				protocol.WriteReply(w, protocol.Error("PUBLISH requires channel and message"))
+				errorCounter.WithLabelValues("PUBLISH").Inc()
+				continue
+			}
+			protocol.WriteReply(w, protocol.Integer(int64(s.pubsub.publish(parts[1], strings.Join(parts[2:], " ")))))
+		case "RENAME":
+			s.countCommand("RENAME")
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error("RENAME requires old key and new key"))
+				errorCounter.WithLabelValues("RENAME").Inc()
+				continue
+			}
+			s.logMu.Lock()
+			err := c.Rename(parts[1], parts[2])
+			if err == nil {
+				s.logWrite(aof.Record{Op: aof.OpRename, Key: parts[1], Value: parts[2]})
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, "RENAME", err)
+			} else {
+				protocol.WriteReply(w, protocol.Status("OK"))
+			}
+		case "DUMP":
+			s.countCommand("DUMP")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("DUMP requires a key"))
+				errorCounter.WithLabelValues("DUMP").Inc()
+				continue
+			}
+			payload, err := c.Dump(parts[1])
+			if err != nil {
+				replyError(w, logger, "DUMP", err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Status(base64.StdEncoding.EncodeToString(payload)))
+		case "RESTORE":
+			s.countCommand("RESTORE")
+			replace := len(parts) == 5 && strings.ToUpper(parts[4]) == "REPLACE"
+			if len(parts) != 4 && !replace {
+				protocol.WriteReply(w, protocol.Error("RESTORE requires key, ttl-ms, payload, and optionally REPLACE"))
+				errorCounter.WithLabelValues("RESTORE").Inc()
+				continue
+			}
+			ttlMs, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil || ttlMs < 0 {
+				protocol.WriteReply(w, protocol.Error("ttl-ms must be a non-negative integer"))
+				errorCounter.WithLabelValues("RESTORE").Inc()
+				continue
+			}
+			payload, err := base64.StdEncoding.DecodeString(parts[3])
+			if err != nil {
+				replyError(w, logger, "RESTORE", fmt.Errorf("%w: %v", cache.ErrCorruptDump, err))
+				continue
+			}
+			s.logMu.Lock()
+			err = c.Restore(parts[1], payload, time.Duration(ttlMs)*time.Millisecond, replace)
+			if err == nil {
+				s.logCurrent(parts[1], "set")
+			}
+			s.logMu.Unlock()
+			if err != nil {
+				replyError(w, logger, "RESTORE", err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "EXISTS":
+			s.countCommand("EXISTS")
+			if len(parts) < 2 {
+				protocol.WriteReply(w, protocol.Error("EXISTS requires at least one key"))
+				errorCounter.WithLabelValues("EXISTS").Inc()
+				continue
+			}
+			count := 0
+			for _, key := range parts[1:] {
+				if st.Exists(key) {
+					count++
+				}
+			}
+			protocol.WriteReply(w, protocol.Integer(int64(count)))
+		case "PTTL":
+			// PTTL replies with the milliseconds key has left, rounded up,
+			// -1 if it never expires, or -2 if it is not set.
+			s.countCommand("PTTL")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("PTTL requires key"))
+				errorCounter.WithLabelValues("PTTL").Inc()
+				continue
+			}
+			expireAt, err := c.ExpireTime(parts[1])
+			switch {
+			case err != nil:
+				protocol.WriteReply(w, protocol.Integer(-2))
+			case expireAt.IsZero():
+				protocol.WriteReply(w, protocol.Integer(-1))
+			default:
+				protocol.WriteReply(w, protocol.Integer(int64((time.Until(expireAt)+time.Millisecond-1)/time.Millisecond)))
+			}
+		case "OBJECT":
+			// OBJECT INFO <key> replies with the number of "field value"
+			// lines that follow, then the lines, then the value as a data
+			// block, empty for keys that do not hold strings. Inspecting a
+			// key does not promote it or count a hit.
+			s.countCommand("OBJECT")
+			if len(parts) != 3 || !strings.EqualFold(parts[1], "INFO") {
+				protocol.WriteReply(w, protocol.Error("OBJECT requires INFO and key"))
+				errorCounter.WithLabelValues("OBJECT").Inc()
+				continue
+			}
+			info, err := c.GetEntryInfo(parts[2])
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			ttl := int64(-1)
+			if info.TTL > 0 {
+				ttl = int64((info.TTL + time.Millisecond - 1) / time.Millisecond)
+			}
+			protocol.WriteReply(w, protocol.Integer(5))
+			protocol.WriteReply(w, protocol.Status("created "+strconv.FormatInt(info.Created.UnixMilli(), 10)))
+			protocol.WriteReply(w, protocol.Status("last-access "+strconv.FormatInt(info.LastAccess.UnixMilli(), 10)))
+			protocol.WriteReply(w, protocol.Status("hits "+strconv.FormatUint(uint64(info.Hits), 10)))
+			protocol.WriteReply(w, protocol.Status("ttl-ms "+strconv.FormatInt(ttl, 10)))
+			protocol.WriteReply(w, protocol.Status("shard "+strconv.Itoa(info.Shard)))
+			protocol.WriteReply(w, protocol.Bulk(info.Value))
+		case "PSYNC":
+			// PSYNC <replication id> <offset> turns the connection into a
+			// replication stream; see serveReplica.
+			s.countCommand("PSYNC")
+			offset, err := 0, error(nil)
+			if len(parts) == 3 {
+				offset, err = strconv.Atoi(parts[2])
+			}
+			if len(parts) != 3 || err != nil {
+				protocol.WriteReply(w, protocol.Error("PSYNC requires replication id and offset"))
+				errorCounter.WithLabelValues("PSYNC").Inc()
+				continue
+			}
+			// The stream lasts as long as the replica stays attached, so
+			// it must not hold up transactions or the other commands.
+			slot.release()
+			share.release()
+			s.serveReplica(conn, r, w, parts[1], int64(offset), logger)
+			return
+		case "REPLICAOF":
+			// REPLICAOF <host> <port> makes this server a replica, and
+			// REPLICAOF NO ONE a primary again.
+			s.countCommand("REPLICAOF")
+			if len(parts) != 3 {
+				protocol.WriteReply(w, protocol.Error("REPLICAOF requires host and port, or NO ONE"))
+				errorCounter.WithLabelValues("REPLICAOF").Inc()
+				continue
+			}
+			primary := ""
+			if !strings.EqualFold(parts[1], "NO") || !strings.EqualFold(parts[2], "ONE") {
+				if port, err := strconv.ParseUint(parts[2], 10, 16); err != nil || port == 0 {
+					protocol.WriteReply(w, protocol.Error("invalid port"))
+					errorCounter.WithLabelValues("REPLICAOF").Inc()
+					continue
+				}
+				primary = net.JoinHostPort(parts[1], parts[2])
+			}
+			s.replicate(primary)
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "SCAN":
+			// SCAN <cursor> [COUNT <n>] replies with the next cursor followed by
+			// the keys of this batch, all on one line.
+			s.countCommand("SCAN")
+			if len(parts) != 2 && (len(parts) != 4 || strings.ToUpper(parts[2]) != "COUNT") {
+				protocol.WriteReply(w, protocol.Error("SCAN requires cursor and optional COUNT <n>"))
+				errorCounter.WithLabelValues("SCAN").Inc()
+				continue
+			}
+			cursor, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				protocol.WriteReply(w, protocol.Error("invalid cursor"))
+				errorCounter.WithLabelValues("SCAN").Inc()
+				continue
+			}
+			count := 0
+			if len(parts) == 4 {
+				if count, err = strconv.Atoi(parts[3]); err != nil || count <= 0 {
+					protocol.WriteReply(w, protocol.Error("COUNT must be a positive integer"))
+					errorCounter.WithLabelValues("SCAN").Inc()
+					continue
+				}
+			}
+			keys, next := c.Scan(cursor, count)
+			keys = ks.keys(keys)
+			protocol.WriteReply(w, protocol.Status(strings.Join(append([]string{strconv.FormatUint(next, 10)}, keys...), " ")))
+		case "SAVE":
+			s.countCommand("SAVE")
+			if s.snapshots == nil {
+				replyError(w, logger, "SAVE", errNoSnapshotFile)
+				continue
+			}
+			if err := s.snapshots.save(); err != nil {
+				replyError(w, logger, "SAVE", err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "BGSAVE":
+			s.countCommand("BGSAVE")
+			if s.snapshots == nil {
+				replyError(w, logger, "BGSAVE", errNoSnapshotFile)
+				continue
+			}
+			if err := s.snapshots.saveInBackground(); err != nil {
+				replyError(w, logger, "BGSAVE", err)
+				continue
+			}
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "LASTSAVE":
+			s.countCommand("LASTSAVE")
+			var last int64
+			if s.snapshots != nil {
+				last = s.snapshots.lastSave.Load()
+			}
+			protocol.WriteReply(w, protocol.Integer(last))
+		case "BGREWRITEAOF":
+			s.countCommand("BGREWRITEAOF")
+			if s.appendLog == nil {
+				replyError(w, logger, "BGREWRITEAOF", errors.New("AOF is disabled"))
+				continue
+			}
+			go func() {
+				if err := s.rewriteAOF(); err != nil {
+					slog.Error("AOF rewrite failed", "err", err)
+				}
+			}()
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "INFO":
+			s.countCommand("INFO")
+			if len(parts) > 2 {
+				protocol.WriteReply(w, protocol.Error("INFO takes at most one section"))
+				errorCounter.WithLabelValues("INFO").Inc()
+				continue
+			}
+			section := ""
+			if len(parts) == 2 {
+				section = parts[1]
+			}
+			if !s.writeInfo(w, section) {
+				protocol.WriteReply(w, protocol.Error("unknown INFO section; use server, stats, or keyspace"))
+				errorCounter.WithLabelValues("INFO").Inc()
+			}
+		case "CONFIG":
+			// CONFIG GET <param> replies "<param> <value>"; CONFIG SET
+			// <param> <value> replies OK. Parameters are named after flags.
+			s.countCommand("CONFIG")
+			sub := ""
+			if len(parts) > 1 {
+				sub = strings.ToUpper(parts[1])
+			}
+			switch {
+			case sub == "GET" && len(parts) == 3:
+				value, err := s.configGet(parts[2])
+				if err != nil {
+					replyError(w, logger, "CONFIG", err)
+					continue
+				}
+				protocol.WriteReply(w, protocol.Status(parts[2]+" "+value))
+			case sub == "SET" && len(parts) == 4:
+				if err := s.configSet(parts[2], parts[3]); err != nil {
+					replyError(w, logger, "CONFIG", err)
+					continue
+				}
+				logger.Info("config changed", "param", parts[2], "value", parts[3])
+				protocol.WriteReply(w, protocol.Status("OK"))
+			default:
+				protocol.WriteReply(w, protocol.Error("CONFIG requires GET <param> or SET <param> <value>"))
+				errorCounter.WithLabelValues("CONFIG").Inc()
+			}
+		case "SELECT":
+			s.countCommand("SELECT")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("SELECT requires a database number"))
+				errorCounter.WithLabelValues("SELECT").Inc()
+				continue
+			}
+			selected, err := ks.selectDB(parts[1], s.config.databases)
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			ks = selected
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "NAMESPACE":
+			s.countCommand("NAMESPACE")
+			if len(parts) != 2 {
+				protocol.WriteReply(w, protocol.Error("NAMESPACE requires a name"))
+				errorCounter.WithLabelValues("NAMESPACE").Inc()
+				continue
+			}
+			selected, err := ks.selectNamespace(parts[1])
+			if err != nil {
+				replyError(w, logger, command, err)
+				continue
+			}
+			ks = selected
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "FLUSHDB":
+			s.countCommand("FLUSHDB")
+			ks.flush(s)
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "FLUSHALL":
+			s.countCommand("FLUSHALL")
+			s.logMu.Lock()
+			st.Clear()
+			s.logWrite(aof.Record{Op: aof.OpFlush})
+			s.logMu.Unlock()
+			protocol.WriteReply(w, protocol.Status("OK"))
+		case "CLIENT":
+			// CLIENT KILL ID <id> and CLIENT KILL ADDR <addr> reply with how
+			// many connections they closed.
+			s.countCommand("CLIENT")
+			sub := ""
+			if len(parts) > 1 {
+				sub = strings.ToUpper(parts[1])
+			}
+			switch {
+			case sub == "LIST" && len(parts) == 2:
+				writeClientList(w, s.clients.list())
+			case sub == "KILL" && len(parts) == 4 && strings.EqualFold(parts[2], "ID"):
+				id, err := strconv.ParseInt(parts[3], 10, 64)
+				if err != nil {
+					protocol.WriteReply(w, protocol.Error("client id must be an integer"))
+					errorCounter.WithLabelValues("CLIENT").Inc()
+					continue
+				}
+				protocol.WriteReply(w, protocol.Integer(int64(s.clients.kill(func(cl *client) bool { return cl.id == id }))))
+			case sub == "KILL" && len(parts) == 4 && strings.EqualFold(parts[2], "ADDR"):
+				protocol.WriteReply(w, protocol.Integer(int64(s.clients.kill(func(cl *client) bool { return cl.addr == parts[3] }))))
+			case sub == "SETNAME" && len(parts) == 3:
+				self.setName(parts[2])
+				protocol.WriteReply(w, protocol.Status("OK"))
+			case sub == "GETNAME" && len(parts) == 2:
+				if name := self.getName(); name != "" {
+					protocol.WriteReply(w, protocol.Status(name))
+				} else {
+					protocol.WriteReply(w, protocol.Nil)
+				}
+			default:
+				protocol.WriteReply(w, protocol.Error("CLIENT requires LIST, KILL ID <id>, KILL ADDR <addr>, SETNAME <name>, or GETNAME"))
+				errorCounter.WithLabelValues("CLIENT").Inc()
+			}
+		case "SLOWLOG":
+			// SLOWLOG GET [n] replies with the n most recent slow commands,
+			// 10 by default, as written by writeSlowEntries.
+			s.countCommand("SLOWLOG")
+			sub := ""
+			if len(parts) > 1 {
+				sub = strings.ToUpper(parts[1])
+			}
+			switch {
+			case sub == "GET" && len(parts) <= 3:
+				n := 10
+				if len(parts) == 3 {
+					var err error
+					if n, err = strconv.Atoi(parts[2]); err != nil || n < 0 {
+						protocol.WriteReply(w, protocol.Error("count must be a non-negative integer"))
+						errorCounter.WithLabelValues("SLOWLOG").Inc()
+						continue
+					}
+				}
+				writeSlowEntries(w, s.slowlog.get(n))
+			case sub == "LEN" && len(parts) == 2:
+				protocol.WriteReply(w, protocol.Integer(int64(s.slowlog.len())))
+			case sub == "RESET" && len(parts) == 2:
+				s.slowlog.reset()
+				protocol.WriteReply(w, protocol.Status("OK"))
+			default:
+				protocol.WriteReply(w, protocol.Error("SLOWLOG requires GET [count], LEN, or RESET"))
+				errorCounter.WithLabelValues("SLOWLOG").Inc()
+			}
+		default:
+			protocol.WriteReply(w, protocol.Error("unknown command"))
+			errorCounter.WithLabelValues("unknown").Inc()
+		}
+		processingDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+		if s.onCommand != nil {
+			s.onCommand(command, time.Since(start))
+		}
+		if d, slow := s.slowlog.record(ks, command, parts, addr, start); slow {
+			logger.Warn("slow command", "command", command, "duration", d)
+		} else if logger.Enabled(context.Background(), slog.LevelDebug) {
+			logger.Debug("command", "command", command, "duration", time.Since(start))
+		}
+	}
+}
+
+// loadPersisted restores the server's cache from the AOF, or else the
+// snapshot with -load-on-start, and then applies -import.
+func (s *Server) loadPersisted() error {
+	c := s.cache
+	if s.config.aofFile != "" {
+		n, err := replayAOF(s.config.aofFile, c)
+		if err != nil {
+			return fmt.Errorf("replay AOF: %w", err)
+		}
+		slog.Info("replayed AOF", "records", n, "path", s.config.aofFile)
+	} else if s.config.loadOnStart && s.config.snapshotFile != "" {
+		switch err := c.LoadFromFile(s.config.snapshotFile); {
+		case errors.Is(err, os.ErrNotExist):
+			slog.Info("no snapshot, starting empty", "path", s.config.snapshotFile)
+		case err != nil:
+			return fmt.Errorf("load snapshot: %w", err)
+		default:
+			slog.Info("loaded snapshot", "keys", c.Len(), "path", s.config.snapshotFile)
+		}
+	}
+	if s.config.importFile != "" {
+		loaded, skipped, err := importJSON(s.config.importFile, c)
+		if err != nil {
+			return fmt.Errorf("import %s: %w", s.config.importFile, err)
+		}
+		slog.Info("imported entries", "loaded", loaded, "skipped", skipped, "path", s.config.importFile)
+	}
+	return nil
+}
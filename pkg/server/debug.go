@@ -0,0 +1,60 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+)
+
+// newDebugHandler returns the -debug-addr interface: the net/http/pprof
+// profiles under /debug/pprof/ and, at /debug/vars, the process's expvar
+// variables along with the server's own, including its cache's size. With
+// authentication enabled, every request needs credentials for a user with the
+// admin permission, as the HTTP interface takes them.
+func (s *Server) newDebugHandler() http.Handler {
+	vars := new(expvar.Map).Init()
+	vars.Set("commands_processed", expvar.Func(func() any {
+		var total int64
+		s.commandTotals.Range(func(_, n any) bool {
+			total += n.(*atomic.Int64).Load()
+			return true
+		})
+		return total
+	}))
+	vars.Set("active_connections", expvar.Func(func() any { return s.openConns.Load() }))
+	vars.Set("cache_keys", expvar.Func(func() any { return s.store.Len() }))
+	if s.cache != nil {
+		vars.Set("cache_memory_bytes", expvar.Func(func() any { return s.cache.MemoryUsage() }))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, _ *http.Request) {
+		serveVars(w, vars)
+	})
+	return s.requireAuth("DEBUG", mux.ServeHTTP)
+}
+
+// serveVars writes the published expvar variables and then those in vars as
+// one JSON object, as expvar.Handler does for the published ones alone.
+func serveVars(w http.ResponseWriter, vars *expvar.Map) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	first := true
+	write := func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	}
+	expvar.Do(write)
+	vars.Do(write)
+	fmt.Fprint(w, "\n}\n")
+}
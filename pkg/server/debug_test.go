@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestDebugHandler(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("a", "1")
+	c.Set("b", "2")
+	srv := New(WithCache(c))
+	srv.countCommand("SET")
+	h := srv.newDebugHandler()
+
+	rec := httpDo(t, h, "GET", "/debug/vars", nil)
+	var vars map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&vars); err != nil {
+		t.Fatalf("expected the expvar variables as JSON, got %v", err)
+	}
+	if vars["cache_keys"] != float64(2) {
+		t.Fatalf("expected cache_keys to be 2, got %v", vars["cache_keys"])
+	}
+	for _, name := range []string{"commands_processed", "active_connections", "cache_memory_bytes", "memstats"} {
+		if _, ok := vars[name]; !ok {
+			t.Fatalf("expected %s in /debug/vars, got %v", name, vars)
+		}
+	}
+	if n, _ := vars["commands_processed"].(float64); n != 1 {
+		t.Fatalf("expected commands to be counted, got %v", vars["commands_processed"])
+	}
+
+	rec = httpDo(t, h, "GET", "/debug/pprof/goroutine?debug=1", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Fatalf("expected a goroutine dump, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDebugHandlerAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	h := New(WithCache(c), WithPassword("hunter2")).newDebugHandler()
+	if rec := httpDo(t, h, "GET", "/debug/pprof/", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+	if rec := httpDo(t, h, "GET", "/debug/pprof/", nil, "Authorization", "Bearer hunter2"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the token, got %d", rec.Code)
+	}
+}
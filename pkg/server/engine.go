@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// The -engine values. The sharded engine is a cache.ShardedCache, bounded by
+// -capacity and -max-bytes, and serves every command and listener. The simple
+// engine is a cache.Cache: an unbounded map of strings serving only the
+// commands in simpleCommands, on the TCP listener, without persistence or
+// replication.
+const (
+	engineSharded = "sharded"
+	engineSimple  = "simple"
+)
+
+// simpleCommands are the commands the simple engine serves: those that only
+// need a cache.Store, and those that do not touch the cache at all.
+var simpleCommands = map[string]bool{
+	"PING": true, "ECHO": true, "QUIT": true, "AUTH": true, "SELECT": true, "NAMESPACE": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true,
+	"SET": true, "PSETEX": true, "SETNX": true, "GET": true, "GETDEL": true, "DEL": true,
+	"EXISTS": true, "FLUSHALL": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PUBLISH": true, "MONITOR": true,
+	"CLIENT": true, "INFO": true, "CONFIG": true, "SLOWLOG": true,
+}
+
+// shardedFlags are the flags that only the sharded engine honors. Setting one
+// with -engine=simple is an error rather than silently ignored.
+var shardedFlags = []string{
+	"shards", "capacity", "max-bytes", "default-ttl", "max-value-bytes", "compress-threshold",
+	"snapshot-file", "aof-file", "import", "replicaof", "notify-keyspace-events",
+	"http-addr", "grpc-addr", "memcached-addr",
+}
+
+// validateEngine checks that the server's engine is known and that no flag
+// needs a different one.
+func (s *Server) validateEngine() error {
+	switch s.config.engine {
+	case engineSharded:
+		return nil
+	case engineSimple:
+	default:
+		return fmt.Errorf("invalid -engine %q: must be simple or sharded", s.config.engine)
+	}
+	defaults := NewConfig().flagSet()
+	for _, name := range shardedFlags {
+		if s.settings.Lookup(name).Value.String() != defaults.Lookup(name).Value.String() {
+			return fmt.Errorf("-%s requires -engine=sharded", name)
+		}
+	}
+	return nil
+}
+
+// checkValueSize returns cache.ErrValueTooLarge if value is larger than the
+// served cache accepts. Only the sharded engine limits value sizes.
+func (s *Server) checkValueSize(value string) error {
+	if limit := s.store.MaxValueBytes(); limit > 0 && len(value) > limit {
+		return cache.ErrValueTooLarge
+	}
+	return nil
+}
+
+// set stores value under key on either engine, expiring it after ttl as
+// cache.Store's SetWithTTL does, unless checkValueSize refuses it.
+func (s *Server) set(key, value string, ttl time.Duration) error {
+	if err := s.checkValueSize(value); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		return s.cache.SetWithTTLE(key, value, ttl)
+	}
+	s.store.SetWithTTL(key, value, ttl)
+	return nil
+}
+
+// del deletes keys on either engine and returns how many existed.
+func (s *Server) del(keys ...string) int {
+	if s.cache != nil {
+		return s.cache.MDel(keys...)
+	}
+	// Every value in a simple engine is a string, so GetDel reports each key
+	// it removes.
+	n := 0
+	for _, key := range keys {
+		if _, err := s.store.GetDel(key); err == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestEngineValidate(t *testing.T) {
+	engine := func(name string, capacity int) Option {
+		return withConfig(func(c *Config) { c.engine, c.capacity = name, capacity })
+	}
+	if err := New(engine("bogus", 0)).validate(); err == nil || err.Error() != `invalid -engine "bogus": must be simple or sharded` {
+		t.Fatalf("expected an unknown engine to be rejected, got %v", err)
+	}
+	if err := New(engine(engineSimple, 0)).validate(); err != nil {
+		t.Fatalf("expected the simple engine to be valid, got %v", err)
+	}
+	if err := New(engine(engineSimple, 10)).validate(); err == nil || err.Error() != "-capacity requires -engine=sharded" {
+		t.Fatalf("expected -capacity to need the sharded engine, got %v", err)
+	}
+	if err := New(engine(engineSharded, 10)).validate(); err != nil {
+		t.Fatalf("expected the sharded engine to honor -capacity, got %v", err)
+	}
+}
+
+func TestSimpleEngine(t *testing.T) {
+	srv := startServer(t, WithCache(cache.NewCache()))
+	if srv.Cache() != nil {
+		t.Fatal("expected no ShardedCache with the simple engine")
+	}
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct{ command, want string }{
+		{"SET a 1", "OK"},
+		{"SET b 2", "OK"},
+		{"CONFIG GET max-bytes", "max-bytes 0"},
+		{"CONFIG SET max-bytes 4096", "ERROR: max-bytes requires -engine=sharded"},
+		{"CONFIG GET engine", "engine simple"},
+	} {
+		if got := configCommand(t, conn, r, tc.command); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.command, tc.want, got)
+		}
+	}
+
+	fmt.Fprint(conn, "INFO\n")
+	_, fields := readInfo(t, r)
+	if fields["db0"] != "keys=2" || fields["used_memory"] != "" {
+		t.Fatalf("expected 2 keys and no cache memory field, got %v", fields)
+	}
+}
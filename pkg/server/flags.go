@@ -0,0 +1,177 @@
+package server
+
+import (
+	"flag"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// Config holds the settings of a Server, each named after its command-line
+// flag. NewConfig returns the defaults for a Server embedded in another
+// program, which listens on a free loopback port and serves no metrics;
+// RegisterFlags exposes the settings as flags with the defaults of a
+// standalone server, as cmd/server does. Options passed to New change the
+// settings they cover.
+type Config struct {
+	authEnabled   bool
+	authPassword  string
+	passwordFile  string
+	authMaxFails  int
+	authWindow    time.Duration
+	authBanTime   time.Duration
+	usersFile     string
+	useTLS        bool
+	certFile      string
+	keyFile       string
+	tlsClientCA   string
+	certPollEvery time.Duration
+	requireCert   bool
+	tcpAddr       string
+	httpAddr      string
+	grpcAddr      string
+	memcachedAddr string
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	idleTimeout   time.Duration
+	maxConns      int
+	rejectQuiet   bool
+	protocolMode  string
+	debugAddr     string
+	metricsAddr   string
+	globalRate    float64
+	ipRate        float64
+	connRate      float64
+	rateKickAfter int
+	workerCount   int
+	engine        string
+	shardCount    int
+	databases     int
+	capacity      int
+	maxRequest    int
+	maxBytes      int64
+	defaultTTL    time.Duration
+	maxValueSize  int
+	compressAbove int
+	maxBitOffset  int
+	snapshotFile  string
+	loadOnStart   bool
+	snapshotEvery time.Duration
+	aofFile       string
+	aofFsync      string
+	importFile    string
+	notifyEvents  bool
+	slowThreshold time.Duration
+	slowlogMaxLen int
+	aofRewriteAt  float64
+	replicaOf     string
+	replicaRO     bool
+	primaryPass   string
+	replBacklog   int
+	replicaFlush  bool
+
+	passwordSet bool // Whether WithPassword set authPassword, so -password-file does not.
+}
+
+// NewConfig returns the default settings.
+func NewConfig() *Config {
+	return &Config{
+		authPassword:  "secret",
+		authMaxFails:  5,
+		authWindow:    time.Minute,
+		authBanTime:   5 * time.Minute,
+		certFile:      "server.crt",
+		keyFile:       "server.key",
+		certPollEvery: 10 * time.Second,
+		tcpAddr:       "127.0.0.1:0",
+		protocolMode:  "line",
+		workerCount:   10,
+		engine:        engineSharded,
+		shardCount:    16,
+		databases:     16,
+		maxRequest:    64 << 10,
+		maxBitOffset:  cache.DefaultMaxBitOffset,
+		aofFsync:      "everysec",
+		slowThreshold: 10 * time.Millisecond,
+		slowlogMaxLen: 128,
+		aofRewriteAt:  2,
+		replicaRO:     true,
+		replBacklog:   1 << 20,
+	}
+}
+
+// RegisterFlags defines c's settings as flags on fs, so that parsing fs
+// configures c. The TCP listener then defaults to :8080 and the metrics server
+// to :9090.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	c.tcpAddr, c.metricsAddr = ":8080", ":9090"
+	c.define(fs)
+}
+
+// flagSet returns a flag set defining c's settings, for looking them up by
+// name.
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	c.define(fs)
+	return fs
+}
+
+// define defines c's settings as flags on fs, defaulting to their current
+// values.
+func (c *Config) define(fs *flag.FlagSet) {
+	fs.BoolVar(&c.authEnabled, "auth", c.authEnabled, "Enable authentication (changeable with CONFIG SET)")
+	fs.StringVar(&c.authPassword, "password", c.authPassword, "Authentication password; prefer -password-file or MYCACHE_PASSWORD, which keep it out of the process list")
+	fs.StringVar(&c.passwordFile, "password-file", c.passwordFile, "File holding the authentication password, overriding -password and MYCACHE_PASSWORD")
+	fs.IntVar(&c.authMaxFails, "auth-max-failures", c.authMaxFails, "Ban a client IP from AUTH after this many failures within -auth-failure-window (0 to never ban)")
+	fs.DurationVar(&c.authWindow, "auth-failure-window", c.authWindow, "Window in which AUTH failures count towards -auth-max-failures")
+	fs.DurationVar(&c.authBanTime, "auth-ban-time", c.authBanTime, "How long a client IP is banned from AUTH")
+	fs.StringVar(&c.usersFile, "users-file", c.usersFile, "File of name:bcrypt-hash:permissions lines; enables -auth, with AUTH checking these users instead of -password")
+	fs.BoolVar(&c.useTLS, "tls", c.useTLS, "Enable TLS")
+	fs.StringVar(&c.certFile, "cert", c.certFile, "TLS certificate file")
+	fs.StringVar(&c.keyFile, "key", c.keyFile, "TLS key file")
+	fs.StringVar(&c.tlsClientCA, "tls-client-ca", c.tlsClientCA, "CA certificate file for verifying TLS client certificates; a verified certificate authenticates the connection, as the -users-file user named by its common name if there is a users file")
+	fs.DurationVar(&c.certPollEvery, "tls-reload-interval", c.certPollEvery, "How often to check -cert and -key for changes and reload them (0 to only reload on SIGHUP)")
+	fs.BoolVar(&c.requireCert, "tls-require-client-cert", c.requireCert, "Reject TLS connections without a client certificate signed by -tls-client-ca")
+	fs.StringVar(&c.tcpAddr, "tcp", c.tcpAddr, "TCP server address")
+	fs.StringVar(&c.httpAddr, "http-addr", c.httpAddr, "Address for the HTTP key/value API (empty to disable)")
+	fs.StringVar(&c.grpcAddr, "grpc-addr", c.grpcAddr, "Address for the gRPC service (empty to disable)")
+	fs.StringVar(&c.memcachedAddr, "memcached-addr", c.memcachedAddr, "Address for a memcached text protocol listener (empty to disable)")
+	fs.DurationVar(&c.readTimeout, "read-timeout", c.readTimeout, "Maximum time to read the rest of a command once it starts arriving (0 for no limit)")
+	fs.DurationVar(&c.writeTimeout, "write-timeout", c.writeTimeout, "Maximum time to write a batch of replies (0 for no limit)")
+	fs.DurationVar(&c.idleTimeout, "idle-timeout", c.idleTimeout, "Close connections that send no command for this long (0 to keep them open; changeable with CONFIG SET)")
+	fs.IntVar(&c.maxConns, "max-connections", c.maxConns, "Maximum number of open connections on the TCP listener (0 for unlimited)")
+	fs.BoolVar(&c.rejectQuiet, "reject-silently", c.rejectQuiet, "Close connections over -max-connections without sending an error")
+	fs.StringVar(&c.protocolMode, "protocol", c.protocolMode, "Wire protocol for the TCP listener: line or resp")
+	fs.StringVar(&c.debugAddr, "debug-addr", c.debugAddr, "Address for pprof profiles and expvar variables, such as localhost:6060 (empty to disable); requires the -password bearer token with -auth")
+	fs.StringVar(&c.metricsAddr, "metrics", c.metricsAddr, "Address of the HTTP server for /metrics and the /healthz and /readyz probes (empty to disable)")
+	fs.Float64Var(&c.globalRate, "rate-limit", c.globalRate, "Maximum commands per second across all connections on the TCP listener (0 for unlimited)")
+	fs.Float64Var(&c.ipRate, "ip-rate-limit", c.ipRate, "Maximum commands per second from each client IP (0 for unlimited)")
+	fs.Float64Var(&c.connRate, "conn-rate-limit", c.connRate, "Maximum commands per second on each connection (0 for unlimited)")
+	fs.IntVar(&c.rateKickAfter, "rate-limit-disconnect", c.rateKickAfter, "Close a connection after this many commands in a row are refused by a rate limit (0 to keep it open)")
+	fs.IntVar(&c.workerCount, "workers", c.workerCount, "Maximum number of commands processed at once on the TCP listener")
+	fs.StringVar(&c.engine, "engine", c.engine, "Cache engine: sharded, which serves every command and honors -shards, -capacity, and persistence, or simple, an unbounded map serving only the string commands")
+	fs.IntVar(&c.shardCount, "shards", c.shardCount, "Number of cache shards (rounded up to a power of two)")
+	fs.IntVar(&c.databases, "databases", c.databases, "Number of logical databases selectable with SELECT")
+	fs.IntVar(&c.capacity, "capacity", c.capacity, "Maximum number of cached items (0 for unlimited)")
+	fs.IntVar(&c.maxRequest, "max-request-bytes", c.maxRequest, "Maximum length of a command line in bytes")
+	fs.Int64Var(&c.maxBytes, "max-bytes", c.maxBytes, "Approximate memory budget for cached entries in bytes (0 for unlimited; changeable with CONFIG SET)")
+	fs.DurationVar(&c.defaultTTL, "default-ttl", c.defaultTTL, "TTL for values written without one (0 for none; changeable with CONFIG SET)")
+	fs.IntVar(&c.maxValueSize, "max-value-bytes", c.maxValueSize, "Maximum value size in bytes (0 for unlimited)")
+	fs.IntVar(&c.compressAbove, "compress-threshold", c.compressAbove, "Store values longer than this many bytes compressed (0 to disable)")
+	fs.IntVar(&c.maxBitOffset, "max-bit-offset", c.maxBitOffset, "Largest bit offset SETBIT and GETBIT accept")
+	fs.StringVar(&c.snapshotFile, "snapshot-file", c.snapshotFile, "Snapshot file for persisting the cache (empty to disable)")
+	fs.BoolVar(&c.loadOnStart, "load-on-start", c.loadOnStart, "Load the snapshot file on startup")
+	fs.DurationVar(&c.snapshotEvery, "snapshot-interval", c.snapshotEvery, "Interval between periodic snapshots (0 to disable)")
+	fs.StringVar(&c.aofFile, "aof-file", c.aofFile, "Append-only file logging every write; replayed on startup instead of the snapshot (empty to disable)")
+	fs.StringVar(&c.aofFsync, "aof-fsync", c.aofFsync, "AOF fsync policy: always, everysec, or no")
+	fs.StringVar(&c.importFile, "import", c.importFile, "JSON file of entries to load on startup, as written by -export")
+	fs.BoolVar(&c.notifyEvents, "notify-keyspace-events", c.notifyEvents, "Publish a message on __keyevent__ channels for every write, deletion, expiration, and eviction")
+	fs.DurationVar(&c.slowThreshold, "slowlog-threshold", c.slowThreshold, "Record commands taking at least this long in the slow log (0 to disable; changeable with CONFIG SET)")
+	fs.IntVar(&c.slowlogMaxLen, "slowlog-max-len", c.slowlogMaxLen, "Maximum number of entries kept in the slow log")
+	fs.Float64Var(&c.aofRewriteAt, "aof-rewrite-multiple", c.aofRewriteAt, "Rewrite the AOF once it grows to this multiple of its size after the last rewrite (0 to disable)")
+	fs.StringVar(&c.replicaOf, "replicaof", c.replicaOf, "Replicate the primary at this host:port, or run as a primary if empty (changeable with REPLICAOF)")
+	fs.BoolVar(&c.replicaRO, "replica-read-only", c.replicaRO, "Refuse writes from clients while replicating -replicaof")
+	fs.StringVar(&c.primaryPass, "primary-password", c.primaryPass, "Password to AUTH to the -replicaof primary with")
+	fs.IntVar(&c.replBacklog, "repl-backlog-bytes", c.replBacklog, "Bytes of recent writes kept for replicas to resume from after a disconnect; a replica further behind resyncs in full")
+	fs.BoolVar(&c.replicaFlush, "replica-flush", c.replicaFlush, "Discard the data as soon as REPLICAOF makes this server a replica, instead of serving it until the first sync replaces it")
+}
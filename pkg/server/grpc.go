@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCommands maps gRPC method names to the command labels used for the
+// matching TCP commands in the Prometheus metrics.
+var grpcCommands = map[string]string{
+	"Get":      "GET",
+	"Set":      "SET",
+	"Delete":   "DEL",
+	"Scan":     "SCAN",
+	"BatchGet": "BATCHGET",
+}
+
+// newGRPCServer returns a gRPC server for s's cache, using TLS if tlsConfig
+// is set. With authentication enabled, every RPC needs "authorization"
+// metadata that authorize accepts for the RPC's command.
+func (s *Server) newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.grpcAuthUnary, s.grpcMetricsUnary),
+		grpc.ChainStreamInterceptor(s.grpcAuthStream, s.grpcMetricsStream),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	gs := grpc.NewServer(opts...)
+	srv := rpc.NewServer(s.cache)
+	// The RPCs that write run under logMu; see grpcAuthUnary.
+	srv.OnWrite = func(rec aof.Record) {
+		if rec.Op == aof.OpSet {
+			observeSet(rec.Key, rec.Value)
+			rec = s.setRecord(rec.Key, rec.Value)
+		}
+		s.logWrite(rec)
+	}
+	srv.Hidden = func(key string) bool { return !(keyspace{}).owns(key) }
+	rpc.RegisterCacheServer(gs, srv)
+	return gs
+}
+
+// grpcCommand returns the metrics label for a full gRPC method name such as
+// "/inmemcache.v1.Cache/Get".
+func grpcCommand(fullMethod string) string {
+	name := path.Base(fullMethod)
+	if command, ok := grpcCommands[name]; ok {
+		return command
+	}
+	return strings.ToUpper(name)
+}
+
+// grpcAuthorize checks the authorization metadata of an RPC running command,
+// as authorize does.
+func (s *Server) grpcAuthorize(ctx context.Context, command string) error {
+	var addr, header string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("authorization"); len(v) > 0 {
+		header = v[0]
+	}
+	err := s.authorize(addr, header, command)
+	if errors.Is(err, errNoPerm) {
+		errorCounter.WithLabelValues("noperm").Inc()
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		errorCounter.WithLabelValues("unauthenticated").Inc()
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+func (s *Server) grpcAuthUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	command := grpcCommand(info.FullMethod)
+	if err := s.grpcAuthorize(ctx, command); err != nil {
+		return nil, err
+	}
+	s.txMu.RLock()
+	defer s.txMu.RUnlock()
+	hold := roleHold{server: s}
+	defer hold.release()
+	if !hold.admit(command) {
+		errorCounter.WithLabelValues(command).Inc()
+		return nil, status.Error(codes.FailedPrecondition, errReadOnly.Error())
+	}
+	if isWrite(command) {
+		// A unary RPC has its request in hand, so the handler holds logMu
+		// only while it applies and logs the write.
+		s.logMu.Lock()
+		defer s.logMu.Unlock()
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) grpcAuthStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.grpcAuthorize(ss.Context(), grpcCommand(info.FullMethod)); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcObserve records a finished RPC's duration and counts its error, if any.
+// Rejected RPCs never reach it, so they are only counted as unauthenticated.
+func grpcObserve(command string, start time.Time, err error) {
+	if err != nil {
+		errorCounter.WithLabelValues(command).Inc()
+	}
+	processingDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+}
+
+func (s *Server) grpcMetricsUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	command := grpcCommand(info.FullMethod)
+	s.countCommand(command)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcObserve(command, start, err)
+	return resp, err
+}
+
+func (s *Server) grpcMetricsStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	command := grpcCommand(info.FullMethod)
+	s.countCommand(command)
+	start := time.Now()
+	err := handler(srv, ss)
+	grpcObserve(command, start, err)
+	return err
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"github.com/vlkhvnn/inmemcache/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcClient serves srv's gRPC service on a loopback port until the test ends
+// and returns a client for it.
+func grpcClient(t *testing.T, srv *Server) rpc.CacheClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := srv.newGRPCServer(nil)
+	go gs.Serve(ln)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewCacheClient(conn)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("k", "v")
+	client := grpcClient(t, New(WithCache(c), WithPassword("hunter2")))
+
+	for _, md := range []metadata.MD{nil, metadata.Pairs("authorization", "Bearer wrong"), metadata.Pairs("authorization", "hunter2")} {
+		ctx := metadata.NewOutgoingContext(context.Background(), md)
+		if _, err := client.Get(ctx, &rpc.GetRequest{Key: "k"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%v: expected Unauthenticated, got %v", md, err)
+		}
+		stream, err := client.Scan(ctx, &rpc.ScanRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%v: expected Scan to be Unauthenticated, got %v", md, err)
+		}
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer hunter2")
+	if resp, err := client.Get(ctx, &rpc.GetRequest{Key: "k"}); err != nil || string(resp.Value) != "v" {
+		t.Fatalf("expected v, got %v, %v", resp, err)
+	}
+	stream, err := client.Scan(ctx, &rpc.ScanRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ent, err := stream.Recv(); err != nil || ent.Key != "k" {
+		t.Fatalf("expected k from Scan, got %v, %v", ent, err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("expected the scan to end, got %v", err)
+	}
+}
+
+func TestGRPCMetricsLabels(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	client := grpcClient(t, New(WithCache(c)))
+	ctx := context.Background()
+
+	sets := testutil.ToFloat64(reqCounter.WithLabelValues("SET"))
+	dels := testutil.ToFloat64(reqCounter.WithLabelValues("DEL"))
+	getErrors := testutil.ToFloat64(errorCounter.WithLabelValues("GET"))
+
+	if _, err := client.Set(ctx, &rpc.SetRequest{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	client.Delete(ctx, &rpc.DeleteRequest{Key: "k"})
+	client.Get(ctx, &rpc.GetRequest{Key: "k"})
+
+	if got := testutil.ToFloat64(reqCounter.WithLabelValues("SET")); got != sets+1 {
+		t.Fatalf("expected Set to be counted as SET, got %v more", got-sets)
+	}
+	if got := testutil.ToFloat64(reqCounter.WithLabelValues("DEL")); got != dels+1 {
+		t.Fatalf("expected Delete to be counted as DEL, got %v more", got-dels)
+	}
+	if got := testutil.ToFloat64(errorCounter.WithLabelValues("GET")); got != getErrors+1 {
+		t.Fatalf("expected a missed Get to count as a GET error, got %v more", got-getErrors)
+	}
+}
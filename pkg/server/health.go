@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+)
+
+// readiness is the server's startup and shutdown progress. The server is
+// ready once its persisted data is loaded and the TCP listener is accepting
+// connections, until it starts draining on shutdown.
+type readiness struct {
+	mu        sync.Mutex
+	loaded    bool
+	listening bool
+	draining  bool
+}
+
+// readyState is the /readyz response body.
+type readyState struct {
+	Status    string `json:"status"` // loading, starting, ready, or draining.
+	Loaded    bool   `json:"loaded"`
+	Listening bool   `json:"listening"`
+	Draining  bool   `json:"draining"`
+}
+
+// load runs load, which restores the persisted data, and marks the data
+// loaded once it succeeds, so the server is not ready while a long snapshot
+// load or AOF replay runs.
+func (r *readiness) load(load func() error) error {
+	if err := load(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loaded = true
+	return nil
+}
+
+// listen records that the TCP listener is accepting connections.
+func (r *readiness) listen() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listening = true
+}
+
+// drain records that the server is shutting down.
+func (r *readiness) drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draining = true
+}
+
+func (r *readiness) state() readyState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := readyState{Loaded: r.loaded, Listening: r.listening, Draining: r.draining}
+	switch {
+	case r.draining:
+		s.Status = "draining"
+	case !r.loaded:
+		s.Status = "loading"
+	case !r.listening:
+		s.Status = "starting"
+	default:
+		s.Status = "ready"
+	}
+	return s
+}
+
+// ServeHTTP serves /readyz: 200 while the server is ready and 503 otherwise,
+// with the state as JSON either way.
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s := r.state()
+	status := http.StatusOK
+	if s.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, s)
+}
+
+// serveHealthz serves /healthz, which succeeds whenever the process can
+// answer at all.
+func serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "up"})
+}
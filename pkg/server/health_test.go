@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// readyzState requests /readyz from r and returns the status code and body.
+func readyzState(t *testing.T, r *readiness) (int, readyState) {
+	t.Helper()
+	rec := httpDo(t, r, "GET", "/readyz", nil)
+	var s readyState
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatalf("expected a JSON state, got %v", err)
+	}
+	return rec.Code, s
+}
+
+func TestReadyz(t *testing.T) {
+	r := &readiness{}
+	started, release, loaded := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		// A slow loader, as for a large snapshot or AOF.
+		loaded <- r.load(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	if code, s := readyzState(t, r); code != http.StatusServiceUnavailable || s.Status != "loading" || s.Loaded {
+		t.Fatalf("expected 503 loading during the replay, got %d %+v", code, s)
+	}
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	if code, s := readyzState(t, r); code != http.StatusServiceUnavailable || s.Status != "starting" || !s.Loaded {
+		t.Fatalf("expected 503 starting before the listener accepts, got %d %+v", code, s)
+	}
+	r.listen()
+	if code, s := readyzState(t, r); code != http.StatusOK || s.Status != "ready" {
+		t.Fatalf("expected 200 ready, got %d %+v", code, s)
+	}
+	r.drain()
+	if code, s := readyzState(t, r); code != http.StatusServiceUnavailable || s.Status != "draining" || !s.Draining {
+		t.Fatalf("expected 503 draining on shutdown, got %d %+v", code, s)
+	}
+}
+
+func TestReadyzFailedLoad(t *testing.T) {
+	r := &readiness{}
+	failure := errors.New("corrupt snapshot")
+	if err := r.load(func() error { return failure }); err != failure {
+		t.Fatalf("expected the load error, got %v", err)
+	}
+	r.listen()
+	if code, s := readyzState(t, r); code != http.StatusServiceUnavailable || s.Loaded {
+		t.Fatalf("expected a failed load to leave the server unready, got %d %+v", code, s)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httpDo(t, http.HandlerFunc(serveHealthz), "GET", "/healthz", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"status\":\"up\"}\n" {
+		t.Fatalf("expected 200 up, got %d %q", rec.Code, rec.Body.String())
+	}
+}
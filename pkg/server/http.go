@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// httpMaxBody caps PUT bodies when -max-value-bytes is unset.
+const httpMaxBody = 512 << 20
+
+// newHTTPHandler returns the REST interface to s's cache:
+//
+//	PUT    /keys/{key}?ttl=30s  store the request body
+//	GET    /keys/{key}          fetch a value: 200 or 404
+//	DELETE /keys/{key}          remove a key: 204 or 404
+//	GET    /keys?prefix=foo     list keys as {"keys": [...]}
+//
+// Errors are JSON objects of the form {"error": "..."}. With authentication
+// enabled, every request needs an Authorization header that authorize accepts
+// for its command.
+func (s *Server) newHTTPHandler() http.Handler {
+	c := s.cache
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{key...}", s.requireAuth("GET", func(w http.ResponseWriter, r *http.Request) {
+		s.countCommand("GET")
+		s.txMu.RLock()
+		defer s.txMu.RUnlock()
+		value, err := c.GetBytes(r.PathValue("key"))
+		if errors.Is(err, cache.ErrWrongType) {
+			httpError(w, "GET", http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			httpError(w, "GET", http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+	}))
+	mux.HandleFunc("PUT /keys/{key...}", s.requireAuth("SET", func(w http.ResponseWriter, r *http.Request) {
+		s.countCommand("SET")
+		var ttl time.Duration
+		if v := r.URL.Query().Get("ttl"); v != "" {
+			var err error
+			if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+				httpError(w, "SET", http.StatusBadRequest, errors.New("ttl must be a positive duration such as 30s"))
+				return
+			}
+		}
+		limit := int64(httpMaxBody)
+		if max := s.store.MaxValueBytes(); max > 0 {
+			limit = int64(max)
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httpError(w, "SET", http.StatusRequestEntityTooLarge, cache.ErrValueTooLarge)
+			return
+		}
+		if err != nil {
+			httpError(w, "SET", http.StatusBadRequest, err)
+			return
+		}
+		// Lock out transactions and role changes only once the body is in.
+		s.txMu.RLock()
+		defer s.txMu.RUnlock()
+		hold := roleHold{server: s}
+		defer hold.release()
+		if !hold.admitWrite() {
+			httpError(w, "SET", http.StatusForbidden, errReadOnly)
+			return
+		}
+		key, value := r.PathValue("key"), string(body)
+		s.logMu.Lock()
+		err = c.SetWithTTLE(key, value, ttl)
+		if err == nil {
+			s.logWrite(s.setRecord(key, value))
+		}
+		s.logMu.Unlock()
+		if err != nil {
+			httpError(w, "SET", http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		observeSet(key, value)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("DELETE /keys/{key...}", s.requireAuth("DEL", func(w http.ResponseWriter, r *http.Request) {
+		s.countCommand("DEL")
+		s.txMu.RLock()
+		defer s.txMu.RUnlock()
+		hold := roleHold{server: s}
+		defer hold.release()
+		if !hold.admitWrite() {
+			httpError(w, "DEL", http.StatusForbidden, errReadOnly)
+			return
+		}
+		key := r.PathValue("key")
+		s.logMu.Lock()
+		removed := c.MDel(key)
+		if removed > 0 {
+			s.logWrite(aof.Record{Op: aof.OpDel, Key: key})
+		}
+		s.logMu.Unlock()
+		if removed == 0 {
+			httpError(w, "DEL", http.StatusNotFound, cache.ErrKeyNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("GET /keys", s.requireAuth("KEYS", func(w http.ResponseWriter, r *http.Request) {
+		s.countCommand("KEYS")
+		s.txMu.RLock()
+		defer s.txMu.RUnlock()
+		keys := keyspace{}.keys(c.KeysWithPrefix(r.URL.Query().Get("prefix")))
+		sort.Strings(keys)
+		writeJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+	}))
+	return mux
+}
+
+// requireAuth rejects requests that authorize refuses for command, before
+// next sees them.
+func (s *Server) requireAuth(command string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(r.RemoteAddr, r.Header.Get("Authorization"), command); err != nil {
+			if errors.Is(err, errNoPerm) {
+				httpError(w, "noperm", http.StatusForbidden, err)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer, Basic realm="mycache"`)
+			httpError(w, "unauthenticated", http.StatusUnauthorized, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// httpError writes err as a JSON error body and counts it against label.
+func httpError(w http.ResponseWriter, label string, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+	errorCounter.WithLabelValues(label).Inc()
+}
+
+// writeJSON writes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("HTTP response write failed", "err", err)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// httpDo sends a request to h and returns the recorded response.
+func httpDo(t *testing.T, h http.Handler, method, target string, body []byte, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// httpErrorBody decodes a JSON error response.
+func httpErrorBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a JSON error, got Content-Type %q", ct)
+	}
+	var body struct{ Error string }
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+		t.Fatalf("expected an error body, got %v", err)
+	}
+	return body.Error
+}
+
+func TestHTTPPutGetBinary(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	h := New(WithCache(c)).newHTTPHandler()
+
+	value := []byte{0, 1, 2, 0xff, '\r', '\n', 'x'}
+	if rec := httpDo(t, h, "PUT", "/keys/bin", value); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT: expected 204, got %d", rec.Code)
+	}
+	rec := httpDo(t, h, "GET", "/keys/bin", nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), value) {
+		t.Fatalf("GET: expected 200 with %v, got %d with %v", value, rec.Code, rec.Body.Bytes())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("expected an octet-stream, got %q", ct)
+	}
+
+	httpDo(t, h, "PUT", "/keys/a/b", []byte("nested"))
+	if v, _ := c.Get("a/b"); v != "nested" {
+		t.Fatalf("expected keys to allow slashes, got %q", v)
+	}
+
+	rec = httpDo(t, h, "GET", "/keys/missing", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("GET missing: expected 404, got %d", rec.Code)
+	}
+	if msg := httpErrorBody(t, rec); msg != cache.ErrKeyNotFound.Error() {
+		t.Fatalf("expected %q, got %q", cache.ErrKeyNotFound, msg)
+	}
+}
+
+func TestHTTPPutTTL(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	h := New(WithCache(c)).newHTTPHandler()
+
+	if rec := httpDo(t, h, "PUT", "/keys/k?ttl=30s", []byte("v")); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT: expected 204, got %d", rec.Code)
+	}
+	if _, at, err := c.PeekWithExpiry("k"); err != nil || time.Until(at) > 30*time.Second || time.Until(at) < 29*time.Second {
+		t.Fatalf("expected k to expire in 30s, got %v, %v", at, err)
+	}
+	for _, ttl := range []string{"soon", "-1s", "0s"} {
+		rec := httpDo(t, h, "PUT", "/keys/k?ttl="+ttl, []byte("v"))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("ttl=%s: expected 400, got %d", ttl, rec.Code)
+		}
+		httpErrorBody(t, rec)
+	}
+}
+
+func TestHTTPPutTooLarge(t *testing.T) {
+	c := cache.NewShardedCache(cache.WithMaxValueBytes(4))
+	defer c.Close()
+	rec := httpDo(t, New(WithCache(c)).newHTTPHandler(), "PUT", "/keys/k", []byte("too large"))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+	httpErrorBody(t, rec)
+	if c.Exists("k") {
+		t.Fatal("expected nothing stored")
+	}
+}
+
+func TestHTTPDelete(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	h := New(WithCache(c)).newHTTPHandler()
+	c.Set("k", "v")
+
+	if rec := httpDo(t, h, "DELETE", "/keys/k", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", rec.Code)
+	}
+	if c.Exists("k") {
+		t.Fatal("expected k to be deleted")
+	}
+	rec := httpDo(t, h, "DELETE", "/keys/k", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("second DELETE: expected 404, got %d", rec.Code)
+	}
+	httpErrorBody(t, rec)
+}
+
+func TestHTTPListKeys(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	h := New(WithCache(c)).newHTTPHandler()
+	for _, key := range []string{"foo:2", "foo:1", "bar", "foo/bar"} {
+		c.Set(key, "v")
+	}
+
+	rec := httpDo(t, h, "GET", "/keys?prefix=foo:", nil)
+	var body struct{ Keys []string }
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected a 200 JSON list, got %d, %v", rec.Code, err)
+	}
+	if want := []string{"foo:1", "foo:2"}; !reflect.DeepEqual(body.Keys, want) {
+		t.Fatalf("expected %v, got %v", want, body.Keys)
+	}
+
+	rec = httpDo(t, h, "GET", "/keys?prefix=none", nil)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"keys":[]}` {
+		t.Fatalf("expected an empty list, got %s", got)
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("k", "v")
+	h := New(WithCache(c), WithPassword("hunter2")).newHTTPHandler()
+
+	for _, header := range [][]string{nil, {"Authorization", "Bearer wrong"}, {"Authorization", "hunter2"}} {
+		rec := httpDo(t, h, "GET", "/keys/k", nil, header...)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%v: expected 401, got %d", header, rec.Code)
+		}
+		httpErrorBody(t, rec)
+	}
+	rec := httpDo(t, h, "GET", "/keys/k", nil, "Authorization", "Bearer hunter2")
+	if body, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || string(body) != "v" {
+		t.Fatalf("expected 200 with v, got %d with %q", rec.Code, body)
+	}
+}
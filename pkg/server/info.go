@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// infoSections lists the INFO sections in output order.
+var infoSections = []string{"server", "stats", "replication", "keyspace"}
+
+// countCommand records a request for command in reqCounter and the server's
+// commandTotals.
+func (s *Server) countCommand(command string) {
+	reqCounter.WithLabelValues(command).Inc()
+	n, ok := s.commandTotals.Load(command)
+	if !ok {
+		n, _ = s.commandTotals.LoadOrStore(command, new(atomic.Int64))
+	}
+	n.(*atomic.Int64).Add(1)
+}
+
+// writeInfo writes the requested INFO section, or all of them if section is
+// empty, as "# Section" headers followed by field:value lines. The output
+// ends with a blank line. It returns false for an unknown section.
+func (s *Server) writeInfo(w io.Writer, section string) bool {
+	c := s.cache // Nil with the simple engine, which has no cache statistics.
+	section = strings.ToLower(section)
+	if section != "" && !slices.Contains(infoSections, section) {
+		return false
+	}
+	for _, name := range infoSections {
+		if section != "" && section != name {
+			continue
+		}
+		switch name {
+		case "server":
+			infoLine(w, "# Server")
+			infoLine(w, "uptime_in_seconds:%d", int64(time.Since(s.startTime).Seconds()))
+			infoLine(w, "go_version:%s", runtime.Version())
+			infoLine(w, "goroutines:%d", runtime.NumGoroutine())
+			infoLine(w, "connected_clients:%d", s.openConns.Load())
+			infoLine(w, "total_connections_received:%d", s.totalConns.Load())
+			infoLine(w, "rejected_connections:%d", s.rejectedConns.Load())
+		case "stats":
+			var total int64
+			var commands []string
+			counts := map[string]int64{}
+			s.commandTotals.Range(func(key, value any) bool {
+				n := value.(*atomic.Int64).Load()
+				commands = append(commands, key.(string))
+				counts[key.(string)] = n
+				total += n
+				return true
+			})
+			sort.Strings(commands)
+			infoLine(w, "# Stats")
+			infoLine(w, "total_commands_processed:%d", total)
+			for _, command := range commands {
+				infoLine(w, "cmdstat_%s:calls=%d", strings.ToLower(command), counts[command])
+			}
+			if c == nil {
+				break
+			}
+			st := c.Stats()
+			infoLine(w, "keyspace_hits:%d", st.Hits)
+			infoLine(w, "keyspace_misses:%d", st.Misses)
+			infoLine(w, "stale_hits:%d", st.StaleHits)
+			infoLine(w, "sets:%d", st.Sets)
+			infoLine(w, "deletes:%d", st.Deletes)
+			infoLine(w, "evicted_keys:%d", st.Evictions)
+		case "replication":
+			infoLine(w, "# Replication")
+			s.writeReplicationInfo(w)
+		case "keyspace":
+			infoLine(w, "# Keyspace")
+			for n, count := range dbKeyCounts(s.store, s.config.databases) {
+				if count > 0 {
+					infoLine(w, "db%d:keys=%d", n, count)
+				}
+			}
+			if c == nil {
+				break
+			}
+			infoLine(w, "used_memory:%d", c.MemoryUsage())
+			infoLine(w, "compression_saved_bytes:%d", c.Stats().BytesSaved)
+		}
+	}
+	infoLine(w, "")
+	return true
+}
+
+// infoLine writes a line of INFO output.
+func infoLine(w io.Writer, format string, args ...any) {
+	protocol.WriteReply(w, protocol.Status(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// readInfo reads an INFO reply up to its terminating blank line and returns
+// its section headers and fields.
+func readInfo(t *testing.T, r *bufio.Reader) ([]string, map[string]string) {
+	t.Helper()
+	var sections []string
+	fields := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected INFO to end with a blank line, got %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return sections, fields
+		case strings.HasPrefix(line, "# "):
+			sections = append(sections, line[2:])
+		default:
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				t.Fatalf("expected a field:value line, got %q", line)
+			}
+			fields[key] = value
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "SET a 1\nSET b 2\nGET a\nGET missing\n")
+	for i := 0; i < 2; i++ {
+		r.ReadString('\n')
+	}
+	readBulkReply(t, r)
+	if line, _ := r.ReadString('\n'); line != "ERROR: key not found\n" {
+		t.Fatalf("expected a miss, got %q", line)
+	}
+
+	fmt.Fprint(conn, "INFO\n")
+	sections, fields := readInfo(t, r)
+	if strings.Join(sections, ",") != "Server,Stats,Replication,Keyspace" {
+		t.Fatalf("expected every section, got %v", sections)
+	}
+	for _, key := range []string{"uptime_in_seconds", "go_version", "goroutines", "connected_clients", "total_commands_processed", "used_memory"} {
+		if fields[key] == "" {
+			t.Fatalf("expected a %s field, got %v", key, fields)
+		}
+	}
+	if fields["db0"] != "keys=2" || fields["keyspace_hits"] != "1" || fields["keyspace_misses"] != "1" {
+		t.Fatalf("expected 2 keys, 1 hit, and 1 miss, got %v", fields)
+	}
+	if !strings.HasPrefix(fields["cmdstat_set"], "calls=") {
+		t.Fatalf("expected per-command totals, got %v", fields)
+	}
+
+	fmt.Fprint(conn, "INFO Keyspace\n")
+	if sections, fields := readInfo(t, r); len(sections) != 1 || sections[0] != "Keyspace" || fields["go_version"] != "" {
+		t.Fatalf("expected only the keyspace section, got %v, %v", sections, fields)
+	}
+
+	fmt.Fprint(conn, "INFO bogus\n")
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "ERROR: unknown INFO section") {
+		t.Fatalf("expected an unknown section error, got %q", line)
+	}
+}
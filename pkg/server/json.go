@@ -0,0 +1,33 @@
+package server
+
+import (
+	"os"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// importJSON loads the entries in the JSON file at path into c.
+func importJSON(path string, c *cache.ShardedCache) (loaded, skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return c.ImportJSONCounts(f)
+}
+
+// exportJSON writes c to path as JSON, or to standard output if path is "-".
+func exportJSON(path string, c *cache.ShardedCache) error {
+	if path == "-" {
+		return c.ExportJSON(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.ExportJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// Keyspace events announce changes to keys on pub/sub channels named
+// "__keyevent__:<event>" for database 0 and "__keyevent@<db>__:<event>" for
+// the others, with the client key as the message. The events are set, append,
+// del, rename_from, rename_to, expired, and evicted, and for writes to hashes,
+// lists, sets, sorted sets, and bits the lowercase command name, such as hset
+// or setbit, followed by del if the write left the key empty. Writes are announced as they are logged, so
+// del is published for every key a DEL names. Channels are shared by every
+// database, so keys in namespaces are never announced.
+
+// keyEventOptions returns the cache options that publish expired and evicted
+// events.
+func (s *Server) keyEventOptions() []cache.Option {
+	return []cache.Option{
+		cache.WithOnExpire(func(key, _ string) { s.publishKeyEvent("expired", key) }),
+		cache.WithOnEvict(func(key, _ string) { s.publishKeyEvent("evicted", key) }),
+	}
+}
+
+// publishWriteEvent publishes the events for a logged write, if
+// -notify-keyspace-events is set.
+func (s *Server) publishWriteEvent(rec aof.Record) {
+	if !s.config.notifyEvents {
+		return
+	}
+	switch rec.Op {
+	case aof.OpSet:
+		s.publishKeyEvent("set", rec.Key)
+	case aof.OpAppend:
+		s.publishKeyEvent("append", rec.Key)
+	case aof.OpDel:
+		s.publishKeyEvent("del", rec.Key)
+	case aof.OpRename:
+		s.publishKeyEvent("rename_from", rec.Key)
+		s.publishKeyEvent("rename_to", rec.Value)
+	}
+}
+
+// publishKeyEvent publishes event for a stored key.
+func (s *Server) publishKeyEvent(event, stored string) {
+	db, key, ok := parseStoredKey(stored)
+	if !ok {
+		return
+	}
+	channel := "__keyevent__:" + event
+	if db != 0 {
+		channel = fmt.Sprintf("__keyevent@%d__:%s", db, event)
+	}
+	s.pubsub.publish(channel, key)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// withKeyEvents turns on -notify-keyspace-events for the server under test.
+func withKeyEvents() Option {
+	return withConfig(func(c *Config) { c.notifyEvents = true })
+}
+
+// expectMessages reads messages from a subscriber, each given as "<channel> <payload>".
+func expectMessages(t *testing.T, r *bufio.Reader, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		channel, payload, _ := strings.Cut(w, " ")
+		if line, err := r.ReadString('\n'); err != nil || line != "message "+channel+"\n" {
+			t.Fatalf("expected a message on %s, got %q, %v", channel, line, err)
+		}
+		if got := readBulkReply(t, r); got != payload {
+			t.Fatalf("expected message %q, got %q", w, channel+" "+got)
+		}
+	}
+}
+
+func TestKeyEventsForWrites(t *testing.T) {
+	// The server builds its cache, with the options that publish evictions.
+	srv := startServer(t, withKeyEvents(), withConfig(func(c *Config) { c.shardCount, c.capacity = 1, 2 }))
+	sub, conn := dial(t, srv), dial(t, srv)
+	sr, r := bufio.NewReader(sub), bufio.NewReader(conn)
+
+	fmt.Fprint(sub, "SUBSCRIBE __keyevent__:set __keyevent__:del __keyevent__:evicted __keyevent@1__:set\n")
+	for i := 0; i < 4; i++ {
+		sr.ReadString('\n')
+	}
+	for _, cmd := range []string{"SET a 1", "DEL a", "SET b 2", "SET c 3", "SET d 4", "SELECT 1", "SET e 5", "NAMESPACE t", "SET f 6"} {
+		configCommand(t, conn, r, cmd)
+	}
+	expectMessages(t, sr,
+		"__keyevent__:set a",
+		"__keyevent__:del a",
+		"__keyevent__:set b",
+		"__keyevent__:set c",
+		"__keyevent__:evicted b",
+		"__keyevent__:set d",
+		"__keyevent__:evicted c",
+		"__keyevent@1__:set e",
+		"__keyevent__:evicted d",
+	)
+	// The namespaced write published no set event.
+	configCommand(t, conn, r, "PUBLISH __keyevent__:set marker")
+	expectMessages(t, sr, "__keyevent__:set marker")
+}
+
+func TestKeyEventsForKinds(t *testing.T) {
+	srv := startServer(t, withKeyEvents())
+	sub, conn := dial(t, srv), dial(t, srv)
+	sr, r := bufio.NewReader(sub), bufio.NewReader(conn)
+
+	fmt.Fprint(sub, "SUBSCRIBE __keyevent__:hset __keyevent__:hdel __keyevent__:rpush __keyevent__:del\n")
+	for i := 0; i < 4; i++ {
+		sr.ReadString('\n')
+	}
+	for _, cmd := range []string{"HSET h f v", "HDEL h missing", "RPUSH l a", "HDEL h f"} {
+		configCommand(t, conn, r, cmd)
+	}
+	// The HDEL that removed nothing published nothing.
+	expectMessages(t, sr,
+		"__keyevent__:hset h",
+		"__keyevent__:rpush l",
+		"__keyevent__:hdel h",
+		"__keyevent__:del h",
+	)
+}
+
+func TestKeyEventsExpiryPublishesOnce(t *testing.T) {
+	srv := startServer(t, withKeyEvents())
+	c := srv.Cache()
+	sub, conn := dial(t, srv), dial(t, srv)
+	sr, r := bufio.NewReader(sub), bufio.NewReader(conn)
+
+	if got := configCommand(t, sub, sr, "SUBSCRIBE __keyevent__:expired"); got != "subscribe __keyevent__:expired 1" {
+		t.Fatalf("expected a subscription, got %q", got)
+	}
+	c.SetWithTTL("session", "v", 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	configCommand(t, conn, r, "GET session")
+	configCommand(t, conn, r, "GET session")
+	c.DeleteExpired()
+	configCommand(t, conn, r, "PUBLISH __keyevent__:expired marker")
+	expectMessages(t, sr, "__keyevent__:expired session", "__keyevent__:expired marker")
+}
+
+func TestKeyEventsDisabledByDefault(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	sub, conn := dial(t, srv), dial(t, srv)
+	sr, r := bufio.NewReader(sub), bufio.NewReader(conn)
+
+	configCommand(t, sub, sr, "SUBSCRIBE __keyevent__:set")
+	if got := configCommand(t, conn, r, "SET k v"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	configCommand(t, conn, r, "PUBLISH __keyevent__:set marker")
+	expectMessages(t, sr, "__keyevent__:set marker")
+}
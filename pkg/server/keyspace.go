@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// internalKeyPrefix starts the stored keys of every logical database but 0
+// and of every namespace. They share the one cache under a per-database and
+// per-namespace key prefix, so persistence, eviction, and memory limits cover
+// them without knowing about them; key listings hide keys with this prefix
+// beyond the connection's own.
+const internalKeyPrefix = "\x00"
+
+// dbPrefix returns the key prefix of logical database n.
+func dbPrefix(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return internalKeyPrefix + "db" + strconv.Itoa(n) + internalKeyPrefix
+}
+
+// keyArgs gives, for each line protocol or RESP command that takes keys, the
+// index of its first key argument and the stride to the next one, or 0 if it
+// takes a single key.
+var keyArgs = map[string]struct{ first, step int }{
+	"SET": {1, 0}, "PSETEX": {1, 0}, "SETNX": {1, 0}, "CAS": {1, 0}, "INCR": {1, 0}, "DECR": {1, 0},
+	"INCRBY": {1, 0}, "DECRBY": {1, 0}, "APPEND": {1, 0}, "GET": {1, 0},
+	"GETDEL": {1, 0}, "PTTL": {1, 0}, "OBJECT": {2, 0}, "DUMP": {1, 0}, "RESTORE": {1, 0}, "SETTAGS": {1, 0},
+	"HSET": {1, 0}, "HGET": {1, 0}, "HGETALL": {1, 0}, "HDEL": {1, 0}, "HINCRBY": {1, 0},
+	"LPUSH": {1, 0}, "RPUSH": {1, 0}, "LPOP": {1, 0}, "RPOP": {1, 0}, "LRANGE": {1, 0},
+	"LLEN": {1, 0}, "LTRIM": {1, 0}, "SADD": {1, 0}, "SREM": {1, 0}, "SISMEMBER": {1, 0},
+	"SCARD": {1, 0}, "SMEMBERS": {1, 0}, "ZADD": {1, 0}, "ZREM": {1, 0}, "ZSCORE": {1, 0},
+	"ZRANK": {1, 0}, "ZCARD": {1, 0}, "ZRANGE": {1, 0}, "ZRANGEBYSCORE": {1, 0},
+	"SETBIT": {1, 0}, "GETBIT": {1, 0}, "BITCOUNT": {1, 0},
+	"LOCK": {1, 0}, "UNLOCK": {1, 0}, "LOCKRENEW": {1, 0},
+	"DEL": {1, 1}, "RENAME": {1, 1}, "MGET": {1, 1}, "EXISTS": {1, 1}, "SINTER": {1, 1},
+	"SUNION": {1, 1}, "WATCH": {1, 1}, "MSET": {1, 2},
+}
+
+// namespacePrefix returns the key prefix of a namespace within a database.
+func namespacePrefix(name string) string {
+	return internalKeyPrefix + "ns:" + name + internalKeyPrefix
+}
+
+// keyspace maps a connection's keys onto the stored keys of its database and
+// namespace. The zero value is database 0 without a namespace.
+type keyspace struct {
+	db        int
+	namespace string
+	prefix    string
+}
+
+// newKeyspace returns the keyspace of a namespace within database db; an
+// empty namespace is the database itself.
+func newKeyspace(db int, namespace string) keyspace {
+	prefix := dbPrefix(db)
+	if namespace != "" {
+		prefix += namespacePrefix(namespace)
+	}
+	return keyspace{db: db, namespace: namespace, prefix: prefix}
+}
+
+// selectDB returns ks moved to the database named by the argument of SELECT,
+// keeping its namespace, out of the given number of databases.
+func (ks keyspace) selectDB(arg string, databases int) (keyspace, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n >= databases {
+		return ks, fmt.Errorf("database must be between 0 and %d", databases-1)
+	}
+	return newKeyspace(n, ks.namespace), nil
+}
+
+// selectNamespace returns ks moved to the namespace named by the argument of
+// NAMESPACE, keeping its database.
+func (ks keyspace) selectNamespace(name string) (keyspace, error) {
+	if strings.Contains(name, internalKeyPrefix) {
+		return ks, errors.New("namespace must not contain NUL bytes")
+	}
+	return newKeyspace(ks.db, name), nil
+}
+
+// key returns the stored key for a client key.
+func (ks keyspace) key(k string) string { return ks.prefix + k }
+
+// strip returns the client key for a stored key the keyspace owns.
+func (ks keyspace) strip(stored string) string { return stored[len(ks.prefix):] }
+
+// owns reports whether a stored key belongs to the keyspace. A database
+// without a namespace does not own the keys of its namespaces.
+func (ks keyspace) owns(stored string) bool {
+	if !strings.HasPrefix(stored, ks.prefix) {
+		return false
+	}
+	return ks.namespace != "" || !strings.HasPrefix(stored[len(ks.prefix):], internalKeyPrefix)
+}
+
+// mapKeys rewrites the key arguments of a command in place.
+func (ks keyspace) mapKeys(command string, parts []string) {
+	args, ok := keyArgs[command]
+	if !ok || ks.prefix == "" {
+		return
+	}
+	for i := args.first; i < len(parts); i += args.step {
+		parts[i] = ks.key(parts[i])
+		if args.step == 0 {
+			break
+		}
+	}
+}
+
+// unmapKeys undoes mapKeys, rewriting stored keys to client keys in place.
+func (ks keyspace) unmapKeys(command string, parts []string) {
+	args, ok := keyArgs[command]
+	if !ok || ks.prefix == "" {
+		return
+	}
+	for i := args.first; i < len(parts); i += args.step {
+		parts[i] = ks.strip(parts[i])
+		if args.step == 0 {
+			break
+		}
+	}
+}
+
+// tags returns the stored tags for client tags, so tenants in different
+// namespaces or databases cannot invalidate each other's keys.
+func (ks keyspace) tags(tags []string) ([]string, error) {
+	stored := make([]string, len(tags))
+	for i, tag := range tags {
+		if strings.Contains(tag, internalKeyPrefix) {
+			return nil, errors.New("tag must not contain NUL bytes")
+		}
+		stored[i] = ks.key(tag)
+	}
+	return stored, nil
+}
+
+// keys returns the client keys the keyspace owns among stored keys.
+func (ks keyspace) keys(stored []string) []string {
+	keys := make([]string, 0, len(stored))
+	for _, k := range stored {
+		if ks.owns(k) {
+			keys = append(keys, ks.strip(k))
+		}
+	}
+	return keys
+}
+
+// flush deletes every key in the keyspace, logging the deletion, and returns
+// how many it removed. Keys written while it runs may survive.
+func (ks keyspace) flush(s *Server) int {
+	c := s.cache
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	if ks.namespace != "" {
+		n := c.DeleteByPrefix(ks.prefix)
+		s.logWrite(aof.Record{Op: aof.OpDelPrefix, Key: ks.prefix})
+		return n
+	}
+	var stored []string
+	for _, k := range c.KeysWithPrefix(ks.prefix) {
+		if ks.owns(k) {
+			stored = append(stored, k)
+		}
+	}
+	n := c.MDel(stored...)
+	for _, k := range stored {
+		s.logWrite(aof.Record{Op: aof.OpDel, Key: k})
+	}
+	return n
+}
+
+// parseStoredKey splits a stored key into its database and client key. It
+// reports false for the keys of namespaces.
+func parseStoredKey(stored string) (db int, key string, ok bool) {
+	if !strings.HasPrefix(stored, internalKeyPrefix) {
+		return 0, stored, true
+	}
+	rest, found := strings.CutPrefix(stored, internalKeyPrefix+"db")
+	if !found {
+		return 0, "", false
+	}
+	name, key, _ := strings.Cut(rest, internalKeyPrefix)
+	db, err := strconv.Atoi(name)
+	if err != nil || strings.HasPrefix(key, internalKeyPrefix) {
+		return 0, "", false
+	}
+	return db, key, true
+}
+
+// dbKeyCounts returns the number of keys in each of the given number of
+// logical databases, including those of its namespaces. Like Len, the count
+// for database 0 includes expired keys not yet removed.
+func dbKeyCounts(c cache.Store, databases int) []int {
+	counts := make([]int, databases)
+	others := 0
+	var keys []string
+	if sc, ok := c.(*cache.ShardedCache); ok {
+		keys = sc.KeysWithPrefix(internalKeyPrefix + "db")
+	} else {
+		keys = c.Keys()
+	}
+	for _, k := range keys {
+		rest, ok := strings.CutPrefix(k, internalKeyPrefix+"db")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, internalKeyPrefix)
+		if n, err := strconv.Atoi(name); err == nil && n > 0 && n < len(counts) {
+			counts[n]++
+			others++
+		}
+	}
+	counts[0] = c.Len() - others
+	return counts
+}
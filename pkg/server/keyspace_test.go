@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestSelectIsolatesDatabases(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	conn0, conn1 := dial(t, srv), dial(t, srv)
+	r0, r1 := bufio.NewReader(conn0), bufio.NewReader(conn1)
+
+	if got := configCommand(t, conn1, r1, "SELECT 1"); got != "OK" {
+		t.Fatalf("SELECT 1: expected OK, got %q", got)
+	}
+	configCommand(t, conn0, r0, "SET k zero")
+	configCommand(t, conn1, r1, "SET k one")
+	configCommand(t, conn1, r1, "SET only1 v")
+
+	fmt.Fprint(conn0, "GET k\n")
+	if got := readBulkReply(t, r0); got != "zero" {
+		t.Fatalf("db 0: expected zero, got %q", got)
+	}
+	fmt.Fprint(conn1, "GET k\n")
+	if got := readBulkReply(t, r1); got != "one" {
+		t.Fatalf("db 1: expected one, got %q", got)
+	}
+	if got := configCommand(t, conn0, r0, "EXISTS only1"); got != "0" {
+		t.Fatalf("expected only1 to be invisible from db 0, got %q", got)
+	}
+	fmt.Fprint(conn1, "MGET k only1\n")
+	if got := readBulkReplies(t, r1, 2); !slices.Equal(got, []string{"one", "v"}) {
+		t.Fatalf("expected MGET to read db 1, got %q", got)
+	}
+
+	fmt.Fprint(conn1, "SCAN 0 COUNT 100\n")
+	line, _ := r1.ReadString('\n')
+	if keys := strings.Fields(line)[1:]; len(keys) != 2 || strings.Contains(line, internalKeyPrefix) {
+		t.Fatalf("expected SCAN to list db 1's two keys, got %q", line)
+	}
+	fmt.Fprint(conn0, "SCAN 0 COUNT 100\n")
+	if line, _ := r0.ReadString('\n'); strings.TrimSpace(line) != "0 k" {
+		t.Fatalf("expected SCAN to list only db 0's key, got %q", line)
+	}
+
+	for _, arg := range []string{"16", "-1", "x"} {
+		if got := configCommand(t, conn0, r0, "SELECT "+arg); !strings.HasPrefix(got, "ERROR: database must be between 0 and 15") {
+			t.Fatalf("SELECT %s: expected a range error, got %q", arg, got)
+		}
+	}
+}
+
+func TestFlushDBAndFlushAll(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	configCommand(t, conn, r, "SET a 1")
+	configCommand(t, conn, r, "SELECT 2")
+	configCommand(t, conn, r, "SET b 2")
+	configCommand(t, conn, r, "SET c 3")
+
+	fmt.Fprint(conn, "INFO keyspace\n")
+	if _, fields := readInfo(t, r); fields["db0"] != "keys=1" || fields["db2"] != "keys=2" || fields["db1"] != "" {
+		t.Fatalf("expected per-db key counts, got %v", fields)
+	}
+
+	if got := configCommand(t, conn, r, "FLUSHDB"); got != "OK" {
+		t.Fatalf("FLUSHDB: expected OK, got %q", got)
+	}
+	if c.Len() != 1 || !c.Exists("a") {
+		t.Fatalf("expected FLUSHDB to keep db 0, got %d keys", c.Len())
+	}
+
+	configCommand(t, conn, r, "SET b 2")
+	if got := configCommand(t, conn, r, "FLUSHALL"); got != "OK" {
+		t.Fatalf("FLUSHALL: expected OK, got %q", got)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expected FLUSHALL to clear every database, got %d keys", c.Len())
+	}
+}
+
+func TestHTTPListKeysHidesOtherDatabases(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("a", "v")
+	c.Set(dbPrefix(3)+"b", "v")
+
+	rec := httpDo(t, New(WithCache(c)).newHTTPHandler(), "GET", "/keys", nil)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"keys":["a"]}` {
+		t.Fatalf("expected only db 0's keys, got %s", got)
+	}
+}
+
+func TestRESPSelect(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	addr := startServer(t, WithCache(c), WithProtocol("resp")).Addr().String()
+	ctx := context.Background()
+	rdb0 := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb0.Close()
+	rdb5 := redis.NewClient(&redis.Options{Addr: addr, DB: 5})
+	defer rdb5.Close()
+
+	if err := rdb5.Set(ctx, "k", "five", 0).Err(); err != nil {
+		t.Fatalf("SET in db 5: %v", err)
+	}
+	if err := rdb0.Get(ctx, "k").Err(); err != redis.Nil {
+		t.Fatalf("expected k to be missing from db 0, got %v", err)
+	}
+	if got, err := rdb5.Get(ctx, "k").Result(); err != nil || got != "five" {
+		t.Fatalf("GET in db 5: expected five, got %q, %v", got, err)
+	}
+	if err := rdb0.Do(ctx, "SELECT", "99").Err(); err == nil || !strings.Contains(err.Error(), "database must be between") {
+		t.Fatalf("expected a range error, got %v", err)
+	}
+}
+
+func TestNamespacesIsolateTenants(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	alice, bob, admin := dial(t, srv), dial(t, srv), dial(t, srv)
+	ra, rb, radmin := bufio.NewReader(alice), bufio.NewReader(bob), bufio.NewReader(admin)
+
+	if got := configCommand(t, alice, ra, "NAMESPACE alice"); got != "OK" {
+		t.Fatalf("NAMESPACE: expected OK, got %q", got)
+	}
+	configCommand(t, bob, rb, "NAMESPACE bob")
+	configCommand(t, alice, ra, "SET k a")
+	configCommand(t, alice, ra, "SET only-alice v")
+	configCommand(t, bob, rb, "SET k b")
+	configCommand(t, admin, radmin, "SET k plain")
+
+	fmt.Fprint(bob, "GET k\n")
+	if got := readBulkReply(t, rb); got != "b" {
+		t.Fatalf("expected bob's own value, got %q", got)
+	}
+	if got := configCommand(t, bob, rb, "EXISTS only-alice"); got != "0" {
+		t.Fatalf("expected alice's key to be invisible to bob, got %q", got)
+	}
+	fmt.Fprint(alice, "SCAN 0 COUNT 100\n")
+	line, _ := ra.ReadString('\n')
+	keys := strings.Fields(line)[1:]
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"k", "only-alice"}) {
+		t.Fatalf("expected SCAN to list alice's two keys without the prefix, got %q", line)
+	}
+	fmt.Fprint(admin, "SCAN 0 COUNT 100\n")
+	if line, _ := radmin.ReadString('\n'); strings.TrimSpace(line) != "0 k" {
+		t.Fatalf("expected namespaced keys to be hidden outside their namespace, got %q", line)
+	}
+
+	if got := configCommand(t, alice, ra, "FLUSHDB"); got != "OK" {
+		t.Fatalf("FLUSHDB: expected OK, got %q", got)
+	}
+	if got := configCommand(t, alice, ra, "EXISTS k only-alice"); got != "0" {
+		t.Fatalf("expected alice's keys to be flushed, got %q", got)
+	}
+	fmt.Fprint(bob, "GET k\n")
+	if got := readBulkReply(t, rb); got != "b" {
+		t.Fatalf("expected bob's key to survive alice's flush, got %q", got)
+	}
+	fmt.Fprint(admin, "GET k\n")
+	if got := readBulkReply(t, radmin); got != "plain" {
+		t.Fatalf("expected the database's own key to survive, got %q", got)
+	}
+
+	configCommand(t, bob, rb, "SELECT 1")
+	fmt.Fprint(bob, "GET k\n")
+	if line, _ := rb.ReadString('\n'); line != "ERROR: key not found\n" {
+		t.Fatalf("expected bob's namespace in db 1 to be empty, got %q", line)
+	}
+	fmt.Fprint(admin, "INFO keyspace\n")
+	if _, fields := readInfo(t, radmin); fields["db0"] != "keys=2" {
+		t.Fatalf("expected db 0 to count its namespaces' keys, got %v", fields)
+	}
+}
+
+func TestDelPrefix(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	conn, tenant := dial(t, srv), dial(t, srv)
+	r, rt := bufio.NewReader(conn), bufio.NewReader(tenant)
+
+	for i := 0; i < 5; i++ {
+		configCommand(t, conn, r, fmt.Sprintf("SET build:42:%d v", i))
+	}
+	configCommand(t, conn, r, "SET build:43:0 v")
+	configCommand(t, tenant, rt, "NAMESPACE build:42:")
+	configCommand(t, tenant, rt, "SET build:42:x v")
+
+	if got := configCommand(t, conn, r, "DELPREFIX build:42:"); got != "5" {
+		t.Fatalf("expected 5 keys removed, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "EXISTS build:43:0"); got != "1" {
+		t.Fatalf("expected other builds to survive, got %q", got)
+	}
+	if got := configCommand(t, tenant, rt, "EXISTS build:42:x"); got != "1" {
+		t.Fatalf("expected namespaced keys to survive, got %q", got)
+	}
+	if got := configCommand(t, tenant, rt, "DELPREFIX build"); got != "1" {
+		t.Fatalf("expected DELPREFIX to apply within the namespace, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "DELPREFIX"); got != "ERROR: DELPREFIX requires a prefix" {
+		t.Fatalf("expected a usage error, got %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+var (
+	activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mycache_connections_active",
+		Help: "Number of open client connections on the TCP listener",
+	})
+	connectionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mycache_connections_total",
+		Help: "Total number of connections admitted on the TCP listener",
+	})
+	connectionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mycache_connection_duration_seconds",
+		Help:    "How long admitted connections on the TCP listener stayed open",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 12), // 1ms to about 70 minutes.
+	})
+	acceptErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mycache_accept_errors_total",
+		Help: "Total number of failed accepts on the TCP listener",
+	})
+	rejectedConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mycache_rejected_connections_total",
+		Help: "Total number of connections refused by -max-connections",
+	})
+	workerQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mycache_worker_queue_depth",
+		Help: "Number of commands waiting for one of the -workers slots",
+	})
+	workersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mycache_workers_busy",
+		Help: "Number of -workers slots running a command",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(activeConnections)
+	prometheus.MustRegister(connectionsTotal)
+	prometheus.MustRegister(connectionDuration)
+	prometheus.MustRegister(acceptErrors)
+	prometheus.MustRegister(rejectedConnections)
+	prometheus.MustRegister(workerQueueDepth)
+	prometheus.MustRegister(workersBusy)
+}
+
+// admitConn reserves a slot for conn under -max-connections. If none is free
+// it closes conn, first telling the client why unless -reject-silently is
+// set, and returns false. An admitted connection must be passed to serveConn.
+func (s *Server) admitConn(conn net.Conn) bool {
+	s.totalConns.Add(1)
+	n := s.openConns.Add(1)
+	if s.config.maxConns > 0 && n > int64(s.config.maxConns) {
+		s.openConns.Add(-1)
+		s.rejectedConns.Add(1)
+		rejectedConnections.Inc()
+		if !s.config.rejectQuiet {
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			if s.config.protocolMode == "resp" {
+				fmt.Fprint(conn, "-ERR max number of clients reached\r\n")
+			} else {
+				protocol.WriteReply(conn, protocol.Error("max connections reached"))
+			}
+		}
+		conn.Close()
+		return false
+	}
+	activeConnections.Inc()
+	connectionsTotal.Inc()
+	return true
+}
+
+// serveConn handles an admitted connection with the server's protocol and
+// frees its slot once the handler returns.
+func (s *Server) serveConn(conn net.Conn) {
+	start := time.Now()
+	defer func() {
+		s.openConns.Add(-1)
+		activeConnections.Dec()
+		connectionDuration.Observe(time.Since(start).Seconds())
+	}()
+	if s.config.protocolMode == "resp" {
+		s.handleRESPConnection(conn)
+	} else {
+		s.handleConnection(conn)
+	}
+}
+
+// workerSlot records whether a connection holds one of its server's worker
+// slots.
+type workerSlot struct {
+	slots chan struct{} // The server's worker slots; nil means no cap.
+	held  bool
+}
+
+// acquire waits for a free worker slot, unless one is already held. Waiting
+// commands are counted in mycache_worker_queue_depth.
+func (s *workerSlot) acquire() {
+	if s.slots != nil && !s.held {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			workerQueueDepth.Inc()
+			s.slots <- struct{}{}
+			workerQueueDepth.Dec()
+		}
+		s.held = true
+		workersBusy.Inc()
+	}
+}
+
+// release gives back the worker slot, if one is held.
+func (s *workerSlot) release() {
+	if s.held {
+		<-s.slots
+		s.held = false
+		workersBusy.Dec()
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestMaxConnectionsRefusesExtraClients(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	const limit = 5
+	srv := startServer(t, WithCache(c), WithMaxConnections(limit))
+	addr := srv.Addr().String()
+	rejected := testutil.ToFloat64(rejectedConnections)
+
+	var accepted []net.Conn
+	refused := 0
+	for i := 0; i < limit+10; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintln(conn, "EXISTS k")
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		switch line, _ := bufio.NewReader(conn).ReadString('\n'); line {
+		case "0\n":
+			accepted = append(accepted, conn)
+		case "ERROR: max connections reached\n":
+			refused++
+		default:
+			t.Fatalf("connection %d: unexpected reply %q", i, line)
+		}
+	}
+	if len(accepted) != limit || refused != 10 {
+		t.Fatalf("expected %d accepted and 10 refused, got %d and %d", limit, len(accepted), refused)
+	}
+	if got := testutil.ToFloat64(rejectedConnections) - rejected; got != 10 {
+		t.Fatalf("expected 10 rejections counted, got %v", got)
+	}
+
+	// Closing a connection frees its slot for the next client.
+	accepted[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.openConns.Load() >= limit {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the closed connection's slot to be released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, "EXISTS k")
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "0\n" {
+		t.Fatalf("expected a new client to be admitted, got %q", line)
+	}
+}
+
+func TestMaxConnectionsRejectSilently(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	addr := startServer(t, WithCache(c), WithMaxConnections(1), withConfig(func(c *Config) { c.rejectQuiet = true })).Addr().String()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	fmt.Fprintln(first, "EXISTS k")
+	if line, _ := bufio.NewReader(first).ReadString('\n'); line != "0\n" {
+		t.Fatalf("expected the first client to be admitted, got %q", line)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(second).ReadString('\n'); line != "" || err == nil {
+		t.Fatalf("expected the second client to be closed without a reply, got %q, %v", line, err)
+	}
+}
+
+func TestWorkersDoNotStarveIdleClients(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), WithWorkers(2))
+	addr := srv.Addr().String()
+
+	// Five clients connect at once and sit idle before sending anything, so
+	// a pool with a worker per connection would leave three of them waiting.
+	conns := make([]net.Conn, 5)
+	for i := range conns {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[i] = conn
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(conns))
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			r := bufio.NewReader(conn)
+			for j := 0; j < 10; j++ {
+				fmt.Fprintf(conn, "SET k%d-%d v\n", i, j)
+				if line, err := r.ReadString('\n'); err != nil || line != "OK\n" {
+					errs <- fmt.Errorf("client %d: expected OK, got %q, %v", i, line, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(srv.workerSlots) != 0 {
+		t.Fatalf("expected every worker slot to be free between commands, %d held", len(srv.workerSlots))
+	}
+}
+
+// histogramCount returns how many observations h has recorded.
+func histogramCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	addr := startServer(t, WithCache(c)).Addr().String()
+	total, active := testutil.ToFloat64(connectionsTotal), testutil.ToFloat64(activeConnections)
+	closed := histogramCount(t, connectionDuration)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := configCommand(t, conn, bufio.NewReader(conn), "PING"); got != "PONG" {
+		t.Fatalf("expected PONG, got %q", got)
+	}
+	if got := testutil.ToFloat64(connectionsTotal) - total; got != 1 {
+		t.Fatalf("expected 1 connection counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(activeConnections) - active; got != 1 {
+		t.Fatalf("expected 1 more active connection, got %v", got)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for histogramCount(t, connectionDuration) == closed {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the closed connection's duration to be observed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := testutil.ToFloat64(activeConnections); got != active {
+		t.Fatalf("expected the active gauge back at %v, got %v", active, got)
+	}
+}
+
+func TestWorkerQueueDepth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), WithWorkers(1))
+	addr := srv.Addr().String()
+
+	// Hold the only slot, so the client's command has to wait for it.
+	busy := testutil.ToFloat64(workersBusy)
+	held := workerSlot{slots: srv.workerSlots}
+	held.acquire()
+	if got := testutil.ToFloat64(workersBusy) - busy; got != 1 {
+		t.Fatalf("expected 1 busy worker, got %v", got)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, "PING")
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(workerQueueDepth) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the waiting command to be counted in the queue depth")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	held.release()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "PONG\n" {
+		t.Fatalf("expected PONG once the slot was free, got %q", line)
+	}
+	if got := testutil.ToFloat64(workerQueueDepth); got != 0 {
+		t.Fatalf("expected an empty queue, got %v", got)
+	}
+}
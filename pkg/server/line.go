@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// Limits on line protocol requests.
+const (
+	lineMaxValue = 512 << 20 // Used when the cache does not limit value sizes.
+
+	// pipelineMaxQueued caps the replies buffered for a client that keeps
+	// pipelining commands before they are flushed.
+	pipelineMaxQueued = 128
+)
+
+var (
+	errLineTooLong  = errors.New("request too large")
+	errBadDataBlock = errors.New("data block is not followed by a newline")
+)
+
+// readLine reads a command line terminated by LF or CRLF, without the
+// terminator. A line over max bytes, which is -max-request-bytes, is consumed
+// in full without being buffered and reported as errLineTooLong, so the next
+// command can still be read.
+func readLine(r *bufio.Reader, max int) (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF && (len(line) > 0 || tooLong) {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > max {
+				tooLong, line = true, nil
+			}
+		}
+		if !isPrefix {
+			if tooLong {
+				return "", errLineTooLong
+			}
+			return string(line), nil
+		}
+	}
+}
+
+// parseLength parses the "$<nbytes>" argument of a binary-safe command,
+// reporting false if arg is not one.
+func parseLength(arg string) (int, bool) {
+	digits, ok := strings.CutPrefix(arg, "$")
+	if !ok || digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	return n, err == nil
+}
+
+// readDataBlock reads an n-byte value followed by LF or CRLF. A value over
+// maxValue bytes, or lineMaxValue if maxValue is zero, is skipped and reported
+// as cache.ErrValueTooLarge, leaving the connection in sync; any other error
+// means it no longer is.
+func readDataBlock(r *bufio.Reader, n, maxValue int) (string, error) {
+	limit := lineMaxValue
+	if maxValue > 0 {
+		limit = maxValue
+	}
+	if n > limit {
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return "", err
+		}
+		if err := readTerminator(r); err != nil {
+			return "", err
+		}
+		return "", cache.ErrValueTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if err := readTerminator(r); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// readTerminator consumes the LF or CRLF that ends a data block.
+func readTerminator(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err == nil && b == '\r' {
+		b, err = r.ReadByte()
+	}
+	if err != nil {
+		return err
+	}
+	if b != '\n' {
+		return errBadDataBlock
+	}
+	return nil
+}
@@ -0,0 +1,757 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// readBulkReply reads a "$<nbytes>" framed GET reply.
+func readBulkReply(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if _, err := fmt.Sscanf(header, "$%d\r\n", &n); err != nil {
+		t.Fatalf("expected a byte count header, got %q", header)
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil || string(data[n:]) != "\r\n" {
+		t.Fatalf("expected %d bytes and CRLF, got %q, %v", n, data, err)
+	}
+	return string(data[:n])
+}
+
+// readBulkReplies reads n data block replies.
+func readBulkReplies(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	values := make([]string, n)
+	for i := range values {
+		values[i] = readBulkReply(t, r)
+	}
+	return values
+}
+
+// readCountedReply reads a count line followed by that many data blocks, as
+// LRANGE and SMEMBERS reply.
+func readCountedReply(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	n, err := strconv.Atoi(readReply(t, r))
+	if err != nil {
+		t.Fatalf("expected a count line, got %v", err)
+	}
+	return readBulkReplies(t, r, n)
+}
+
+func TestLineBinarySafeSet(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c)))
+		r := bufio.NewReader(conn)
+
+		values := []string{
+			"line one\nline two",
+			"carriage\rreturn\r\n",
+			"  leading and trailing  ",
+			"runs    of   spaces",
+			"null\x00bytes\x00",
+			"",
+		}
+		for i, value := range values {
+			key := fmt.Sprintf("k%d", i)
+			fmt.Fprintf(conn, "SET %s $%d\r\n%s\r\n", key, len(value), value)
+			if line, err := r.ReadString('\n'); err != nil || line != "OK\n" {
+				t.Fatalf("SET %q: expected OK, got %q, %v", value, line, err)
+			}
+			fmt.Fprintf(conn, "GET %s\n", key)
+			if got := readBulkReply(t, r); got != value {
+				t.Fatalf("expected %q back, got %q", value, got)
+			}
+		}
+
+		// A bare LF after the data block is accepted too.
+		fmt.Fprint(conn, "SET lf $3\nabc\nGET lf\n")
+		if line, _ := r.ReadString('\n'); line != "OK\n" {
+			t.Fatalf("expected OK, got %q", line)
+		}
+		if got := readBulkReply(t, r); got != "abc" {
+			t.Fatalf("expected abc, got %q", got)
+		}
+	})
+}
+
+func TestLineGetDelBinarySafe(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c)))
+		r := bufio.NewReader(conn)
+
+		c.Set("k", "line one\nline two")
+		fmt.Fprint(conn, "GETDEL k\nPING\n")
+		if got := readBulkReply(t, r); got != "line one\nline two" {
+			t.Fatalf("expected the value back, got %q", got)
+		}
+		if line, _ := r.ReadString('\n'); line != "PONG\n" {
+			t.Fatalf("expected the next reply intact, got %q", line)
+		}
+		if c.Exists("k") {
+			t.Fatal("expected GETDEL to remove the key")
+		}
+	})
+}
+
+func TestLineOneLineSetStillWorks(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c)))
+		r := bufio.NewReader(conn)
+
+		fmt.Fprint(conn, "SET greeting hello world\r\nSET n 5\nGET greeting\n")
+		for _, want := range []string{"OK\n", "OK\n"} {
+			if line, _ := r.ReadString('\n'); line != want {
+				t.Fatalf("expected %q, got %q", want, line)
+			}
+		}
+		if got := readBulkReply(t, r); got != "hello world" {
+			t.Fatalf("expected hello world, got %q", got)
+		}
+		if v, _ := c.Get("n"); v != "5" {
+			t.Fatalf("expected a numeric one-line value to be stored as is, got %q", v)
+		}
+	})
+}
+
+// Values with line breaks come back in data blocks from every command, so
+// they cannot be read as extra replies.
+func TestLineMGetLineBreaks(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "SET k $3\r\na\nb\r\n")
+	if got := readReply(t, r); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	fmt.Fprint(conn, "MGET k other\n")
+	if got := readBulkReply(t, r); got != "a\nb" {
+		t.Fatalf("expected the value with its line break, got %q", got)
+	}
+	if got := readReply(t, r); got != "(nil)" {
+		t.Fatalf("expected (nil) for the missing key, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "PING"); got != "PONG" {
+		t.Fatalf("expected the connection to stay in step, got %q", got)
+	}
+}
+
+func TestLineBinarySafeSetErrors(t *testing.T) {
+	c := cache.NewShardedCache(cache.WithMaxValueBytes(4))
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	// An oversized value is skipped, and the next command still works.
+	fmt.Fprint(conn, "SET big $9\r\ntoo\nlarge\r\nSET ok $2\r\nhi\r\n")
+	line, _ := r.ReadString('\n')
+	if !strings.HasPrefix(line, "ERROR:") {
+		t.Fatalf("expected an error for an oversized value, got %q", line)
+	}
+	if line, _ := r.ReadString('\n'); line != "OK\n" {
+		t.Fatalf("expected the connection to stay in sync, got %q", line)
+	}
+	if c.Exists("big") {
+		t.Fatal("expected the oversized value not to be stored")
+	}
+
+	// MSET refuses every pair if one value is over the cache's limit.
+	if got := configCommand(t, conn, r, "MSET a 1 b toolarge"); got != "ERROR: "+cache.ErrValueTooLarge.Error() {
+		t.Fatalf("expected MSET to be refused, got %q", got)
+	}
+	if c.Exists("a") || c.Exists("b") {
+		t.Fatal("expected MSET to store nothing")
+	}
+
+	// A block longer than its header closes the connection.
+	fmt.Fprint(conn, "SET bad $2\r\ntoolong\r\n")
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "ERROR:") {
+		t.Fatalf("expected an error for a bad data block, got %q", line)
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestLinePipelinedBatch(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c)))
+
+		const n = 1000
+		var batch strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&batch, "SET k%d v%d\r\n", i, i)
+		}
+		batch.WriteString("GET k999\r\n")
+		go conn.Write([]byte(batch.String()))
+
+		r := bufio.NewReader(conn)
+		for i := 0; i < n; i++ {
+			if line, err := r.ReadString('\n'); err != nil || line != "OK\n" {
+				t.Fatalf("reply %d: expected OK, got %q, %v", i, line, err)
+			}
+		}
+		if got := readBulkReply(t, r); got != "v999" {
+			t.Fatalf("expected the replies in request order, got %q last", got)
+		}
+		if c.Len() != n {
+			t.Fatalf("expected %d keys, got %d", n, c.Len())
+		}
+	})
+}
+
+// benchmarkLineSets sends b.N SETs, in batches of depth before reading the
+// replies.
+func benchmarkLineSets(b *testing.B, depth int) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := New(WithCache(c), WithRegisterer(prometheus.NewRegistry()))
+	if err := srv.Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += depth {
+		batch := min(depth, b.N-i)
+		for j := 0; j < batch; j++ {
+			fmt.Fprintf(w, "SET k%d v\r\n", i+j)
+		}
+		if err := w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < batch; j++ {
+			if _, err := r.ReadString('\n'); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkLineSequential(b *testing.B) { benchmarkLineSets(b, 1) }
+func BenchmarkLinePipelined(b *testing.B)  { benchmarkLineSets(b, 100) }
+
+func TestLineRequestTooLarge(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c), withConfig(func(c *Config) { c.maxRequest = 32 })))
+		r := bufio.NewReader(conn)
+
+		// "SET k " is 6 bytes, so these lines are one byte under, at, and over
+		// the limit.
+		for _, n := range []int{25, 26, 27} {
+			fmt.Fprintf(conn, "SET k %s\r\n", strings.Repeat("x", n))
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "OK\n"; 6+n <= 32 && line != want {
+				t.Fatalf("%d-byte line: expected %q, got %q", 6+n, want, line)
+			}
+			if want := "ERROR: request too large\n"; 6+n > 32 && line != want {
+				t.Fatalf("%d-byte line: expected %q, got %q", 6+n, want, line)
+			}
+		}
+		if v, _ := c.Get("k"); len(v) != 26 {
+			t.Fatalf("expected the line at the limit to be stored, got %d bytes", len(v))
+		}
+
+		// A line far over the limit, spanning many reads, is skipped in full and
+		// the connection stays usable.
+		go fmt.Fprintf(conn, "SET big %s\nSET after ok\n", strings.Repeat("x", 1<<20))
+		if line, _ := r.ReadString('\n'); line != "ERROR: request too large\n" {
+			t.Fatalf("expected a too large error, got %q", line)
+		}
+		if line, _ := r.ReadString('\n'); line != "OK\n" {
+			t.Fatalf("expected the next command to succeed, got %q", line)
+		}
+		if c.Exists("big") {
+			t.Fatal("expected the oversized command not to run")
+		}
+	})
+}
+
+func TestLinePingEchoQuit(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c)))
+		r := bufio.NewReader(conn)
+
+		fmt.Fprint(conn, "PING\nping hello there\nECHO  a  message \nECHO\nQUIT\nPING\n")
+		for _, want := range []string{"PONG\n", "hello there\n", "a message\n", "ERROR: ECHO requires a message\n", "OK\n"} {
+			if line, err := r.ReadString('\n'); err != nil || line != want {
+				t.Fatalf("expected %q, got %q, %v", want, line, err)
+			}
+		}
+		if line, err := r.ReadString('\n'); err != io.EOF {
+			t.Fatalf("expected QUIT to close the connection, got %q, %v", line, err)
+		}
+	})
+}
+
+func TestLinePingBeforeAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c), WithPassword("hunter2")))
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "PING\nECHO hi\nAUTH hunter2\nECHO hi\n")
+	for _, want := range []string{"PONG\n", "ERROR: Authentication required. Please use AUTH <password>\n", "OK\n", "hi\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("expected %q, got %q, %v", want, line, err)
+		}
+	}
+}
+
+func TestLineSetTagsInvalTag(t *testing.T) {
+	c := cache.NewShardedCache(cache.WithShardCount(1), cache.WithShardCapacity(4))
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	conn, tenant := dial(t, srv), dial(t, srv)
+	r, rt := bufio.NewReader(conn), bufio.NewReader(tenant)
+
+	fmt.Fprint(conn, "SETTAGS a 1 user:1\nSETTAGS b 2 user:1 feed\nSETTAGS c 3 feed\n")
+	for i := 0; i < 3; i++ {
+		r.ReadString('\n')
+	}
+	configCommand(t, tenant, rt, "NAMESPACE other")
+	configCommand(t, tenant, rt, "SETTAGS x 1 user:1")
+	fmt.Fprint(conn, "SETTAGS d 4 user:1\nSETTAGS e 5\n")
+	for _, want := range []string{"OK\n", "ERROR: SETTAGS requires key, value, and at least one tag\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("expected %q, got %q, %v", want, line, err)
+		}
+	}
+
+	// a was evicted to make room for d, so only b and d carry the tag.
+	if got := configCommand(t, conn, r, "INVALTAG user:1"); got != "2" {
+		t.Fatalf("expected 2 keys invalidated, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "EXISTS a b c d"); got != "1" {
+		t.Fatalf("expected only c to remain, got %q", got)
+	}
+	if got := configCommand(t, tenant, rt, "EXISTS x"); got != "1" {
+		t.Fatalf("expected another namespace's tag to be untouched, got %q", got)
+	}
+	if got := configCommand(t, tenant, rt, "INVALTAG user:1"); got != "1" {
+		t.Fatalf("expected the tenant's own key invalidated, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "INVALTAG"); got != "ERROR: INVALTAG requires a tag" {
+		t.Fatalf("expected a usage error, got %q", got)
+	}
+}
+
+func TestLineHashCommands(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	for cmd, want := range map[string]string{
+		"HSET user name ada lovelace": "1",
+		"HSET user lang ada":          "1",
+	} {
+		if got := configCommand(t, conn, r, cmd); got != want {
+			t.Fatalf("%s: expected %q, got %q", cmd, want, got)
+		}
+	}
+	if got := configCommand(t, conn, r, "HSET user lang cobol"); got != "0" {
+		t.Fatalf("expected an existing field to report 0, got %q", got)
+	}
+	fmt.Fprint(conn, "HGET user name\n")
+	if value := readBulkReply(t, r); value != "ada lovelace" {
+		t.Fatalf("expected the joined value, got %q", value)
+	}
+
+	fmt.Fprint(conn, "HGETALL user\n")
+	if got := readReply(t, r); got != "2" {
+		t.Fatalf("expected 2 fields, got %q", got)
+	}
+	if got := readBulkReplies(t, r, 4); !slices.Equal(got, []string{"lang", "cobol", "name", "ada lovelace"}) {
+		t.Fatalf("expected the fields and values, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "HINCRBY user visits 3"); got != "3" {
+		t.Fatalf("expected 3, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "HINCRBY user lang 1"); got != "ERROR: value is not an integer" {
+		t.Fatalf("expected a non-numeric error, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "GET user"); !strings.HasPrefix(got, "ERROR: WRONGTYPE ") {
+		t.Fatalf("expected a WRONGTYPE error, got %q", got)
+	}
+	configCommand(t, conn, r, "SET plain v")
+	if got := configCommand(t, conn, r, "HGET plain f"); !strings.HasPrefix(got, "ERROR: WRONGTYPE ") {
+		t.Fatalf("expected a WRONGTYPE error, got %q", got)
+	}
+
+	if got := configCommand(t, conn, r, "HDEL user name lang visits missing"); got != "3" {
+		t.Fatalf("expected 3 fields removed, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "EXISTS user"); got != "0" {
+		t.Fatalf("expected the empty hash to be gone, got %q", got)
+	}
+}
+
+func TestLineListCommands(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	if got := configCommand(t, conn, r, "RPUSH q b c"); got != "2" {
+		t.Fatalf("expected length 2, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "LPUSH q a"); got != "3" {
+		t.Fatalf("expected length 3, got %q", got)
+	}
+	fmt.Fprint(conn, "LRANGE q 0 -1\n")
+	if got := readCountedReply(t, r); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("expected a, b, and c, got %q", got)
+	}
+	fmt.Fprint(conn, "RPOP q\n")
+	if value := readBulkReply(t, r); value != "c" {
+		t.Fatalf("expected c, got %q", value)
+	}
+	if got := configCommand(t, conn, r, "LTRIM q 1 -1"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "LLEN q"); got != "1" {
+		t.Fatalf("expected length 1, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "LRANGE q x 1"); got != "ERROR: start and stop must be integers" {
+		t.Fatalf("expected an index error, got %q", got)
+	}
+	configCommand(t, conn, r, "SET plain v")
+	if got := configCommand(t, conn, r, "LPUSH plain x"); !strings.HasPrefix(got, "ERROR: WRONGTYPE ") {
+		t.Fatalf("expected a WRONGTYPE error, got %q", got)
+	}
+
+	fmt.Fprint(conn, "LPOP q\n")
+	if value := readBulkReply(t, r); value != "b" {
+		t.Fatalf("expected b, got %q", value)
+	}
+	if got := configCommand(t, conn, r, "LPOP q"); got != "ERROR: key not found" {
+		t.Fatalf("expected the emptied list to be gone, got %q", got)
+	}
+}
+
+func TestLineSetCommands(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct{ cmd, want string }{
+		{"SADD a x y x", "2"},
+		{"SADD b y z", "2"},
+		{"SISMEMBER a y", "1"},
+		{"SISMEMBER a z", "0"},
+		{"SISMEMBER nope x", "0"},
+		{"SCARD a", "2"},
+		{"SINTER a", "ERROR: SINTER requires two keys"},
+		{"SMEMBERS", "ERROR: SMEMBERS requires key"},
+		{"SADD", "ERROR: SADD requires key and at least one member"},
+	} {
+		if got := configCommand(t, conn, r, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+	for cmd, want := range map[string][]string{
+		"SMEMBERS a": {"x", "y"},
+		"SINTER a b": {"y"},
+		"SUNION a b": {"x", "y", "z"},
+	} {
+		fmt.Fprintln(conn, cmd)
+		if got := readCountedReply(t, r); !slices.Equal(got, want) {
+			t.Fatalf("%s: expected %q, got %q", cmd, want, got)
+		}
+	}
+	configCommand(t, conn, r, "SET plain v")
+	if got := configCommand(t, conn, r, "SADD plain x"); !strings.HasPrefix(got, "ERROR: WRONGTYPE ") {
+		t.Fatalf("expected a WRONGTYPE error, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "SREM a x y"); got != "2" {
+		t.Fatalf("expected 2 members removed, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "EXISTS a"); got != "0" {
+		t.Fatalf("expected the empty set to be gone, got %q", got)
+	}
+}
+
+func TestLineSortedSetCommands(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct{ cmd, want string }{
+		{"ZADD board 30 ada 10 bob 20 cy", "3"},
+		{"ZADD board 40 bob", "0"},
+		{"ZSCORE board bob", "40"},
+		{"ZRANK board ada", "1"},
+		{"ZCARD board", "3"},
+		{"ZADD board x ada", "ERROR: score is not a number"},
+		{"ZADD board 1", "ERROR: ZADD requires key and score member pairs"},
+		{"ZRANK board dee", "ERROR: key not found"},
+	} {
+		if got := configCommand(t, conn, r, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+	fmt.Fprintln(conn, "ZRANGE board 0 -1")
+	if got := readCountedReply(t, r); !slices.Equal(got, []string{"cy", "ada", "bob"}) {
+		t.Fatalf("ZRANGE: expected cy, ada, and bob, got %q", got)
+	}
+	// With scores, each member is followed by a line with its score.
+	for cmd, want := range map[string][]string{
+		"ZRANGE board -1 -1 WITHSCORES":          {"1", "bob", "40"},
+		"ZRANGEBYSCORE board 25 +inf withscores": {"2", "ada", "30", "bob", "40"},
+	} {
+		fmt.Fprintln(conn, cmd)
+		got := []string{readReply(t, r)}
+		for len(got) < len(want) {
+			got = append(got, readBulkReply(t, r), readReply(t, r))
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s: expected %q, got %q", cmd, want, got)
+		}
+	}
+	configCommand(t, conn, r, "SET plain v")
+	if got := configCommand(t, conn, r, "ZADD plain 1 x"); !strings.HasPrefix(got, "ERROR: WRONGTYPE ") {
+		t.Fatalf("expected a WRONGTYPE error, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "ZREM board ada bob cy"); got != "3" {
+		t.Fatalf("expected 3 members removed, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "EXISTS board"); got != "0" {
+		t.Fatalf("expected the empty sorted set to be gone, got %q", got)
+	}
+}
+
+func TestLineBitCommands(t *testing.T) {
+	c := cache.NewShardedCache(cache.WithMaxBitOffset(1023))
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct{ cmd, want string }{
+		{"SETBIT seen 7 1", "0"},
+		{"SETBIT seen 7 1", "1"},
+		{"SETBIT seen 100 1", "0"},
+		{"GETBIT seen 7", "1"},
+		{"GETBIT seen 8", "0"},
+		{"BITCOUNT seen", "2"},
+		{"SETBIT seen 7 0", "1"},
+		{"BITCOUNT seen", "1"},
+		{"BITCOUNT missing", "0"},
+		{"SETBIT seen 1024 1", "ERROR: bit offset is out of range"},
+		{"SETBIT seen 1 2", "ERROR: offset must be an integer and value 0 or 1"},
+		{"GETBIT seen x", "ERROR: offset must be an integer"},
+	} {
+		if got := configCommand(t, conn, r, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+	configCommand(t, conn, r, "SADD set m")
+	if got := configCommand(t, conn, r, "SETBIT set 0 1"); !strings.HasPrefix(got, "ERROR: WRONGTYPE ") {
+		t.Fatalf("expected a WRONGTYPE error, got %q", got)
+	}
+}
+
+// histogramSum returns the count and sum of h's observations.
+func histogramSum(t *testing.T, h prometheus.Histogram) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestLineSetSizeHistograms(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+	keys, keySum := histogramSum(t, keyBytes)
+	values, valueSum := histogramSum(t, valueBytes)
+
+	configCommand(t, conn, r, "SELECT 1")
+	if got := configCommand(t, conn, r, "SET abc "+strings.Repeat("x", 100)); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	// The key is observed as the client sent it, without its database prefix.
+	if n, sum := histogramSum(t, keyBytes); n != keys+1 || sum-keySum != 3 {
+		t.Fatalf("expected one 3-byte key observed, got %d more totalling %v", n-keys, sum-keySum)
+	}
+	if n, sum := histogramSum(t, valueBytes); n != values+1 || sum-valueSum != 100 {
+		t.Fatalf("expected one 100-byte value observed, got %d more totalling %v", n-values, sum-valueSum)
+	}
+}
+
+func TestLinePSetEx(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	if got := configCommand(t, conn, r, "PSETEX k 60000 hello world"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	if v, _ := c.Get("k"); v != "hello world" {
+		t.Fatalf("expected the value to be stored, got %q", v)
+	}
+	if _, expireAt, _ := c.PeekWithExpiry("k"); time.Until(expireAt) <= 59*time.Second || time.Until(expireAt) > time.Minute {
+		t.Fatalf("expected a TTL of about a minute, got an expiry at %v", expireAt)
+	}
+
+	fmt.Fprint(conn, "PSETEX bin 60000 $5\r\na\nb\r\n\r\n")
+	if line, _ := r.ReadString('\n'); line != "OK\n" {
+		t.Fatalf("expected OK for a data block, got %q", line)
+	}
+	if v, _ := c.Get("bin"); v != "a\nb\r\n" {
+		t.Fatalf("expected the binary value to be stored, got %q", v)
+	}
+
+	for _, command := range []string{"PSETEX k 0 v", "PSETEX k soon v", "PSETEX k 100"} {
+		if got := configCommand(t, conn, r, command); !strings.HasPrefix(got, "ERROR: PSETEX requires") {
+			t.Fatalf("%s: expected an error, got %q", command, got)
+		}
+	}
+}
+
+func TestLinePTTL(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	c.SetWithTTL("k", "v", time.Minute)
+	c.Set("forever", "v")
+	got := configCommand(t, conn, r, "PTTL k")
+	if ms, err := strconv.Atoi(got); err != nil || ms <= 59000 || ms > 60000 {
+		t.Fatalf("expected about 60000 milliseconds, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "PTTL forever"); got != "-1" {
+		t.Fatalf("expected -1 for a key without a TTL, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "PTTL missing"); got != "-2" {
+		t.Fatalf("expected -2 for a missing key, got %q", got)
+	}
+}
+
+func TestLineObjectInfo(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	c.SetWithTTL("k", "v", time.Minute)
+	c.Get("k")
+	if got := configCommand(t, conn, r, "OBJECT INFO k"); got != "5" {
+		t.Fatalf("expected 5 fields, got %q", got)
+	}
+	fields := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		name, value, _ := strings.Cut(readReply(t, r), " ")
+		fields[name] = value
+	}
+	if value := readBulkReply(t, r); value != "v" {
+		t.Fatalf("expected the value, got %q", value)
+	}
+	if fields["hits"] != "1" || fields["shard"] == "" || fields["created"] == "" || fields["last-access"] == "" {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+	if ms, err := strconv.Atoi(fields["ttl-ms"]); err != nil || ms <= 59000 || ms > 60000 {
+		t.Fatalf("expected about 60000 milliseconds to live, got %q", fields["ttl-ms"])
+	}
+	if got := configCommand(t, conn, r, "OBJECT INFO missing"); got != "ERROR: key not found" {
+		t.Fatalf("expected key not found, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "OBJECT ENCODING k"); !strings.HasPrefix(got, "ERROR: OBJECT requires") {
+		t.Fatalf("expected an error, got %q", got)
+	}
+}
+
+func TestLineAppendDataBlock(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	c.Set("k", "a")
+	fmt.Fprint(conn, "APPEND k $4\r\n b\r\n\r\n")
+	if line, _ := r.ReadString('\n'); line != "5\n" {
+		t.Fatalf("expected the new length, got %q", line)
+	}
+	if v, _ := c.Get("k"); v != "a b\r\n" {
+		t.Fatalf("expected the suffix to be appended verbatim, got %q", v)
+	}
+}
+
+func TestLineLock(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct{ command, want string }{
+		{"LOCK job 60000 holder-1", "1"},
+		{"LOCK job 60000 holder-2", "0"},
+		{"LOCKRENEW job holder-2 60000", "0"},
+		{"UNLOCK job holder-2", "0"},
+		{"LOCKRENEW job holder-1 80", "1"},
+	} {
+		if got := configCommand(t, conn, r, tc.command); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.command, tc.want, got)
+		}
+	}
+
+	// The renewed lease expires on its own, and the stale holder cannot
+	// release the next holder's lock.
+	time.Sleep(150 * time.Millisecond)
+	for _, tc := range []struct{ command, want string }{
+		{"LOCK job 60000 holder-2", "1"},
+		{"UNLOCK job holder-1", "0"},
+		{"LOCKRENEW job holder-1 60000", "0"},
+		{"UNLOCK job holder-2", "1"},
+		{"UNLOCK job holder-2", "0"},
+	} {
+		if got := configCommand(t, conn, r, tc.command); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.command, tc.want, got)
+		}
+	}
+	if c.Exists("job") {
+		t.Fatal("expected UNLOCK to delete the lock")
+	}
+
+	for _, command := range []string{"LOCK job 0 t", "LOCK job soon t", "LOCK job 100", "LOCKRENEW job t -1", "UNLOCK job"} {
+		if got := configCommand(t, conn, r, command); !strings.HasPrefix(got, "ERROR: ") {
+			t.Fatalf("%s: expected an error, got %q", command, got)
+		}
+	}
+}
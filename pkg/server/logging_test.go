@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent loggers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON records written so far.
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var recs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("expected a JSON record, got %q: %v", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// find returns the first record with the given message, or nil.
+func find(recs []map[string]any, msg string) map[string]any {
+	for _, rec := range recs {
+		if rec["msg"] == msg {
+			return rec
+		}
+	}
+	return nil
+}
+
+// count returns how many records have the given message.
+func count(recs []map[string]any, msg string) int {
+	n := 0
+	for _, rec := range recs {
+		if rec["msg"] == msg {
+			n++
+		}
+	}
+	return n
+}
+
+// captureLogs logs JSON records at debug level and above into a buffer
+// until the test ends.
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+	buf := &syncBuffer{}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	return buf
+}
+
+func TestConnectionLogging(t *testing.T) {
+	logs := captureLogs(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), WithPassword("hunter2"))
+	conn := dial(t, srv)
+	if got := configCommand(t, conn, bufio.NewReader(conn), "AUTH wrong"); got != "ERROR: Invalid password" {
+		t.Fatalf("expected AUTH to fail, got %q", got)
+	}
+	conn = dial(t, srv)
+	r := bufio.NewReader(conn)
+	if got := configCommand(t, conn, r, "AUTH hunter2"); got != "OK" {
+		t.Fatalf("expected AUTH to succeed, got %q", got)
+	}
+	configCommand(t, conn, r, "GET missing")
+	conn.Close()
+
+	var recs []map[string]any
+	deadline := time.Now().Add(2 * time.Second)
+	for recs = logs.records(t); count(recs, "connection closed") < 2; recs = logs.records(t) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected both connections' closes to be logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rec := find(recs, "connection opened"); rec == nil || rec["conn_id"] == nil || rec["remote_addr"] == nil {
+		t.Fatalf("expected the open to carry the connection's id and address, got %v", rec)
+	}
+	if rec := find(recs, "auth failed"); rec == nil || rec["level"] != "WARN" || rec["outcome"] != "invalid" || rec["conn_id"] == nil {
+		t.Fatalf("expected a warning for the failed AUTH, got %v", rec)
+	}
+	authed := find(recs, "authenticated")
+	if authed == nil || authed["method"] != "password" || authed["user"] != "default" || authed["remote_addr"] != conn.LocalAddr().String() {
+		t.Fatalf("expected the successful AUTH to be logged, got %v", authed)
+	}
+	if rec := find(recs, "command"); rec == nil || rec["conn_id"] != authed["conn_id"] {
+		t.Fatalf("expected commands to be logged at debug level, got %v", rec)
+	}
+	for _, rec := range recs {
+		if rec["msg"] == "connection closed" && rec["conn_id"] == authed["conn_id"] && rec["commands"] != float64(2) {
+			t.Fatalf("expected the close to count 2 commands, got %v", rec)
+		}
+	}
+}
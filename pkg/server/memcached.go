@@ -0,0 +1,362 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// Limits on memcached requests, matching memcached's own.
+const (
+	memcachedMaxLine     = 2048
+	memcachedMaxItem     = 1 << 20           // Used when -max-value-bytes is unset.
+	memcachedRelativeTTL = 30 * 24 * 60 * 60 // Larger exptimes are Unix timestamps.
+)
+
+// memcachedCommands lists the supported commands, upper-cased.
+var memcachedCommands = map[string]bool{
+	"GET": true, "GETS": true, "SET": true, "ADD": true, "REPLACE": true,
+	"DELETE": true, "INCR": true, "DECR": true, "TOUCH": true,
+}
+
+// errMemcachedLineTooLong is returned for a command line over memcachedMaxLine.
+var errMemcachedLineTooLong = errors.New("line too long")
+
+// serveMemcached accepts memcached text protocol connections on ln until it
+// is closed.
+func (s *Server) serveMemcached(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("failed to accept memcached connection", "err", err)
+			continue
+		}
+		go s.handleMemcachedConnection(conn)
+	}
+}
+
+// handleMemcachedConnection serves a single memcached text protocol
+// connection. It supports get, gets, set, add, replace, delete, incr, decr,
+// and touch. Flags are kept with each value but are not written to the
+// append-only file; CAS tokens are always reported as zero.
+func (s *Server) handleMemcachedConnection(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	timeouts := s.newConnTimeouts(conn)
+
+	for {
+		timeouts.awaitCommand()
+		line, err := readMemcachedLine(r)
+		if err != nil {
+			if errors.Is(err, errMemcachedLineTooLong) {
+				fmt.Fprint(w, "CLIENT_ERROR line too long\r\n")
+				timeouts.flush(w)
+				errorCounter.WithLabelValues("memcached").Inc()
+			} else if err != io.EOF && !timeouts.timedOut(err) {
+				slog.Warn("memcached connection error", "remote_addr", conn.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		timeouts.beginCommand()
+		start := time.Now()
+		command := strings.ToUpper(args[0])
+		if !s.execMemcached(r, w, command, args) {
+			timeouts.flush(w)
+			return
+		}
+		processingDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+		if r.Buffered() == 0 {
+			if err := timeouts.flush(w); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readMemcachedLine reads a CRLF- or LF-terminated command line.
+func readMemcachedLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > memcachedMaxLine {
+			return "", errMemcachedLineTooLong
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// execMemcached runs one command, reading its data block from r if it has
+// one, and writes the reply to w. It returns false if the connection should
+// be closed.
+func (s *Server) execMemcached(r *bufio.Reader, w *bufio.Writer, command string, args []string) bool {
+	c := s.cache
+	if !memcachedCommands[command] {
+		w.WriteString("ERROR\r\n")
+		errorCounter.WithLabelValues("unknown").Inc()
+		return true
+	}
+	s.countCommand(command)
+	// A storage command waits for transactions, and may be refused, only
+	// once its data block is read, below.
+	share := txShare{mu: &s.txMu}
+	defer share.release()
+	hold := roleHold{server: s}
+	defer hold.release()
+	switch command {
+	case "SET", "ADD", "REPLACE":
+	default:
+		share.acquire()
+	}
+	switch command {
+	case "DELETE", "INCR", "DECR", "TOUCH":
+		if !hold.admitWrite() {
+			w.WriteString("SERVER_ERROR " + errReadOnly.Error() + "\r\n")
+			errorCounter.WithLabelValues(command).Inc()
+			return true
+		}
+	}
+	noreply := command != "GET" && command != "GETS" && len(args) > 1 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	reply := func(line string) {
+		if !noreply {
+			w.WriteString(line)
+			w.WriteString("\r\n")
+		}
+	}
+	clientError := func(msg string) {
+		reply("CLIENT_ERROR " + msg)
+		errorCounter.WithLabelValues(command).Inc()
+	}
+
+	switch command {
+	case "GET", "GETS":
+		if len(args) < 2 {
+			w.WriteString("ERROR\r\n")
+			errorCounter.WithLabelValues(command).Inc()
+			return true
+		}
+		for _, key := range args[1:] {
+			value, flags, err := c.GetFlagged(key)
+			if err != nil {
+				continue
+			}
+			if command == "GETS" {
+				fmt.Fprintf(w, "VALUE %s %d %d 0\r\n", key, flags, len(value))
+			} else {
+				fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, flags, len(value))
+			}
+			w.WriteString(value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "SET", "ADD", "REPLACE":
+		if len(args) != 5 {
+			clientError("bad command line format")
+			return true
+		}
+		flags, err1 := strconv.ParseUint(args[2], 10, 32)
+		exptime, err2 := strconv.ParseInt(args[3], 10, 64)
+		size, err3 := strconv.Atoi(args[4])
+		if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+			clientError("bad command line format")
+			return true
+		}
+		limit := memcachedMaxItem
+		if max := s.store.MaxValueBytes(); max > 0 {
+			limit = max
+		}
+		if size > limit {
+			// Skip the data block so the connection stays in sync.
+			if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+				return false
+			}
+			reply("SERVER_ERROR object too large for cache")
+			errorCounter.WithLabelValues(command).Inc()
+			return true
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return false
+		}
+		if data[size] != '\r' || data[size+1] != '\n' {
+			clientError("bad data chunk")
+			return false
+		}
+		share.acquire()
+		if !hold.admitWrite() {
+			reply("SERVER_ERROR " + errReadOnly.Error())
+			errorCounter.WithLabelValues(command).Inc()
+			return true
+		}
+		mode := cache.SetAlways
+		switch command {
+		case "ADD":
+			mode = cache.SetIfAbsent
+		case "REPLACE":
+			mode = cache.SetIfPresent
+		}
+		key, value := args[1], string(data[:size])
+		ttl := memcachedTTL(exptime)
+		s.logMu.Lock()
+		stored, err := c.SetFlagged(key, value, uint32(flags), ttl, mode)
+		if err == nil && stored {
+			s.logWrite(s.setRecord(key, value))
+		}
+		s.logMu.Unlock()
+		if err != nil {
+			reply("SERVER_ERROR " + err.Error())
+			errorCounter.WithLabelValues(command).Inc()
+			return true
+		}
+		if !stored {
+			reply("NOT_STORED")
+			return true
+		}
+		observeSet(key, value)
+		reply("STORED")
+	case "DELETE":
+		if len(args) != 2 {
+			clientError("bad command line format")
+			return true
+		}
+		s.logMu.Lock()
+		removed := c.MDel(args[1])
+		if removed > 0 {
+			s.logWrite(aof.Record{Op: aof.OpDel, Key: args[1]})
+		}
+		s.logMu.Unlock()
+		if removed == 0 {
+			reply("NOT_FOUND")
+			return true
+		}
+		reply("DELETED")
+	case "INCR", "DECR":
+		if len(args) != 3 {
+			clientError("bad command line format")
+			return true
+		}
+		delta, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			clientError("invalid numeric delta argument")
+			return true
+		}
+		s.logMu.Lock()
+		n, err := memcachedIncr(c, args[1], delta, command == "DECR")
+		if err == nil {
+			s.logCurrent(args[1], "set")
+		}
+		s.logMu.Unlock()
+		switch {
+		case errors.Is(err, cache.ErrKeyNotFound):
+			reply("NOT_FOUND")
+		case errors.Is(err, cache.ErrNotNumeric):
+			clientError("cannot increment or decrement non-numeric value")
+		case err != nil:
+			reply("SERVER_ERROR " + err.Error())
+			errorCounter.WithLabelValues(command).Inc()
+		default:
+			reply(strconv.FormatUint(n, 10))
+		}
+	case "TOUCH":
+		if len(args) != 3 {
+			clientError("bad command line format")
+			return true
+		}
+		exptime, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			clientError("invalid exptime argument")
+			return true
+		}
+		s.logMu.Lock()
+		touched := c.Expire(args[1], memcachedTTL(exptime))
+		if touched {
+			s.logCurrent(args[1], "set")
+		}
+		s.logMu.Unlock()
+		if !touched {
+			reply("NOT_FOUND")
+			return true
+		}
+		reply("TOUCHED")
+	}
+	return true
+}
+
+// memcachedTTL converts a memcached exptime into a TTL. Zero means no
+// expiration, values up to 30 days are relative seconds, and larger values
+// are absolute Unix times. A time already in the past yields a TTL so short
+// the entry expires at once, as memcached treats it.
+func memcachedTTL(exptime int64) time.Duration {
+	var ttl time.Duration
+	switch {
+	case exptime == 0:
+		return cache.NoExpiration
+	case exptime <= memcachedRelativeTTL:
+		ttl = time.Duration(exptime) * time.Second
+	default:
+		ttl = time.Until(time.Unix(exptime, 0))
+	}
+	if ttl <= 0 {
+		ttl = time.Nanosecond
+	}
+	return ttl
+}
+
+// memcachedIncr applies memcached's incr or decr to key: the value must be an
+// unsigned decimal integer, incr wraps around at 2^64, and decr stops at zero.
+// The entry keeps its TTL and flags.
+func memcachedIncr(c *cache.ShardedCache, key string, delta uint64, decr bool) (uint64, error) {
+	for {
+		old, err := c.Peek(key)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseUint(old, 10, 64)
+		if err != nil {
+			return 0, cache.ErrNotNumeric
+		}
+		switch {
+		case !decr:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+		swapped, err := c.CompareAndSwap(key, old, strconv.FormatUint(n, 10))
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return n, nil
+		}
+	}
+}
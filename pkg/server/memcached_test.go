@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// listenMemcached serves srv's memcached protocol on a loopback port until
+// the test ends and returns its address.
+func listenMemcached(t *testing.T, srv *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.serveMemcached(ln)
+	return ln.Addr().String()
+}
+
+func TestMemcachedWithClient(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	mc := memcache.New(listenMemcached(t, New(WithCache(c))))
+
+	binary := []byte("line one\r\nline two\x00")
+	if err := mc.Set(&memcache.Item{Key: "k", Value: binary, Flags: 42}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	it, err := mc.Get("k")
+	if err != nil || string(it.Value) != string(binary) || it.Flags != 42 {
+		t.Fatalf("get: expected %q with flags 42, got %+v, %v", binary, it, err)
+	}
+	if _, err := mc.Get("missing"); err != memcache.ErrCacheMiss {
+		t.Fatalf("expected a cache miss, got %v", err)
+	}
+
+	if err := mc.Add(&memcache.Item{Key: "k", Value: []byte("x")}); err != memcache.ErrNotStored {
+		t.Fatalf("add on an existing key: expected ErrNotStored, got %v", err)
+	}
+	if err := mc.Replace(&memcache.Item{Key: "absent", Value: []byte("x")}); err != memcache.ErrNotStored {
+		t.Fatalf("replace on a missing key: expected ErrNotStored, got %v", err)
+	}
+	if err := mc.Add(&memcache.Item{Key: "absent", Value: []byte("x")}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	items, err := mc.GetMulti([]string{"k", "absent", "missing"})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("get multi: expected 2 items, got %v, %v", items, err)
+	}
+
+	mc.Set(&memcache.Item{Key: "n", Value: []byte("10")})
+	if n, err := mc.Increment("n", 5); err != nil || n != 15 {
+		t.Fatalf("incr: expected 15, got %d, %v", n, err)
+	}
+	if n, err := mc.Decrement("n", 100); err != nil || n != 0 {
+		t.Fatalf("decr: expected to stop at 0, got %d, %v", n, err)
+	}
+	if _, err := mc.Increment("missing", 1); err != memcache.ErrCacheMiss {
+		t.Fatalf("incr on a missing key: expected a cache miss, got %v", err)
+	}
+	if _, err := mc.Increment("k", 1); err == nil {
+		t.Fatal("expected incr on a non-numeric value to fail")
+	}
+
+	if err := mc.Delete("k"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := mc.Delete("k"); err != memcache.ErrCacheMiss {
+		t.Fatalf("second delete: expected a cache miss, got %v", err)
+	}
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	mc := memcache.New(listenMemcached(t, New(WithCache(c))))
+
+	if err := mc.Set(&memcache.Item{Key: "k", Value: []byte("v"), Expiration: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, at, _ := c.PeekWithExpiry("k"); time.Until(at) > time.Second || time.Until(at) <= 0 {
+		t.Fatalf("expected a relative exptime of 1s, expires at %v", at)
+	}
+	if err := mc.Touch("k", int32(time.Now().Add(time.Hour).Unix())); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if _, at, _ := c.PeekWithExpiry("k"); time.Until(at) < 59*time.Minute {
+		t.Fatalf("expected an absolute exptime an hour out, expires at %v", at)
+	}
+	if err := mc.Touch("missing", 10); err != memcache.ErrCacheMiss {
+		t.Fatalf("touch on a missing key: expected a cache miss, got %v", err)
+	}
+	if err := mc.Set(&memcache.Item{Key: "k", Value: []byte("v"), Expiration: -1}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := mc.Get("k"); err != memcache.ErrCacheMiss {
+		t.Fatalf("expected a negative exptime to expire at once, got %v", err)
+	}
+}
+
+func TestMemcachedNoreply(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn, err := net.Dial("tcp", listenMemcached(t, New(WithCache(c))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("set a 0 0 2 noreply\r\nhi\r\ndelete missing noreply\r\nbogus\r\nget a\r\n"))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"ERROR\r\n", "VALUE a 0 2\r\n", "hi\r\n", "END\r\n"} {
+		line, err := r.ReadString('\n')
+		if err != nil || line != want {
+			t.Fatalf("expected %q, got %q, %v", want, line, err)
+		}
+	}
+
+	conn.Write([]byte("set b 0 0 2\r\ntoolong\r\n"))
+	if line, _ := r.ReadString('\n'); line != "CLIENT_ERROR bad data chunk\r\n" {
+		t.Fatalf("expected a bad data chunk error, got %q", line)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// monitorBuffer is how many fed commands may wait for a monitor's connection
+// to write them. Commands fed to a monitor whose buffer is full are dropped,
+// so a slow monitor never delays the connections it watches.
+var monitorBuffer = 1024
+
+var monitorDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "mycache_monitor_dropped_total",
+	Help: "Total number of commands dropped because a MONITOR connection fell behind",
+})
+
+func init() {
+	prometheus.MustRegister(monitorDropped)
+}
+
+// monitorHub fans every command a server processes out to its MONITOR
+// connections.
+// count mirrors len(set) so feed costs one atomic load when nobody watches.
+type monitorHub struct {
+	count atomic.Int32
+	mu    sync.RWMutex
+	set   map[*monitor]struct{}
+}
+
+func newMonitorHub() *monitorHub {
+	return &monitorHub{set: make(map[*monitor]struct{})}
+}
+
+// monitor is a connection that has issued MONITOR. out carries formatted
+// commands to the goroutine started by deliver.
+type monitor struct {
+	out  chan string
+	done chan struct{}
+}
+
+// add registers a new monitor and returns it.
+func (h *monitorHub) add() *monitor {
+	m := &monitor{out: make(chan string, monitorBuffer), done: make(chan struct{})}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.set[m] = struct{}{}
+	h.count.Add(1)
+	return m
+}
+
+// remove unregisters m and waits for its deliver goroutine to return.
+func (h *monitorHub) remove(m *monitor) {
+	h.mu.Lock()
+	delete(h.set, m)
+	h.count.Add(-1)
+	h.mu.Unlock()
+	close(m.out)
+	<-m.done
+}
+
+// feed reports a command received from addr while in database db, as
+//
+//	<unix seconds>.<micros> [<db> <addr>] "<arg>" "<arg>" ...
+//
+// A monitor whose buffer is full misses the command, which is counted in
+// mycache_monitor_dropped_total.
+func (h *monitorHub) feed(db int, addr string, parts []string) {
+	if h.count.Load() == 0 {
+		return
+	}
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, db, addr)
+	for _, part := range parts {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(part))
+	}
+	line := b.String()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for m := range h.set {
+		select {
+		case m.out <- line:
+		default:
+			monitorDropped.Inc()
+		}
+	}
+}
+
+// deliver writes fed commands to w until the monitor is removed. mu must be
+// held by whoever else writes to w, as for subscriber.deliver.
+func (m *monitor) deliver(w *bufio.Writer, mu *sync.Mutex, timeouts *connTimeouts) {
+	defer close(m.done)
+	for line := range m.out {
+		mu.Lock()
+		protocol.WriteReply(w, protocol.Status(line))
+		// Write whatever else is already queued before flushing.
+		for more := true; more; {
+			select {
+			case line, ok := <-m.out:
+				if !ok {
+					more = false
+					break
+				}
+				protocol.WriteReply(w, protocol.Status(line))
+			default:
+				more = false
+			}
+		}
+		if err := timeouts.flush(w); err != nil {
+			// Unblock the connection goroutine's read so it cleans up.
+			timeouts.conn.Close()
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestMonitorStreamsCommands(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	mconn := dial(t, srv)
+	mr := bufio.NewReader(mconn)
+	if got := configCommand(t, mconn, mr, "MONITOR"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	for _, cmd := range []string{"SET k hello world", "SELECT 2", "GET k"} {
+		configCommand(t, conn, r, cmd)
+	}
+	addr := regexp.QuoteMeta(conn.LocalAddr().String())
+	for _, want := range []string{
+		`^\d+\.\d{6} \[0 ` + addr + `\] "SET" "k" "hello" "world"$`,
+		`^\d+\.\d{6} \[0 ` + addr + `\] "SELECT" "2"$`,
+		`^\d+\.\d{6} \[2 ` + addr + `\] "GET" "k"$`,
+	} {
+		line, err := mr.ReadString('\n')
+		if err != nil || !regexp.MustCompile(want).MatchString(strings.TrimSuffix(line, "\n")) {
+			t.Fatalf("expected a line matching %s, got %q, %v", want, line, err)
+		}
+	}
+
+	if got := configCommand(t, mconn, mr, "GET k"); got != "ERROR: only PING and QUIT are allowed while monitoring" {
+		t.Fatalf("expected commands to be refused while monitoring, got %q", got)
+	}
+
+	// Disconnecting removes the monitor.
+	mconn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.monitors.count.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no monitors after a disconnect, got %d", srv.monitors.count.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMonitorRequiresAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), WithPassword("hunter2"))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	if got := configCommand(t, conn, r, "MONITOR"); !strings.HasPrefix(got, "ERROR: Authentication required") {
+		t.Fatalf("expected authentication to be required, got %q", got)
+	}
+	if n := srv.monitors.count.Load(); n != 0 {
+		t.Fatalf("expected no monitor to be added, got %d", n)
+	}
+}
+
+func TestMonitorDropsForSlowMonitor(t *testing.T) {
+	old := monitorBuffer
+	monitorBuffer = 2
+	t.Cleanup(func() { monitorBuffer = old })
+
+	h := newMonitorHub()
+	h.feed(0, "nobody", []string{"GET", "k"}) // No monitors: nothing to do.
+	m := h.add()
+	dropped := testutil.ToFloat64(monitorDropped)
+	for i := 0; i < 5; i++ {
+		h.feed(0, "a", []string{"PING"})
+	}
+	if got := testutil.ToFloat64(monitorDropped) - dropped; got != 3 {
+		t.Fatalf("expected 3 dropped commands counted, got %v", got)
+	}
+
+	var mu sync.Mutex
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	go m.deliver(w, &mu, &connTimeouts{})
+	h.remove(m)
+	if got := strings.Count(buf.String(), `[0 a] "PING"`); got != 2 {
+		t.Fatalf("expected the 2 buffered commands to be delivered, got %q", buf.String())
+	}
+	if h.count.Load() != 0 || len(h.set) != 0 {
+		t.Fatal("expected remove to unregister the monitor")
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// pubsubBuffer is how many published messages may wait for a subscriber's
+// connection to write them. Messages published to a subscriber whose buffer
+// is full are dropped, so a slow subscriber never blocks PUBLISH.
+var pubsubBuffer = 1024
+
+var pubsubDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "mycache_pubsub_dropped_total",
+	Help: "Total number of published messages dropped because a subscriber fell behind",
+})
+
+func init() {
+	prometheus.MustRegister(pubsubDropped)
+}
+
+// broker routes published messages to the subscribers of each of a server's
+// channels. Channels are shared by every database: SELECT and NAMESPACE do
+// not apply to them.
+type broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*subscriber]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{channels: make(map[string]map[*subscriber]struct{})}
+}
+
+// pubsubMessage is a message queued for a subscriber.
+type pubsubMessage struct {
+	channel, payload string
+}
+
+// subscriber is a connection's membership in the broker. Its channels are
+// owned by the connection goroutine; out carries messages to the goroutine
+// started by deliver, which writes them between the connection's replies.
+type subscriber struct {
+	channels map[string]struct{}
+	out      chan pubsubMessage
+	done     chan struct{}
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		channels: make(map[string]struct{}),
+		out:      make(chan pubsubMessage, pubsubBuffer),
+		done:     make(chan struct{}),
+	}
+}
+
+// subscribe adds s to channel and returns how many channels s is subscribed to.
+func (b *broker) subscribe(s *subscriber, channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.channels[channel]
+	if subs == nil {
+		subs = make(map[*subscriber]struct{})
+		b.channels[channel] = subs
+	}
+	subs[s] = struct{}{}
+	s.channels[channel] = struct{}{}
+	return len(s.channels)
+}
+
+// unsubscribe removes s from channel and returns how many channels s is still
+// subscribed to.
+func (b *broker) unsubscribe(s *subscriber, channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if subs := b.channels[channel]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(b.channels, channel)
+		}
+	}
+	delete(s.channels, channel)
+	return len(s.channels)
+}
+
+// publish queues payload for every subscriber of channel and returns how many
+// received it. A subscriber whose buffer is full misses the message, which is
+// counted in mycache_pubsub_dropped_total.
+func (b *broker) publish(channel, payload string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := 0
+	for s := range b.channels[channel] {
+		select {
+		case s.out <- pubsubMessage{channel, payload}:
+			n++
+		default:
+			pubsubDropped.Inc()
+		}
+	}
+	return n
+}
+
+// subscribed returns the channels s is subscribed to, sorted.
+func (s *subscriber) subscribed() []string {
+	channels := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	slices.Sort(channels)
+	return channels
+}
+
+// writeMessage writes m as a "message <channel>" line followed by its
+// payload in a data block, since payloads published over RESP may hold line
+// breaks.
+func writeMessage(w io.Writer, m pubsubMessage) {
+	protocol.WriteReply(w, protocol.Status("message "+m.channel))
+	protocol.WriteReply(w, protocol.Bulk(m.payload))
+}
+
+// deliver writes queued messages to w, as writeMessage does, until close is
+// called. mu must be held by whoever else writes to w; the
+// connection goroutine holds it except while waiting for a command.
+func (s *subscriber) deliver(w *bufio.Writer, mu *sync.Mutex, timeouts *connTimeouts) {
+	defer close(s.done)
+	for m := range s.out {
+		mu.Lock()
+		writeMessage(w, m)
+		// Write whatever else is already queued before flushing.
+		for more := true; more; {
+			select {
+			case m, ok := <-s.out:
+				if !ok {
+					more = false
+					break
+				}
+				writeMessage(w, m)
+			default:
+				more = false
+			}
+		}
+		if err := timeouts.flush(w); err != nil {
+			// Unblock the connection goroutine's read so it cleans up.
+			timeouts.conn.Close()
+		}
+		mu.Unlock()
+	}
+}
+
+// close unsubscribes s from every channel and waits for deliver to return.
+// No publisher can reach s afterwards, so its buffer is safely closed.
+func (s *subscriber) close(b *broker) {
+	for _, channel := range s.subscribed() {
+		b.unsubscribe(s, channel)
+	}
+	close(s.out)
+	<-s.done
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// subscribers returns the number of subscribers to channel on b.
+func subscribers(b *broker, channel string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.channels[channel])
+}
+
+func TestPubSubFanOut(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	pub := dial(t, srv)
+	pr := bufio.NewReader(pub)
+	var subs []*bufio.Reader
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn := dial(t, srv)
+		r := bufio.NewReader(conn)
+		if got := configCommand(t, conn, r, "SUBSCRIBE fanout"); got != "subscribe fanout 1" {
+			t.Fatalf("expected a subscription, got %q", got)
+		}
+		subs, conns = append(subs, r), append(conns, conn)
+	}
+
+	if got := configCommand(t, pub, pr, "PUBLISH fanout key k changed"); got != "3" {
+		t.Fatalf("expected 3 receivers, got %q", got)
+	}
+	for i, r := range subs {
+		if line, err := r.ReadString('\n'); err != nil || line != "message fanout\n" {
+			t.Fatalf("subscriber %d: expected the message, got %q, %v", i, line, err)
+		}
+		if got := readBulkReply(t, r); got != "key k changed" {
+			t.Fatalf("subscriber %d: expected the payload, got %q", i, got)
+		}
+	}
+
+	if got := configCommand(t, conns[0], subs[0], "GET k"); got != "ERROR: only SUBSCRIBE, UNSUBSCRIBE, and PING are allowed while subscribed" {
+		t.Fatalf("expected commands to be refused while subscribed, got %q", got)
+	}
+	if got := configCommand(t, conns[0], subs[0], "UNSUBSCRIBE"); got != "unsubscribe fanout 0" {
+		t.Fatalf("expected to leave the channel, got %q", got)
+	}
+	if got := configCommand(t, conns[0], subs[0], "GET k"); got != "ERROR: key not found" {
+		t.Fatalf("expected normal commands after unsubscribing, got %q", got)
+	}
+
+	// Disconnecting drops the subscription.
+	conns[1].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for subscribers(srv.pubsub, "fanout") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 1 subscriber after a disconnect, got %d", subscribers(srv.pubsub, "fanout"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := configCommand(t, pub, pr, "PUBLISH fanout bye"); got != "1" {
+		t.Fatalf("expected 1 receiver, got %q", got)
+	}
+}
+
+func TestPubSubCommandErrors(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+	for _, tc := range []struct{ cmd, want string }{
+		{"SUBSCRIBE", "ERROR: SUBSCRIBE requires at least one channel"},
+		{"PUBLISH nobody", "ERROR: PUBLISH requires channel and message"},
+		{"PUBLISH nobody hi", "0"},
+		{"UNSUBSCRIBE", "unsubscribe (nil) 0"},
+	} {
+		if got := configCommand(t, conn, r, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+	fmt.Fprint(conn, "SUBSCRIBE a b\n")
+	for _, want := range []string{"subscribe a 1\n", "subscribe b 2\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("expected %q, got %q, %v", want, line, err)
+		}
+	}
+	if got := configCommand(t, conn, r, "PING"); got != "PONG" {
+		t.Fatalf("expected PING while subscribed, got %q", got)
+	}
+}
+
+func TestPubSubDropsForSlowSubscriber(t *testing.T) {
+	old := pubsubBuffer
+	pubsubBuffer = 2
+	t.Cleanup(func() { pubsubBuffer = old })
+
+	b := newBroker()
+	s := newSubscriber()
+	b.subscribe(s, "c")
+	dropped := testutil.ToFloat64(pubsubDropped)
+	var received []int
+	for i := 0; i < 5; i++ {
+		received = append(received, b.publish("c", "m"))
+	}
+	if !slices.Equal(received, []int{1, 1, 0, 0, 0}) {
+		t.Fatalf("expected messages past the buffer to miss the subscriber, got %v", received)
+	}
+	if got := testutil.ToFloat64(pubsubDropped) - dropped; got != 3 {
+		t.Fatalf("expected 3 dropped messages counted, got %v", got)
+	}
+
+	var mu sync.Mutex
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	go s.deliver(w, &mu, &connTimeouts{})
+	s.close(b)
+	if got := buf.String(); got != "message c\n$1\r\nm\r\nmessage c\n$1\r\nm\r\n" {
+		t.Fatalf("expected the buffered messages to be delivered, got %q", got)
+	}
+	if len(b.channels) != 0 {
+		t.Fatal("expected close to leave every channel")
+	}
+}
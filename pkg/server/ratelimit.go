@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mycache_throttled_requests_total",
+	Help: "Total number of commands refused by a rate limit, by the limit that refused them: global, ip, or connection",
+}, []string{"limit"})
+
+func init() {
+	prometheus.MustRegister(throttledRequests)
+}
+
+// ipLimiterIdle is how long an IP's bucket is kept after its last command.
+// An idle bucket has refilled, so dropping it loses nothing.
+const ipLimiterIdle = time.Minute
+
+// tokenBucket allows rate commands per second on average, in bursts of up
+// to one second's worth.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: now}
+}
+
+// allow takes a token at now, reporting false if none is left.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// idleSince reports whether the bucket has not been used since before t.
+func (b *tokenBucket) idleSince(t time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last.Before(t)
+}
+
+// ipLimiter holds a token bucket per client IP, dropping buckets idle for
+// ipLimiterIdle.
+type ipLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newIPLimiter() *ipLimiter {
+	return &ipLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// bucket returns ip's bucket at now, creating it with the given rate.
+func (l *ipLimiter) bucket(ip string, rate float64, now time.Time) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= ipLimiterIdle {
+		l.lastSweep = now
+		for ip, b := range l.buckets {
+			if b.idleSince(now.Add(-ipLimiterIdle)) {
+				delete(l.buckets, ip)
+			}
+		}
+	}
+	b := l.buckets[ip]
+	if b == nil {
+		b = newTokenBucket(rate, now)
+		l.buckets[ip] = b
+	}
+	return b
+}
+
+// connLimits applies the rate limits to one connection's commands.
+type connLimits struct {
+	bucket     *tokenBucket // For -conn-rate-limit, or nil.
+	ips        *ipLimiter   // The server's buckets for -ip-rate-limit.
+	ipRate     float64      // -ip-rate-limit.
+	global     *tokenBucket // The server's bucket for -rate-limit, or nil.
+	kickAfter  int          // -rate-limit-disconnect.
+	ip         string
+	violations int // Commands refused in a row.
+}
+
+func (s *Server) newConnLimits(conn net.Conn) *connLimits {
+	l := &connLimits{ips: s.ipLimits, ipRate: s.config.ipRate, global: s.globalLimit, kickAfter: s.config.rateKickAfter}
+	if s.config.connRate > 0 {
+		l.bucket = newTokenBucket(s.config.connRate, time.Now())
+	}
+	l.ip, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	return l
+}
+
+// allow reports whether the connection may run a command now, counting a
+// refusal in mycache_throttled_requests_total, and whether it has been
+// refused -rate-limit-disconnect times in a row and should be closed.
+func (l *connLimits) allow() (ok, disconnect bool) {
+	now := time.Now()
+	limit := ""
+	switch {
+	case l.bucket != nil && !l.bucket.allow(now):
+		limit = "connection"
+	case l.ipRate > 0 && !l.ips.bucket(l.ip, l.ipRate, now).allow(now):
+		limit = "ip"
+	case l.global != nil && !l.global.allow(now):
+		limit = "global"
+	default:
+		l.violations = 0
+		return true, false
+	}
+	throttledRequests.WithLabelValues(limit).Inc()
+	l.violations++
+	return false, l.kickAfter > 0 && l.violations >= l.kickAfter
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// withRates sets the rate limit settings of the server under test.
+func withRates(conn, ip float64, kickAfter int) Option {
+	return withConfig(func(c *Config) { c.connRate, c.ipRate, c.rateKickAfter = conn, ip, kickAfter })
+}
+
+// pings sends n pipelined PINGs and returns the replies.
+func pings(t *testing.T, conn interface{ Write([]byte) (int, error) }, r *bufio.Reader, n int) []string {
+	t.Helper()
+	conn.Write([]byte(strings.Repeat("PING\n", n)))
+	replies := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		replies = append(replies, strings.TrimSuffix(line, "\n"))
+	}
+	return replies
+}
+
+func TestConnRateLimit(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c), withRates(5, 0, 0)))
+	r := bufio.NewReader(conn)
+
+	throttled := testutil.ToFloat64(throttledRequests.WithLabelValues("connection"))
+	replies := pings(t, conn, r, 8)
+	want := []string{"PONG", "PONG", "PONG", "PONG", "PONG", "ERROR: rate limit exceeded", "ERROR: rate limit exceeded", "ERROR: rate limit exceeded"}
+	if !slices.Equal(replies, want) {
+		t.Fatalf("expected 5 commands through, got %q", replies)
+	}
+	if got := testutil.ToFloat64(throttledRequests.WithLabelValues("connection")) - throttled; got != 3 {
+		t.Fatalf("expected 3 throttled commands counted, got %v", got)
+	}
+
+	// The bucket refills over time.
+	time.Sleep(250 * time.Millisecond)
+	if got := configCommand(t, conn, r, "PING"); got != "PONG" {
+		t.Fatalf("expected the limit to recover, got %q", got)
+	}
+}
+
+func TestIPRateLimitDisconnects(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), withRates(0, 4, 3))
+	first := dial(t, srv)
+	if replies := pings(t, first, bufio.NewReader(first), 3); len(replies) != 3 || replies[2] != "PONG" {
+		t.Fatalf("expected 3 commands through, got %q", replies)
+	}
+
+	// Another connection from the same IP shares the budget, and is closed
+	// after 3 refusals in a row.
+	second := dial(t, srv)
+	replies := pings(t, second, bufio.NewReader(second), 6)
+	want := []string{"PONG", "ERROR: rate limit exceeded", "ERROR: rate limit exceeded", "ERROR: rate limit exceeded"}
+	if !slices.Equal(replies, want) {
+		t.Fatalf("expected the connection to be closed after 3 refusals, got %q", replies)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, now)
+	if !b.allow(now) || !b.allow(now) || b.allow(now) {
+		t.Fatal("expected a burst of 2")
+	}
+	if !b.allow(now.Add(500*time.Millisecond)) || b.allow(now.Add(500*time.Millisecond)) {
+		t.Fatal("expected one token after half a second")
+	}
+	if !b.allow(now.Add(time.Hour)) || !b.allow(now.Add(time.Hour)) || b.allow(now.Add(time.Hour)) {
+		t.Fatal("expected the bucket to refill no further than its burst")
+	}
+}
+
+func TestIPLimiterEvictsIdle(t *testing.T) {
+	l := newIPLimiter()
+	now := time.Now()
+	l.bucket("10.0.0.1", 1, now)
+	l.bucket("10.0.0.2", 1, now.Add(ipLimiterIdle)).allow(now.Add(ipLimiterIdle))
+	l.bucket("10.0.0.2", 1, now.Add(2*ipLimiterIdle))
+	if _, ok := l.buckets["10.0.0.1"]; ok || len(l.buckets) != 1 {
+		t.Fatalf("expected the idle IP to be evicted, got %v", l.buckets)
+	}
+}
@@ -0,0 +1,559 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// Replication: a replica started with -replicaof connects to its primary's
+// TCP listener, authenticates with -primary-password if one is set, and sends
+// "PSYNC <replication id> <offset>", or "PSYNC ? -1" the first time. The
+// primary replies with either
+//
+//	FULLRESYNC <replication id> <offset>
+//	$<nbytes>
+//	<snapshot in the -snapshot-file format>
+//
+// or, if the replica's offset is still in its backlog, "CONTINUE <replication
+// id>". Either way it then streams every write passed to logWrite from that
+// offset on, framed as in the AOF, while the replica acknowledges its offset
+// with an "ACK <offset>" line once a second.
+
+// errReadOnly is returned for writes to a replica with -replica-read-only.
+var errReadOnly = errors.New("READONLY this server is a replica and does not accept writes")
+
+// replicaRetryDelay is how long a replica waits to reconnect after losing its
+// primary.
+var replicaRetryDelay = time.Second
+
+// replicationFeed is a stream of write records, numbered by byte offset. It
+// records nothing until the first replica syncs, and then keeps at least the
+// last -repl-backlog-bytes of the stream so a replica that reconnects can
+// resume where it left off.
+type replicationFeed struct {
+	mu       sync.Mutex
+	cache    *cache.ShardedCache // The cache the records apply to, once a replica has synced.
+	id       string              // Identifies the stream; offsets in another are meaningless.
+	offset   int64               // Bytes recorded so far.
+	backlog  []byte              // The stream's most recent bytes, ending at offset.
+	changed  chan struct{}       // Closed when records are added or the stream is reset.
+	replicas map[*replicaConn]struct{}
+	limit    int // The backlog's size, from -repl-backlog-bytes.
+
+	fullSyncs    int64
+	partialSyncs int64
+}
+
+// replicaConn is a replica streaming from this server.
+type replicaConn struct {
+	addr  string
+	conn  net.Conn
+	acked atomic.Int64 // The offset the replica last acknowledged.
+	ackAt atomic.Int64 // Unix nanoseconds of its last acknowledgement.
+}
+
+func newReplicationFeed(limit int) *replicationFeed {
+	return &replicationFeed{id: newReplicationID(), changed: make(chan struct{}), replicas: make(map[*replicaConn]struct{}), limit: limit}
+}
+
+// newReplicationID returns a random 40-character hex id.
+func newReplicationID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// record adds rec to the stream, once a replica has synced. An append is
+// recorded as the value it left, so that, like every other record, it can
+// safely be applied to a snapshot that already reflects it.
+func (f *replicationFeed) record(rec aof.Record) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cache == nil {
+		return
+	}
+	if rec.Op == aof.OpAppend {
+		value, expireAt, err := f.cache.PeekWithExpiry(rec.Key)
+		if err != nil {
+			return // The key has since been deleted or replaced, which is recorded too.
+		}
+		rec = aof.Record{Op: aof.OpSet, Key: rec.Key, Value: value, ExpireAt: expireAt}
+	}
+	n := len(f.backlog)
+	f.backlog = aof.AppendRecord(f.backlog, rec)
+	f.offset += int64(len(f.backlog) - n)
+	// Trim once the backlog is twice its size, so trimming copies each byte
+	// at most once.
+	if limit := f.limit; len(f.backlog) > 2*limit {
+		f.backlog = append(make([]byte, 0, 2*limit), f.backlog[len(f.backlog)-limit:]...)
+	}
+	f.notifyLocked()
+}
+
+// recording reports whether the feed records writes, which it does once a
+// replica has synced.
+func (f *replicationFeed) recording() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cache != nil
+}
+
+// attach registers rc, which asked to resume stream id at offset, and returns
+// the stream's id and the offset to stream to rc from: its own if the backlog
+// still holds it, or else the current offset, with full set to send a
+// snapshot first.
+func (f *replicationFeed) attach(c *cache.ShardedCache, rc *replicaConn, id string, offset int64) (string, int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache = c
+	f.replicas[rc] = struct{}{}
+	full := id != f.id || offset < f.offset-int64(len(f.backlog)) || offset > f.offset
+	if full {
+		offset = f.offset
+		f.fullSyncs++
+	} else {
+		f.partialSyncs++
+	}
+	rc.acked.Store(offset)
+	rc.ackAt.Store(time.Now().UnixNano())
+	return f.id, offset, full
+}
+
+// detach unregisters rc.
+func (f *replicationFeed) detach(rc *replicaConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.replicas, rc)
+}
+
+// since returns the stream from offset on and a channel closed when there is
+// more. It reports false if offset is no longer in the backlog.
+func (f *replicationFeed) since(offset int64) ([]byte, <-chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	start := f.offset - int64(len(f.backlog))
+	if offset < start || offset > f.offset {
+		return nil, nil, false
+	}
+	return bytes.Clone(f.backlog[offset-start:]), f.changed, true
+}
+
+// reset starts a new stream, for when the data no longer follows from the old
+// one, and disconnects the replicas so they resync in full.
+func (f *replicationFeed) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.id = newReplicationID()
+	f.backlog = nil
+	for rc := range f.replicas {
+		rc.conn.Close()
+	}
+	f.notifyLocked()
+}
+
+// notifyLocked wakes the replicas waiting for records. The caller must hold
+// f.mu.
+func (f *replicationFeed) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// serveReplica takes over a connection that sent PSYNC id offset and streams
+// the server's replication feed to it until either side closes the
+// connection.
+func (s *Server) serveReplica(conn net.Conn, r *bufio.Reader, w *bufio.Writer, id string, offset int64, logger *slog.Logger) {
+	f, c := s.replFeed, s.cache
+	conn.SetDeadline(time.Time{})
+	rc := &replicaConn{addr: conn.RemoteAddr().String(), conn: conn}
+	// A snapshot is taken under logMu, with the offset it is sent at, so it
+	// holds exactly the writes fed before that offset.
+	var snapshot bytes.Buffer
+	var err error
+	s.logMu.Lock()
+	id, offset, full := f.attach(c, rc, id, offset)
+	if full {
+		err = c.WriteSnapshot(&snapshot)
+	}
+	s.logMu.Unlock()
+	defer f.detach(rc)
+	if err != nil {
+		logger.Error("replica snapshot failed", "err", err)
+		return
+	}
+	if full {
+		fmt.Fprintf(w, "FULLRESYNC %s %d\r\n$%d\r\n", id, offset, snapshot.Len())
+		w.Write(snapshot.Bytes())
+		w.WriteString("\r\n")
+	} else {
+		fmt.Fprintf(w, "CONTINUE %s\r\n", id)
+	}
+	if err := w.Flush(); err != nil {
+		return
+	}
+	logger.Info("replica synced", "full", full, "offset", offset)
+
+	// Read acknowledgements until the replica goes away, which ends the
+	// stream too.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			line, err := readLine(r, s.config.maxRequest)
+			if err != nil {
+				return
+			}
+			if s, ok := strings.CutPrefix(line, "ACK "); ok {
+				if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+					rc.acked.Store(n)
+					rc.ackAt.Store(time.Now().UnixNano())
+				}
+			}
+		}
+	}()
+	defer func() {
+		conn.Close()
+		<-gone
+	}()
+	for {
+		data, changed, ok := f.since(offset)
+		if !ok {
+			logger.Warn("replica fell behind the backlog, disconnecting it to resync", "offset", offset)
+			return
+		}
+		if len(data) > 0 {
+			if s.config.writeTimeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(s.config.writeTimeout))
+			}
+			w.Write(data)
+			if err := w.Flush(); err != nil {
+				logger.Info("replica stream ended", "err", err)
+				return
+			}
+			offset += int64(len(data))
+		}
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		}
+	}
+}
+
+// refusesWrites reports whether writes from clients are refused, because the
+// server is a replica and -replica-read-only is set.
+func (s *Server) refusesWrites() bool {
+	return s.config.replicaRO && s.primaryLink.Load() != nil
+}
+
+// isWrite reports whether command changes keys.
+func isWrite(command string) bool {
+	return (required(command) == permWrite && command != "PUBLISH") || command == "FLUSHDB" || command == "FLUSHALL"
+}
+
+// roleHold is a connection's hold on its server's roleMu for the write it is
+// running. roleMu orders client writes against role changes. A write holds it
+// for reading from the read-only check until it is applied, and replicate
+// holds it to change roles, so a write either lands before a demotion or is
+// refused after it.
+type roleHold struct {
+	server *Server
+	held   bool
+}
+
+// admit reports whether command may run in this server's role, as
+// admitWrite does for a write.
+func (h *roleHold) admit(command string) bool {
+	return !isWrite(command) || h.admitWrite()
+}
+
+// admitWrite reports whether a write may run in this server's role. It holds
+// roleMu, even when refusing the write, until release.
+func (h *roleHold) admitWrite() bool {
+	if !h.held {
+		h.server.roleMu.RLock()
+		h.held = true
+	}
+	return !h.server.refusesWrites()
+}
+
+// release lets go of roleMu, if it is held.
+func (h *roleHold) release() {
+	if h.held {
+		h.server.roleMu.RUnlock()
+		h.held = false
+	}
+}
+
+// replicate makes this server a replica of addr, or a primary if addr is
+// empty, once the writes in flight have been applied. A new primary is
+// replicated from a full resync; with -replica-flush, the data is discarded
+// at once rather than served until then.
+func (s *Server) replicate(addr string) {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	old := s.primaryLink.Load()
+	if old != nil && old.addr == addr {
+		return
+	}
+	if old != nil {
+		old.close()
+		s.primaryLink.Store(nil)
+	}
+	if addr == "" {
+		if old != nil {
+			slog.Info("promoted to primary", "former_primary", old.addr)
+		}
+		return
+	}
+	if s.config.replicaFlush {
+		s.cache.Clear()
+		s.appendToLog(aof.Record{Op: aof.OpFlush})
+		s.replFeed.reset()
+	}
+	link := newReplicaLink(addr, s)
+	s.primaryLink.Store(link)
+	link.start()
+	slog.Info("replicating", "primary", addr, "read_only", s.config.replicaRO, "flushed", s.config.replicaFlush)
+}
+
+// replicaLink keeps a server's cache in sync with a primary, reconnecting
+// whenever the connection fails. Records it applies are passed on to the
+// server's AOF and its own replicas.
+type replicaLink struct {
+	addr   string
+	server *Server
+	ctx    context.Context // Done once the link is closed.
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when run returns.
+
+	mu     sync.Mutex
+	id     string // The primary's replication id, or empty before the first sync.
+	offset int64  // Offset in the primary's stream of the data applied.
+	up     bool
+	lastIO time.Time // When the primary last sent anything.
+}
+
+func newReplicaLink(addr string, s *Server) *replicaLink {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &replicaLink{addr: addr, server: s, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// start replicates in the background until close.
+func (l *replicaLink) start() {
+	go func() {
+		defer close(l.done)
+		l.run()
+	}()
+}
+
+// run syncs with the primary until close, waiting replicaRetryDelay after each
+// failure before reconnecting.
+func (l *replicaLink) run() {
+	for {
+		err := l.sync()
+		l.mu.Lock()
+		l.up = false
+		l.mu.Unlock()
+		if l.ctx.Err() != nil {
+			return
+		}
+		slog.Warn("lost connection to primary", "primary", l.addr, "err", err)
+		select {
+		case <-time.After(replicaRetryDelay):
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// close stops replicating and waits for the last record to be applied. It
+// may be called more than once.
+func (l *replicaLink) close() {
+	l.cancel()
+	<-l.done
+}
+
+// sync connects to the primary, resyncs, and applies its stream until the
+// connection fails or the link is closed.
+func (l *replicaLink) sync() error {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(l.ctx, "tcp", l.addr)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-l.ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	if l.server.config.primaryPass != "" {
+		fmt.Fprintf(w, "AUTH %s\r\n", l.server.config.primaryPass)
+		w.Flush()
+		if line, err := readLine(r, l.server.config.maxRequest); err != nil || line != "OK" {
+			return fmt.Errorf("AUTH: %q, %v", line, err)
+		}
+	}
+
+	l.mu.Lock()
+	id, offset := l.id, l.offset
+	l.mu.Unlock()
+	if id == "" {
+		id, offset = "?", -1
+	}
+	fmt.Fprintf(w, "PSYNC %s %d\r\n", id, offset)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	line, err := readLine(r, l.server.config.maxRequest)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		if offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return fmt.Errorf("bad PSYNC reply %q", line)
+		}
+		if err := l.loadSnapshot(r); err != nil {
+			return err
+		}
+		id = fields[1]
+		slog.Info("resynced from primary", "primary", l.addr, "keys", l.server.cache.Len())
+	case len(fields) == 2 && fields[0] == "CONTINUE" && fields[1] == id:
+		slog.Info("resumed replication from primary", "primary", l.addr, "offset", offset)
+	default:
+		return fmt.Errorf("bad PSYNC reply %q", line)
+	}
+	l.mu.Lock()
+	l.id, l.offset, l.up, l.lastIO = id, offset, true, time.Now()
+	l.mu.Unlock()
+
+	go l.acknowledge(conn, done)
+	for {
+		rec, n, err := aof.ReadRecord(r)
+		if err != nil {
+			return err
+		}
+		l.server.logMu.Lock()
+		applyRecord(l.server.cache, rec)
+		l.server.logWrite(rec)
+		l.server.logMu.Unlock()
+		l.mu.Lock()
+		l.offset += n
+		l.lastIO = time.Now()
+		l.mu.Unlock()
+	}
+}
+
+// loadSnapshot replaces the cache's contents with the snapshot that follows a
+// FULLRESYNC. The data no longer follows from what this server's replicas
+// and AOF have seen, so the feed is reset and the AOF rewritten from it.
+func (l *replicaLink) loadSnapshot(r *bufio.Reader) error {
+	header, err := readLine(r, l.server.config.maxRequest)
+	if err != nil {
+		return err
+	}
+	n, ok := parseLength(header)
+	if !ok {
+		return fmt.Errorf("bad snapshot header %q", header)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if err := readTerminator(r); err != nil {
+		return err
+	}
+	s := l.server
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	s.cache.Clear()
+	if err := s.cache.ReadSnapshot(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	s.replFeed.reset()
+	if s.appendLog != nil {
+		s.appendToLog(aof.Record{Op: aof.OpFlush})
+		for _, key := range s.cache.KeysWithPrefix("") {
+			if rec, ok := s.currentRecord(key); ok {
+				s.appendToLog(rec)
+			}
+		}
+	}
+	return nil
+}
+
+// acknowledge sends the applied offset to the primary once a second until
+// done is closed.
+func (l *replicaLink) acknowledge(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			offset := l.offset
+			l.mu.Unlock()
+			fmt.Fprintf(conn, "ACK %d\r\n", offset)
+		case <-done:
+			return
+		}
+	}
+}
+
+// writeReplicationInfo writes the fields of INFO's replication section.
+func (s *Server) writeReplicationInfo(w io.Writer) {
+	if l := s.primaryLink.Load(); l != nil {
+		l.mu.Lock()
+		status, lastIO := "down", -1
+		if l.up {
+			status, lastIO = "up", int(time.Since(l.lastIO).Seconds())
+		}
+		infoLine(w, "role:replica")
+		infoLine(w, "primary_addr:%s", l.addr)
+		infoLine(w, "primary_link_status:%s", status)
+		infoLine(w, "primary_last_io_seconds_ago:%d", lastIO)
+		infoLine(w, "primary_repl_id:%s", l.id)
+		infoLine(w, "primary_repl_offset:%d", l.offset)
+		l.mu.Unlock()
+	} else {
+		infoLine(w, "role:primary")
+	}
+	f := s.replFeed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	replicas := slices.SortedFunc(maps.Keys(f.replicas), func(a, b *replicaConn) int { return strings.Compare(a.addr, b.addr) })
+	infoLine(w, "connected_replicas:%d", len(replicas))
+	for i, rc := range replicas {
+		lag := int64(time.Since(time.Unix(0, rc.ackAt.Load())).Seconds())
+		infoLine(w, "replica%d:addr=%s,offset=%d,lag=%d", i, rc.addr, rc.acked.Load(), lag)
+	}
+	infoLine(w, "repl_id:%s", f.id)
+	infoLine(w, "repl_offset:%d", f.offset)
+	infoLine(w, "repl_backlog_bytes:%d", len(f.backlog))
+	infoLine(w, "sync_full:%d", f.fullSyncs)
+	infoLine(w, "sync_partial_ok:%d", f.partialSyncs)
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// useShortRetry gives replicas a short replicaRetryDelay until the test ends.
+// Call it before startServer, so the old value comes back only after the
+// handlers return.
+func useShortRetry(t *testing.T) {
+	t.Helper()
+	delay := replicaRetryDelay
+	replicaRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { replicaRetryDelay = delay })
+}
+
+// startReplica replicates primary into c until the test ends. The link
+// belongs to a server of its own, so what it applies is not streamed back to
+// the primary's replicas.
+func startReplica(t *testing.T, primary string, c *cache.ShardedCache) *replicaLink {
+	t.Helper()
+	link := newReplicaLink(primary, New(WithCache(c)))
+	runReplica(t, link)
+	return link
+}
+
+// runReplica runs link until the test ends or it is closed.
+func runReplica(t *testing.T, link *replicaLink) {
+	t.Helper()
+	link.start()
+	t.Cleanup(link.close)
+}
+
+// waitForValue waits for key to hold want in c.
+func waitForValue(t *testing.T, c *cache.ShardedCache, key, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		value, err := c.Get(key)
+		if err == nil && value == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s=%q on the replica, got %q, %v", key, want, value, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// linkOffset returns link's replication id and offset.
+func linkOffset(link *replicaLink) (string, int64) {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.id, link.offset
+}
+
+func TestReplicationStream(t *testing.T) {
+	useShortRetry(t)
+	primary := cache.NewShardedCache()
+	defer primary.Close()
+	replica := cache.NewShardedCache()
+	defer replica.Close()
+	primary.Set("before", "sync")
+	srv := startServer(t, WithCache(primary))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	startReplica(t, conn.RemoteAddr().String(), replica)
+
+	waitForValue(t, replica, "before", "sync")
+	for _, cmd := range []string{"SET a 1", "APPEND a 23", "SET gone x", "DEL gone", "PSETEX short 60000 v", "INCR n"} {
+		configCommand(t, conn, r, cmd)
+	}
+	waitForValue(t, replica, "n", "1")
+	if value, _ := replica.Get("a"); value != "123" {
+		t.Fatalf("expected the append to replicate, got %q", value)
+	}
+	if replica.Exists("gone") {
+		t.Fatal("expected the delete to replicate")
+	}
+	if at, err := replica.ExpireTime("short"); err != nil || time.Until(at) <= 0 || time.Until(at) > time.Minute {
+		t.Fatalf("expected the TTL to replicate, got %v, %v", at, err)
+	}
+
+	fmt.Fprint(conn, "INFO replication\n")
+	_, fields := readInfo(t, r)
+	if fields["role"] != "primary" || fields["connected_replicas"] != "1" || fields["sync_full"] != "1" {
+		t.Fatalf("expected one fully synced replica, got %v", fields)
+	}
+	if !strings.HasPrefix(fields["replica0"], "addr=127.0.0.1:") || fields["repl_offset"] == "0" {
+		t.Fatalf("expected the replica and a nonzero offset, got %v", fields)
+	}
+}
+
+// Racing writes to one key reach the replica in the order the primary applied
+// them, with the expiration each got there.
+func TestReplicationOrdersConcurrentWrites(t *testing.T) {
+	useShortRetry(t)
+	primary := cache.NewShardedCache(cache.WithDefaultTTL(time.Hour))
+	defer primary.Close()
+	replica := cache.NewShardedCache()
+	defer replica.Close()
+	srv := startServer(t, WithCache(primary))
+	startReplica(t, srv.Addr().String(), replica)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		conn := dial(t, srv)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := bufio.NewReader(conn)
+			for j := 0; j < 100; j++ {
+				fmt.Fprintf(conn, "SET k %d-%d\n", i, j)
+				if line, err := r.ReadString('\n'); err != nil || line != "OK\n" {
+					t.Errorf("expected OK, got %q, %v", line, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	conn := dial(t, srv)
+	configCommand(t, conn, bufio.NewReader(conn), "SET done 1")
+	waitForValue(t, replica, "done", "1")
+
+	want, _ := primary.Get("k")
+	if got, _ := replica.Get("k"); got != want {
+		t.Fatalf("expected the replica to end with %q, got %q", want, got)
+	}
+	wantAt, _ := primary.ExpireTime("k")
+	if gotAt, err := replica.ExpireTime("k"); err != nil || wantAt.IsZero() || gotAt.Sub(wantAt).Abs() > time.Second {
+		t.Fatalf("expected the default TTL's expiration %v on the replica, got %v, %v", wantAt, gotAt, err)
+	}
+}
+
+// Hashes, lists, sets, sorted sets, and bits reach the replica both in the
+// snapshot and in the stream.
+func TestReplicationKinds(t *testing.T) {
+	useShortRetry(t)
+	primary := cache.NewShardedCache()
+	defer primary.Close()
+	replica := cache.NewShardedCache()
+	defer replica.Close()
+	primary.HSet("h", "before", "sync")
+	primary.Set("synced", "1")
+	srv := startServer(t, WithCache(primary))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	startReplica(t, conn.RemoteAddr().String(), replica)
+
+	waitForValue(t, replica, "synced", "1")
+	for _, cmd := range []string{
+		"HSET h f v", "HINCRBY h n 2", "RPUSH l a b c", "LPOP l", "SADD s x y", "SREM s x",
+		"ZADD z 1.5 m", "SETBIT b 7 1", "HSET gone f v", "HDEL gone f", "SET done 1",
+	} {
+		configCommand(t, conn, r, cmd)
+	}
+	waitForValue(t, replica, "done", "1")
+	if fields, _ := replica.HGetAll("h"); len(fields) != 3 || fields["before"] != "sync" || fields["f"] != "v" || fields["n"] != "2" {
+		t.Fatalf("expected the hash to replicate, got %v", fields)
+	}
+	if values, _ := replica.LRange("l", 0, -1); strings.Join(values, ",") != "b,c" {
+		t.Fatalf("expected the list to replicate, got %q", values)
+	}
+	if members, _ := replica.SMembers("s"); len(members) != 1 || members[0] != "y" {
+		t.Fatalf("expected the set to replicate, got %q", members)
+	}
+	if score, err := replica.ZScore("z", "m"); err != nil || score != 1.5 {
+		t.Fatalf("expected the sorted set to replicate, got %v, %v", score, err)
+	}
+	if bit, _ := replica.GetBit("b", 7); !bit {
+		t.Fatal("expected the bit to replicate")
+	}
+	if replica.Exists("gone") {
+		t.Fatal("expected the emptied hash to be deleted on the replica")
+	}
+}
+
+func TestReplicationResume(t *testing.T) {
+	useShortRetry(t)
+	primary := cache.NewShardedCache()
+	defer primary.Close()
+	replica := cache.NewShardedCache()
+	defer replica.Close()
+	srv := startServer(t, WithCache(primary))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	link := startReplica(t, conn.RemoteAddr().String(), replica)
+
+	configCommand(t, conn, r, "SET a 1")
+	waitForValue(t, replica, "a", "1")
+	// Drop the replica's connection; it reconnects, and its offset is still
+	// in the backlog.
+	srv.replFeed.mu.Lock()
+	for rc := range srv.replFeed.replicas {
+		rc.conn.Close()
+	}
+	srv.replFeed.mu.Unlock()
+	configCommand(t, conn, r, "SET b 2")
+	waitForValue(t, replica, "b", "2")
+
+	fmt.Fprint(conn, "INFO replication\n")
+	_, fields := readInfo(t, r)
+	if fields["sync_full"] != "1" || fields["sync_partial_ok"] != "1" {
+		t.Fatalf("expected a full then a partial sync, got %v", fields)
+	}
+	if id, _ := linkOffset(link); id != fields["repl_id"] {
+		t.Fatalf("expected the replica to follow stream %s, got %s", fields["repl_id"], id)
+	}
+}
+
+func TestReplicationBacklogLost(t *testing.T) {
+	useShortRetry(t)
+	primary := cache.NewShardedCache()
+	defer primary.Close()
+	replica := cache.NewShardedCache()
+	defer replica.Close()
+	srv := startServer(t, WithCache(primary), withConfig(func(c *Config) { c.replBacklog = 64 }))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	addr := conn.RemoteAddr().String()
+	link := startReplica(t, addr, replica)
+
+	configCommand(t, conn, r, "SET a 1")
+	waitForValue(t, replica, "a", "1")
+	link.close()
+	id, offset := linkOffset(link)
+
+	// Write more than the backlog holds while the replica is away.
+	for i := range 20 {
+		configCommand(t, conn, r, "SET key"+strconv.Itoa(i)+" value")
+	}
+	configCommand(t, conn, r, "DEL a")
+	resumed := newReplicaLink(addr, New(WithCache(replica)))
+	resumed.id, resumed.offset = id, offset
+	runReplica(t, resumed)
+	waitForValue(t, replica, "key19", "value")
+	if replica.Exists("a") {
+		t.Fatal("expected the full resync to drop a")
+	}
+
+	fmt.Fprint(conn, "INFO replication\n")
+	_, fields := readInfo(t, r)
+	if fields["sync_full"] != "2" || fields["sync_partial_ok"] != "0" {
+		t.Fatalf("expected two full syncs, got %v", fields)
+	}
+}
+
+func TestReplicaReadOnly(t *testing.T) {
+	useShortRetry(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("a", "1")
+	// startReplicaServer serves c as a replica whose primary is unreachable.
+	startReplicaServer := func(opts ...Option) (net.Conn, *bufio.Reader) {
+		srv := startServer(t, append([]Option{WithCache(c)}, opts...)...)
+		link := newReplicaLink("127.0.0.1:1", srv)
+		srv.primaryLink.Store(link)
+		runReplica(t, link)
+		conn := dial(t, srv)
+		return conn, bufio.NewReader(conn)
+	}
+	conn, r := startReplicaServer()
+
+	for _, cmd := range []string{"SET a 2", "DEL a", "FLUSHALL"} {
+		if got := configCommand(t, conn, r, cmd); got != "ERROR: "+errReadOnly.Error() {
+			t.Fatalf("%s: expected a read-only error, got %q", cmd, got)
+		}
+	}
+	fmt.Fprint(conn, "GET a\n")
+	if got := readBulkReply(t, r); got != "1" {
+		t.Fatalf("expected reads to work, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "PUBLISH news hi"); got != "0" {
+		t.Fatalf("expected PUBLISH to work, got %q", got)
+	}
+	fmt.Fprint(conn, "INFO replication\n")
+	if _, fields := readInfo(t, r); fields["role"] != "replica" || fields["primary_link_status"] != "down" {
+		t.Fatalf("expected a replica with its link down, got %v", fields)
+	}
+
+	conn, r = startReplicaServer(withConfig(func(c *Config) { c.replicaRO = false }))
+	if got := configCommand(t, conn, r, "SET a 2"); got != "OK" {
+		t.Fatalf("expected writes without -replica-read-only, got %q", got)
+	}
+}
+
+// startFakePrimary accepts one replica on a loopback port and sends it a full
+// resync of snapshot, then holds the connection until the replica closes it.
+// It returns the port. The replica never gets its snapshot if snapshot is
+// nil.
+func startFakePrimary(t *testing.T, snapshot *cache.ShardedCache) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if line, err := readLine(r, NewConfig().maxRequest); err != nil || line != "PSYNC ? -1" {
+			return
+		}
+		if snapshot != nil {
+			var data bytes.Buffer
+			snapshot.WriteSnapshot(&data)
+			fmt.Fprintf(conn, "FULLRESYNC %s 0\r\n$%d\r\n%s\r\n", newReplicationID(), data.Len(), data.Bytes())
+		}
+		io.Copy(io.Discard, r)
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestReplicaOf(t *testing.T) {
+	useShortRetry(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("old", "data")
+	snapshot := cache.NewShardedCache()
+	defer snapshot.Close()
+	snapshot.Set("k", "v")
+	port := startFakePrimary(t, snapshot)
+	srv := startServer(t, WithCache(c))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+
+	for cmd, want := range map[string]string{
+		"REPLICAOF 127.0.0.1":        "ERROR: REPLICAOF requires host and port, or NO ONE",
+		"REPLICAOF 127.0.0.1 0":      "ERROR: invalid port",
+		"REPLICAOF 127.0.0.1 999999": "ERROR: invalid port",
+	} {
+		if got := configCommand(t, conn, r, cmd); got != want {
+			t.Fatalf("%s: expected %q, got %q", cmd, want, got)
+		}
+	}
+
+	if got := configCommand(t, conn, r, "REPLICAOF 127.0.0.1 "+port); got != "OK" {
+		t.Fatalf("expected REPLICAOF to succeed, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "SET a 1"); got != "ERROR: "+errReadOnly.Error() {
+		t.Fatalf("expected writes to be refused once demoted, got %q", got)
+	}
+	fmt.Fprint(conn, "INFO replication\n")
+	if _, fields := readInfo(t, r); fields["role"] != "replica" || fields["primary_addr"] != "127.0.0.1:"+port {
+		t.Fatalf("expected INFO to show the new primary, got %v", fields)
+	}
+	waitForValue(t, c, "k", "v")
+	if c.Exists("old") {
+		t.Fatal("expected the full resync to replace the data")
+	}
+
+	if got := configCommand(t, conn, r, "REPLICAOF no one"); got != "OK" {
+		t.Fatalf("expected REPLICAOF NO ONE to succeed, got %q", got)
+	}
+	fmt.Fprint(conn, "INFO replication\n")
+	if _, fields := readInfo(t, r); fields["role"] != "primary" {
+		t.Fatalf("expected INFO to show a primary, got %v", fields)
+	}
+	if got := configCommand(t, conn, r, "SET a 1"); got != "OK" {
+		t.Fatalf("expected writes once promoted, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "REPLICAOF NO ONE"); got != "OK" {
+		t.Fatalf("expected promoting a primary to do nothing, got %q", got)
+	}
+}
+
+func TestReplicaOfFlush(t *testing.T) {
+	useShortRetry(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("old", "data")
+	port := startFakePrimary(t, nil)
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	if got := configCommand(t, conn, r, "REPLICAOF 127.0.0.1 "+port); got != "OK" {
+		t.Fatalf("expected REPLICAOF to succeed, got %q", got)
+	}
+	if !c.Exists("old") {
+		t.Fatal("expected the data to be kept until the first sync")
+	}
+	configCommand(t, conn, r, "REPLICAOF NO ONE")
+
+	conn = dial(t, startServer(t, WithCache(c), withConfig(func(c *Config) { c.replicaFlush = true })))
+	r = bufio.NewReader(conn)
+	if got := configCommand(t, conn, r, "REPLICAOF 127.0.0.1 "+port); got != "OK" {
+		t.Fatalf("expected REPLICAOF to succeed, got %q", got)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expected -replica-flush to discard the data, got %d keys", c.Len())
+	}
+}
+
+func TestReplicaOfInFlightWrites(t *testing.T) {
+	useShortRetry(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	port := startFakePrimary(t, nil)
+	srv := startServer(t, WithCache(c))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+
+	// Every write is either applied before the demotion or refused, never
+	// applied after it.
+	writer, err := net.Dial("tcp", conn.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	replies := make(chan []string)
+	go func() {
+		wr := bufio.NewReader(writer)
+		var got []string
+		for i := range 200 {
+			fmt.Fprintf(writer, "SET k%d v\n", i)
+			line, err := readLine(wr, NewConfig().maxRequest)
+			if err != nil {
+				break
+			}
+			got = append(got, line)
+		}
+		replies <- got
+	}()
+	configCommand(t, conn, r, "REPLICAOF 127.0.0.1 "+port)
+	applied := c.Len()
+	got := <-replies
+	if c.Len() != applied {
+		t.Fatalf("expected no writes after the demotion, got %d keys, then %d", applied, c.Len())
+	}
+	for i, line := range got {
+		want := "OK"
+		if i >= applied {
+			want = "ERROR: " + errReadOnly.Error()
+		}
+		if line != want {
+			t.Fatalf("SET k%d: expected %q, got %q", i, want, line)
+		}
+	}
+}
+
+func TestReplicationExecWhileAttached(t *testing.T) {
+	useShortRetry(t)
+	primary := cache.NewShardedCache()
+	defer primary.Close()
+	replica := cache.NewShardedCache()
+	defer replica.Close()
+	srv := startServer(t, WithCache(primary))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	startReplica(t, conn.RemoteAddr().String(), replica)
+
+	configCommand(t, conn, r, "SET before 1")
+	waitForValue(t, replica, "before", "1")
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "MULTI\nSET a 1\nINCR n\nEXEC\n")
+	expectLines(t, r, "OK", "QUEUED", "QUEUED", "2", "OK", "1")
+	waitForValue(t, replica, "n", "1")
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// handleRESPConnection serves a single connection speaking RESP, so Redis
+// clients can talk to the cache. It supports GET, SET (with EX or PX), DEL,
+// PING, and AUTH. Replies are flushed once every pipelined command read so far
+// has been answered.
+func (s *Server) handleRESPConnection(conn net.Conn) {
+	defer conn.Close()
+	r := protocol.NewReader(conn)
+	w := protocol.NewWriter(conn)
+	timeouts := s.newConnTimeouts(conn)
+	authenticated := !s.authEnabled.Load()
+	perm := permAdmin
+	var ks keyspace
+	self := s.clients.register(conn, authenticated)
+	defer s.clients.unregister(self)
+	timeouts.log = self.log
+	if !authenticated {
+		timeouts.awaitCommand()
+		granted, ok, err := s.certPermission(conn)
+		if err != nil {
+			self.log.Warn("TLS handshake failed", "err", err)
+			return
+		}
+		if ok {
+			authenticated, perm = true, granted
+			self.setAuthenticated()
+			self.log.Info("authenticated", "method", "certificate")
+		}
+	}
+	limits := s.newConnLimits(conn)
+	slot := workerSlot{slots: s.workerSlots}
+	defer slot.release()
+	hold := roleHold{server: s}
+	defer hold.release()
+	share := txShare{mu: &s.txMu}
+	defer share.release()
+
+	for {
+		slot.release()
+		hold.release()
+		share.release()
+		timeouts.awaitCommand()
+		args, err := r.ReadCommand()
+		if err != nil {
+			if errors.Is(err, protocol.ErrProtocol) {
+				w.WriteError("ERR " + err.Error())
+				timeouts.flush(w)
+				errorCounter.WithLabelValues("protocol").Inc()
+			} else if err != io.EOF && !timeouts.timedOut(err) {
+				self.log.Warn("connection error", "err", err)
+			}
+			return
+		}
+		timeouts.beginCommand()
+		slot.acquire()
+		share.acquire()
+		start := time.Now()
+		command := strings.ToUpper(args[0])
+		self.noteCommand(command, ks.db)
+
+		if ok, disconnect := limits.allow(); !ok {
+			w.WriteError("ERR rate limit exceeded")
+			if disconnect {
+				timeouts.flush(w)
+				return
+			}
+		} else if s.authEnabled.Load() && !authenticated && command != "AUTH" {
+			w.WriteError("NOAUTH Authentication required.")
+			errorCounter.WithLabelValues("unauthenticated").Inc()
+		} else if perm < permAdmin && !perm.allows(command) {
+			w.WriteError(fmt.Sprintf("NOPERM %s requires the %s permission", command, required(command)))
+			errorCounter.WithLabelValues("noperm").Inc()
+		} else if !hold.admit(command) {
+			w.WriteError(errReadOnly.Error())
+			errorCounter.WithLabelValues(command).Inc()
+		} else if !s.execRESP(w, command, args, self, &authenticated, &perm, &ks) {
+			timeouts.flush(w)
+			return
+		}
+		processingDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+		if r.Buffered() == 0 {
+			if err := timeouts.flush(w); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// execRESP runs one command from the client self in the keyspace ks and
+// writes its reply. It returns false if the connection should be closed. AUTH
+// updates authenticated and perm.
+func (s *Server) execRESP(w *protocol.Writer, command string, args []string, self *client, authenticated *bool, perm *permission, ks *keyspace) bool {
+	c := s.store
+	ks.mapKeys(command, args)
+	switch command {
+	case "AUTH":
+		s.countCommand("AUTH")
+		if len(args) != 2 && len(args) != 3 {
+			respArityError(w, command)
+			return true
+		}
+		if !s.authEnabled.Load() {
+			w.WriteError("ERR AUTH called without a password configured")
+			errorCounter.WithLabelValues("AUTH").Inc()
+			return true
+		}
+		granted, err := s.checkAuth(self.log, self.addr, args[1:])
+		if err != nil {
+			if errors.Is(err, errAuthBanned) {
+				w.WriteError("ERR " + err.Error())
+			} else {
+				w.WriteError("WRONGPASS invalid password")
+			}
+			errorCounter.WithLabelValues("AUTH").Inc()
+			return false // Close connection on failed auth.
+		}
+		*authenticated, *perm = true, granted
+		self.setAuthenticated()
+		w.WriteSimpleString("OK")
+	case "PING":
+		s.countCommand("PING")
+		switch len(args) {
+		case 1:
+			w.WriteSimpleString("PONG")
+		case 2:
+			w.WriteBulkString(args[1])
+		default:
+			respArityError(w, command)
+		}
+	case "SELECT":
+		s.countCommand("SELECT")
+		if len(args) != 2 {
+			respArityError(w, command)
+			return true
+		}
+		selected, err := ks.selectDB(args[1], s.config.databases)
+		if err != nil {
+			w.WriteError("ERR " + err.Error())
+			errorCounter.WithLabelValues("SELECT").Inc()
+			return true
+		}
+		*ks = selected
+		w.WriteSimpleString("OK")
+	case "NAMESPACE":
+		s.countCommand("NAMESPACE")
+		if len(args) != 2 {
+			respArityError(w, command)
+			return true
+		}
+		selected, err := ks.selectNamespace(args[1])
+		if err != nil {
+			w.WriteError("ERR " + err.Error())
+			errorCounter.WithLabelValues("NAMESPACE").Inc()
+			return true
+		}
+		*ks = selected
+		w.WriteSimpleString("OK")
+	case "GET":
+		s.countCommand("GET")
+		if len(args) != 2 {
+			respArityError(w, command)
+			return true
+		}
+		value, err := c.Get(args[1])
+		if errors.Is(err, cache.ErrKeyNotFound) {
+			w.WriteNull()
+		} else if err != nil {
+			respError(w, command, err)
+		} else {
+			w.WriteBulkString(value)
+		}
+	case "SET":
+		s.countCommand("SET")
+		if len(args) != 3 && len(args) != 5 {
+			respArityError(w, command)
+			return true
+		}
+		key, value := args[1], args[2]
+		var ttl time.Duration
+		if len(args) == 5 {
+			n, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || n <= 0 {
+				w.WriteError("ERR invalid expire time in 'set' command")
+				errorCounter.WithLabelValues("SET").Inc()
+				return true
+			}
+			switch strings.ToUpper(args[3]) {
+			case "EX":
+				ttl = time.Duration(n) * time.Second
+			case "PX":
+				ttl = time.Duration(n) * time.Millisecond
+			default:
+				w.WriteError("ERR syntax error")
+				errorCounter.WithLabelValues("SET").Inc()
+				return true
+			}
+		}
+		if err := s.checkValueSize(value); err != nil {
+			respError(w, command, err)
+			return true
+		}
+		s.logMu.Lock()
+		err := s.set(key, value, ttl)
+		if err == nil {
+			s.logWrite(s.setRecord(key, value))
+		}
+		s.logMu.Unlock()
+		if err != nil {
+			respError(w, command, err)
+			return true
+		}
+		observeSet(ks.strip(key), value)
+		w.WriteSimpleString("OK")
+	case "DEL":
+		s.countCommand("DEL")
+		if len(args) < 2 {
+			respArityError(w, command)
+			return true
+		}
+		s.logMu.Lock()
+		removed := s.del(args[1:]...)
+		for _, key := range args[1:] {
+			s.logWrite(aof.Record{Op: aof.OpDel, Key: key})
+		}
+		s.logMu.Unlock()
+		w.WriteInteger(int64(removed))
+	default:
+		w.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		errorCounter.WithLabelValues("unknown").Inc()
+	}
+	return true
+}
+
+// respArityError replies that command was called with the wrong number of
+// arguments.
+func respArityError(w *protocol.Writer, command string) {
+	w.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+	errorCounter.WithLabelValues(command).Inc()
+}
+
+// respError is replyError for RESP connections.
+func respError(w *protocol.Writer, command string, err error) {
+	if errors.Is(err, cache.ErrWrongType) {
+		w.WriteError("WRONGTYPE " + err.Error())
+	} else {
+		w.WriteError("ERR " + err.Error())
+	}
+	errorCounter.WithLabelValues(command).Inc()
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+func TestRESPWithRedisClient(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		srv := startServer(t, WithCache(c), WithProtocol("resp"))
+		rdb := redis.NewClient(&redis.Options{Addr: srv.Addr().String()})
+		defer rdb.Close()
+		ctx := context.Background()
+
+		if got, err := rdb.Ping(ctx).Result(); err != nil || got != "PONG" {
+			t.Fatalf("PING: expected PONG, got %q, %v", got, err)
+		}
+		binary := "line one\r\nline two\x00"
+		if err := rdb.Set(ctx, "greeting", binary, 0).Err(); err != nil {
+			t.Fatalf("SET: %v", err)
+		}
+		if got, err := rdb.Get(ctx, "greeting").Result(); err != nil || got != binary {
+			t.Fatalf("GET: expected %q, got %q, %v", binary, got, err)
+		}
+		if _, err := rdb.Get(ctx, "missing").Result(); err != redis.Nil {
+			t.Fatalf("GET missing: expected redis.Nil, got %v", err)
+		}
+
+		if err := rdb.Set(ctx, "short", "v", 50*time.Millisecond).Err(); err != nil {
+			t.Fatalf("SET PX: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := rdb.Get(ctx, "short").Result(); err != redis.Nil {
+			t.Fatalf("expected short to expire, got %v", err)
+		}
+
+		if n, err := rdb.Del(ctx, "greeting", "missing").Result(); err != nil || n != 1 {
+			t.Fatalf("DEL: expected 1, got %d, %v", n, err)
+		}
+
+		pipe := rdb.Pipeline()
+		set := pipe.Set(ctx, "p", "1", 0)
+		get := pipe.Get(ctx, "p")
+		if _, err := pipe.Exec(ctx); err != nil || set.Err() != nil || get.Val() != "1" {
+			t.Fatalf("pipeline: %v, %v, %q", err, set.Err(), get.Val())
+		}
+
+		if err := rdb.Do(ctx, "NOSUCH").Err(); err == nil {
+			t.Fatal("expected an error for an unknown command")
+		}
+	})
+}
+
+func TestRESPAuth(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		addr := startServer(t, WithCache(c), WithProtocol("resp"), WithPassword("hunter2")).Addr().String()
+		ctx := context.Background()
+
+		anon := redis.NewClient(&redis.Options{Addr: addr})
+		defer anon.Close()
+		if err := anon.Get(ctx, "k").Err(); err == nil || err == redis.Nil {
+			t.Fatalf("expected NOAUTH without a password, got %v", err)
+		}
+
+		rdb := redis.NewClient(&redis.Options{Addr: addr, Password: "hunter2"})
+		defer rdb.Close()
+		if err := rdb.Set(ctx, "k", "v", 0).Err(); err != nil {
+			t.Fatalf("SET after AUTH: %v", err)
+		}
+		if got, err := rdb.Get(ctx, "k").Result(); err != nil || got != "v" {
+			t.Fatalf("GET after AUTH: expected v, got %q, %v", got, err)
+		}
+	})
+}
+
+func TestRESPInlineCommands(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		conn := dial(t, startServer(t, WithCache(c), WithProtocol("resp")))
+		r := bufio.NewReader(conn)
+
+		for _, step := range []struct{ send, want string }{
+			{"PING\r\n", "+PONG\r\n"},
+			{"SET k hello\r\n", "+OK\r\n"},
+			{"get k\n", "$5\r\n"},
+			{"", "hello\r\n"},
+			{"DEL k\r\n", ":1\r\n"},
+			{"GET k\r\n", "$-1\r\n"},
+		} {
+			if step.send != "" {
+				conn.Write([]byte(step.send))
+			}
+			line, err := r.ReadString('\n')
+			if err != nil || line != step.want {
+				t.Fatalf("after %q: expected %q, got %q, %v", step.send, step.want, line, err)
+			}
+		}
+	})
+}
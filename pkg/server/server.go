@@ -0,0 +1,628 @@
+// Package server implements the cache server: the line protocol or RESP on
+// its TCP listener, and optionally the HTTP API, the gRPC service, and the
+// memcached text protocol on listeners of their own, along with persistence,
+// replication, and metrics. cmd/server runs one configured from flags; other
+// programs can embed one with New.
+//
+// A Server's settings come from a Config, which WithConfig supplies and
+// Config.RegisterFlags exposes as flags; Options change single settings. Each
+// Server keeps its own settings and state, such as its clients, channels,
+// transactions, replication role, and append-only file, so a process can run
+// several. The Prometheus metrics other than the cache's are process-wide,
+// counting every Server's traffic together.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vlkhvnn/inmemcache/pkg/aof"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"google.golang.org/grpc"
+)
+
+// ErrServerClosed is returned by Start on a Server that was shut down.
+var ErrServerClosed = errors.New("server closed")
+
+// CommandHook is called after each command on the TCP listener with the
+// command's upper-cased name and how long it took, alongside the server's own
+// Prometheus metrics.
+type CommandHook func(command string, elapsed time.Duration)
+
+// Server is a cache server. Create one with New, run it with Start, and stop
+// it with Shutdown.
+type Server struct {
+	config     Config
+	settings   *flag.FlagSet // Defines config's settings, for CONFIG GET.
+	ln         net.Listener
+	store      cache.Store         // The engine's cache, of either kind.
+	cache      *cache.ShardedCache // store if it is a ShardedCache, or nil.
+	password   string              // config's password, or -password-file's contents.
+	tlsConfig  *tls.Config
+	onCommand  CommandHook
+	registerer prometheus.Registerer
+
+	// Settings CONFIG SET changes while the server runs.
+	authEnabled atomicBool
+	idleTimeout atomicDuration
+	configMu    sync.Mutex // Serializes CONFIG SET.
+
+	users        map[string]user // From -users-file, or nil to check password.
+	authThrottle *authLimiter
+	certs        *certReloader // Serves the TLS certificate; nil without -tls.
+	clients      *clientRegistry
+	pubsub       *broker
+	monitors     *monitorHub
+	slowlog      *slowLog
+	txMu         sync.RWMutex                // Held for writing by EXEC; see transaction.go.
+	roleMu       sync.RWMutex                // Orders client writes against role changes; see roleHold.
+	logMu        sync.Mutex                  // Orders logged writes; see logWrite.
+	primaryLink  atomic.Pointer[replicaLink] // Replicates -replicaof's primary; nil on a primary.
+	replFeed     *replicationFeed            // The writes to stream to the server's replicas.
+	appendLog    *aof.Writer                 // Nil when the append-only file is disabled.
+	snapshots    *snapshotter                // Nil when no snapshot file is set.
+	ready        *readiness
+
+	// workerSlots caps how many commands run at once across the TCP
+	// listener's connections. A connection holds a slot only while it runs a
+	// command, so idle clients never keep others waiting. A nil channel, as
+	// before Start, means no cap.
+	workerSlots chan struct{}
+	globalLimit *tokenBucket // For -rate-limit, or nil.
+	ipLimits    *ipLimiter   // For -ip-rate-limit.
+
+	// Counts for INFO and /debug/vars. openConns counts connections on the
+	// TCP listener that have been admitted and not yet closed, and
+	// commandTotals counts requests by command label, alongside reqCounter,
+	// as *atomic.Int64 values.
+	startTime                            time.Time
+	openConns, totalConns, rejectedConns atomic.Int64
+	commandTotals                        sync.Map
+
+	mu       sync.Mutex
+	started  bool
+	closed   bool
+	shutdown chan struct{} // Closed by Shutdown.
+	servers  []*http.Server
+	grpc     *grpc.Server
+	others   []net.Listener // The memcached listener, if any.
+	conns    sync.WaitGroup // Connections on the TCP listener and its accept loop.
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithConfig makes the server use config's settings, replacing those that
+// earlier Options set, so it comes before any others. New copies config, which
+// may then be reused.
+func WithConfig(config *Config) Option {
+	return func(s *Server) { s.config = *config }
+}
+
+// WithAddr sets the address of the TCP listener, overriding -tcp. The default,
+// "127.0.0.1:0", is a free port, which Addr then reports.
+func WithAddr(addr string) Option {
+	return func(s *Server) { s.config.tcpAddr = addr }
+}
+
+// WithMetricsAddr serves /metrics and the /healthz and /readyz probes on
+// addr, overriding -metrics. By default there is no metrics server.
+func WithMetricsAddr(addr string) Option {
+	return func(s *Server) { s.config.metricsAddr = addr }
+}
+
+// WithListener makes the server accept connections from ln instead of
+// listening on an address. With TLS configured, ln is wrapped to serve it.
+func WithListener(ln net.Listener) Option {
+	return func(s *Server) { s.ln = ln }
+}
+
+// WithProtocol sets the wire protocol of the TCP listener, "line" or "resp",
+// overriding -protocol.
+func WithProtocol(name string) Option {
+	return func(s *Server) { s.config.protocolMode = name }
+}
+
+// WithCache makes the server serve c instead of a cache built from the
+// -engine, -shards, -capacity, and related settings. The engine is sharded if
+// c is a *cache.ShardedCache, and simple otherwise.
+func WithCache(c cache.Store) Option {
+	return func(s *Server) {
+		s.store, s.cache = c, nil
+		if sc, ok := c.(*cache.ShardedCache); ok {
+			s.cache = sc
+		}
+	}
+}
+
+// WithPassword enables authentication with password, overriding -auth,
+// -password, and -password-file.
+func WithPassword(password string) Option {
+	return func(s *Server) {
+		s.config.authPassword, s.config.passwordSet = password, true
+		s.config.authEnabled = true
+	}
+}
+
+// WithTLSConfig serves every listener over TLS with config, instead of the
+// -tls, -cert, and -key flags. Certificates are then not reloaded.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) { s.tlsConfig = config }
+}
+
+// WithWorkers caps how many commands run at once on the TCP listener,
+// overriding -workers.
+func WithWorkers(n int) Option {
+	return func(s *Server) { s.config.workerCount = n }
+}
+
+// WithMaxConnections caps the open connections on the TCP listener,
+// overriding -max-connections; 0 is unlimited.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) { s.config.maxConns = n }
+}
+
+// WithCommandHook calls hook after each command on the TCP listener.
+func WithCommandHook(hook CommandHook) Option {
+	return func(s *Server) { s.onCommand = hook }
+}
+
+// WithRegisterer registers the server's cache metrics with reg instead of
+// the default Prometheus registry. The other metrics are shared by every
+// Server in the process and always in the default registry, which the
+// -metrics listener serves. The simple engine has no cache metrics.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(s *Server) { s.registerer = reg }
+}
+
+// New returns a Server configured by opts, with NewConfig's settings unless
+// WithConfig supplies others. It does not start serving until Start.
+func New(opts ...Option) *Server {
+	s := &Server{
+		config:     *NewConfig(),
+		registerer: prometheus.DefaultRegisterer,
+		shutdown:   make(chan struct{}),
+		clients:    newClientRegistry(),
+		pubsub:     newBroker(),
+		monitors:   newMonitorHub(),
+		ready:      &readiness{},
+		ipLimits:   newIPLimiter(),
+		startTime:  time.Now(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.store != nil {
+		s.config.engine = engineSimple
+		if s.cache != nil {
+			s.config.engine = engineSharded
+		}
+	}
+	s.settings = s.config.flagSet()
+	s.password = s.config.authPassword
+	s.authThrottle = newAuthLimiter(s.config.authMaxFails, s.config.authWindow, s.config.authBanTime)
+	s.slowlog = newSlowLog(s.config.slowlogMaxLen)
+	s.replFeed = newReplicationFeed(s.config.replBacklog)
+	s.authEnabled.Store(s.config.authEnabled)
+	s.idleTimeout.Store(int64(s.config.idleTimeout))
+	s.slowlog.threshold.Store(int64(s.config.slowThreshold))
+	return s
+}
+
+// Cache returns the cache the server serves, which Start creates unless
+// WithCache supplied one. It is nil with the simple engine; see Store.
+func (s *Server) Cache() *cache.ShardedCache {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cache
+}
+
+// Store returns the cache the server serves with either engine.
+func (s *Server) Store() cache.Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store
+}
+
+// Addr returns the address of the TCP listener, or nil before Start.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil || !s.started {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// validate checks the settings that must hold before the server starts.
+func (s *Server) validate() error {
+	switch {
+	case s.config.protocolMode != "line" && s.config.protocolMode != "resp":
+		return fmt.Errorf("invalid -protocol %q: must be line or resp", s.config.protocolMode)
+	case s.config.maxRequest < 1:
+		return errors.New("invalid -max-request-bytes: must be at least 1")
+	case s.config.databases < 1:
+		return errors.New("invalid -databases: must be at least 1")
+	case s.config.workerCount < 1:
+		return errors.New("invalid -workers: must be at least 1")
+	case s.config.replBacklog < 1:
+		return errors.New("invalid -repl-backlog-bytes: must be at least 1")
+	}
+	return s.validateEngine()
+}
+
+// configure loads the password and users.
+func (s *Server) configure() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if !s.config.passwordSet {
+		if err := s.loadPassword(); err != nil {
+			return fmt.Errorf("load password: %w", err)
+		}
+	}
+	if s.config.usersFile != "" {
+		var err error
+		if s.users, err = loadUsers(s.config.usersFile); err != nil {
+			return fmt.Errorf("load users: %w", err)
+		}
+		s.authEnabled.Store(true)
+		slog.Info("loaded users", "users", len(s.users), "path", s.config.usersFile)
+	}
+	return nil
+}
+
+// load creates the cache from the server's settings, if none was given, and
+// restores the persisted data into it.
+func (s *Server) load() error {
+	if s.store != nil {
+		return nil
+	}
+	if s.config.engine == engineSimple {
+		s.store = cache.NewCache()
+		return nil
+	}
+	s.cache = s.newCache()
+	s.store = s.cache
+	if err := s.ready.load(s.loadPersisted); err != nil {
+		return fmt.Errorf("load persisted data: %w", err)
+	}
+	return nil
+}
+
+// newCache returns a cache configured by the server's settings.
+func (s *Server) newCache() *cache.ShardedCache {
+	opts := []cache.Option{
+		cache.WithShardCount(s.config.shardCount),
+		cache.WithMaxValueBytes(s.config.maxValueSize),
+		cache.WithMaxBitOffset(s.config.maxBitOffset),
+		cache.WithCompression(s.config.compressAbove),
+		cache.WithMaxBytes(s.config.maxBytes),
+		cache.WithDefaultTTL(s.config.defaultTTL),
+	}
+	if s.config.notifyEvents {
+		opts = append(opts, s.keyEventOptions()...)
+	}
+	if s.config.capacity > 0 {
+		opts = append(opts, cache.WithTotalCapacity(s.config.capacity))
+	} else {
+		opts = append(opts, cache.WithShardCapacity(0))
+	}
+	return cache.NewShardedCache(opts...)
+}
+
+// Export loads the persisted data into a new cache configured by the
+// server's settings, as Start would, and writes it as JSON to path, or to
+// standard output if path is "-", without serving it.
+func (s *Server) Export(path string) error {
+	if err := s.configure(); err != nil {
+		return err
+	}
+	if s.config.engine != engineSharded {
+		return errors.New("-export requires -engine=sharded")
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	if err := exportJSON(path, s.cache); err != nil {
+		return fmt.Errorf("export %s: %w", path, err)
+	}
+	slog.Info("exported entries", "entries", s.cache.Len(), "path", path)
+	return nil
+}
+
+// Start loads the persisted data, opens the listeners, and serves them in the
+// background until Shutdown. If ctx is done before the listeners are open,
+// Start gives up and returns ctx's error.
+func (s *Server) Start(ctx context.Context) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	if s.started {
+		return errors.New("server already started")
+	}
+	if err := s.configure(); err != nil {
+		return err
+	}
+	policy, err := aof.ParseFsyncPolicy(s.config.aofFsync)
+	if err != nil {
+		return fmt.Errorf("invalid -aof-fsync: %w", err)
+	}
+	// Close whatever was opened if a later step fails.
+	defer func() {
+		if err != nil {
+			s.closeListeners()
+		}
+	}()
+
+	// The metrics server comes up first, so /readyz reports the load. It has
+	// its own mux, since net/http/pprof and expvar register their handlers on
+	// http.DefaultServeMux.
+	if s.config.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", serveHealthz)
+		mux.Handle("/readyz", s.ready)
+		if err := s.serveHTTP("metrics server", s.config.metricsAddr, mux, nil); err != nil {
+			return err
+		}
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.config.aofFile != "" {
+		if s.appendLog, err = aof.Open(s.config.aofFile, policy); err != nil {
+			return fmt.Errorf("open AOF: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				s.appendLog.Close()
+				s.appendLog = nil
+			}
+		}()
+	}
+	if s.cache != nil {
+		if err := s.registerer.Register(newCacheCollector(s.cache)); err != nil {
+			return fmt.Errorf("register cache metrics: %w", err)
+		}
+	}
+	if s.tlsConfig == nil && s.config.useTLS {
+		if s.tlsConfig, err = s.newTLSConfig(); err != nil {
+			return fmt.Errorf("set up TLS: %w", err)
+		}
+	}
+	if err := s.listen(); err != nil {
+		return err
+	}
+
+	// Each connection gets its own goroutine; -workers caps how many run a
+	// command at once.
+	s.workerSlots = make(chan struct{}, s.config.workerCount)
+	if s.config.globalRate > 0 {
+		s.globalLimit = newTokenBucket(s.config.globalRate, time.Now())
+	}
+
+	// Snapshot periodically, and once more on shutdown, if a snapshot file is set.
+	if s.config.snapshotFile != "" {
+		s.snapshots = &snapshotter{cache: s.cache, path: s.config.snapshotFile}
+		if s.config.snapshotEvery > 0 {
+			go s.snapshots.run(s.config.snapshotEvery, s.shutdown)
+		}
+	}
+	if s.appendLog != nil && s.config.aofRewriteAt > 0 {
+		go s.autoRewriteAOF(s.config.aofRewriteAt, s.shutdown)
+	}
+	if s.config.replicaOf != "" {
+		s.replicate(s.config.replicaOf)
+	}
+	// Reload rotated TLS certificates when their files change.
+	if s.certs != nil && s.config.certPollEvery > 0 {
+		go s.certs.watch(s.config.certPollEvery, s.shutdown)
+	}
+
+	s.started = true
+	s.ready.listen()
+	s.conns.Add(1)
+	go s.serve()
+	return nil
+}
+
+// listen opens the TCP listener, unless WithListener supplied one, and the
+// other listeners that the settings enable but the metrics server, serving
+// all but the TCP listener. The caller must hold s.mu.
+func (s *Server) listen() error {
+	if s.ln == nil {
+		ln, err := net.Listen("tcp", s.config.tcpAddr)
+		if err != nil {
+			return fmt.Errorf("listen on %s: %w", s.config.tcpAddr, err)
+		}
+		s.ln = ln
+	}
+	if s.tlsConfig != nil {
+		s.ln = tls.NewListener(s.ln, s.tlsConfig)
+	}
+	slog.Info("server listening", "addr", s.ln.Addr().String(), "tls", s.tlsConfig != nil)
+
+	// Serve the profiling endpoints on their own listener, off the data and
+	// metrics ports.
+	if s.config.debugAddr != "" {
+		if err := s.serveHTTP("debug server", s.config.debugAddr, s.newDebugHandler(), nil); err != nil {
+			return err
+		}
+	}
+	// Serve the HTTP API, with the same TLS settings as the TCP listener.
+	if s.config.httpAddr != "" {
+		if err := s.serveHTTP("HTTP API", s.config.httpAddr, s.newHTTPHandler(), s.tlsConfig); err != nil {
+			return err
+		}
+	}
+	// Serve the gRPC service, with the same TLS settings as the TCP listener.
+	if s.config.grpcAddr != "" {
+		gln, err := net.Listen("tcp", s.config.grpcAddr)
+		if err != nil {
+			return fmt.Errorf("listen on %s: %w", s.config.grpcAddr, err)
+		}
+		s.grpc = s.newGRPCServer(s.tlsConfig)
+		slog.Info("gRPC service listening", "addr", gln.Addr().String())
+		go func() {
+			if err := s.grpc.Serve(gln); err != nil {
+				slog.Error("gRPC server failed", "err", err)
+			}
+		}()
+	}
+	// Serve the memcached text protocol on a second listener, if requested.
+	if s.config.memcachedAddr != "" {
+		if s.authEnabled.Load() {
+			return errors.New("-memcached-addr cannot be combined with -auth: the memcached text protocol has no authentication")
+		}
+		mln, err := net.Listen("tcp", s.config.memcachedAddr)
+		if err != nil {
+			return fmt.Errorf("listen on %s: %w", s.config.memcachedAddr, err)
+		}
+		if s.tlsConfig != nil {
+			mln = tls.NewListener(mln, s.tlsConfig)
+		}
+		s.others = append(s.others, mln)
+		slog.Info("memcached protocol listening", "addr", mln.Addr().String())
+		go s.serveMemcached(mln)
+	}
+	return nil
+}
+
+// serveHTTP serves handler on addr in the background, over TLS if config is
+// not nil, until Shutdown. The caller must hold s.mu.
+func (s *Server) serveHTTP(name, addr string, handler http.Handler, config *tls.Config) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	srv := &http.Server{Handler: handler}
+	s.servers = append(s.servers, srv)
+	slog.Info(name+" listening", "addr", ln.Addr().String())
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error(name+" failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// serve accepts connections on the TCP listener and serves each on its own
+// goroutine until Shutdown.
+func (s *Server) serve() {
+	defer s.conns.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				return
+			default:
+			}
+			acceptErrors.Inc()
+			slog.Error("failed to accept connection", "err", err)
+			continue
+		}
+		if s.admitConn(conn) {
+			s.conns.Add(1)
+			go func() {
+				defer s.conns.Done()
+				s.serveConn(conn)
+			}()
+		}
+	}
+}
+
+// ReloadTLS reloads the certificate and key files given by -cert and -key,
+// keeping the current certificate if that fails. It does nothing unless the
+// server serves TLS from those files.
+func (s *Server) ReloadTLS() {
+	if s.certs != nil {
+		s.certs.reloadAndLog()
+	}
+}
+
+// Shutdown stops the listeners, closes the open connections, and waits for
+// their handlers to return, or for ctx to be done. It then stops replicating,
+// closes the AOF, and takes a final snapshot if a snapshot file is set.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	s.ready.drain()
+	close(s.shutdown)
+	s.closeListeners()
+	s.clients.kill(func(*client) bool { return true })
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if link := s.primaryLink.Swap(nil); link != nil {
+		link.close()
+	}
+	if s.appendLog != nil {
+		if cerr := s.appendLog.Close(); cerr != nil {
+			slog.Error("failed to close AOF", "err", cerr)
+		}
+	}
+	if s.snapshots != nil {
+		if serr := s.snapshots.save(); serr != nil {
+			slog.Error("final snapshot failed", "err", serr)
+		} else {
+			slog.Info("saved snapshot", "path", s.config.snapshotFile)
+		}
+	}
+	return err
+}
+
+// closeListeners closes every listener the server opened.
+func (s *Server) closeListeners() {
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+	for _, ln := range s.others {
+		ln.Close()
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// startServer starts a Server configured by opts, on a free port by default,
+// shutting it down when the test ends.
+func startServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	opts = append([]Option{WithRegisterer(prometheus.NewRegistry())}, opts...)
+	srv := New(opts...)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return srv
+}
+
+// withConfig returns an Option that changes the server's settings with set.
+func withConfig(set func(c *Config)) Option {
+	return func(s *Server) { set(&s.config) }
+}
+
+// dial connects to srv's TCP listener, closing the connection when the test
+// ends.
+func dial(t *testing.T, srv *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// forEachEngine runs test in a subtest for each engine, passing it a new
+// cache of that engine to serve with WithCache.
+func forEachEngine(t *testing.T, test func(t *testing.T, c cache.Store)) {
+	t.Run(engineSimple, func(t *testing.T) { test(t, cache.NewCache()) })
+	t.Run(engineSharded, func(t *testing.T) {
+		c := cache.NewShardedCache()
+		defer c.Close()
+		test(t, c)
+	})
+}
+
+// notServed is the error the simple engine replies to command with.
+func notServed(command string) string {
+	return "ERROR: " + command + " is not served by the simple engine; use -engine=sharded"
+}
+
+func TestServerCommands(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, c cache.Store) {
+		var mu sync.Mutex
+		seen := make(map[string]int)
+		srv := startServer(t, WithCache(c), WithCommandHook(func(command string, _ time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[command]++
+		}))
+		conn := dial(t, srv)
+		r := bufio.NewReader(conn)
+		_, simple := c.(*cache.Cache)
+
+		for _, tc := range []struct {
+			command, want string
+			simple        string // The simple engine's reply, if it differs.
+		}{
+			{"PING", "PONG", ""},
+			{"SET greeting hello", "OK", ""},
+			{"SETNX greeting bye", "0", ""},
+			{"INCR counter", "1", notServed("INCR")},
+			{"INCRBY counter 41", "42", notServed("INCRBY")},
+			{"HSET user name ada", "1", notServed("HSET")},
+			{"LPUSH queue a b", "2", notServed("LPUSH")},
+			{"LLEN queue", "2", notServed("LLEN")},
+			{"SADD tags x y x", "2", notServed("SADD")},
+			{"SCARD tags", "2", notServed("SCARD")},
+			{"EXISTS greeting counter missing", "2", "1"},
+			{"DEL counter", "OK", ""},
+			{"MULTI", "OK", ""},
+			{"SET a 1", "QUEUED", ""},
+			{"EXEC", "1", ""},
+			{"", "OK", ""}, // The reply of the SET that EXEC ran.
+		} {
+			want := tc.want
+			if simple && tc.simple != "" {
+				want = tc.simple
+			}
+			if tc.command == "" {
+				if got := readReply(t, r); got != want {
+					t.Fatalf("expected %q from EXEC, got %q", want, got)
+				}
+				continue
+			}
+			if got := configCommand(t, conn, r, tc.command); got != want {
+				t.Fatalf("%s: expected %q, got %q", tc.command, want, got)
+			}
+		}
+		reads := map[string]string{"GET greeting": "hello"}
+		if !simple {
+			reads["HGET user name"] = "ada"
+		}
+		for command, want := range reads {
+			conn.Write([]byte(command + "\n"))
+			if got := readBulkReply(t, r); got != want {
+				t.Fatalf("%s: expected %q, got %q", command, want, got)
+			}
+		}
+		if v, err := srv.Store().Get("a"); err != nil || v != "1" {
+			t.Fatalf("expected the transaction's write in the cache, got %q, %v", v, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if seen["SET"] != 2 || seen["PING"] != 1 {
+			t.Fatalf("expected the hook to see every command, got %v", seen)
+		}
+	})
+}
+
+// readReply reads one reply line without its terminator.
+func readReply(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestServerPassword(t *testing.T) {
+	srv := startServer(t, WithPassword("hunter2"))
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	if got := configCommand(t, conn, r, "GET key"); got != "ERROR: Authentication required. Please use AUTH <password>" {
+		t.Fatalf("expected GET to need AUTH, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "AUTH hunter2"); got != "OK" {
+		t.Fatalf("expected AUTH to succeed, got %q", got)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv := startServer(t)
+	conn := dial(t, srv)
+	r := bufio.NewReader(conn)
+	if got := configCommand(t, conn, r, "PING"); got != "PONG" {
+		t.Fatalf("expected PONG, got %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Fatal("expected the open connection to be closed")
+	}
+	if _, err := net.Dial("tcp", srv.Addr().String()); err == nil {
+		t.Fatal("expected the listener to be closed")
+	}
+	if err := srv.Start(context.Background()); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed from a restart, got %v", err)
+	}
+	if err := srv.Shutdown(ctx); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed from a second Shutdown, got %v", err)
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	first := startServer(t, WithPassword("first"))
+	second := startServer(t, WithPassword("second"))
+	conn1 := dial(t, first)
+	if got := configCommand(t, conn1, bufio.NewReader(conn1), "AUTH second"); got != "ERROR: Invalid password" {
+		t.Fatalf("expected the first server to keep its own password, got %q", got)
+	}
+	conn1, conn2 := dial(t, first), dial(t, second)
+	r1, r2 := bufio.NewReader(conn1), bufio.NewReader(conn2)
+	if got := configCommand(t, conn1, r1, "AUTH first"); got != "OK" {
+		t.Fatalf("expected AUTH to succeed on the first server, got %q", got)
+	}
+	if got := configCommand(t, conn2, r2, "AUTH second"); got != "OK" {
+		t.Fatalf("expected AUTH to succeed on the second server, got %q", got)
+	}
+	if got := configCommand(t, conn1, r1, "CONFIG SET idle-timeout 1h"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	if got := configCommand(t, conn2, r2, "CONFIG GET idle-timeout"); got != "idle-timeout 0s" {
+		t.Fatalf("expected the second server's setting to be unchanged, got %q", got)
+	}
+
+	// Shutting one down closes only its own clients.
+	if err := first.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r1.ReadString('\n'); err == nil {
+		t.Fatal("expected the first server's client to be closed")
+	}
+	if got := configCommand(t, conn2, r2, "PING"); got != "PONG" {
+		t.Fatalf("expected the second server's client to stay connected, got %q", got)
+	}
+}
+
+func TestServerDefaults(t *testing.T) {
+	// Two embedded servers start side by side on free ports, with no metrics
+	// server between them.
+	first, second := startServer(t), startServer(t)
+	if first.Addr().String() == second.Addr().String() {
+		t.Fatalf("expected each server its own port, got %s twice", first.Addr())
+	}
+	for _, srv := range []*Server{first, second} {
+		if len(srv.servers) != 0 {
+			t.Fatalf("expected no HTTP servers by default, got %d", len(srv.servers))
+		}
+	}
+	if srv := startServer(t, WithMetricsAddr("127.0.0.1:0")); len(srv.servers) != 1 {
+		t.Fatalf("expected WithMetricsAddr to start the metrics server, got %d HTTP servers", len(srv.servers))
+	}
+
+	// The flags keep the standalone server's addresses.
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	NewConfig().RegisterFlags(fs)
+	for name, want := range map[string]string{"tcp": ":8080", "metrics": ":9090"} {
+		if got := fs.Lookup(name).DefValue; got != want {
+			t.Fatalf("expected -%s to default to %s, got %s", name, want, got)
+		}
+	}
+}
+
+func TestServerStartErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := New(WithAddr(ln.Addr().String()), WithRegisterer(prometheus.NewRegistry()))
+	if err := srv.Start(context.Background()); err == nil {
+		t.Fatal("expected Start to fail on an address in use")
+	}
+	if err := New(WithWorkers(0)).Start(context.Background()); err == nil {
+		t.Fatal("expected Start to reject zero workers")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(WithAddr("127.0.0.1:0")).Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Start to give up on a canceled context, got %v", err)
+	}
+}
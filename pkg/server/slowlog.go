@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/protocol"
+)
+
+// Argument truncation keeps huge values out of the slow log.
+const (
+	slowlogMaxArgs     = 32
+	slowlogMaxArgBytes = 128
+)
+
+// slowEntry is a command recorded in the slow log.
+type slowEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	addr     string
+	args     []string // The command name and its truncated arguments.
+}
+
+// slowLog is a ring buffer of the most recent slow commands.
+type slowLog struct {
+	threshold atomicDuration // The slowest a command may be without being logged.
+
+	mu      sync.Mutex
+	entries []slowEntry // Ring of up to cap(entries) entries.
+	next    int         // Index the next entry is written to once the ring is full.
+	nextID  int64
+}
+
+func newSlowLog(maxLen int) *slowLog {
+	return &slowLog{entries: make([]slowEntry, 0, max(maxLen, 0))}
+}
+
+// record logs a command from addr that started at start if it took at least
+// the log's threshold, returning its duration and whether it was logged. parts
+// is the command as processed, with its keys mapped into ks; the entry holds
+// the client keys.
+func (l *slowLog) record(ks keyspace, command string, parts []string, addr string, start time.Time) (time.Duration, bool) {
+	d := time.Since(start)
+	if threshold := l.threshold.Get(); threshold <= 0 || d < threshold || cap(l.entries) == 0 {
+		return d, false
+	}
+	ent := slowEntry{time: start, duration: d, addr: addr, args: truncateArgs(ks, command, parts)}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ent.id = l.nextID
+	l.nextID++
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, ent)
+	} else {
+		l.entries[l.next] = ent
+		l.next = (l.next + 1) % len(l.entries)
+	}
+	return d, true
+}
+
+// truncateArgs copies at most slowlogMaxArgs of parts, each cut to
+// slowlogMaxArgBytes, so the slow log never pins a large value in memory.
+func truncateArgs(ks keyspace, command string, parts []string) []string {
+	n := min(len(parts), slowlogMaxArgs)
+	args := make([]string, n, n+1)
+	copy(args, parts)
+	ks.unmapKeys(command, args)
+	for i, arg := range args {
+		if len(arg) > slowlogMaxArgBytes {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgBytes], len(arg)-slowlogMaxArgBytes)
+		}
+		args[i] = strings.Clone(arg)
+	}
+	if len(parts) > n {
+		args = append(args, fmt.Sprintf("... (%d more arguments)", len(parts)-n))
+	}
+	return args
+}
+
+// get returns up to n entries, newest first.
+func (l *slowLog) get(n int) []slowEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n = min(n, len(l.entries))
+	entries := make([]slowEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		// The newest entry sits just before next.
+		entries = append(entries, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return entries
+}
+
+// len returns the number of entries held.
+func (l *slowLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// reset discards every entry.
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = l.entries[:0]
+	l.next = 0
+}
+
+// writeSlowEntries writes entries as a count line followed by one line each:
+//
+//	<id> <unix seconds> <microseconds> <addr> "<command>" "<arg>" ...
+func writeSlowEntries(w io.Writer, entries []slowEntry) {
+	protocol.WriteReply(w, protocol.Integer(int64(len(entries))))
+	for _, ent := range entries {
+		line := fmt.Sprintf("%d %d %d %s", ent.id, ent.time.Unix(), ent.duration.Microseconds(), ent.addr)
+		for _, arg := range ent.args {
+			line += " " + strconv.Quote(arg)
+		}
+		protocol.WriteReply(w, protocol.Status(line))
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bufio"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// recordSlow makes the server under test record every command in a slow log
+// of maxLen entries.
+func recordSlow(maxLen int) Option {
+	return withConfig(func(c *Config) { c.slowlogMaxLen, c.slowThreshold = maxLen, time.Nanosecond })
+}
+
+func TestSlowLogCommands(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c), recordSlow(128)))
+	r := bufio.NewReader(conn)
+
+	long := strings.Repeat("v", 200)
+	for _, cmd := range []string{"SELECT 3", "SET k " + long, "SADD s" + strings.Repeat(" m", 40)} {
+		configCommand(t, conn, r, cmd)
+	}
+	if got := configCommand(t, conn, r, "SLOWLOG LEN"); got != "3" {
+		t.Fatalf("expected 3 entries, got %q", got)
+	}
+
+	if got := configCommand(t, conn, r, "SLOWLOG GET 3"); got != "3" {
+		t.Fatalf("expected 3 entries, got %q", got)
+	}
+	addr := regexp.QuoteMeta(conn.LocalAddr().String())
+	for _, want := range []string{
+		`^3 \d+ \d+ ` + addr + ` "SLOWLOG" "LEN"$`,
+		`^2 \d+ \d+ ` + addr + ` "SADD" "s"( "m"){30} "... \(10 more arguments\)"$`,
+		`^1 \d+ \d+ ` + addr + ` "SET" "k" "v{128}... \(72 more bytes\)"$`,
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil || !regexp.MustCompile(want).MatchString(strings.TrimSuffix(line, "\n")) {
+			t.Fatalf("expected a line matching %s, got %q, %v", want, line, err)
+		}
+	}
+
+	for _, tc := range []struct{ cmd, want string }{
+		{"SLOWLOG GET x", "ERROR: count must be a non-negative integer"},
+		{"SLOWLOG", "ERROR: SLOWLOG requires GET [count], LEN, or RESET"},
+		{"SLOWLOG RESET", "OK"},
+		{"SLOWLOG GET", "1"}, // The RESET itself.
+	} {
+		if got := configCommand(t, conn, r, tc.cmd); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+}
+
+func TestSlowLogThreshold(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c), recordSlow(128)))
+	r := bufio.NewReader(conn)
+	if got := configCommand(t, conn, r, "CONFIG SET slowlog-threshold 1h"); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	configCommand(t, conn, r, "SET k v")
+	if got := configCommand(t, conn, r, "SLOWLOG LEN"); got != "0" {
+		t.Fatalf("expected only commands over the threshold to be recorded, got %q", got)
+	}
+}
+
+func TestSlowLogRing(t *testing.T) {
+	slowlog := newSlowLog(3)
+	slowlog.threshold.Store(int64(time.Nanosecond))
+	start := time.Now().Add(-time.Second)
+	for _, cmd := range []string{"A", "B", "C", "D", "E"} {
+		slowlog.record(keyspace{}, cmd, []string{cmd}, "addr", start)
+	}
+	var got []string
+	for _, ent := range slowlog.get(10) {
+		got = append(got, ent.args[0])
+	}
+	if !slices.Equal(got, []string{"E", "D", "C"}) || slowlog.len() != 3 {
+		t.Fatalf("expected the 3 newest entries, newest first, got %v", got)
+	}
+	if ids := slowlog.get(1)[0].id; ids != 4 {
+		t.Fatalf("expected ids to keep counting across the ring, got %d", ids)
+	}
+}
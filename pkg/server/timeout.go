@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// connTimeouts applies the server's -read-timeout, -write-timeout, and idle
+// timeout to a connection. A zero timeout leaves that deadline unset.
+type connTimeouts struct {
+	conn         net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  *atomicDuration // The server's -idle-timeout, which CONFIG SET changes.
+	idle         bool            // Whether the current read deadline is the idle timeout.
+	log          *slog.Logger    // Where timeouts are logged; nil means slog.Default().
+}
+
+// newConnTimeouts returns the timeouts of conn, a connection to s.
+func (s *Server) newConnTimeouts(conn net.Conn) *connTimeouts {
+	return &connTimeouts{conn: conn, readTimeout: s.config.readTimeout, writeTimeout: s.config.writeTimeout, idleTimeout: &s.idleTimeout}
+}
+
+func (t *connTimeouts) logger() *slog.Logger {
+	if t.log == nil {
+		return slog.Default()
+	}
+	return t.log
+}
+
+// awaitCommand sets the read deadline for waiting on the next command: the
+// idle timeout if there is one, else the read timeout.
+func (t *connTimeouts) awaitCommand() {
+	if idle := t.idleTimeout.Get(); idle > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(idle))
+		t.idle = true
+	} else if t.readTimeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(t.readTimeout))
+	}
+}
+
+// beginCommand sets the deadlines for running a command whose first line has
+// arrived: the read timeout covers the rest of it, such as a data block, and
+// the write timeout covers replies flushed while it runs.
+func (t *connTimeouts) beginCommand() {
+	if t.readTimeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(t.readTimeout))
+		t.idle = false
+	}
+	if t.writeTimeout > 0 {
+		t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	}
+}
+
+// flush writes buffered replies within the write timeout.
+func (t *connTimeouts) flush(w interface{ Flush() error }) error {
+	if t.writeTimeout > 0 {
+		t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	}
+	err := w.Flush()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.logger().Info("closing connection: write timed out", "timeout", t.writeTimeout)
+	}
+	return err
+}
+
+// timedOut reports whether err is a read deadline expiring, logging why the
+// connection is being closed if so.
+func (t *connTimeouts) timedOut(err error) bool {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	if t.idle {
+		t.logger().Info("closing connection: idle", "timeout", t.idleTimeout.Get())
+	} else {
+		t.logger().Info("closing connection: read timed out", "timeout", t.readTimeout)
+	}
+	return true
+}
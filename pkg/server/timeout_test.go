@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// withTimeouts sets the timeouts of the server under test.
+func withTimeouts(read, write, idle time.Duration) Option {
+	return withConfig(func(c *Config) { c.readTimeout, c.writeTimeout, c.idleTimeout = read, write, idle })
+}
+
+// expectClosedWithin waits for the server to close conn and checks that it
+// did so between min and max after start.
+func expectClosedWithin(t *testing.T, r io.Reader, start time.Time, min, max time.Duration) {
+	t.Helper()
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("expected the server to close the connection, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < min || elapsed > max {
+		t.Fatalf("expected the connection to close after %s to %s, took %s", min, max, elapsed)
+	}
+}
+
+func TestIdleTimeoutClosesSilentConnection(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+
+	conn := dial(t, startServer(t, WithCache(c), withTimeouts(0, 0, 100*time.Millisecond)))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectClosedWithin(t, conn, time.Now(), 90*time.Millisecond, time.Second)
+}
+
+func TestIdleTimeoutResetsOnEachCommand(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+
+	conn := dial(t, startServer(t, WithCache(c), withTimeouts(0, 0, 150*time.Millisecond)))
+	r := bufio.NewReader(conn)
+	for i := 0; i < 5; i++ {
+		time.Sleep(75 * time.Millisecond)
+		fmt.Fprintf(conn, "SET k%d v\n", i)
+		if line, err := r.ReadString('\n'); err != nil || line != "OK\n" {
+			t.Fatalf("command %d: expected OK from an active connection, got %q, %v", i, line, err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectClosedWithin(t, r, time.Now(), 140*time.Millisecond, time.Second)
+}
+
+func TestIdleTimeoutAppliesAfterAuth(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+
+	conn := dial(t, startServer(t, WithCache(c), withTimeouts(0, 0, 100*time.Millisecond), WithPassword("hunter2")))
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "AUTH hunter2\n")
+	if line, _ := r.ReadString('\n'); line != "OK\n" {
+		t.Fatalf("expected OK, got %q", line)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectClosedWithin(t, r, time.Now(), 90*time.Millisecond, time.Second)
+}
+
+func TestReadTimeoutClosesStalledDataBlock(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+
+	conn := dial(t, startServer(t, WithCache(c), withTimeouts(100*time.Millisecond, 0, time.Minute)))
+	fmt.Fprint(conn, "SET k $10\r\nabc")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectClosedWithin(t, conn, time.Now(), 90*time.Millisecond, time.Second)
+	if c.Exists("k") {
+		t.Fatal("expected nothing stored from a stalled data block")
+	}
+}
+
+func TestIdleTimeoutRESP(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+
+	conn := dial(t, startServer(t, WithCache(c), withTimeouts(0, 0, 100*time.Millisecond), WithProtocol("resp")))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectClosedWithin(t, conn, time.Now(), 90*time.Millisecond, time.Second)
+}
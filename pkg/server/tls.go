@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// newTLSConfig returns the TLS settings shared by every listener: the -cert
+// and -key pair and, with -tls-client-ca, verification of client
+// certificates against that CA.
+//
+// The certificate is served by s.certs, which reloads it when the files
+// change.
+func (s *Server) newTLSConfig() (*tls.Config, error) {
+	var err error
+	if s.certs, err = newCertReloader(s.config.certFile, s.config.keyFile); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{GetCertificate: s.certs.getCertificate}
+	if s.config.tlsClientCA == "" {
+		if s.config.requireCert {
+			return nil, errors.New("-tls-require-client-cert needs -tls-client-ca")
+		}
+		return config, nil
+	}
+	pem, err := os.ReadFile(s.config.tlsClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", s.config.tlsClientCA)
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if s.config.requireCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// certPermission completes the TLS handshake on conn, if it is a TLS
+// connection, and returns the permission its verified client certificate
+// grants. Without -users-file a verified certificate grants every permission;
+// with one, the certificate's common name must be a user, whose permission it
+// grants. It reports false if the certificate does not authenticate the
+// connection, and returns an error if the handshake fails.
+func (s *Server) certPermission(conn net.Conn) (permission, bool, error) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return permNone, false, nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return permNone, false, err
+	}
+	chains := tc.ConnectionState().VerifiedChains
+	if len(chains) == 0 {
+		return permNone, false, nil
+	}
+	if s.users == nil {
+		return permAdmin, true, nil
+	}
+	u, ok := s.users[chains[0][0].Subject.CommonName]
+	return u.perm, ok, nil
+}
+
+// certReloader holds the certificate loaded from a certificate and key file
+// pair and swaps in a new one when they change, so rotated certificates are
+// served without a restart. A failed reload keeps the old certificate.
+type certReloader struct {
+	certPath, keyPath string
+	cert              atomic.Pointer[tls.Certificate]
+
+	mu      sync.Mutex // Serializes reloads.
+	modTime time.Time  // Latest modification time of the files when last loaded.
+}
+
+// newCertReloader loads the certificate and key at certPath and keyPath.
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	r := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// reload loads the certificate and key again, keeping the current
+// certificate if that fails.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load certificate and key: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the later modification time of the two files.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certPath, r.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// changed reports whether either file was modified since the last load.
+func (r *certReloader) changed() bool {
+	modTime, err := r.latestModTime()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return err == nil && !modTime.Equal(r.modTime)
+}
+
+// watch reloads the certificate whenever its files change, checking every
+// interval until stop is closed, and logs the outcome.
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if r.changed() {
+				r.reloadAndLog()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reloadAndLog reloads the certificate and logs the outcome.
+func (r *certReloader) reloadAndLog() {
+	if err := r.reload(); err != nil {
+		slog.Error("TLS certificate reload failed, keeping the current certificate", "err", err)
+		return
+	}
+	slog.Info("reloaded TLS certificate", "path", r.certPath)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// testCA is a throwaway certificate authority.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for commonName signed by the CA, usable by
+// servers on 127.0.0.1 and by clients.
+func (ca *testCA) issue(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM writes cert and its key as PEM files at certPath and keyPath.
+func writePEM(t *testing.T, cert tls.Certificate, certPath, keyPath string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+// startTLSServer starts a server for c with TLS, with the server certificate
+// issued by ca and client certificates verified against it, until the test
+// ends. The certificate files are polled every 5ms.
+func startTLSServer(t *testing.T, c *cache.ShardedCache, ca *testCA, require bool, opts ...Option) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	cert, key, clientCA := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writePEM(t, ca.issue(t, "server"), cert, key)
+	os.WriteFile(clientCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600)
+	tlsConfig := withConfig(func(c *Config) {
+		c.useTLS, c.certFile, c.keyFile, c.tlsClientCA = true, cert, key, clientCA
+		c.requireCert, c.certPollEvery = require, 5*time.Millisecond
+	})
+	return startServer(t, append([]Option{WithCache(c), tlsConfig}, opts...)...)
+}
+
+// dialTLS connects to addr trusting ca, presenting certs, and returns the
+// connection and a reader for it.
+func dialTLS(t *testing.T, addr string, ca *testCA, certs ...tls.Certificate) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool, Certificates: certs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestClientCertAuthenticates(t *testing.T) {
+	ca := newTestCA(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	addr := startTLSServer(t, c, ca, false, WithPassword("hunter2")).Addr().String()
+
+	conn, r := dialTLS(t, addr, ca, ca.issue(t, "app"))
+	if got := configCommand(t, conn, r, "SET k v"); got != "OK" {
+		t.Fatalf("expected a client certificate to authenticate, got %q", got)
+	}
+
+	// Without -tls-require-client-cert, other clients may still use AUTH.
+	conn, r = dialTLS(t, addr, ca)
+	if got := configCommand(t, conn, r, "EXISTS k"); !strings.HasPrefix(got, "ERROR: Authentication required") {
+		t.Fatalf("expected authentication to be required, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "AUTH hunter2"); got != "OK" {
+		t.Fatalf("expected AUTH to succeed, got %q", got)
+	}
+
+	// A certificate from another CA is rejected at the handshake.
+	other := newTestCA(t)
+	conn, r = dialTLS(t, addr, ca, other.issue(t, "app"))
+	conn.Write([]byte("PING\n"))
+	if line, err := r.ReadString('\n'); err == nil {
+		t.Fatalf("expected an untrusted certificate to be rejected, got %q", line)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	addr := startTLSServer(t, c, ca, true).Addr().String()
+
+	conn, r := dialTLS(t, addr, ca)
+	conn.Write([]byte("PING\n"))
+	if line, err := r.ReadString('\n'); err == nil {
+		t.Fatalf("expected a connection without a certificate to be rejected, got %q", line)
+	}
+	conn, r = dialTLS(t, addr, ca, ca.issue(t, "app"))
+	if got := configCommand(t, conn, r, "PING"); got != "PONG" {
+		t.Fatalf("expected PONG, got %q", got)
+	}
+}
+
+func TestClientCertMapsToUser(t *testing.T) {
+	users := useUsers(t, "dash:viewer:read")
+	ca := newTestCA(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	addr := startTLSServer(t, c, ca, false, users).Addr().String()
+
+	conn, r := dialTLS(t, addr, ca, ca.issue(t, "dash"))
+	if got := configCommand(t, conn, r, "EXISTS k"); got != "0" {
+		t.Fatalf("expected the certificate's user to be logged in, got %q", got)
+	}
+	if got := configCommand(t, conn, r, "SET k v"); got != "ERROR: NOPERM SET requires the write permission" {
+		t.Fatalf("expected the user's permissions to apply, got %q", got)
+	}
+
+	conn, r = dialTLS(t, addr, ca, ca.issue(t, "stranger"))
+	if got := configCommand(t, conn, r, "EXISTS k"); !strings.HasPrefix(got, "ERROR: Authentication required") {
+		t.Fatalf("expected a certificate naming no user not to authenticate, got %q", got)
+	}
+}
+
+// servedName returns the common name of the certificate the server at addr
+// presents to a new connection.
+func servedName(t *testing.T, addr string, ca *testCA) string {
+	t.Helper()
+	conn, _ := dialTLS(t, addr, ca)
+	defer conn.Close()
+	return conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertReload(t *testing.T) {
+	ca := newTestCA(t)
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startTLSServer(t, c, ca, false)
+	addr := srv.Addr().String()
+	if got := servedName(t, addr, ca); got != "server" {
+		t.Fatalf("expected the initial certificate, got %q", got)
+	}
+
+	// Rotate the files, as cert-manager would.
+	writePEM(t, ca.issue(t, "rotated"), srv.config.certFile, srv.config.keyFile)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(srv.config.certFile, future, future)
+	deadline := time.Now().Add(2 * time.Second)
+	for servedName(t, addr, ca) != "rotated" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected new handshakes to present the rotated certificate")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A broken rotation keeps the last good certificate.
+	os.WriteFile(srv.config.certFile, []byte("not a certificate"), 0o600)
+	if err := srv.certs.reload(); err == nil {
+		t.Fatal("expected reloading a broken certificate to fail")
+	}
+	if got := servedName(t, addr, ca); got != "rotated" {
+		t.Fatalf("expected the last good certificate to be served, got %q", got)
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// Transactions: MULTI starts queuing a connection's commands instead of
+// running them, and EXEC runs the queue as one unit, replying with the number
+// of commands and then each command's reply in order. DISCARD drops the
+// queue. A command that cannot be queued, because it is unknown, has the
+// wrong number of arguments, or may not run in a transaction, is refused at
+// once and makes EXEC refuse the whole transaction.
+//
+// WATCH makes a transaction optimistic: it records the version of each key
+// named, and EXEC replies (nil) and runs nothing if any of them was written
+// since. EXEC, DISCARD, and UNWATCH forget the watched keys.
+//
+// EXEC holds the server's txMu for writing while it runs the queue, and
+// every other command holds it for reading, so no command on any connection
+// sees a transaction half applied. A single lock is simpler than locking the
+// shards of the keys involved, at the cost of stalling every connection for
+// the length of an EXEC. EXEC therefore collects its replies in memory and
+// writes them to the client only once txMu is released, so a client that
+// stops reading cannot stall the others.
+
+// errExecAbort is the EXEC reply of a transaction with a command that failed
+// to queue.
+const errExecAbort = "EXECABORT transaction discarded because of previous errors"
+
+// txShare is a connection's read hold on its server's txMu for the command
+// it is running.
+type txShare struct {
+	mu   *sync.RWMutex
+	held bool
+}
+
+// acquire takes txMu for reading, unless the hold is already taken.
+func (s *txShare) acquire() {
+	if !s.held {
+		s.mu.RLock()
+		s.held = true
+	}
+}
+
+// release lets go of txMu, if it is held.
+func (s *txShare) release() {
+	if s.held {
+		s.mu.RUnlock()
+		s.held = false
+	}
+}
+
+// arity bounds the fields of a command line, the command included. A max of
+// -1 means no limit, and with pairs set the fields after the first min come
+// in pairs.
+type arity struct {
+	min, max int
+	pairs    bool
+}
+
+// txCommands gives the arity of each command that may be queued in a
+// transaction, matching the checks each one's handler makes.
+var txCommands = map[string]arity{
+	"PING": {1, -1, false}, "ECHO": {2, -1, false},
+	"SET": {3, -1, false}, "PSETEX": {4, -1, false}, "SETNX": {3, -1, false}, "CAS": {4, 4, false},
+	"INCR": {2, 2, false}, "DECR": {2, 2, false}, "INCRBY": {3, 3, false}, "DECRBY": {3, 3, false},
+	"LOCK": {4, 4, false}, "UNLOCK": {3, 3, false}, "LOCKRENEW": {4, 4, false},
+	"APPEND": {3, -1, false}, "GET": {2, -1, false}, "MGET": {2, -1, false}, "MSET": {3, -1, true},
+	"GETDEL": {2, -1, false}, "DEL": {2, -1, false}, "DELPREFIX": {2, 2, false},
+	"SETTAGS": {4, -1, false}, "INVALTAG": {2, 2, false},
+	"HSET": {4, -1, false}, "HGET": {3, 3, false}, "HGETALL": {2, 2, false}, "HDEL": {3, -1, false}, "HINCRBY": {4, 4, false},
+	"LPUSH": {3, -1, false}, "RPUSH": {3, -1, false}, "LPOP": {2, 2, false}, "RPOP": {2, 2, false},
+	"LRANGE": {4, 4, false}, "LTRIM": {4, 4, false}, "LLEN": {2, 2, false},
+	"SADD": {3, -1, false}, "SREM": {3, -1, false}, "SISMEMBER": {3, 3, false}, "SCARD": {2, 2, false},
+	"SMEMBERS": {2, 2, false}, "SINTER": {3, 3, false}, "SUNION": {3, 3, false},
+	"ZADD": {4, -1, true}, "ZREM": {3, -1, false}, "ZSCORE": {3, 3, false}, "ZRANK": {3, 3, false},
+	"ZCARD": {2, 2, false}, "ZRANGE": {4, 5, false}, "ZRANGEBYSCORE": {4, 5, false},
+	"SETBIT": {4, 4, false}, "GETBIT": {3, 3, false}, "BITCOUNT": {2, 2, false},
+	"PUBLISH": {3, -1, false}, "RENAME": {3, 3, false}, "DUMP": {2, 2, false}, "RESTORE": {4, 5, false},
+	"EXISTS": {2, -1, false}, "PTTL": {2, 2, false}, "OBJECT": {3, 3, false}, "SELECT": {2, 2, false},
+	"FLUSHDB": {1, 1, false}, "FLUSHALL": {1, 1, false},
+}
+
+// txControl holds the commands that manage a transaction rather than being
+// queued in one.
+var txControl = map[string]bool{"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true}
+
+// checkQueueable returns why the command line parts may not be queued in a
+// transaction, or nil.
+func checkQueueable(command string, parts []string) error {
+	a, ok := txCommands[command]
+	if !ok {
+		return fmt.Errorf("%s cannot be queued in MULTI", command)
+	}
+	n := len(parts)
+	if n < a.min || (a.max >= 0 && n > a.max) || (a.pairs && (n-a.min)%2 != 0) {
+		return fmt.Errorf("wrong number of arguments for %s", command)
+	}
+	return nil
+}
+
+// dataBlockLength returns the length of the data block that follows the
+// command line parts, if it has one: SET, PSETEX, and APPEND take their value
+// as one when it is given as $<nbytes>.
+func dataBlockLength(command string, parts []string) (int, bool) {
+	at := 2
+	switch command {
+	case "SET", "APPEND":
+	case "PSETEX":
+		at = 3
+	default:
+		return 0, false
+	}
+	if len(parts) != at+1 {
+		return 0, false
+	}
+	return parseLength(parts[at])
+}
+
+// watchSet maps the stored keys a connection watches to their versions when
+// it first watched them.
+type watchSet map[string]uint64
+
+// add starts watching keys, keeping the version of keys already watched.
+func (ws watchSet) add(c *cache.ShardedCache, keys []string) {
+	for _, key := range keys {
+		if _, ok := ws[key]; !ok {
+			ws[key] = c.Version(key)
+		}
+	}
+}
+
+// changed reports whether any watched key was written since it was watched.
+// A key that is deleted or expires counts as written.
+func (ws watchSet) changed(c *cache.ShardedCache) bool {
+	for key, version := range ws {
+		if c.Version(key) != version {
+			return true
+		}
+	}
+	return false
+}
+
+// transaction is a connection's state from MULTI on: the commands queued,
+// and, while EXEC runs them, the connection's own reader and writer and the
+// replies held back from the latter.
+type transaction struct {
+	queued  bytes.Buffer
+	count   int
+	writes  bool          // Some queued command is a write.
+	aborted bool          // A command failed to queue.
+	mu      *sync.RWMutex // The server's txMu, held for writing while EXEC runs.
+	conn    *bufio.Reader
+	out     *bufio.Writer
+	replies bytes.Buffer
+	held    *bufio.Writer // Writes to replies.
+}
+
+// queue adds a command line, and its data block if it has one, as the
+// client sent them.
+func (tx *transaction) queue(command, line string, block *string) {
+	tx.queued.WriteString(line)
+	tx.queued.WriteString("\r\n")
+	if block != nil {
+		tx.queued.WriteString(*block)
+		tx.queued.WriteString("\r\n")
+	}
+	tx.count++
+	tx.writes = tx.writes || isWrite(command)
+}
+
+// abort makes EXEC refuse tx. It does nothing outside a transaction or while
+// EXEC runs one.
+func (tx *transaction) abort() {
+	if tx != nil && tx.conn == nil {
+		tx.aborted = true
+	}
+}
+
+// replaying reports whether EXEC is running tx's commands.
+func (tx *transaction) replaying() bool {
+	return tx != nil && tx.conn != nil
+}
+
+// exec starts running tx's commands, taking txMu for writing in place of
+// share. It returns the reader to take them from instead of conn, and the
+// writer to reply to instead of out, which holds the replies in memory.
+func (tx *transaction) exec(conn *bufio.Reader, out *bufio.Writer, share *txShare) (*bufio.Reader, *bufio.Writer) {
+	share.release()
+	share.mu.Lock()
+	tx.mu, tx.conn, tx.out = share.mu, conn, out
+	tx.held = bufio.NewWriter(&tx.replies)
+	return bufio.NewReader(&tx.queued), tx.held
+}
+
+// finish lets go of txMu once tx's commands have run, then passes the
+// replies held back to the connection's writer. It returns the connection's
+// reader and writer to carry on with.
+func (tx *transaction) finish() (*bufio.Reader, *bufio.Writer) {
+	tx.held.Flush()
+	tx.mu.Unlock()
+	tx.out.Write(tx.replies.Bytes())
+	return tx.conn, tx.out
+}
+
+// done reports whether err from reading the next command means tx's
+// commands have all run.
+func (tx *transaction) done(err error) bool {
+	return tx.replaying() && err == io.EOF
+}
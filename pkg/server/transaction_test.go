@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+)
+
+// expectLines reads one reply line per want and fails unless they match.
+func expectLines(t *testing.T, r *bufio.Reader, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(line, "\n"); got != w {
+			t.Fatalf("expected %q, got %q", w, got)
+		}
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("word", "text")
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "MULTI\nSET a 1\nSET b $5\r\nhello\r\nINCR n\nINCR word\nGET b\n")
+	expectLines(t, r, "OK", "QUEUED", "QUEUED", "QUEUED", "QUEUED", "QUEUED")
+	if value, err := c.Get("a"); err == nil {
+		t.Fatalf("expected nothing to run before EXEC, got a=%q", value)
+	}
+	fmt.Fprint(conn, "EXEC\n")
+	// A command that fails as it runs does not stop the others.
+	expectLines(t, r, "5", "OK", "OK", "1", "ERROR: value is not an integer")
+	if got := readBulkReply(t, r); got != "hello" {
+		t.Fatalf("expected GET to see the transaction's own write, got %q", got)
+	}
+	if value, _ := c.Get("a"); value != "1" {
+		t.Fatalf("expected a=1 after EXEC, got %q", value)
+	}
+
+	// The connection runs commands normally again.
+	fmt.Fprint(conn, "GET a\n")
+	if got := readBulkReply(t, r); got != "1" {
+		t.Fatalf("expected 1, got %q", got)
+	}
+}
+
+func TestMultiExecAbort(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	conn := dial(t, startServer(t, WithCache(c)))
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "MULTI\nSET a 1\nSET b\nBOGUS\nSUBSCRIBE news\nEXEC\n")
+	expectLines(t, r, "OK", "QUEUED",
+		"ERROR: wrong number of arguments for SET",
+		"ERROR: BOGUS cannot be queued in MULTI",
+		"ERROR: SUBSCRIBE cannot be queued in MULTI",
+		"ERROR: "+errExecAbort)
+	if c.Exists("a") {
+		t.Fatal("expected an aborted transaction to run nothing")
+	}
+
+	for _, tc := range []struct{ command, want string }{
+		{"EXEC", "ERROR: EXEC without MULTI"},
+		{"DISCARD", "ERROR: DISCARD without MULTI"},
+		{"MULTI", "OK"},
+		{"MULTI", "ERROR: MULTI calls can not be nested"},
+		{"SET a 1", "QUEUED"},
+		{"DISCARD", "OK"},
+		{"EXEC", "ERROR: EXEC without MULTI"},
+	} {
+		if got := configCommand(t, conn, r, tc.command); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.command, tc.want, got)
+		}
+	}
+	if c.Exists("a") {
+		t.Fatal("expected a discarded transaction to run nothing")
+	}
+}
+
+func TestMultiExecAtomic(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c))
+	writer := dial(t, srv)
+	reader := dial(t, srv)
+	wr, rr := bufio.NewReader(writer), bufio.NewReader(reader)
+	configCommand(t, writer, wr, "MSET a 0 b 0")
+
+	// Commands between the two SETs widen the window a reader could land in.
+	const padding = 20
+	done := make(chan error)
+	go func() {
+		for i := 1; i <= 300; i++ {
+			fmt.Fprintf(writer, "MULTI\nSET a %d\n%sSET b %d\nEXEC\n", i, strings.Repeat("INCR pad\n", padding), i)
+			for range 2*(padding+2) + 2 {
+				if _, err := wr.ReadString('\n'); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		default:
+		}
+		fmt.Fprint(reader, "MGET a b\n")
+		if values := readBulkReplies(t, rr, 2); values[0] != values[1] {
+			t.Fatalf("expected a and b to change together, got %q and %q", values[0], values[1])
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("k", "1")
+	srv := startServer(t, WithCache(c))
+	conn, other := dial(t, srv), dial(t, srv)
+	r, or := bufio.NewReader(conn), bufio.NewReader(other)
+
+	// exec runs SET k value in a transaction and returns EXEC's reply.
+	exec := func(value string) string {
+		t.Helper()
+		fmt.Fprintf(conn, "MULTI\nSET k %s\nEXEC\n", value)
+		expectLines(t, r, "OK", "QUEUED")
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "1\n" {
+			expectLines(t, r, "OK")
+		}
+		return strings.TrimSuffix(line, "\n")
+	}
+
+	configCommand(t, conn, r, "WATCH k")
+	configCommand(t, other, or, "SET k 2")
+	if got := exec("3"); got != "(nil)" {
+		t.Fatalf("expected EXEC to abort after k changed, got %q", got)
+	}
+	if value, _ := c.Get("k"); value != "2" {
+		t.Fatalf("expected the aborted transaction to run nothing, got k=%q", value)
+	}
+
+	// EXEC forgot the watch, and an unchanged key lets EXEC run.
+	configCommand(t, other, or, "SET k 4")
+	configCommand(t, conn, r, "WATCH k")
+	fmt.Fprint(conn, "GET k\n")
+	readBulkReply(t, r)
+	if got := exec("5"); got != "1" {
+		t.Fatalf("expected EXEC to run with k unchanged, got %q", got)
+	}
+
+	configCommand(t, conn, r, "WATCH k")
+	configCommand(t, conn, r, "UNWATCH")
+	configCommand(t, other, or, "SET k 6")
+	if got := exec("7"); got != "1" {
+		t.Fatalf("expected EXEC to run after UNWATCH, got %q", got)
+	}
+
+	// Creating a watched key counts as a change, and watches are per
+	// database.
+	configCommand(t, conn, r, "WATCH missing")
+	configCommand(t, other, or, "SET missing 1")
+	if got := exec("8"); got != "(nil)" {
+		t.Fatalf("expected EXEC to abort after a watched key was created, got %q", got)
+	}
+	configCommand(t, conn, r, "SELECT 1")
+	configCommand(t, conn, r, "WATCH k")
+	configCommand(t, other, or, "SET k 9")
+	if got := exec("10"); got != "1" {
+		t.Fatalf("expected a write in another database to leave the watch alone, got %q", got)
+	}
+
+	for _, tc := range []struct{ command, want string }{
+		{"WATCH", "ERROR: WATCH requires at least one key"},
+		{"MULTI", "OK"},
+		{"WATCH k", "ERROR: WATCH inside MULTI is not allowed"},
+		{"EXEC", "0"},
+	} {
+		if got := configCommand(t, conn, r, tc.command); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.command, tc.want, got)
+		}
+	}
+}
+
+func TestExecClientNotReading(t *testing.T) {
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("big", strings.Repeat("x", 1<<20))
+	conn := dial(t, startServer(t, WithCache(c)))
+
+	// The replies to this EXEC are far more than the socket buffers hold,
+	// and the client never reads them.
+	fmt.Fprint(conn, "MULTI\n"+strings.Repeat("GET big\n", 64)+"EXEC\n")
+
+	other, err := net.Dial("tcp", conn.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(other)
+	for _, command := range []string{"SET a 1", "MULTI", "SET b 2", "EXEC"} {
+		configCommand(t, other, r, command)
+	}
+	expectLines(t, r, "OK")
+	if value, _ := c.Get("b"); value != "2" {
+		t.Fatalf("expected other connections to run transactions, got b=%q", value)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// permission is what a user may do. Each level includes the ones below it.
+type permission uint8
+
+const (
+	permNone  permission = iota // Commands every connection may run.
+	permRead                    // Reading keys and subscribing.
+	permWrite                   // Changing keys and publishing.
+	permAdmin                   // Configuration, persistence, and other clients.
+)
+
+var permissionNames = map[string]permission{"read": permRead, "write": permWrite, "admin": permAdmin}
+
+func (p permission) String() string {
+	for name, q := range permissionNames {
+		if p == q {
+			return name
+		}
+	}
+	return "none"
+}
+
+// commandPermissions gives the permission each command needs. Commands not
+// listed need permAdmin.
+var commandPermissions = map[string]permission{
+	"PING": permNone, "ECHO": permNone, "QUIT": permNone, "AUTH": permNone,
+	"SELECT": permNone, "NAMESPACE": permNone, "MULTI": permNone, "EXEC": permNone, "DISCARD": permNone,
+	"UNWATCH": permNone,
+
+	"GET": permRead, "MGET": permRead, "EXISTS": permRead, "PTTL": permRead, "OBJECT": permRead, "SCAN": permRead, "DUMP": permRead,
+	"HGET": permRead, "HGETALL": permRead, "LRANGE": permRead, "LLEN": permRead,
+	"SISMEMBER": permRead, "SCARD": permRead, "SMEMBERS": permRead, "SINTER": permRead,
+	"SUNION": permRead, "ZSCORE": permRead, "ZRANK": permRead, "ZCARD": permRead,
+	"ZRANGE": permRead, "ZRANGEBYSCORE": permRead, "GETBIT": permRead, "BITCOUNT": permRead,
+	"SUBSCRIBE": permRead, "UNSUBSCRIBE": permRead, "INFO": permRead, "LASTSAVE": permRead,
+	"WATCH": permRead, "KEYS": permRead, "BATCHGET": permRead, // The HTTP key listing and the gRPC BatchGet.
+
+	"SET": permWrite, "PSETEX": permWrite, "SETNX": permWrite, "CAS": permWrite, "INCR": permWrite, "DECR": permWrite,
+	"INCRBY": permWrite, "DECRBY": permWrite, "APPEND": permWrite, "MSET": permWrite,
+	"GETDEL": permWrite, "DEL": permWrite, "DELPREFIX": permWrite, "SETTAGS": permWrite,
+	"INVALTAG": permWrite, "RENAME": permWrite, "RESTORE": permWrite, "HSET": permWrite,
+	"HDEL": permWrite, "HINCRBY": permWrite, "LPUSH": permWrite, "RPUSH": permWrite,
+	"LPOP": permWrite, "RPOP": permWrite, "LTRIM": permWrite, "SADD": permWrite,
+	"SREM": permWrite, "ZADD": permWrite, "ZREM": permWrite, "SETBIT": permWrite,
+	"LOCK": permWrite, "UNLOCK": permWrite, "LOCKRENEW": permWrite, "PUBLISH": permWrite,
+}
+
+// required returns the permission command needs.
+func required(command string) permission {
+	if need, ok := commandPermissions[command]; ok {
+		return need
+	}
+	return permAdmin
+}
+
+// allows reports whether p is enough to run command.
+func (p permission) allows(command string) bool { return p >= required(command) }
+
+// user is an entry of the -users-file.
+type user struct {
+	hash []byte // bcrypt hash of the password.
+	perm permission
+}
+
+// loadUsers reads a users file of "name:hash:permissions" lines, where hash
+// is a bcrypt hash, as made by "htpasswd -nbB name password", and permissions
+// is a comma-separated list of read, write, and admin. Blank lines and lines
+// starting with # are ignored.
+func loadUsers(path string) (map[string]user, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	loaded := make(map[string]user)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:hash:permissions", path, n)
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			return nil, fmt.Errorf("%s:%d: password hash: %v", path, n, err)
+		}
+		u := user{hash: []byte(fields[1])}
+		for _, name := range strings.Split(fields[2], ",") {
+			p, ok := permissionNames[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown permission %q; use read, write, or admin", path, n, name)
+			}
+			u.perm = max(u.perm, p)
+		}
+		if _, dup := loaded[fields[0]]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate user %q", path, n, fields[0])
+		}
+		loaded[fields[0]] = u
+	}
+	return loaded, scanner.Err()
+}
+
+// authenticate checks the arguments of AUTH, either "<password>" or
+// "<user> <password>", and returns the user's permission. Without a users
+// file, the only user is "default", with the server's password and every
+// permission; with one, "AUTH <password>" logs in as the user named default.
+func (s *Server) authenticate(args []string) (permission, bool) {
+	name, password := "default", ""
+	switch len(args) {
+	case 1:
+		password = args[0]
+	case 2:
+		name, password = args[0], args[1]
+	default:
+		return permNone, false
+	}
+	if s.users == nil {
+		ok := name == "default" && subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+		return permAdmin, ok
+	}
+	u, ok := s.users[name]
+	if !ok {
+		// Check against some other user's hash anyway, so failing takes as
+		// long for unknown users as for wrong passwords.
+		for _, other := range s.users {
+			bcrypt.CompareHashAndPassword(other.hash, []byte(password))
+			break
+		}
+		return permNone, false
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return permNone, false
+	}
+	return u.perm, true
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vlkhvnn/inmemcache/pkg/cache"
+	"github.com/vlkhvnn/inmemcache/pkg/rpc"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// useUsers writes a users file of the given "name:password:permissions"
+// lines, hashing each password, and returns an Option setting -users-file to
+// it, so the server requires AUTH as its users.
+func useUsers(t *testing.T, lines ...string) Option {
+	t.Helper()
+	var file strings.Builder
+	for _, line := range lines {
+		fields := strings.Split(line, ":")
+		hash, err := bcrypt.GenerateFromPassword([]byte(fields[1]), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(fields[0] + ":" + string(hash) + ":" + fields[2] + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, []byte(file.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return withConfig(func(c *Config) { c.usersFile = path })
+}
+
+func TestUsersPermissions(t *testing.T) {
+	users := useUsers(t, "dash:viewer:read", "app:s3cret:write", "ops:root:admin,read")
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("k", "v")
+	srv := startServer(t, WithCache(c), users)
+
+	for _, tc := range []struct {
+		user string
+		cmds []struct{ cmd, want string }
+	}{
+		{"dash viewer", []struct{ cmd, want string }{
+			{"EXISTS k", "1"},
+			{"SET k other", "ERROR: NOPERM SET requires the write permission"},
+			{"DEL k", "ERROR: NOPERM DEL requires the write permission"},
+			{"FLUSHALL", "ERROR: NOPERM FLUSHALL requires the admin permission"},
+		}},
+		{"app s3cret", []struct{ cmd, want string }{
+			{"SET k v2", "OK"},
+			{"CONFIG GET shards", "ERROR: NOPERM CONFIG requires the admin permission"},
+		}},
+		{"ops root", []struct{ cmd, want string }{
+			{"CONFIG GET shards", "shards 16"},
+			{"SET k v3", "OK"},
+		}},
+	} {
+		conn := dial(t, srv)
+		r := bufio.NewReader(conn)
+		if got := configCommand(t, conn, r, "AUTH "+tc.user); got != "OK" {
+			t.Fatalf("AUTH %s: expected OK, got %q", tc.user, got)
+		}
+		for _, cc := range tc.cmds {
+			if got := configCommand(t, conn, r, cc.cmd); got != cc.want {
+				t.Fatalf("%s: %s: expected %q, got %q", tc.user, cc.cmd, cc.want, got)
+			}
+		}
+	}
+}
+
+func TestUsersRejectBadCredentials(t *testing.T) {
+	users := useUsers(t, "dash:viewer:read")
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), users)
+	for _, auth := range []string{"AUTH dash wrong", "AUTH nobody viewer", "AUTH viewer", "AUTH"} {
+		conn := dial(t, srv)
+		r := bufio.NewReader(conn)
+		if got := configCommand(t, conn, r, auth); got != "ERROR: Invalid password" {
+			t.Fatalf("%s: expected a failure, got %q", auth, got)
+		}
+		if _, err := r.ReadString('\n'); err == nil {
+			t.Fatalf("%s: expected the connection to be closed", auth)
+		}
+	}
+}
+
+func TestUsersRESP(t *testing.T) {
+	users := useUsers(t, "dash:viewer:read")
+	c := cache.NewShardedCache()
+	defer c.Close()
+	srv := startServer(t, WithCache(c), WithProtocol("resp"), users)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr().String(), Username: "dash", Password: "viewer"})
+	defer rdb.Close()
+	ctx := context.Background()
+	if _, err := rdb.Get(ctx, "k").Result(); err != redis.Nil {
+		t.Fatalf("GET: expected redis.Nil, got %v", err)
+	}
+	if err := rdb.Set(ctx, "k", "v", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatalf("SET: expected NOPERM, got %v", err)
+	}
+}
+
+func TestUsersHTTPAndGRPC(t *testing.T) {
+	users := useUsers(t, "dash:viewer:read")
+	c := cache.NewShardedCache()
+	defer c.Close()
+	c.Set("k", "v")
+	srv := New(WithCache(c), users)
+	if err := srv.configure(); err != nil {
+		t.Fatal(err)
+	}
+	h, debug := srv.newHTTPHandler(), srv.newDebugHandler()
+	viewer := "Basic " + base64.StdEncoding.EncodeToString([]byte("dash:viewer"))
+
+	for _, tc := range []struct {
+		handler       http.Handler
+		method, path  string
+		authorization string
+		want          int
+	}{
+		{h, "PUT", "/keys/pwned", "Bearer secret", http.StatusUnauthorized},
+		{h, "GET", "/keys/k", "Bearer viewer", http.StatusUnauthorized},
+		{h, "GET", "/keys/k", viewer, http.StatusOK},
+		{h, "GET", "/keys", viewer, http.StatusOK},
+		{h, "PUT", "/keys/pwned", viewer, http.StatusForbidden},
+		{h, "DELETE", "/keys/k", viewer, http.StatusForbidden},
+		{debug, "GET", "/debug/vars", viewer, http.StatusForbidden},
+	} {
+		if rec := httpDo(t, tc.handler, tc.method, tc.path, []byte("x"), "Authorization", tc.authorization); rec.Code != tc.want {
+			t.Fatalf("%s %s with %q: expected %d, got %d", tc.method, tc.path, tc.authorization, tc.want, rec.Code)
+		}
+	}
+	if c.Exists("pwned") {
+		t.Fatal("expected the refused PUT not to store its key")
+	}
+
+	client := grpcClient(t, srv)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", viewer)
+	if resp, err := client.Get(ctx, &rpc.GetRequest{Key: "k"}); err != nil || string(resp.Value) != "v" {
+		t.Fatalf("expected v, got %v, %v", resp, err)
+	}
+	if _, err := client.Set(ctx, &rpc.SetRequest{Key: "pwned", Value: []byte("x")}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected Set to be PermissionDenied, got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	if _, err := client.Get(ctx, &rpc.GetRequest{Key: "k"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the shared password to be refused, got %v", err)
+	}
+}
+
+func TestLoadUsersErrors(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	for _, tc := range []struct{ file, want string }{
+		{"a:" + string(hash), "expected name:hash:permissions"},
+		{"a:plaintext:read", "password hash"},
+		{"a:" + string(hash) + ":root", `unknown permission "root"`},
+		{"a:" + string(hash) + ":read\na:" + string(hash) + ":write", `duplicate user "a"`},
+	} {
+		path := filepath.Join(t.TempDir(), "users")
+		os.WriteFile(path, []byte("# comment\n\n"+tc.file+"\n"), 0o600)
+		if _, err := loadUsers(path); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%q: expected an error containing %q, got %v", tc.file, tc.want, err)
+		}
+	}
+}